@@ -0,0 +1,159 @@
+package secure
+
+import "time"
+
+// An AlertCode says why a peer ended a connection with an alert.
+type AlertCode byte
+
+// Alert codes
+const (
+	// AlertInternal is sent for failures with no more specific code.
+	AlertInternal AlertCode = iota + 1
+
+	// AlertProtocol means that the peer's hello was malformed
+	// or unexpected.
+	AlertProtocol
+
+	// AlertServerName means that the server does not answer
+	// to the name the client gave.
+	AlertServerName
+
+	// AlertCertificate means that the peer's certificate was
+	// malformed, untrusted or missing from the certificate logs.
+	AlertCertificate
+
+	// AlertUnauthorized means that the server rejected the client,
+	// such as its key or the tenant in its ClientInfo.
+	AlertUnauthorized
+
+	// AlertQuota means that the client is over its quota.
+	AlertQuota
+)
+
+// Size (in bytes) of the longest reason an alert carries
+const maxAlertReason = 255
+
+func (a AlertCode) String() string {
+	switch a {
+	case AlertInternal:
+		return "internal error"
+	case AlertProtocol:
+		return "protocol error"
+	case AlertServerName:
+		return "unknown server name"
+	case AlertCertificate:
+		return "bad certificate"
+	case AlertUnauthorized:
+		return "unauthorized"
+	case AlertQuota:
+		return "quota exceeded"
+	}
+	return "unknown alert"
+}
+
+// An AlertError is what Read, Write and Handshake return once the peer
+// ended the connection with an alert, so that a peer rejecting the
+// connection can be told apart from the network failing. A Server's
+// Authorize may return one to choose the alert its client gets.
+type AlertError struct {
+	Code AlertCode
+
+	// Reason is whatever the peer added for humans, if anything.
+	// It is truncated to 255 bytes.
+	Reason string
+}
+
+func (e *AlertError) Error() string {
+	if e.Reason == "" {
+		return "peer alert: " + e.Code.String()
+	}
+	return "peer alert: " + e.Code.String() + ": " + e.Reason
+}
+
+// alertFor returns the alert that tells the peer the connection
+// failed because of err, or nil if err is none of its business
+func alertFor(err error) *AlertError {
+	switch err {
+	case ErrHello:
+		return &AlertError{Code: AlertProtocol}
+	case ErrServerName:
+		return &AlertError{Code: AlertServerName}
+	case ErrCertificate, ErrNotLogged:
+		return &AlertError{Code: AlertCertificate}
+	case ErrQuota:
+		return &AlertError{Code: AlertQuota}
+	}
+	return nil
+}
+
+// parseAlert returns the AlertError an alert frame carries: the code as
+// a byte followed by the reason
+func parseAlert(payload []byte) error {
+	if len(payload) < 1 || len(payload) > 1+maxAlertReason {
+		return ErrFrame
+	}
+	return &AlertError{Code: AlertCode(payload[0]), Reason: string(payload[1:])}
+}
+
+// Alert ends the stream with an alert frame instead of a close frame,
+// so that the peer Reader returns an *AlertError with code and reason
+// rather than io.EOF. It does not close the wrapped io.Writer. Later
+// writes fail with ErrWriterClosed.
+func (s *Writer) Alert(code AlertCode, reason string) error {
+	if s.closed {
+		return nil
+	}
+	if len(reason) > maxAlertReason {
+		reason = reason[:maxAlertReason]
+	}
+	err := s.WriteFrame(FrameAlert, append([]byte{byte(code)}, reason...))
+	s.closed = true
+	return err
+}
+
+// CloseAlert closes the connection like Close, but sends an alert with
+// code and reason in place of the close frame, so that the peer's Read,
+// Write or Handshake fail with an *AlertError saying why. Before the
+// handshake has completed, there is no key to seal the alert with, and
+// the connection is closed without it.
+func (c *Conn) CloseAlert(code AlertCode, reason string) error {
+	c.handshakeMu.Lock()
+	handshaked := c.handshaked
+	c.handshakeMu.Unlock()
+	if handshaked && !c.closing() {
+		c.sendAlert(&AlertError{Code: code, Reason: reason})
+	}
+	return c.Close()
+}
+
+// sendAlert writes a in place of the close frame if neither has been
+// written yet. Like sendClose, it bounds the write in case the peer
+// stopped reading.
+func (c *Conn) sendAlert(a *AlertError) error {
+	if c.w == nil || c.failed() != nil {
+		return nil
+	}
+
+	c.lockUrgent()
+	defer c.unlockUrgent()
+	if c.w.closed {
+		return nil
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	if c.state() != StateHandshaking {
+		c.setState(StateDraining)
+	}
+	return c.w.Alert(a.Code, a.Reason)
+}
+
+// reject tells the client why a Server turned it away, with the alert
+// err is or the one for code otherwise
+func (c *Conn) reject(err error, code AlertCode) {
+	a, ok := err.(*AlertError)
+	if !ok {
+		if a = alertFor(err); a == nil {
+			a = &AlertError{Code: code}
+		}
+	}
+	c.sendAlert(a)
+}
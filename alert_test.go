@@ -0,0 +1,170 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterAlert(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, priv, pub)
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Alert(AlertUnauthorized, strings.Repeat("x", 300)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("more")); err != ErrWriterClosed {
+		t.Fatalf("Expected ErrWriterClosed after the alert, got %v", err)
+	}
+
+	r := NewReader(&buf, priv, pub)
+	p := make([]byte, 64)
+	if n, err := r.Read(p); err != nil || string(p[:n]) != "hello" {
+		t.Fatalf("Unexpected read: %q, %v", p[:n], err)
+	}
+	want := &AlertError{Code: AlertUnauthorized, Reason: strings.Repeat("x", 255)}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Read(p); !reflect.DeepEqual(err, want) {
+			t.Fatalf("Expected %v, got %v", want, err)
+		}
+	}
+}
+
+func TestAuthorizeAlert(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	served := make(chan struct{}, 1)
+	srv := &Server{
+		Authorize: func(c *Conn) error {
+			info := c.ConnectionState().PeerClientInfo
+			if info == nil {
+				return nil
+			}
+			switch info.UserAgent {
+			case "banned":
+				return errors.New("banned")
+			case "other":
+				return &AlertError{Code: AlertUnauthorized, Reason: "unknown tenant"}
+			}
+			return nil
+		},
+		Quota: &Quota{MaxConns: 1},
+		Handler: HandlerFunc(func(c *Conn) {
+			served <- struct{}{}
+			buf := make([]byte, MaxMessageSize)
+			for {
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+			}
+		}),
+	}
+	go srv.Serve(l)
+
+	for _, tt := range []struct {
+		agent string
+		want  *AlertError
+	}{
+		{"banned", &AlertError{Code: AlertUnauthorized}},
+		{"other", &AlertError{Code: AlertUnauthorized, Reason: "unknown tenant"}},
+	} {
+		conn, err := Dial("tcp", l.Addr().String(), &Config{ClientInfo: &ClientInfo{UserAgent: tt.agent}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(make([]byte, 1)); !reflect.DeepEqual(err, tt.want) {
+			t.Fatalf("Expected %v, got %v", tt.want, err)
+		}
+		if _, err := conn.Write([]byte("hello")); !reflect.DeepEqual(err, tt.want) {
+			t.Fatalf("Expected Write to fail with %v, got %v", tt.want, err)
+		}
+		conn.Close()
+	}
+
+	// the second connection of a client is over quota
+	pub, priv := mustGenerateKey(t, nil)
+	config := &Config{PublicKey: pub, PrivateKey: priv}
+	first, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte("hello"))
+	<-served
+	second, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, err := second.Read(make([]byte, 1)); !reflect.DeepEqual(err, &AlertError{Code: AlertQuota}) {
+		t.Fatalf("Expected AlertQuota, got %v", err)
+	}
+}
+
+func TestHandshakeAlert(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{ServerNames: []string{"a.example"}, Suites: []Suite{SuiteAES256GCM}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	want := &AlertError{Code: AlertServerName}
+
+	// a client waiting for the server's hello hears it from Handshake
+	_, err = Dial("tcp", l.Addr().String(), &Config{ServerName: "b.example", Suites: []Suite{SuiteAES256GCM}})
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("Expected %v from Dial, got %v", want, err)
+	}
+
+	// and one that does not from its first Read
+	conn, err := Dial("tcp", l.Addr().String(), &Config{ServerName: "b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); !reflect.DeepEqual(err, want) {
+		t.Fatalf("Expected %v from Read, got %v", want, err)
+	}
+}
+
+func TestCloseAlert(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewClientConn(client, nil)
+	c.waitHello = true
+	s := NewServerConn(server, nil)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		if err := s.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		done <- s.CloseAlert(AlertInternal, "maintenance")
+	}()
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	want := &AlertError{Code: AlertInternal, Reason: "maintenance"}
+	if _, err := c.Read(make([]byte, 1)); !reflect.DeepEqual(err, want) {
+		t.Fatalf("Expected %v, got %v", want, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := want.Error(); got != "peer alert: internal error: maintenance" {
+		t.Fatalf("Unexpected message %q", got)
+	}
+}
@@ -0,0 +1,240 @@
+package secure
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FrameCheckpoint is the control frame WriteArchive sends after every
+// complete entry of an archive. It carries the number of entries
+// complete so far, counted from the start of the walk, as a big endian
+// uint64. Being a control frame, it is skipped by readers that do not
+// handle it, so the data frames alone still make up a valid tar stream.
+const FrameCheckpoint FrameType = 0xff
+
+// ErrArchive means that an archive entry had a name that would be
+// extracted outside the target directory
+var ErrArchive = errors.New("invalid archive entry")
+
+// A frameWriter can send control frames along with its data,
+// as *Writer and *Conn do
+type frameWriter interface {
+	io.Writer
+	WriteFrame(t FrameType, payload []byte) error
+}
+
+// A frameHandler can pass control frames to handlers,
+// as *Reader and *Conn do
+type frameHandler interface {
+	io.Reader
+	Handle(t FrameType, fn func(payload []byte) error)
+}
+
+// WriteArchive writes the regular files and directories of fsys to w
+// as a tar stream, in the lexical order of fs.WalkDir, skipping the
+// first skip entries. If w is a *Writer or a *Conn, each entry is
+// sealed in frames of its own and followed by a FrameCheckpoint, so
+// that an interrupted transfer can resume with the count the receiver
+// got from ExtractArchive. w must accept messages of MaxMessageSize.
+// WriteArchive does not close w.
+func WriteArchive(w io.Writer, fsys fs.FS, skip uint64) error {
+	bw := bufio.NewWriterSize(chunkWriter{w}, MaxMessageSize)
+	tw := tar.NewWriter(bw)
+	fw, checkpoints := w.(frameWriter)
+
+	var n uint64
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." || !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if n++; n <= skip {
+			return nil
+		}
+
+		if err := writeArchiveEntry(tw, fsys, name, d); err != nil {
+			return err
+		}
+		if !checkpoints {
+			return nil
+		}
+
+		// the entry must be on the wire before the checkpoint vouches for it
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		return fw.WriteFrame(FrameCheckpoint, b[:])
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeArchiveEntry writes the header of one file or directory,
+// and the contents of a file
+func writeArchiveEntry(tw *tar.Writer, fsys fs.FS, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if d.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if d.IsDir() {
+		return nil
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ExtractArchive extracts a tar stream written by WriteArchive from r
+// into dir, and returns the number of entries known to be complete.
+// If r is a *Reader or a *Conn, that is the count of the last
+// FrameCheckpoint received, which the sender passes to WriteArchive
+// to resume after a failure. Otherwise it is the number of entries
+// extracted. Entries other than regular files and directories are
+// skipped, and names leading outside dir fail with ErrArchive.
+func ExtractArchive(r io.Reader, dir string) (uint64, error) {
+	var done uint64
+	checkpoints := false
+	if h, ok := r.(frameHandler); ok {
+		checkpoints = true
+		h.Handle(FrameCheckpoint, func(payload []byte) error {
+			if len(payload) != 8 {
+				return ErrFrame
+			}
+			done = binary.BigEndian.Uint64(payload)
+			return nil
+		})
+	}
+
+	tr := tar.NewReader(&frameBuffer{r: r})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return done, nil
+		}
+		if err != nil {
+			return done, err
+		}
+		if err := extractArchiveEntry(tr, hdr, dir); err != nil {
+			return done, err
+		}
+		if !checkpoints {
+			done++
+		}
+	}
+}
+
+// extractArchiveEntry creates the file or directory of one entry
+func extractArchiveEntry(tr *tar.Reader, hdr *tar.Header, dir string) error {
+	// tar names use slashes, but Windows also splits paths at
+	// backslashes, which could lead out of dir there
+	name := path.Clean(hdr.Name)
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, `\`) {
+		return ErrArchive
+	}
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return ErrArchive
+	}
+	target := filepath.Join(dir, local)
+	mode := hdr.FileInfo().Mode().Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode|0700)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// A chunkWriter splits writes into messages of at most MaxMessageSize
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxMessageSize {
+			chunk = chunk[:MaxMessageSize]
+		}
+		n, err := c.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// A frameBuffer buffers whole messages, so that reads of any size
+// work on a *Reader or a *Conn
+type frameBuffer struct {
+	r    io.Reader
+	buf  [MaxFrameMessageSize]byte
+	rest []byte
+}
+
+func (b *frameBuffer) Read(p []byte) (int, error) {
+	for len(b.rest) == 0 {
+		n, err := b.r.Read(b.buf[:])
+		b.rest = b.buf[:n]
+		if err != nil && n == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.rest)
+	b.rest = b.rest[n:]
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package secure
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func archiveFS() fstest.MapFS {
+	big := make([]byte, 3*MaxMessageSize+17)
+	rand.Read(big)
+	return fstest.MapFS{
+		"a.txt":         {Data: []byte("first")},
+		"docs/big.bin":  {Data: big},
+		"docs/empty":    {Data: nil},
+		"docs/sub/c.md": {Data: []byte("# nested")},
+		"z.txt":         {Data: []byte("last")},
+	}
+}
+
+func checkExtracted(t *testing.T, fsys fstest.MapFS, dir string) {
+	for name, f := range fsys {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, f.Data) {
+			t.Fatalf("Unexpected contents of %s", name)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fsys := archiveFS()
+	var buf bytes.Buffer
+	if err := WriteArchive(NewWriter(&buf, priv, pub), fsys, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ExtractArchive(NewReader(&buf, priv, pub), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the files plus the docs and docs/sub directories
+	if n != 7 {
+		t.Fatalf("Unexpected checkpoint: %d", n)
+	}
+	checkExtracted(t, fsys, dir)
+}
+
+func TestArchiveResume(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fsys := archiveFS()
+	var buf bytes.Buffer
+	if err := WriteArchive(NewWriter(&buf, priv, pub), fsys, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfer is cut in the middle of the big file
+	cut := bytes.NewReader(buf.Bytes()[:buf.Len()/2])
+	n, err := ExtractArchive(NewReader(cut, priv, pub), dir)
+	if err == nil {
+		t.Fatal("Expected an error from a truncated archive")
+	}
+	if n == 0 || n >= 7 {
+		t.Fatalf("Unexpected checkpoint: %d", n)
+	}
+
+	buf.Reset()
+	if err := WriteArchive(NewWriter(&buf, priv, pub), fsys, n); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = ExtractArchive(NewReader(&buf, priv, pub), dir); err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Fatalf("Unexpected checkpoint: %d", n)
+	}
+	checkExtracted(t, fsys, dir)
+}
+
+func TestArchiveFile(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fsys := archiveFS()
+	var buf bytes.Buffer
+	w, err := NewFileWriter(&buf, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteArchive(w, fsys, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFileReader(&buf, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ExtractArchive(r, dir); err != nil || n != 7 {
+		t.Fatalf("Unexpected result: %d, %v", n, err)
+	}
+	checkExtracted(t, fsys, dir)
+}
+
+func TestArchiveEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"../evil", "/etc/evil", "a/../../evil", `a\..\..\evil`, `C:\evil`} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+		tw.Write([]byte("evil"))
+		tw.Close()
+
+		if _, err := ExtractArchive(&buf, dir); err != ErrArchive {
+			t.Fatalf("Expected ErrArchive for %q, got %v", name, err)
+		}
+	}
+}
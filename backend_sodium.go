@@ -0,0 +1,71 @@
+//go:build sodium
+// +build sodium
+
+// Building with -tags sodium replaces the pure Go batch backend with
+// libsodium, for platforms where its assembly outperforms the Go
+// implementation. The wire output is identical. Only SealMany and
+// OpenMany use the backend; Reader, Writer and Conn always seal and
+// open frames in Go.
+
+package secure
+
+// #cgo pkg-config: libsodium
+// #include <sodium.h>
+import "C"
+
+import "golang.org/x/crypto/nacl/box"
+
+func init() {
+	if C.sodium_init() < 0 {
+		panic("secure: libsodium failed to initialize")
+	}
+	defaultBackend = sodiumBackend{}
+}
+
+type sodiumBackend struct{}
+
+func (sodiumBackend) sealMany(dst, msgs [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte) {
+	for i, msg := range msgs {
+		var out []byte
+		dst[i], out = sliceForAppend(dst[i], len(msg)+box.Overhead)
+		C.crypto_box_easy_afternm(uchars(out), uchars(msg), C.ulonglong(len(msg)),
+			(*C.uchar)(&nonces[i][0]), (*C.uchar)(&shared[0]))
+	}
+}
+
+func (sodiumBackend) openMany(dst, boxes [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte) bool {
+	for i, b := range boxes {
+		if len(b) < box.Overhead {
+			return false
+		}
+
+		var out []byte
+		dst[i], out = sliceForAppend(dst[i], len(b)-box.Overhead)
+		if C.crypto_box_open_easy_afternm(uchars(out), uchars(b), C.ulonglong(len(b)),
+			(*C.uchar)(&nonces[i][0]), (*C.uchar)(&shared[0])) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uchars returns a C pointer to the start of b, or nil if b is empty
+func uchars(b []byte) *C.uchar {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.uchar)(&b[0])
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice
+// and the newly added tail
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,49 @@
+//go:build sodium
+// +build sodium
+
+package secure
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestSodiumBackend(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	var shared [KeySize]byte
+	box.Precompute(&shared, pub, priv)
+
+	msgs := [][]byte{[]byte("hello world\n"), {}, bytes.Repeat([]byte{'x'}, MaxMessageSize)}
+	nonces := make([][NonceSize]byte, len(msgs))
+	for i := range nonces {
+		nonces[i][0] = byte(i)
+	}
+
+	goBoxes := make([][]byte, len(msgs))
+	naclBackend{}.sealMany(goBoxes, msgs, nonces, &shared)
+	sodiumBoxes := make([][]byte, len(msgs))
+	sodiumBackend{}.sealMany(sodiumBoxes, msgs, nonces, &shared)
+
+	for i := range msgs {
+		if !bytes.Equal(goBoxes[i], sodiumBoxes[i]) {
+			t.Fatalf("Backends disagree on message %d", i)
+		}
+	}
+
+	opened := make([][]byte, len(msgs))
+	if !(sodiumBackend{}).openMany(opened, goBoxes, nonces, &shared) {
+		t.Fatal("libsodium failed to open boxes sealed in Go")
+	}
+	for i := range msgs {
+		if !bytes.Equal(opened[i], msgs[i]) {
+			t.Fatalf("Unexpected result for message %d", i)
+		}
+	}
+
+	goBoxes[0][0] ^= 1
+	if (sodiumBackend{}).openMany(make([][]byte, len(msgs)), goBoxes, nonces, &shared) {
+		t.Fatal("libsodium opened a tampered box")
+	}
+}
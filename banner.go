@@ -0,0 +1,100 @@
+package secure
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// A Banner describes a server to its clients, for debugging and for
+// discovering what the server supports. It is sent in the server's
+// hello, so it is encrypted and authenticated like any frame.
+type Banner struct {
+	// Software names the server's software and its version,
+	// such as "myserver/1.2".
+	Software string
+
+	// MaxMessageSize is the largest message the server accepts. A
+	// server fills it in from its Config if it is zero.
+	MaxMessageSize int
+
+	// Metadata holds whatever else the server wants to tell its
+	// clients. Keys must be 1 to 255 bytes long.
+	Metadata map[string]string
+}
+
+// marshal encodes b as the software, prefixed with its length, the
+// maximum message size as a big endian uint32 and the metadata
+func (b *Banner) marshal() ([]byte, error) {
+	if len(b.Software) > 255 || b.MaxMessageSize < 0 {
+		return nil, ErrHello
+	}
+	data := append([]byte{byte(len(b.Software))}, b.Software...)
+	var max [4]byte
+	binary.BigEndian.PutUint32(max[:], uint32(b.MaxMessageSize))
+	data = append(data, max[:]...)
+	return appendMetadata(data, b.Metadata)
+}
+
+func (b *Banner) unmarshal(data []byte) error {
+	if len(data) < 1 || len(data) < 1+int(data[0])+4 {
+		return ErrHello
+	}
+	n := int(data[0])
+	b.Software = string(data[1 : 1+n])
+	b.MaxMessageSize = int(binary.BigEndian.Uint32(data[1+n:]))
+
+	var err error
+	b.Metadata, err = parseMetadata(data[1+n+4:])
+	return err
+}
+
+// appendMetadata appends the pairs of m to data in key order, each
+// key prefixed with its length as a byte and each value with its
+// length as a big endian uint16
+func appendMetadata(data []byte, m map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k, v := range m {
+		if len(k) == 0 || len(k) > 255 || len(v) > 0xffff {
+			return nil, ErrHello
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(m[k])))
+		data = append(append(data, byte(len(k))), k...)
+		data = append(append(data, size[:]...), m[k]...)
+	}
+	if len(data) > 0xffff {
+		return nil, ErrHello
+	}
+	return data, nil
+}
+
+func parseMetadata(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	m := make(map[string]string)
+	for len(data) > 0 {
+		n := int(data[0])
+		if n == 0 || len(data) < 1+n+2 {
+			return nil, ErrHello
+		}
+		k := string(data[1 : 1+n])
+		size := int(binary.BigEndian.Uint16(data[1+n:]))
+		data = data[1+n+2:]
+		if len(data) < size {
+			return nil, ErrHello
+		}
+		if _, dup := m[k]; dup {
+			return nil, ErrHello
+		}
+		m[k] = string(data[:size])
+		data = data[size:]
+	}
+	return m, nil
+}
@@ -0,0 +1,68 @@
+package secure
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBanner(t *testing.T) {
+	banner := &Banner{Software: "test/1.0", Metadata: map[string]string{"region": "eu", "features": "a,b"}}
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{Banner: banner, MaxMessageSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := *banner
+	want.MaxMessageSize = 4096
+	if got := conn.ConnectionState().PeerBanner; got == nil || !reflect.DeepEqual(*got, want) {
+		t.Fatalf("Unexpected banner: %+v", got)
+	}
+	if banner.MaxMessageSize != 0 {
+		t.Fatal("The configured banner was modified")
+	}
+}
+
+func TestBannerEncoding(t *testing.T) {
+	for _, b := range []Banner{
+		{},
+		{Software: "x", MaxMessageSize: MaxFrameMessageSize},
+		{Metadata: map[string]string{"k": "", "long": strings.Repeat("v", 1000)}},
+	} {
+		data, err := b.marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Banner
+		if err := got.unmarshal(data); err != nil || !reflect.DeepEqual(got, b) {
+			t.Fatalf("Banner %+v did not round trip: %+v, %v", b, got, err)
+		}
+		if err := got.unmarshal(data[:len(data)-1]); err != ErrHello {
+			t.Fatalf("Expected ErrHello for a truncated banner, got %v", err)
+		}
+	}
+
+	for _, b := range []Banner{
+		{Software: strings.Repeat("x", 256)},
+		{Metadata: map[string]string{"": "empty key"}},
+		{Metadata: map[string]string{"big": strings.Repeat("v", 0x10000)}},
+	} {
+		if _, err := b.marshal(); err != ErrHello {
+			t.Fatalf("Expected ErrHello for an oversized banner, got %v", err)
+		}
+	}
+}
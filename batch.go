@@ -0,0 +1,119 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A backend seals and opens batches of messages under one precomputed
+// shared key. It exists so an optimized implementation can replace the
+// pure Go one without changing SealMany and OpenMany.
+type backend interface {
+	// sealMany appends the sealed form of msgs[i] to dst[i]
+	// using nonces[i]
+	sealMany(dst, msgs [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte)
+
+	// openMany appends the opened form of boxes[i] to dst[i]
+	// and reports whether every box authenticated
+	openMany(dst, boxes [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte) bool
+}
+
+type naclBackend struct{}
+
+func (naclBackend) sealMany(dst, msgs [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte) {
+	for i := range msgs {
+		dst[i] = box.SealAfterPrecomputation(dst[i], msgs[i], &nonces[i], shared)
+	}
+}
+
+func (naclBackend) openMany(dst, boxes [][]byte, nonces [][NonceSize]byte, shared *[KeySize]byte) bool {
+	for i := range boxes {
+		var ok bool
+		if dst[i], ok = box.OpenAfterPrecomputation(dst[i], boxes[i], &nonces[i], shared); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var defaultBackend backend = naclBackend{}
+
+// SealMany encrypts each message in msgs into a complete frame, in the
+// same format a Writer emits, numbered as the frames of one stream. The
+// shared key is computed once for the whole batch and the nonces share
+// a single random prefix, which makes it much cheaper than one Writer
+// per message for message-queue style workloads where every message is
+// stored or delivered on its own.
+// priv and pub should be keys generated with box.GenerateKey
+func SealMany(msgs [][]byte, priv, pub *[KeySize]byte) ([][]byte, error) {
+	var shared [KeySize]byte
+	box.Precompute(&shared, pub, priv)
+
+	var base [NonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, base[:nonceDirOffset]); err != nil {
+		return nil, errors.New("secure: cant generate random nonce: " + err.Error())
+	}
+
+	nonces := make([][NonceSize]byte, len(msgs))
+	plains := make([][]byte, len(msgs))
+	frames := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		if len(msg) > MaxMessageSize {
+			return nil, ErrFrameTooLarge
+		}
+
+		plains[i] = make([]byte, frameTypeSize+len(msg))
+		plains[i][0] = byte(FrameData)
+		copy(plains[i][frameTypeSize:], msg)
+
+		nonces[i] = base
+		binary.BigEndian.PutUint64(nonces[i][nonceSeqOffset:], uint64(i))
+
+		frames[i] = make([]byte, HeaderSize, SealedSize(len(msg)))
+		copy(frames[i], nonces[i][:])
+		binary.LittleEndian.PutUint16(frames[i][NonceSize:], uint16(len(plains[i])+box.Overhead))
+	}
+
+	defaultBackend.sealMany(frames, plains, nonces, &shared)
+	return frames, nil
+}
+
+// OpenMany decrypts frames produced by SealMany or a Writer,
+// one frame per element. It fails with ErrDecrypt if any
+// of them fails to authenticate and with ErrFrameType if
+// any of them is not a data frame.
+// priv and pub should be keys generated with box.GenerateKey
+func OpenMany(frames [][]byte, priv, pub *[KeySize]byte) ([][]byte, error) {
+	var shared [KeySize]byte
+	box.Precompute(&shared, pub, priv)
+
+	nonces := make([][NonceSize]byte, len(frames))
+	boxes := make([][]byte, len(frames))
+	for i, frame := range frames {
+		if len(frame) < Overhead {
+			return nil, ErrFrame
+		}
+		if int(binary.LittleEndian.Uint16(frame[NonceSize:])) != len(frame)-HeaderSize {
+			return nil, ErrFrame
+		}
+
+		copy(nonces[i][:], frame)
+		boxes[i] = frame[HeaderSize:]
+	}
+
+	msgs := make([][]byte, len(frames))
+	if !defaultBackend.openMany(msgs, boxes, nonces, &shared) {
+		return nil, ErrDecrypt
+	}
+	for i := range msgs {
+		if FrameType(msgs[i][0]) != FrameData {
+			return nil, ErrFrameType
+		}
+		msgs[i] = msgs[i][frameTypeSize:]
+	}
+	return msgs, nil
+}
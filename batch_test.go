@@ -0,0 +1,51 @@
+package secure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealOpenMany(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	msgs := [][]byte{[]byte("hello world\n"), {}, bytes.Repeat([]byte{'x'}, MaxMessageSize)}
+
+	frames, err := SealMany(msgs, priv, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// frames must be readable as a stream too
+	var wire bytes.Buffer
+	for _, frame := range frames {
+		wire.Write(frame)
+	}
+	secureR := NewReader(&wire, priv, pub)
+	buf := make([]byte, MaxMessageSize)
+	for i := range msgs {
+		n, err := secureR.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msgs[i]) {
+			t.Fatalf("Unexpected result for message %d", i)
+		}
+	}
+
+	opened, err := OpenMany(frames, priv, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range msgs {
+		if !bytes.Equal(opened[i], msgs[i]) {
+			t.Fatalf("Unexpected result for message %d", i)
+		}
+	}
+
+	frames[1][HeaderSize] ^= 1
+	if _, err := OpenMany(frames, priv, pub); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt for a tampered frame, got %v", err)
+	}
+	if _, err := OpenMany([][]byte{frames[0][:HeaderSize]}, priv, pub); err != ErrFrame {
+		t.Fatalf("Expected ErrFrame for a truncated frame, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// A Command is a subcommand of an App, such as serve or bench.
+type Command struct {
+	// Name selects the command, as the first argument.
+	Name string
+
+	// Short describes the command in a line, for help.
+	Short string
+
+	// Run runs the command with the arguments following its name,
+	// writing what it reports to stdout.
+	Run func(args []string, stdout io.Writer) error
+}
+
+// An App is a command line tool made of Commands.
+type App struct {
+	// Name is the name of the tool, shown by help.
+	Name string
+
+	// Commands are the commands of the tool, in the order help
+	// lists them.
+	Commands []*Command
+
+	// Default, if not nil, runs with all the arguments when the
+	// first one names no command.
+	Default func(args []string, stdout io.Writer) error
+}
+
+// New returns the challenge2 tool, with its built-in commands and, as
+// Default, the flags of its original interface, such as -l and -via.
+func New() *App {
+	return &App{
+		Name: "challenge2",
+		Commands: []*Command{
+			{"serve", "Run an echo server", serveCommand},
+			{"dial", "Send a message to a server, or exchange one with a peer", dialCommand},
+			{"keygen", "Generate a long-term key pair", keygenCommand},
+			{"tunnel", "Forward TCP connections over secure connections", tunnelCommand},
+			{"send", "Send a directory or file", sendCommand},
+			{"recv", "Receive a directory or file", receiveCommand},
+			{"receive", "Same as recv", receiveCommand},
+			{"enc", "Encrypt a file for a public key or with a passphrase", encCommand},
+			{"dec", "Decrypt a file from enc", decCommand},
+			{"bench", "Measure throughput, latency and handshake rate", benchCommand},
+			{"soak", "Hold many connections to a server and report errors and resource usage", soakCommand},
+			{"check", "Check the health of a server", checkCommand},
+			{"keys", "Back up and restore keys", keysCommand},
+			{"discover", "Find and advertise peers on the local network", discoverCommand},
+			{"relay", "Pair peers meeting with -via", relayCommand},
+		},
+		Default: flagMode,
+	}
+}
+
+// Add adds cmds to the App, replacing those of the same names.
+func (a *App) Add(cmds ...*Command) {
+	for _, cmd := range cmds {
+		replaced := false
+		for i, c := range a.Commands {
+			if c.Name == cmd.Name {
+				a.Commands[i], replaced = cmd, true
+			}
+		}
+		if !replaced {
+			a.Commands = append(a.Commands, cmd)
+		}
+	}
+}
+
+// Lookup returns the command named name, or nil if there is none.
+func (a *App) Lookup(name string) *Command {
+	for _, c := range a.Commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Run runs the command named by the first of args, which exclude the
+// program name, with the others, or Default with all of them. The
+// help command lists the commands.
+func (a *App) Run(args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		if c := a.Lookup(args[0]); c != nil {
+			return c.Run(args[1:], stdout)
+		}
+		if args[0] == "help" {
+			a.help(stdout)
+			return nil
+		}
+	}
+	if a.Default == nil {
+		a.help(stdout)
+		return fmt.Errorf("usage: %s <command> [arguments]", a.Name)
+	}
+	return a.Default(args, stdout)
+}
+
+// help lists the commands of the App to w
+func (a *App) help(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [arguments]\n\nCommands:\n", a.Name)
+	for _, c := range a.Commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.Name, c.Short)
+	}
+}
+
+// Main runs the App with the program's arguments, and exits
+// with status 1 after logging the error if it fails.
+func (a *App) Main() {
+	if err := a.Run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse parses the flags of fs from args, adding the -config flag,
+// and then sets the others from the environment and config file,
+// so that every command can be configured the same way
+func parse(fs *flag.FlagSet, args []string) error {
+	if fs.Lookup("config") == nil {
+		fs.String("config", "", "Read the flags not given on the command line from this file")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return configure(fs)
+}
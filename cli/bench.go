@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// benchCommand runs the bench subcommand, which measures the
+// throughput, latency and handshake rate between two instances,
+// one of them serving with -l:
+//
+//	challenge2 bench -l 9000
+//	challenge2 bench -size 16k -duration 10s localhost:9000
+func benchCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var keys keyFlags
+	port := fs.Int("l", 0, "Serve benchmarks on this port")
+	size := fs.String("size", "16k", "Size of the messages echoed, with an optional k or m suffix")
+	duration := fs.Duration("duration", 10*time.Second, "How long each measurement runs")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if *port != 0 && fs.NArg() == 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		return keys.benchServe(l)
+	}
+
+	n, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+	if *port != 0 || fs.NArg() != 1 || *duration <= 0 {
+		return errors.New("usage: bench -l <port> | bench [-size n] [-duration d] <addr>")
+	}
+	return keys.bench(stdout, fs.Arg(0), n, *duration)
+}
+
+// parseSize parses a message size such as 512, 16k or 1m
+func parseSize(s string) (int, error) {
+	digits, mult := s, 1
+	switch {
+	case strings.HasSuffix(strings.ToLower(s), "k"):
+		digits, mult = s[:len(s)-1], 1<<10
+	case strings.HasSuffix(strings.ToLower(s), "m"):
+		digits, mult = s[:len(s)-1], 1<<20
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n <= 0 || n > secure.MaxMessageSize/mult {
+		return 0, fmt.Errorf("invalid size %q: it must be between 1 and %d bytes", s, secure.MaxMessageSize)
+	}
+	return n * mult, nil
+}
+
+// benchServe echoes every message of every connection on l
+func (keys keyFlags) benchServe(l net.Listener) error {
+	pub, priv, err := keys.keyPair()
+	if err != nil {
+		return err
+	}
+	info, err := keys.keyInfo()
+	if err != nil {
+		return err
+	}
+
+	srv := &secure.Server{
+		Config: &secure.Config{
+			PrivateKey:  priv,
+			PublicKey:   pub,
+			PairingCode: []byte(keys.code),
+			KeyInfo:     info,
+		},
+		Handler: secure.HandlerFunc(func(c *secure.Conn) {
+			var buf [secure.MaxMessageSize]byte
+			for {
+				n, err := c.Read(buf[:])
+				if err != nil {
+					return
+				}
+				if _, err := c.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+		}),
+	}
+	return srv.Serve(l)
+}
+
+// bench measures the server at addr, echoing messages of size bytes
+// on one connection and then handshaking new connections, each for
+// duration, and prints the results to w
+func (keys keyFlags) bench(w io.Writer, addr string, size int, duration time.Duration) error {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := secure.Dial("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, size)
+	buf := make([]byte, secure.MaxMessageSize)
+	var rtts []time.Duration
+	start := time.Now()
+	for time.Since(start) < duration {
+		t := time.Now()
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		rtts = append(rtts, time.Since(t))
+	}
+	elapsed := time.Since(start)
+
+	// every message crosses the connection twice
+	mbps := float64(2*size*len(rtts)) / elapsed.Seconds() / 1e6
+	fmt.Fprintf(w, "Throughput: %.2f MB/s (%d round trips of %d bytes)\n", mbps, len(rtts), size)
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	fmt.Fprintf(w, "Latency: p50 %v, p90 %v, p99 %v, max %v\n",
+		percentile(rtts, 50), percentile(rtts, 90), percentile(rtts, 99), rtts[len(rtts)-1])
+
+	handshakes := 0
+	start = time.Now()
+	for time.Since(start) < duration {
+		c, err := secure.Dial("tcp", addr, config)
+		if err != nil {
+			return err
+		}
+		c.Close()
+		handshakes++
+	}
+	fmt.Fprintf(w, "Handshakes: %.1f/s\n", float64(handshakes)/time.Since(start).Seconds())
+	return nil
+}
+
+// percentile returns the p-th percentile of the sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := len(sorted) * p / 100
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// checkCommand runs the check subcommand, which connects to a server,
+// completes the handshake and pings it, and reports the latency of
+// both, the negotiated suite and the server's key fingerprint. With
+// -count it pings several times and reports the smoothed round trip
+// time along with the range. It fails if any step does, so that it can
+// serve as a health probe:
+//
+//	challenge2 check -timeout 2s -count 5 localhost:9000
+func checkCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	var keys keyFlags
+	timeout := fs.Duration("timeout", 5*time.Second, "Fail if the check takes longer than this")
+	count := fs.Int("count", 1, "Number of pings to send")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the server")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *timeout <= 0 || *count < 1 {
+		return errors.New("usage: check [-timeout d] [-count n] [-key file] [-code code] <addr>")
+	}
+	return keys.check(stdout, fs.Arg(0), *timeout, *count)
+}
+
+// check runs the health check against the server at addr, pinging it
+// count times
+func (keys keyFlags) check(stdout io.Writer, addr string, timeout time.Duration, count int) error {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	nc, err := (&secure.Dialer{Timeout: timeout, Config: config}).Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	conn := nc.(*secure.Conn)
+	defer conn.Close()
+	handshake := time.Since(start)
+
+	conn.SetDeadline(start.Add(timeout))
+	var min, max time.Duration
+	for i := 0; i < count; i++ {
+		rtt, err := conn.Ping()
+		if err != nil {
+			return err
+		}
+		if i == 0 || rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+	}
+
+	state := conn.ConnectionState()
+	fmt.Fprintf(stdout, "handshake   %v\n", handshake.Round(time.Microsecond))
+	if count == 1 {
+		fmt.Fprintf(stdout, "ping        %v\n", state.RTT.Round(time.Microsecond))
+	} else {
+		fmt.Fprintf(stdout, "ping        %v (%v-%v over %d)\n", state.RTT.Round(time.Microsecond),
+			min.Round(time.Microsecond), max.Round(time.Microsecond), count)
+	}
+	fmt.Fprintf(stdout, "suite       %v\n", state.Suite)
+	fmt.Fprintf(stdout, "fingerprint %s\n", secure.Fingerprint(state.PeerPublicKey))
+	if b := state.PeerBanner; b != nil {
+		fmt.Fprintf(stdout, "software    %s\n", b.Software)
+		fmt.Fprintf(stdout, "max message %d\n", b.MaxMessageSize)
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+// Package cli implements the challenge2 command, so that other programs
+// can embed it, or extend it with commands of their own:
+//
+//	app := cli.New()
+//	app.Add(&cli.Command{
+//		Name:  "audit",
+//		Short: "Audit the key files",
+//		Run: func(args []string, stdout io.Writer) error {
+//			fmt.Fprintln(stdout, "audited", len(args), "files")
+//			return nil
+//		},
+//	})
+//	app.Main()
+//
+// Every command reads the flags it was not given on the command line
+// from the environment, such as CHALLENGE2_KEY for -key, and then from
+// the file given with -config, which holds one flag per line:
+//
+//	key = "/etc/challenge2/server.key"
+//	code: 7-crossover-clockwork
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// keyFlags holds the -key and -code flags, which every command
+// connecting to a peer has. Each invocation parses its own, so that
+// commands can run concurrently.
+type keyFlags struct {
+	keyFile, code string
+}
+
+// Dial generates a private/public key pair,
+// connects to the server, perform the handshake
+// and return a reader/writer.
+func (keys keyFlags) dial(addr string) (io.ReadWriteCloser, error) {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return secure.Dial("tcp", addr, config)
+}
+
+// clientConfig returns the configuration
+// given by the flags for connecting to a server
+func (keys keyFlags) clientConfig() (*secure.Config, error) {
+	config := &secure.Config{PairingCode: []byte(keys.code)}
+
+	if keys.keyFile != "" {
+		pub, priv, err := keys.keyPair()
+		if err != nil {
+			return nil, err
+		}
+		config.PublicKey, config.PrivateKey = pub, priv
+		if config.KeyInfo, err = keys.keyInfo(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// rendezvous meets the peer holding the same pairing code through
+// the relay at addr and pairs with it end-to-end, so the relay only
+// ever forwards ciphertext.
+// The part of the -code before the first dash names the channel.
+func (keys keyFlags) rendezvous(addr string) (*secure.Conn, error) {
+	code := keys.code
+	i := strings.Index(code, "-")
+	if i <= 0 {
+		return nil, fmt.Errorf("pairing code %q must look like <channel>-<words>", code)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	initiator, err := secure.Rendezvous(conn, code[:i])
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	config := &secure.Config{PairingCode: []byte(code)}
+	if keys.keyFile != "" {
+		if config.PublicKey, config.PrivateKey, err = keys.keyPair(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if config.KeyInfo, err = keys.keyInfo(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	secCon := secure.NewServerConn(conn, config)
+	if initiator {
+		secCon = secure.NewClientConn(conn, config)
+	}
+
+	if err := secCon.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return secCon, nil
+}
+
+// serverConfig returns the configuration
+// given by the flags for serving clients
+func (keys keyFlags) serverConfig() (*secure.Config, error) {
+	pub, priv, err := keys.keyPair()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := keys.keyInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &secure.Config{
+		PrivateKey:  priv,
+		PublicKey:   pub,
+		PairingCode: []byte(keys.code),
+		KeyInfo:     info,
+		Banner:      &secure.Banner{Software: "challenge2"},
+	}, nil
+}
+
+// Serve starts a secure echo server on the given listener.
+func (keys keyFlags) serve(l net.Listener) error {
+	config, err := keys.serverConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &secure.Server{
+		Config:  config,
+		Handler: secure.HandlerFunc(handleConnection),
+	}
+	return srv.Serve(l)
+}
+
+func handleConnection(c *secure.Conn) {
+	// echo
+	var buf [secure.MaxMessageSize]byte
+	n, err := c.Read(buf[:])
+	if err == io.EOF {
+		// closed without a message, such as by check
+		return
+	}
+	if err != nil {
+		log.Printf("Serve: cant read message: %v", err)
+		return
+	}
+	// write back message
+	if _, err := c.Write(buf[:n]); err != nil {
+		log.Printf("Serve: cant write message: %v", err)
+		return
+	}
+}
+
+// exchange sends the message in args to a paired peer,
+// or prints the one it receives if there is none.
+func exchange(conn io.ReadWriter, args []string, stdout io.Writer) error {
+	if len(args) == 1 {
+		_, err := conn.Write([]byte(args[0]))
+		return err
+	}
+
+	var buf [secure.MaxMessageSize]byte
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\n", buf[:n])
+	return nil
+}
+
+// serveCommand runs the serve subcommand, which echoes the first
+// message of every connection like the -l flag, as a daemon:
+//
+//	challenge2 serve -key server.key -pidfile /run/challenge2.pid 9000
+//
+// SIGTERM and SIGINT shut it down gracefully, and SIGHUP reloads the
+// key file, the environment and the -config file for new connections.
+// On Windows, it can run as a service instead, installed with -service
+// and its name among the arguments; the stop control then shuts it
+// down and the paramchange control reloads it.
+func serveCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var keys keyFlags
+	pidFile, grace, service := serveFlags(fs, &keys)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *grace < 0 {
+		return errors.New("usage: serve [-key file] [-code code] [-pidfile file] [-grace d] [-service name] <port>")
+	}
+	port, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || port <= 0 {
+		return fmt.Errorf("invalid port %q", fs.Arg(0))
+	}
+
+	config, err := keys.serverConfig()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Printf("Serving on %s", secure.FormatURI(publicAddr(l.Addr()), config.PublicKey))
+
+	d := &daemon{
+		srv: &secure.Server{
+			Config:  config,
+			Handler: secure.HandlerFunc(handleConnection),
+		},
+		pidFile: *pidFile,
+		grace:   *grace,
+		reload: func() (*secure.Config, error) {
+			fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			var keys keyFlags
+			serveFlags(fs, &keys)
+			if err := parse(fs, args); err != nil {
+				return nil, err
+			}
+			return keys.serverConfig()
+		},
+	}
+	run := d.run
+	if *service != "" {
+		run = func(l net.Listener) error {
+			return runService(*service, func(controls <-chan control) error {
+				d.controls = controls
+				return d.run(l)
+			})
+		}
+	}
+	if err := run(l); err != secure.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// publicAddr returns the address for clients to dial a server
+// listening on addr, naming this host if it listens on all of them
+func publicAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			host = "localhost"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// serveFlags defines the flags of the serve subcommand on fs,
+// parsing -key and -code into keys
+func serveFlags(fs *flag.FlagSet, keys *keyFlags) (pidFile *string, grace *time.Duration, service *string) {
+	pidFile = fs.String("pidfile", "", "Write the process ID to this file while serving")
+	grace = fs.Duration("grace", 30*time.Second, "How long shutting down waits for connections to be served")
+	service = fs.String("service", "", "Run as the Windows service of this name")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the clients")
+	return pidFile, grace, service
+}
+
+// dialCommand runs the dial subcommand, which sends a message to a
+// server and prints its reply, or exchanges one with a peer through a
+// relay, sending it if given and otherwise printing the peer's:
+//
+//	challenge2 dial localhost:9000 hello
+//	challenge2 dial -via relay.example.com:9000 -code 7-crossover-clockwork hello
+//	challenge2 dial -pair secure-pair://relay.example.com:9000/...
+func dialCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("dial", flag.ContinueOnError)
+	var keys keyFlags
+	via := fs.String("via", "", "Meet the peer holding the same -code through the relay at this address")
+	pairWith := fs.String("pair", "", "Pair through the relay named by a "+pairScheme+" URI and check the peer's fingerprint")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *pairWith != "" && *via == "" && fs.NArg() <= 1:
+		conn, err := keys.pair(*pairWith)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return exchange(conn, fs.Args(), stdout)
+	case *via != "" && *pairWith == "" && fs.NArg() <= 1:
+		conn, err := keys.rendezvous(*via)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return exchange(conn, fs.Args(), stdout)
+	case *via == "" && *pairWith == "" && fs.NArg() == 2:
+		return keys.send(fs.Arg(0), fs.Arg(1), stdout)
+	}
+	return errors.New("usage: dial <addr> <message> | dial -via <relay> -code <code> [message] | dial -pair <uri> [message]")
+}
+
+// send sends msg to the server at addr and prints its reply
+func (keys keyFlags) send(addr, msg string, stdout io.Writer) error {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return err
+	}
+	resp, err := secure.Send(addr, []byte(msg), &secure.SendOptions{Config: config})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\n", resp)
+	return nil
+}
+
+// flagMode runs the original interface of challenge2, whose
+// flags select what it does
+func flagMode(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("challenge2", flag.ContinueOnError)
+	var keys keyFlags
+	port := fs.Int("l", 0, "Listen mode. Specify port")
+	relayPort := fs.Int("r", 0, "Relay mode. Specify port")
+	via := fs.String("via", "", "Meet the peer holding the same -code through the relay at this address")
+	keygenFile := fs.String("keygen", "", "Write a new private key to this file and its public key to the file plus .pub")
+	expires := fs.Duration("expires", 0, "With -keygen, make the key expire after this long")
+	conformanceAddr := fs.String("conformance", "", "Probe the echo server at this address for conformance with the wire format")
+	pubkey := fs.Bool("pubkey", false, "Print the fingerprint and encoding of the -key public key, and with -via and -code the URI and QR code for a peer to pair with it")
+	pairWith := fs.String("pair", "", "Pair through the relay named by a "+pairScheme+" URI and check the peer's fingerprint")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer instead of trusting the exchanged keys")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	// Public key mode
+	if *pubkey {
+		if keys.keyFile == "" {
+			return errors.New("usage: -pubkey -key <file> [-via <relay> -code <pairing code>]")
+		}
+		pub, _, err := keys.keyPair()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, secure.Fingerprint(pub))
+		fmt.Fprintln(stdout, secure.FormatPublicKey(pub, secure.KeyBech32))
+		if *via != "" && keys.code != "" {
+			uri := pairURI(*via, keys.code, pub)
+			code, err := pairQR(uri)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, uri)
+			fmt.Fprint(stdout, code)
+		}
+		return nil
+	}
+
+	// Pairing mode: the URI replaces -via and -code
+	if *pairWith != "" {
+		if fs.NArg() > 1 {
+			return errors.New("usage: -pair <uri> [message]")
+		}
+		conn, err := keys.pair(*pairWith)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return exchange(conn, fs.Args(), stdout)
+	}
+
+	// Conformance mode
+	if *conformanceAddr != "" {
+		return conformance(stdout, *conformanceAddr)
+	}
+
+	// Key generation mode
+	if *keygenFile != "" {
+		return keygen(*keygenFile, *expires)
+	}
+
+	// Server mode
+	if *port != 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		return keys.serve(l)
+	}
+
+	// Relay mode
+	if *relayPort != 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *relayPort))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		return relay(l, 0)
+	}
+
+	// Rendezvous mode: send a message if one is given, otherwise receive one
+	if *via != "" {
+		if fs.NArg() > 1 {
+			return errors.New("usage: -via <relay> -code <pairing code> [message]")
+		}
+		conn, err := keys.rendezvous(*via)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return exchange(conn, fs.Args(), stdout)
+	}
+
+	// Client mode
+	if fs.NArg() != 2 {
+		return errors.New("usage: [-code <pairing code>] <port> <message>")
+	}
+	return keys.send("localhost:"+fs.Arg(0), fs.Arg(1), stdout)
+}
@@ -0,0 +1,1050 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/jboverfelt/secure"
+)
+
+func TestSecureEchoServer(t *testing.T) {
+	// Create a random listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Start the server
+	go keyFlags{}.serve(l)
+
+	conn, err := keyFlags{}.dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expected := "hello world\n"
+	if _, err := fmt.Fprint(conn, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buf[:n]); got != expected {
+		t.Fatalf("Unexpected result:\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
+	}
+}
+
+func TestSecureServe(t *testing.T) {
+	// Create a random listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Start the server
+	go keyFlags{}.serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unexpected := "hello world\n"
+	if _, err := fmt.Fprint(conn, unexpected); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got == unexpected {
+		t.Fatalf("Unexpected result:\nGot raw data instead of serialized key")
+	}
+}
+
+func TestSecureDial(t *testing.T) {
+	// Create a random listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Start the server
+	go func(l net.Listener) {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				// write server key
+				key := [32]byte{}
+				c.Write(key[:])
+				// read client's key
+				keyBuf := make([]byte, 32)
+				_, err := io.ReadFull(c, keyBuf)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// skip the client hello, then read the message
+				var enc []byte
+				for i := 0; i < 2; i++ {
+					// read nonce
+					var nonce [24]byte
+					if _, err := io.ReadFull(c, nonce[:]); err != nil {
+						t.Error(err)
+						return
+					}
+
+					// Read the ciphertext size
+					var size uint16
+					if err := binary.Read(c, binary.LittleEndian, &size); err != nil {
+						t.Error(err)
+						return
+					}
+
+					// make a buffer large enough to handle
+					// the overhead associated with an encrypted message
+					enc = make([]byte, size)
+					if _, err := io.ReadFull(c, enc); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+
+				if got := string(enc); got == "hello world\n" {
+					t.Error("Unexpected result. Got raw data instead of encrypted")
+				}
+			}(conn)
+		}
+	}(l)
+
+	conn, err := keyFlags{}.dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expected := "hello world\n"
+	if _, err := fmt.Fprint(conn, expected); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecureEchoServerPairing(t *testing.T) {
+	keys := keyFlags{code: "7-crossover-clockwork"}
+
+	// Create a random listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Start the server
+	go keys.serve(l)
+
+	conn, err := keys.dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expected := "hello world\n"
+	if _, err := fmt.Fprint(conn, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buf[:n]); got != expected {
+		t.Fatalf("Unexpected result:\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
+	}
+}
+
+func TestRendezvous(t *testing.T) {
+	// Create a random listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Start the relay
+	go relay(l, 0)
+
+	expected := "hello world\n"
+	go func() {
+		conn, err := keyFlags{code: "7-crossover-clockwork"}.rendezvous(l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if _, err := fmt.Fprint(conn, expected); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := keyFlags{code: "7-crossover-clockwork"}.rendezvous(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buf[:n]); got != expected {
+		t.Fatalf("Unexpected result:\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
+	}
+}
+
+func TestKeygen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keygen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.key")
+	if err := keygen(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := keyFlags{keyFile: path}
+	pub, _, err := keys.keyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePub, err := secure.DecodePublicKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *filePub != *pub {
+		t.Fatal("Unexpected result. The public key file does not match the private key.")
+	}
+	if info, err := keys.keyInfo(); err != nil || info.ID != secure.KeyID(pub) {
+		t.Fatalf("Unexpected key info: %+v, %v", info, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go keyFlags{}.serve(l)
+
+	var report bytes.Buffer
+	if err := conformance(&report, l.Addr().String()); err != nil {
+		t.Fatalf("%v\n%s", err, report.String())
+	}
+}
+
+func TestPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key")
+	if err := keygen(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	keys := keyFlags{keyFile: path}
+	pub, _, err := keys.keyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go new(secure.Relay).Serve(l)
+
+	uri := pairURI(l.Addr().String(), "9-alpha-bravo", pub)
+	via, code, _, err := parsePairURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if via != l.Addr().String() || code != "9-alpha-bravo" {
+		t.Fatalf("Unexpected pairing URI: %s", uri)
+	}
+
+	// -pubkey shows the URI along with its QR code
+	var out bytes.Buffer
+	if err := flagMode([]string{"-pubkey", "-key", path, "-via", via, "-code", code}, &out); err != nil {
+		t.Fatal(err)
+	}
+	qr, err := pairQR(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), uri+"\n"+qr) {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+
+	// the side showing the URI waits at the relay
+	show := func() {
+		shower := keys
+		shower.code = code
+		conn, err := shower.rendezvous(via)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1))
+	}
+
+	go show()
+	conn, err := keys.pair(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	go show()
+	other := strings.Replace(uri, "fp=", "fp=0", 1)
+	if _, err := keys.pair(other); err != secure.ErrFingerprint {
+		t.Fatalf("Expected ErrFingerprint, got %v", err)
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.key")
+	if err := keygen(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := keysCommand([]string{"split", "-n", "3", "-k", "2", path}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := filepath.Join(dir, "restored.key")
+	if err := keysCommand([]string{"combine", "-o", restored, path + ".share3", path + ".share1"}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	priv, _, err := secure.LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := secure.LoadKeyFile(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *priv {
+		t.Fatal("Unexpected result. The shares recovered another key.")
+	}
+
+	if err := keysCommand([]string{"combine", "-o", restored, path + ".share2"}, ioutil.Discard); err != secure.ErrKeyShare {
+		t.Fatalf("Expected ErrKeyShare, got %v", err)
+	}
+}
+
+func TestKeysMnemonic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mnemonic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.key")
+	var phrase bytes.Buffer
+	if err := keysCommand([]string{"mnemonic", path}, &phrase); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(phrase.String())); n != 24 {
+		t.Fatalf("Unexpected mnemonic length: %d words", n)
+	}
+
+	restored := filepath.Join(dir, "restored.key")
+	if err := recoverKey(restored, &phrase); err != nil {
+		t.Fatal(err)
+	}
+	priv, _, err := secure.LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := secure.LoadKeyFile(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *priv {
+		t.Fatal("Unexpected result. The mnemonic recovered another key.")
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	src, err := ioutil.TempDir("", "send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"a.txt": "hello", "sub/b.txt": "world"}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(dir string) {
+		for name, data := range files {
+			got, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != data {
+				t.Fatalf("Unexpected contents of %s: %q", name, got)
+			}
+		}
+	}
+
+	// over a connection
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	dst := filepath.Join(src, "..", filepath.Base(src)+".out")
+	defer os.RemoveAll(dst)
+	done := make(chan error, 1)
+	var out bytes.Buffer
+	go func() { done <- keyFlags{}.receive(l, dst, &out) }()
+
+	if err := sendCommand([]string{"-r", src, l.Addr().String()}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Received 3 entries\n" {
+		t.Fatalf("Unexpected output: %q", got)
+	}
+	check(dst)
+
+	// to an encrypted file
+	keys, err := ioutil.TempDir("", "sendkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keys)
+	key := filepath.Join(keys, "peer.key")
+	if err := keygen(key, 0); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(keys, "archive.sec")
+	if err := sendCommand([]string{"-r", src, "-o", archive, "-to", key + ".pub"}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	fromFile := filepath.Join(keys, "out")
+	if err := receiveCommand([]string{"-d", fromFile, "-i", archive, "-key", key}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	check(fromFile)
+}
+
+func TestSendReceiveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a previous transfer was interrupted
+	if err := ioutil.WriteFile(dst, data[:4000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan error, 1)
+	var out bytes.Buffer
+	go func() { done <- keyFlags{}.receiveOne(l, dst, &out) }()
+
+	if err := sendCommand([]string{"-f", src, l.Addr().String()}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Resumed at 4000 bytes\n" {
+		t.Fatalf("Unexpected output: %q", got)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Unexpected result. The received file differs.")
+	}
+}
+
+func TestDiscoverAnswer(t *testing.T) {
+	a := advertiser{"laptop", 9000, "1a2b-3c4d"}
+	resp := a.answer(mdnsQuery())
+	if resp == nil {
+		t.Fatal("Expected an answer to a query for the service")
+	}
+	if a.answer(resp) != nil {
+		t.Fatal("Unexpected answer to a response")
+	}
+
+	peers := parsePeers(resp, net.IPv4(192, 168, 1, 7))
+	if len(peers) != 1 {
+		t.Fatalf("Unexpected peers: %+v", peers)
+	}
+	if p := peers[0]; p.name != "laptop" || p.addr != "192.168.1.7:9000" || p.fingerprint != "1a2b-3c4d" {
+		t.Fatalf("Unexpected peer: %+v", p)
+	}
+
+	// other responders compress names
+	name := appendDNSName(nil, mdnsService)
+	msg := append([]byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0}, name...)
+	if got, next, err := readDNSName(append(msg, 0xc0, 12), len(msg)); err != nil || got != mdnsService || next != len(msg)+2 {
+		t.Fatalf("Unexpected compressed name: %q, %d, %v", got, next, err)
+	}
+	if _, _, err := readDNSName([]byte{0xc0, 0}, 0); err != errDNS {
+		t.Fatalf("Expected errDNS for a pointer loop, got %v", err)
+	}
+}
+
+func TestBench(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go keyFlags{}.benchServe(l)
+
+	var out bytes.Buffer
+	if err := (keyFlags{}).bench(&out, l.Addr().String(), 16<<10, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Throughput: ", "Latency: p50 ", "Handshakes: "} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Missing %q in output:\n%s", want, out.String())
+		}
+	}
+
+	for s, want := range map[string]int{"512": 512, "16k": 16 << 10, "32K": 32 << 10} {
+		if n, err := parseSize(s); err != nil || n != want {
+			t.Fatalf("parseSize(%q) = %d, %v", s, n, err)
+		}
+	}
+	for _, s := range []string{"", "0", "1m", "33k", "x"} {
+		if _, err := parseSize(s); err == nil {
+			t.Fatalf("parseSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSoak(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go keyFlags{}.benchServe(l)
+
+	res, err := keyFlags{}.soak(l.Addr().String(), 5, 1000, 100*time.Millisecond, []int{64, 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.opened != 5 || res.peakActive == 0 || res.messages == 0 || res.errorRate() != 0 {
+		t.Fatalf("Unexpected soak result %+v", res)
+	}
+	var out bytes.Buffer
+	res.report(&out)
+	for _, want := range []string{"Connections: 5 opened, 0 failed", "Error rate: 0.00%", "Peak usage: "} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Missing %q in output:\n%s", want, out.String())
+		}
+	}
+
+	// an unreachable server fails every connection
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	res, err = keyFlags{}.soak(closed.Addr().String(), 2, 1000, 10*time.Millisecond, []int{64})
+	if err != nil || res.dialFailed != 2 || res.errorRate() != 100 {
+		t.Fatalf("Unexpected soak result %+v, %v", res, err)
+	}
+
+	for s, want := range map[string]float64{"100/s": 100, "60/m": 1, "2.5": 2.5} {
+		if r, err := parseRate(s); err != nil || r != want {
+			t.Fatalf("parseRate(%q) = %v, %v", s, r, err)
+		}
+	}
+	for _, s := range []string{"", "0/s", "-1", "x/m"} {
+		if _, err := parseRate(s); err == nil {
+			t.Fatalf("parseRate(%q) succeeded", s)
+		}
+	}
+	if err := soakCommand([]string{"-conns", "0", "localhost:1"}, ioutil.Discard); err == nil {
+		t.Fatal("Expected a usage error")
+	}
+}
+
+func TestRelayCommand(t *testing.T) {
+	for _, args := range [][]string{nil, {"x"}, {"-wait", "1m"}, {"1", "2"}} {
+		if err := relayCommand(args, ioutil.Discard); err == nil {
+			t.Fatalf("relayCommand(%q) succeeded", args)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go keyFlags{}.serve(l)
+
+	var out bytes.Buffer
+	if err := checkCommand([]string{"-timeout", "5s", l.Addr().String()}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"handshake ", "ping ", "suite       box\n", "fingerprint ", "software    challenge2\n", "max message 32768\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Missing %q in output:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := checkCommand([]string{"-count", "3", l.Addr().String()}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), " over 3)\n") {
+		t.Fatalf("Missing ping range in output:\n%s", out.String())
+	}
+
+	l.Close()
+	if err := (keyFlags{}).check(&out, l.Addr().String(), time.Second, 1); err == nil {
+		t.Fatal("check of a closed server succeeded")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "challenge2.toml")
+	err = ioutil.WriteFile(path, []byte(`# comment
+port = 9000
+code = "from \"file\"" # say "hi"
+via: relay.example.com:7000
+name = 'literal # not a comment'
+verbose = true
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	port := fs.Int("port", 0, "")
+	code := fs.String("code", "", "")
+	via := fs.String("via", "", "")
+	name := fs.String("name", "", "")
+	verbose := fs.Bool("verbose", false, "")
+	if err := fs.Parse([]string{"-config", path, "-port", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(envPrefix+"VIA", "relay.example.org:7000")
+	defer os.Unsetenv(envPrefix + "VIA")
+
+	if err := configure(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 1 {
+		t.Errorf("port = %d, want the command line's 1", *port)
+	}
+	if *via != "relay.example.org:7000" {
+		t.Errorf("via = %q, want the environment's", *via)
+	}
+	if *code != `from "file"` || *name != "literal # not a comment" || !*verbose {
+		t.Errorf("code = %q, name = %q, verbose = %v", *code, *name, *verbose)
+	}
+
+	for _, value := range []string{`"unterminated`, `"a\"`, `"a" "b"`, `'a' b`} {
+		if _, err := unquote(value); err == nil {
+			t.Errorf("unquote(%s) succeeded", value)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte("unknown = 1\n"), 0600)
+	if err := configure(fs); err == nil || !strings.Contains(err.Error(), ":1: unknown setting") {
+		t.Fatalf("unknown setting: %v", err)
+	}
+	ioutil.WriteFile(path, []byte("port = x\n"), 0600)
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", path, "")
+	fs.Int("port", 0, "")
+	if err := configure(fs); err == nil {
+		t.Fatal("invalid value accepted")
+	}
+}
+
+func TestApp(t *testing.T) {
+	app := New()
+	var ran []string
+	app.Add(&Command{Name: "bench", Run: func(args []string, stdout io.Writer) error {
+		ran = append(ran, "bench "+strings.Join(args, " "))
+		return nil
+	}}, &Command{Name: "audit", Short: "Audit things", Run: func(args []string, stdout io.Writer) error {
+		ran = append(ran, "audit")
+		return nil
+	}})
+	app.Default = func(args []string, stdout io.Writer) error {
+		ran = append(ran, "default "+strings.Join(args, " "))
+		return nil
+	}
+
+	for _, args := range [][]string{{"bench", "-size", "1k"}, {"audit"}, {"-l", "9000"}, nil} {
+		if err := app.Run(args, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"bench -size 1k", "audit", "default -l 9000", "default "}
+	if fmt.Sprint(ran) != fmt.Sprint(want) {
+		t.Fatalf("ran %q, want %q", ran, want)
+	}
+
+	var out bytes.Buffer
+	if err := app.Run([]string{"help"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"serve ", "tunnel ", "audit      Audit things"} {
+		if !strings.Contains(out.String(), name) {
+			t.Fatalf("help lacks %q:\n%s", name, out.String())
+		}
+	}
+
+	app.Default = nil
+	if err := app.Run([]string{"nope"}, ioutil.Discard); err == nil {
+		t.Fatal("unknown command succeeded")
+	}
+}
+
+func TestDialCommand(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go keyFlags{}.serve(l)
+
+	var out bytes.Buffer
+	if err := New().Run([]string{"dial", l.Addr().String(), "hello"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Fatalf("dial printed %q", out.String())
+	}
+	if err := dialCommand([]string{"-via", "x", "-pair", "y"}, &out); err == nil {
+		t.Fatal("-via with -pair accepted")
+	}
+
+	// serve pins a key of its own
+	other, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := secure.FormatURI(publicAddr(l.Addr()), other)
+	if err := dialCommand([]string{uri, "hello"}, &out); err != secure.ErrFingerprint {
+		t.Fatalf("Expected ErrFingerprint dialing %s, got %v", uri, err)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		// echo everything, then answer the half-close
+		io.Copy(c, c)
+		io.WriteString(c, "bye")
+	}()
+
+	in, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	go keyFlags{}.tunnelIn(in, target.Addr().String())
+
+	out, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	go keyFlags{}.tunnelOut(out, in.Addr().String())
+
+	c, err := net.Dial("tcp", out.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	msg := bytes.Repeat([]byte("tunneled "), 10000)
+	go func() {
+		c.Write(msg)
+		c.(*net.TCPConn).CloseWrite()
+	}()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	got, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(msg, "bye"...); !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes back, want %d", len(got), len(want))
+	}
+
+	if err := tunnelCommand([]string{"-l", "1", "-serve", "2", "addr"}, ioutil.Discard); err == nil {
+		t.Fatal("-l with -serve accepted")
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "challenge2.pid")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	keys := make([]*[secure.KeySize]byte, 2)
+	configs := make([]*secure.Config, 2)
+	for i := range configs {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], configs[i] = pub, &secure.Config{PublicKey: pub, PrivateKey: priv}
+	}
+	controls := make(chan control)
+	d := &daemon{
+		srv:      &secure.Server{Config: configs[0], Handler: secure.HandlerFunc(handleConnection)},
+		pidFile:  pidFile,
+		grace:    time.Second,
+		reload:   func() (*secure.Config, error) { return configs[1], nil },
+		controls: controls,
+	}
+	done := make(chan error, 1)
+	go func() { done <- d.run(l) }()
+
+	peer := func() *[secure.KeySize]byte {
+		t.Helper()
+		conn, err := secure.Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		pub, err := conn.PeerPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+
+	if *peer() != *keys[0] {
+		t.Fatal("not served with the initial key")
+	}
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("PID file holds %q, %v", data, err)
+	}
+
+	controls <- controlReload
+	if *peer() != *keys[1] {
+		t.Fatal("not served with the reloaded key")
+	}
+
+	controls <- controlStop
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Fatal("PID file left behind")
+	}
+
+	ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())), 0644)
+	if err := writePIDFile(pidFile); err == nil {
+		t.Fatal("PID file of a running process overwritten")
+	}
+}
+
+func TestSignalControls(t *testing.T) {
+	controls, stop := signalControls()
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range reloadSignals {
+		if err := p.Signal(sig); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ctl := <-controls:
+			if ctl != controlReload {
+				t.Fatalf("%v delivered as %v", sig, ctl)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v not delivered", sig)
+		}
+	}
+
+	if err := runService("challenge2", nil); runtime.GOOS != "windows" && err != errNoService {
+		t.Fatalf("runService = %v, want errNoService", err)
+	}
+}
+
+func TestEncDec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plain := filepath.Join(dir, "notes.txt")
+	data := bytes.Repeat([]byte("secret notes\n"), 10000)
+	if err := ioutil.WriteFile(plain, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(dir, "peer.key")
+	if err := keygen(key, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, pub, err := secure.LoadKeyFile(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passFile := filepath.Join(dir, "pass")
+	if err := ioutil.WriteFile(passFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrongFile := filepath.Join(dir, "wrong")
+	if err := ioutil.WriteFile(wrongFile, []byte("wrong horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, dec := filepath.Join(dir, "notes.sec"), filepath.Join(dir, "notes.out")
+	// the passphrase case comes last, leaving its file for the checks below
+	for _, tc := range []struct {
+		name  string
+		flags [2][]string
+	}{
+		{"public key", [2][]string{{"-to", key + ".pub"}, {"-key", key}}},
+		{"pasted key", [2][]string{{"-to", secure.FormatPublicKey(pub, secure.KeyBase58)}, {"-key", key}}},
+		{"passphrase", [2][]string{{"-p", "-passfile", passFile, "-memory", "64", "-time", "1"}, {"-p", "-passfile", passFile}}},
+	} {
+		name, flags := tc.name, tc.flags
+		if err := encCommand(append(flags[0], "-o", enc, plain), ioutil.Discard); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var out bytes.Buffer
+		if err := decCommand(append(flags[1], enc), &out); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: unexpected result. The file did not survive the round trip.", name)
+		}
+	}
+
+	// a wrong passphrase leaves no output behind
+	err = decCommand([]string{"-p", "-passfile", wrongFile, "-o", dec, enc}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("Expected a wrong passphrase error, got %v", err)
+	}
+	if _, err := os.Stat(dec); !os.IsNotExist(err) {
+		t.Fatalf("Expected the output to be removed, got %v", err)
+	}
+
+	if err := encCommand([]string{"-p", "-to", key + ".pub", plain}, ioutil.Discard); err == nil {
+		t.Fatal("Expected a usage error for both -p and -to")
+	}
+}
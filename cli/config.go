@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables
+// overriding flags, such as CHALLENGE2_KEY for -key
+const envPrefix = "CHALLENGE2_"
+
+// configure sets the flags of fs not given on the command line from
+// the environment and then from the config file named by the -config
+// flag of fs, if any, so that the command line takes precedence over
+// the environment, which takes precedence over the file.
+//
+// The file holds one flag per line, named as on the command line
+// without the dash, in either of the flat forms of TOML and YAML:
+//
+//	# serve on 9000 with a long-term key
+//	l = 9000
+//	key = "/etc/challenge2/server.key"
+//	code: pairing-code
+//
+// Values may be quoted as Go strings; tables, lists and nesting are
+// not supported. The variable for a flag is its name in upper case
+// with dashes turned to underscores, after envPrefix.
+func configure(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if v, ok := os.LookupEnv(name); ok && err == nil && !set[f.Name] {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("%s: %v", name, err)
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	path := ""
+	if f := fs.Lookup("config"); f != nil {
+		path = f.Value.String()
+	}
+	if path == "" {
+		return nil
+	}
+
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if fs.Lookup(v.name) == nil {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, v.line, v.name)
+		}
+		if set[v.name] {
+			continue
+		}
+		if err := fs.Set(v.name, v.value); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, v.line, err)
+		}
+	}
+	return nil
+}
+
+// A configValue is a setting read from a config file
+type configValue struct {
+	name, value string
+	line        int
+}
+
+// readConfig reads the settings of the config file at path
+func readConfig(path string) ([]configValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values []configValue
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text[0] == '#' || text == "---" {
+			continue
+		}
+		i := strings.IndexAny(text, "=:")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, line)
+		}
+		v := configValue{
+			name:  strings.TrimSpace(text[:i]),
+			value: strings.TrimSpace(text[i+1:]),
+			line:  line,
+		}
+		if v.value, err = unquote(v.value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		values = append(values, v)
+	}
+	return values, s.Err()
+}
+
+// unquote returns the string value s, quoted or not, stripped of
+// any comment following it
+func unquote(s string) (string, error) {
+	var end int
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for end = 1; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+	case strings.HasPrefix(s, "'"):
+		end = 1 + strings.IndexByte(s[1:], '\'')
+		if end == 0 {
+			end = len(s)
+		}
+	default:
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		return s, nil
+	}
+
+	if end >= len(s) {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if rest := strings.TrimSpace(s[end+1:]); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %s after string", rest)
+	}
+	if s[0] == '\'' {
+		return s[1:end], nil
+	}
+	return strconv.Unquote(s[:end+1])
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/jboverfelt/secure"
+)
+
+// How long a probe waits for the peer before failing
+const probeTimeout = 2 * time.Second
+
+// A probe checks one aspect of a peer's implementation of the wire
+// format. Each probe runs on a connection of its own.
+type probe struct {
+	name string
+	run  func(p *prober) error
+}
+
+// probes are run against an echo server, which must answer a valid
+// message with the same message, and drop the connection without
+// answering when it receives anything invalid.
+var probes = []probe{
+	{"echo", func(p *prober) error {
+		return p.expectEcho(p.seal(secure.FrameData, []byte("hello world\n")), "hello world\n")
+	}},
+	{"ping", func(p *prober) error {
+		if _, err := p.conn.Write(p.seal(secure.FramePing, []byte("probe"))); err != nil {
+			return err
+		}
+		t, payload, err := p.readFrame()
+		if err != nil {
+			return err
+		}
+		if t != secure.FramePong || string(payload) != "probe" {
+			return fmt.Errorf("answered with frame type %d %q, expected a pong", t, payload)
+		}
+		return nil
+	}},
+	{"unknown control frame is skipped", func(p *prober) error {
+		frames := append(p.seal(secure.FrameControl, []byte("ignored")), p.seal(secure.FrameData, []byte("after"))...)
+		return p.expectEcho(frames, "after")
+	}},
+	{"empty message", func(p *prober) error {
+		return p.expectEcho(p.seal(secure.FrameData, nil), "")
+	}},
+	{"unknown protocol frame type", func(p *prober) error {
+		return p.expectRejected(p.seal(secure.FrameControl-1, []byte("hello")))
+	}},
+	{"truncated frame", func(p *prober) error {
+		frame := p.seal(secure.FrameData, []byte("hello world\n"))
+		if _, err := p.conn.Write(frame[:len(frame)-4]); err != nil {
+			return err
+		}
+		if cw, ok := p.conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		return p.expectRejected(nil)
+	}},
+	{"length shorter than overhead", func(p *prober) error {
+		frame := p.seal(secure.FrameData, []byte("hello"))
+		binary.LittleEndian.PutUint16(frame[secure.NonceSize:], box.Overhead-1)
+		return p.expectRejected(frame[:secure.HeaderSize+box.Overhead-1])
+	}},
+	{"corrupted ciphertext", func(p *prober) error {
+		frame := p.seal(secure.FrameData, []byte("hello world\n"))
+		frame[len(frame)-1] ^= 1
+		return p.expectRejected(frame)
+	}},
+	{"corrupted nonce", func(p *prober) error {
+		frame := p.seal(secure.FrameData, []byte("hello world\n"))
+		frame[0] ^= 1
+		return p.expectRejected(frame)
+	}},
+	{"frame from another session", func(p *prober) error {
+		other, err := newProber(p.addr)
+		if err != nil {
+			return err
+		}
+		defer other.conn.Close()
+		return p.expectRejected(other.seal(secure.FrameData, []byte("hello world\n")))
+	}},
+	{"duplicated frame", func(p *prober) error {
+		// the echo server only answers once, so
+		// repeat the hello instead of a message
+		return p.expectRejected(p.hello)
+	}},
+	{"skipped frame number", func(p *prober) error {
+		p.seal(secure.FrameData, []byte("dropped"))
+		return p.expectRejected(p.seal(secure.FrameData, []byte("hello world\n")))
+	}},
+	{"huge frame", func(p *prober) error {
+		frame := p.seal(secure.FrameData, make([]byte, secure.MaxFrameMessageSize))
+		return p.expectRejected(frame)
+	}},
+}
+
+// A prober speaks the wire format by hand, so that it can send
+// frames a Writer never would.
+type prober struct {
+	addr   string
+	conn   net.Conn
+	shared [secure.KeySize]byte
+	seq    uint64
+	hello  []byte
+}
+
+// newProber connects to addr and runs the handshake
+// up to and including the server's hello
+func newProber(addr string) (*prober, error) {
+	conn, err := net.DialTimeout("tcp", addr, probeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	p := &prober{addr: addr, conn: conn}
+	if err := p.handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake: %v", err)
+	}
+	return p, nil
+}
+
+func (p *prober) handshake() error {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	var peerPub [secure.KeySize]byte
+	if _, err := io.ReadFull(p.conn, peerPub[:]); err != nil {
+		return err
+	}
+	if _, err := p.conn.Write(pub[:]); err != nil {
+		return err
+	}
+	box.Precompute(&p.shared, &peerPub, priv)
+
+	p.hello = p.seal(secure.FrameHello, nil)
+	if _, err := p.conn.Write(p.hello); err != nil {
+		return err
+	}
+	t, _, err := p.readFrame()
+	if err != nil {
+		return err
+	}
+	if t != secure.FrameHello {
+		return fmt.Errorf("first frame has type %d, expected a hello", t)
+	}
+	return nil
+}
+
+// seal returns the wire encoding of the next frame. Its nonce is 15
+// random bytes, the direction (1 for frames sent by the client) and
+// the big endian frame number.
+func (p *prober) seal(t secure.FrameType, payload []byte) []byte {
+	var nonce [secure.NonceSize]byte
+	rand.Read(nonce[:15])
+	nonce[15] = 1
+	binary.BigEndian.PutUint64(nonce[16:], p.seq)
+	p.seq++
+
+	enc := box.SealAfterPrecomputation(nil, append([]byte{byte(t)}, payload...), &nonce, &p.shared)
+	frame := make([]byte, secure.HeaderSize, secure.HeaderSize+len(enc))
+	copy(frame, nonce[:])
+	binary.LittleEndian.PutUint16(frame[secure.NonceSize:], uint16(len(enc)))
+	return append(frame, enc...)
+}
+
+// readFrame reads and opens the next frame from the peer
+func (p *prober) readFrame() (secure.FrameType, []byte, error) {
+	var header [secure.HeaderSize]byte
+	if _, err := io.ReadFull(p.conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	var nonce [secure.NonceSize]byte
+	copy(nonce[:], header[:])
+
+	enc := make([]byte, binary.LittleEndian.Uint16(header[secure.NonceSize:]))
+	if _, err := io.ReadFull(p.conn, enc); err != nil {
+		return 0, nil, err
+	}
+
+	plain, ok := box.OpenAfterPrecomputation(nil, enc, &nonce, &p.shared)
+	if !ok || len(plain) == 0 {
+		return 0, nil, errors.New("peer sent a frame that does not authenticate")
+	}
+	return secure.FrameType(plain[0]), plain[1:], nil
+}
+
+// expectEcho sends frames and expects msg back
+func (p *prober) expectEcho(frames []byte, msg string) error {
+	if _, err := p.conn.Write(frames); err != nil {
+		return err
+	}
+	t, payload, err := p.readFrame()
+	if err != nil {
+		return err
+	}
+	if t != secure.FrameData || string(payload) != msg {
+		return fmt.Errorf("answered with frame type %d %q, expected %q", t, payload, msg)
+	}
+	return nil
+}
+
+// expectRejected sends frames and expects the peer to drop the
+// connection, with or without a close frame, but without answering
+func (p *prober) expectRejected(frames []byte) error {
+	if len(frames) > 0 {
+		// the peer may hang up before reading everything
+		p.conn.Write(frames)
+	}
+
+	t, _, err := p.readFrame()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return errors.New("peer kept the connection open")
+	}
+	if err == nil && t != secure.FrameClose {
+		return fmt.Errorf("peer answered an invalid frame with frame type %d", t)
+	}
+	return nil
+}
+
+// conformance runs every probe against the echo server at addr,
+// writes a report to w and fails if any probe did.
+func conformance(w io.Writer, addr string) error {
+	failed := 0
+	for _, pr := range probes {
+		p, err := newProber(addr)
+		if err == nil {
+			err = pr.run(p)
+			p.conn.Close()
+		}
+
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", pr.name, err)
+		} else {
+			fmt.Fprintf(w, "PASS  %s\n", pr.name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d probes failed", failed, len(probes))
+	}
+	return nil
+}
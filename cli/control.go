@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// A control is a request from the system to a daemon, delivered as a
+// signal on Unix and by the service control manager on Windows
+type control int
+
+const (
+	// controlStop asks for a graceful shutdown
+	controlStop control = iota
+
+	// controlReload asks to reload the configuration
+	controlReload
+)
+
+// errNoService means that -service was used on a
+// system without a service manager to run under
+var errNoService = errors.New("services are only supported on Windows")
+
+// stopSignals are the signals asking a daemon to shut down: SIGTERM
+// from init systems, and interrupts from terminals, such as Ctrl+C
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// signalControls returns the controls delivered as signals
+// to the process, until stop is called
+func signalControls() (controls <-chan control, stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(stopSignals, reloadSignals...)...)
+
+	c := make(chan control, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				ctl := controlStop
+				for _, s := range reloadSignals {
+					if sig == s {
+						ctl = controlReload
+					}
+				}
+				c <- ctl
+			case <-done:
+				return
+			}
+		}
+	}()
+	return c, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package cli
+
+import "os"
+
+// reloadSignals is empty, as only Unix has a signal for reloading
+var reloadSignals []os.Signal
+
+// runService fails with errNoService: only Windows has a
+// service manager to run under.
+func runService(name string, fn func(controls <-chan control) error) error {
+	return errNoService
+}
+
+// running reports false, as there is no telling whether
+// a process exists here
+func running(pid int) bool {
+	return false
+}
@@ -0,0 +1,28 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals asking a daemon to reload
+// its configuration
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// runService fails with errNoService: only Windows has a
+// service manager to run under.
+func runService(name string, fn func(controls <-chan control) error) error {
+	return errNoService
+}
+
+// running reports whether the process pid exists
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
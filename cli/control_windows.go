@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// Service types, states, accepted controls and controls,
+// from the Windows service API
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop        = 0x1
+	serviceAcceptShutdown    = 0x4
+	serviceAcceptParamChange = 0x8
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+	serviceControlParamChange = 6
+
+	errorCallNotImplemented = 120
+	errorServiceSpecific    = 1066
+)
+
+// reloadSignals is empty, as Windows has no signal for reloading:
+// services reload on the paramchange control instead, such as sent
+// with "sc control <name> paramchange".
+var reloadSignals []os.Signal
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// serviceStatus is SERVICE_STATUS
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// service is the state of the one service a process can run, which
+// the callbacks of the service control manager share
+var service struct {
+	once               sync.Once
+	mainProc, ctrlProc uintptr
+
+	name     *uint16
+	fn       func(controls <-chan control) error
+	handle   uintptr
+	controls chan control
+	err      error
+}
+
+// runService runs fn as the Windows service name, with the controls
+// of the service control manager: stop and shutdown as controlStop,
+// and paramchange as controlReload. It returns what fn returns once
+// the service has stopped. The process must have been started by the
+// service control manager, as installed with
+//
+//	sc create challenge2 binPath= "C:\challenge2.exe serve -service challenge2 -key C:\server.key 9000"
+func runService(name string, fn func(controls <-chan control) error) error {
+	service.once.Do(func() {
+		service.mainProc = syscall.NewCallback(serviceMain)
+		service.ctrlProc = syscall.NewCallback(serviceCtrlHandler)
+	})
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	service.name, service.fn = n, fn
+	service.controls = make(chan control, 4)
+
+	table := []serviceTableEntry{{n, service.mainProc}, {nil, 0}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return err
+	}
+	return service.err
+}
+
+// setServiceStatus reports the state of the service
+func setServiceStatus(state, accepts, exitCode uint32) {
+	status := serviceStatus{
+		serviceType:      serviceWin32OwnProcess,
+		currentState:     state,
+		controlsAccepted: accepts,
+		win32ExitCode:    exitCode,
+	}
+	if exitCode != 0 {
+		status.win32ExitCode, status.serviceSpecificExitCode = errorServiceSpecific, exitCode
+	}
+	procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// serviceMain is the ServiceMain of the service, which runs it
+// on a thread of the service control manager
+func serviceMain(argc, argv uintptr) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(service.name)), service.ctrlProc, 0)
+	if h == 0 {
+		service.err = err
+		return 0
+	}
+	service.handle = h
+
+	setServiceStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown|serviceAcceptParamChange, 0)
+	service.err = service.fn(service.controls)
+	exitCode := uint32(0)
+	if service.err != nil {
+		exitCode = 1
+	}
+	setServiceStatus(serviceStopped, 0, exitCode)
+	return 0
+}
+
+// serviceCtrlHandler is the HandlerEx of the service, which passes
+// the controls of the service control manager on to it
+func serviceCtrlHandler(ctl, eventType, eventData, context uintptr) uintptr {
+	switch ctl {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, 0, 0)
+		sendControl(service.controls, controlStop)
+	case serviceControlParamChange:
+		sendControl(service.controls, controlReload)
+	case serviceControlInterrogate:
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// sendControl delivers ctl unless the controls are piling up
+// undelivered, so that the handler never blocks
+func sendControl(controls chan control, ctl control) {
+	select {
+	case controls <- ctl:
+	default:
+	}
+}
+
+// running reports false, as Windows processes
+// cannot be signalled to tell whether they exist
+func running(pid int) bool {
+	return false
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// A daemon runs a Server the way init systems and service managers
+// expect: it records its process ID in a file, shuts the Server down
+// gracefully when asked to stop, and reloads its configuration when
+// asked to without dropping the connections being served.
+type daemon struct {
+	srv *secure.Server
+
+	// pidFile, if set, is where the process ID is written
+	pidFile string
+
+	// grace is how long shutting down waits for connections
+	// to be served before closing them
+	grace time.Duration
+
+	// reload returns the configuration to serve new connections
+	// with, loaded again from the key file and configuration
+	reload func() (*secure.Config, error)
+
+	// controls delivers the requests to stop and reload; if nil,
+	// they are the signals of the process
+	controls <-chan control
+}
+
+// run serves l until the daemon is told to stop
+func (d *daemon) run(l net.Listener) error {
+	if d.pidFile != "" {
+		if err := writePIDFile(d.pidFile); err != nil {
+			return err
+		}
+		defer os.Remove(d.pidFile)
+	}
+
+	controls := d.controls
+	if controls == nil {
+		c, stop := signalControls()
+		defer stop()
+		controls = c
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- d.srv.Serve(l) }()
+	for {
+		select {
+		case err := <-served:
+			return err
+		case ctl := <-controls:
+			if ctl == controlReload {
+				d.reloadConfig()
+				continue
+			}
+			log.Printf("Shutting down")
+			return d.shutdown()
+		}
+	}
+}
+
+// reloadConfig has new connections served with the reloaded
+// configuration, or keeps the current one if it fails to load
+func (d *daemon) reloadConfig() {
+	config, err := d.reload()
+	if err != nil {
+		log.Printf("Reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	d.srv.Reload(config)
+	log.Printf("Reloaded the configuration")
+}
+
+// shutdown stops accepting connections and waits for those being
+// served, closing them once the grace period is over
+func (d *daemon) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.grace)
+	defer cancel()
+	if err := d.srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		return err
+	}
+	log.Printf("Closing the connections still open after %v", d.grace)
+	return d.srv.Close()
+}
+
+// writePIDFile writes the process ID to path, failing if the
+// file names another process that is still running
+func writePIDFile(path string) error {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && running(pid) {
+			return fmt.Errorf("%s: already running as process %d", path, pid)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// Multicast DNS group and the DNS-SD service type peers advertise,
+// with the fingerprint of their public key in a TXT record
+const (
+	mdnsGroup   = "224.0.0.251:5353"
+	mdnsService = "_secure._tcp.local."
+	mdnsTXTKey  = "fp="
+)
+
+// DNS record types and class used by discovery
+const (
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsClassIN = 1
+)
+
+// How long advertised records may be cached, in seconds
+const mdnsTTL = 120
+
+// errDNS means that a DNS message could not be parsed
+var errDNS = errors.New("malformed DNS message")
+
+// A peer is a receiver found on the local network
+type peer struct {
+	name        string
+	addr        string
+	fingerprint string
+}
+
+// discoverCommand runs the discover subcommand, which lists the peers
+// advertising on the local network, or advertises this one so that
+// senders can find it without typing its address:
+//
+//	challenge2 discover
+//	challenge2 discover -a laptop -l 9000 -key laptop.key
+func discoverCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("discover", flag.ContinueOnError)
+	var keys keyFlags
+	name := fs.String("a", "", "Advertise this peer under the given name instead of browsing")
+	port := fs.Int("l", 0, "With -a, the port receive listens on")
+	wait := fs.Duration("t", 2*time.Second, "How long to wait for answers when browsing")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file whose fingerprint to advertise")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *name != "" && (*port <= 0 || *port > 65535 || keys.keyFile == "") {
+		return errors.New("usage: discover [-t wait] | discover -a <name> -l <port> -key <private key file>")
+	}
+
+	if *name == "" {
+		peers, err := browse(*wait)
+		if err != nil {
+			return err
+		}
+		for _, p := range peers {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\n", p.name, p.addr, p.fingerprint)
+		}
+		return nil
+	}
+
+	if strings.ContainsAny(*name, ".") || len(*name) > 63 {
+		return fmt.Errorf("invalid name %q: it must be one DNS label", *name)
+	}
+	pub, _, err := keys.keyPair()
+	if err != nil {
+		return err
+	}
+	return advertise(advertiser{*name, uint16(*port), secure.Fingerprint(pub)})
+}
+
+// An advertiser answers the DNS-SD queries for this peer
+type advertiser struct {
+	name        string
+	port        uint16
+	fingerprint string
+}
+
+// answer returns the response to a query asking for the service,
+// or nil if the query does not concern it
+func (a advertiser) answer(query []byte) []byte {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil
+	}
+	questions, _, err := parseDNS(query)
+	if err != nil {
+		return nil
+	}
+
+	instance := a.name + "." + mdnsService
+	asked := false
+	for _, q := range questions {
+		if strings.EqualFold(q, mdnsService) || strings.EqualFold(q, instance) {
+			asked = true
+		}
+	}
+	if !asked {
+		return nil
+	}
+
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], a.port)
+	srv = appendDNSName(srv, a.name+".local.")
+	txt := mdnsTXTKey + a.fingerprint
+
+	// the id is echoed for legacy unicast queriers
+	b := []byte{query[0], query[1], 0x84, 0, 0, 0, 0, 3, 0, 0, 0, 0}
+	b = appendDNSRecord(b, mdnsService, dnsTypePTR, appendDNSName(nil, instance))
+	b = appendDNSRecord(b, instance, dnsTypeSRV, srv)
+	b = appendDNSRecord(b, instance, dnsTypeTXT, append([]byte{byte(len(txt))}, txt...))
+	return b
+}
+
+// advertise answers queries for the service on the local
+// network until it fails
+func advertise(a advertiser) error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsGroup)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		resp := a.answer(buf[:n])
+		if resp == nil {
+			continue
+		}
+		// queriers not on the mDNS port get unicast answers
+		dst := group
+		if src.Port != group.Port {
+			dst = src
+		}
+		conn.WriteToUDP(resp, dst)
+	}
+}
+
+// browse asks the local network for the service and
+// returns the peers that answered within wait
+func browse(wait time.Duration) ([]peer, error) {
+	group, err := net.ResolveUDPAddr("udp4", mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(mdnsQuery(), group); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+	var peers []peer
+	seen := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return peers, nil
+		}
+		if err != nil {
+			return peers, err
+		}
+		for _, p := range parsePeers(buf[:n], src.IP) {
+			if !seen[p.name+p.addr] {
+				seen[p.name+p.addr] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+}
+
+// mdnsQuery returns a query for the instances of the service
+func mdnsQuery() []byte {
+	query := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	query = appendDNSName(query, mdnsService)
+	return append(query, 0, dnsTypePTR, 0, dnsClassIN)
+}
+
+// parsePeers returns the peers described by a response from ip
+func parsePeers(msg []byte, ip net.IP) []peer {
+	_, records, err := parseDNS(msg)
+	if err != nil {
+		return nil
+	}
+
+	ports := make(map[string]uint16)
+	fingerprints := make(map[string]string)
+	var names []string
+	for _, r := range records {
+		switch r.typ {
+		case dnsTypePTR:
+			if strings.EqualFold(r.name, mdnsService) {
+				if instance, _, err := readDNSName(msg, r.off); err == nil {
+					names = append(names, instance)
+				}
+			}
+		case dnsTypeSRV:
+			if len(r.data) >= 6 {
+				ports[strings.ToLower(r.name)] = binary.BigEndian.Uint16(r.data[4:])
+			}
+		case dnsTypeTXT:
+			for txt := r.data; len(txt) > 0 && len(txt) > int(txt[0]); txt = txt[1+int(txt[0]):] {
+				if s := string(txt[1 : 1+int(txt[0])]); strings.HasPrefix(s, mdnsTXTKey) {
+					fingerprints[strings.ToLower(r.name)] = strings.TrimPrefix(s, mdnsTXTKey)
+				}
+			}
+		}
+	}
+
+	var peers []peer
+	for _, instance := range names {
+		port, ok := ports[strings.ToLower(instance)]
+		if !ok {
+			continue
+		}
+		peers = append(peers, peer{
+			name:        strings.TrimSuffix(instance, "."+mdnsService),
+			addr:        net.JoinHostPort(ip.String(), strconv.Itoa(int(port))),
+			fingerprint: fingerprints[strings.ToLower(instance)],
+		})
+	}
+	return peers
+}
+
+// A dnsRecord is a resource record of a parsed message. off is where
+// its data starts in the message, for names compressed within it.
+type dnsRecord struct {
+	name string
+	typ  uint16
+	data []byte
+	off  int
+}
+
+// parseDNS returns the names asked about in a DNS message
+// and its resource records
+func parseDNS(msg []byte) (questions []string, records []dnsRecord, err error) {
+	if len(msg) < 12 {
+		return nil, nil, errDNS
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, nil, errDNS
+		}
+		questions = append(questions, name)
+		off = next + 4
+	}
+	for i := 0; i < rr; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, nil, errDNS
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10
+		if off+size > len(msg) {
+			return nil, nil, errDNS
+		}
+		records = append(records, dnsRecord{name, typ, msg[off : off+size], off})
+		off += size
+	}
+	return questions, records, nil
+}
+
+// readDNSName reads the possibly compressed name at off in msg,
+// and returns it with the offset following it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNS
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNS
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case off+1+n > len(msg):
+			return "", 0, errDNS
+		default:
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// appendDNSName appends name, which ends with a dot, uncompressed
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+// appendDNSRecord appends a resource record of the IN class
+func appendDNSRecord(b []byte, name string, typ uint16, data []byte) []byte {
+	b = appendDNSName(b, name)
+	var fixed [10]byte
+	binary.BigEndian.PutUint16(fixed[0:], typ)
+	binary.BigEndian.PutUint16(fixed[2:], dnsClassIN)
+	binary.BigEndian.PutUint32(fixed[4:], mdnsTTL)
+	binary.BigEndian.PutUint16(fixed[8:], uint16(len(data)))
+	return append(append(b, fixed[:]...), data...)
+}
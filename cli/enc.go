@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/jboverfelt/secure"
+)
+
+// encCommand runs the enc subcommand, which encrypts a file for the
+// holder of a private key or, with -p, with a key derived from a
+// passphrase, which is asked for on the terminal unless -passfile
+// names a file holding it:
+//
+//	challenge2 enc -to peer.key.pub -o notes.txt.sec notes.txt
+//	challenge2 enc -p -o notes.txt.sec notes.txt
+//
+// The file is read from stdin if none is named, and written to stdout
+// without -o.
+func encCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("enc", flag.ContinueOnError)
+	to := fs.String("to", "", "Public key of the recipient, as printed by -pubkey, or its key file")
+	pass := fs.Bool("p", false, "Encrypt with a passphrase instead of a public key")
+	passFile := fs.String("passfile", "", "With -p, read the passphrase from the first line of this file")
+	out := fs.String("o", "", "Write the encrypted file here instead of to stdout")
+	def := secure.DefaultArgon2Params
+	passes := fs.Uint("time", uint(def.Time), "With -p, argon2id passes over the memory")
+	memory := fs.Uint("memory", uint(def.Memory), "With -p, argon2id memory in KiB")
+	threads := fs.Uint("threads", uint(def.Threads), "With -p, argon2id lanes")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *pass == (*to != "") || fs.NArg() > 1 {
+		return errors.New("usage: enc -to <public key or file> [-o file] [file] | enc -p [-passfile file] [-o file] [file]")
+	}
+	if *passes > math.MaxUint32 || *memory > math.MaxUint32 || *threads > math.MaxUint8 {
+		return errors.New("argon2id parameter out of range")
+	}
+	params := secure.Argon2Params{Time: uint32(*passes), Memory: uint32(*memory), Threads: uint8(*threads)}
+
+	return convert(fs.Arg(0), *out, stdout, func(w io.Writer) (io.WriteCloser, error) {
+		if !*pass {
+			recipient, err := publicKey(*to)
+			if err != nil {
+				return nil, err
+			}
+			return secure.NewFileWriter(w, recipient)
+		}
+
+		passphrase, err := passphrase(*passFile, true)
+		if err != nil {
+			return nil, err
+		}
+		return secure.NewPassphraseWriter(w, passphrase, &params)
+	}, nil)
+}
+
+// publicKey parses a public key pasted on the command line,
+// or reads it from the key file at s
+func publicKey(s string) (*[secure.KeySize]byte, error) {
+	if pub, err := secure.ParsePublicKey(s); err == nil {
+		return pub, nil
+	}
+	data, err := ioutil.ReadFile(s)
+	if err != nil {
+		return nil, err
+	}
+	return secure.DecodePublicKey(data)
+}
+
+// decCommand runs the dec subcommand, which decrypts a file written by
+// enc with the -key private key or, with -p, the passphrase:
+//
+//	challenge2 dec -key peer.key -o notes.txt notes.txt.sec
+//	challenge2 dec -p -o notes.txt notes.txt.sec
+func decCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("dec", flag.ContinueOnError)
+	var keys keyFlags
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file the file was encrypted for")
+	pass := fs.Bool("p", false, "Decrypt with a passphrase instead of a private key")
+	passFile := fs.String("passfile", "", "With -p, read the passphrase from the first line of this file")
+	out := fs.String("o", "", "Write the decrypted file here instead of to stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *pass == (keys.keyFile != "") || fs.NArg() > 1 {
+		return errors.New("usage: dec -key <private key file> [-o file] [file] | dec -p [-passfile file] [-o file] [file]")
+	}
+
+	return convert(fs.Arg(0), *out, stdout, nil, func(r io.Reader) (io.Reader, error) {
+		if !*pass {
+			_, priv, err := keys.keyPair()
+			if err != nil {
+				return nil, err
+			}
+			return secure.NewFileReader(r, priv)
+		}
+
+		passphrase, err := passphrase(*passFile, false)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := secure.NewPassphraseReader(r, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return decryptErrors{dec}, nil
+	})
+}
+
+// convert copies the file named in, or stdin, to the file named out,
+// or stdout, through the writer from encrypt or the reader from
+// decrypt. A partly written output file is removed on failure.
+func convert(in, out string, stdout io.Writer, encrypt func(io.Writer) (io.WriteCloser, error), decrypt func(io.Reader) (io.Reader, error)) (err error) {
+	var r io.Reader = os.Stdin
+	if in != "" && in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	w := stdout
+	if out != "" {
+		f, ferr := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(out)
+			}
+		}()
+		w = f
+	}
+
+	if decrypt != nil {
+		if r, err = decrypt(r); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	enc, err := encrypt(w)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, r); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// decryptErrors explains the error a wrong passphrase causes
+type decryptErrors struct {
+	r io.Reader
+}
+
+func (d decryptErrors) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == secure.ErrDecrypt {
+		err = errors.New("wrong passphrase or corrupt file")
+	}
+	return n, err
+}
+
+// passphrase reads the passphrase from the first line of the file
+// named path or, if path is empty, asks for it on the terminal,
+// twice if confirm is set
+func passphrase(path string, confirm bool) ([]byte, error) {
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.SplitN(string(data), "\n", 2)[0]
+		return []byte(strings.TrimSuffix(line, "\r")), nil
+	}
+
+	in, out, err := openTerminal()
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to ask for the passphrase on, use -passfile: %v", err)
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	r := bufio.NewReader(in)
+	pass, err := prompt(r, in, out, "Passphrase: ")
+	if err != nil || !confirm {
+		return pass, err
+	}
+	again, err := prompt(r, in, out, "Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, again) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// prompt writes msg to out and reads a line from r, which reads in,
+// without echoing it
+func prompt(r *bufio.Reader, in, out *os.File, msg string) ([]byte, error) {
+	fmt.Fprint(out, msg)
+	if err := setEcho(in, false); err != nil {
+		return nil, err
+	}
+	line, err := r.ReadString('\n')
+	setEcho(in, true)
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/jboverfelt/secure"
+)
+
+// How long before a key expires to start warning about it
+const expiryWarning = 7 * 24 * time.Hour
+
+// keyPair loads the key pair from the -key file,
+// or generates a fresh one if none was given.
+func (keys keyFlags) keyPair() (pub, priv *[secure.KeySize]byte, err error) {
+	if keys.keyFile == "" {
+		return box.GenerateKey(rand.Reader)
+	}
+
+	priv, pub, err = secure.LoadKeyFile(keys.keyFile)
+	return pub, priv, err
+}
+
+// keyInfo loads the rotation metadata of the -key file, if any, and
+// warns when the key is about to expire. Handshakes using an expired
+// key fail.
+func (keys keyFlags) keyInfo() (*secure.KeyInfo, error) {
+	if keys.keyFile == "" {
+		return nil, nil
+	}
+
+	info, err := secure.LoadKeyInfo(keys.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if info.Expired(time.Now()) {
+		log.Printf("Key %s expired at %s", keys.keyFile, info.Expires)
+	} else if info.Expired(time.Now().Add(expiryWarning)) {
+		log.Printf("Key %s expires at %s, rotate it with -keygen", keys.keyFile, info.Expires)
+	}
+	return info, nil
+}
+
+// keygen writes a fresh private key to path and its public key to
+// path.pub. Both files record the key's ID and creation time, and its expiry time unless
+// lifetime is zero.
+func keygen(path string, lifetime time.Duration) error {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	info := secure.NewKeyInfo(pub, lifetime)
+
+	data, err := secure.EncodePrivateKey(priv, nil)
+	if err != nil {
+		return err
+	}
+	if data, err = secure.AddKeyInfo(data, info); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	if data, err = secure.AddKeyInfo(secure.EncodePublicKey(pub), info); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".pub", data, 0644)
+}
+
+// keygenCommand runs the keygen subcommand, which generates a key pair
+// like the -keygen flag:
+//
+//	challenge2 keygen -expires 8760h server.key
+func keygenCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	expires := fs.Duration("expires", 0, "Make the key expire after this long")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: keygen [-expires d] <private key file>")
+	}
+	return keygen(fs.Arg(0), *expires)
+}
+
+// keysCommand runs the keys subcommand, which backs up private keys,
+// either split into shares or as a mnemonic phrase, and restores them:
+//
+//	challenge2 keys split -n 5 -k 3 server.key
+//	challenge2 keys combine -o server.key server.key.share1 server.key.share4 server.key.share5
+//	challenge2 keys mnemonic server.key
+//	challenge2 keys recover server.key < phrase.txt
+func keysCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys split|combine|mnemonic|recover [flags] files...")
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "split":
+		n := fs.Int("n", 5, "Number of shares to write")
+		k := fs.Int("k", 3, "Number of shares required to recover the key")
+		if err := parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: keys split [-n shares] [-k required] <private key file>")
+		}
+		return splitKey(fs.Arg(0), *n, *k)
+
+	case "combine":
+		out := fs.String("o", "", "File to write the recovered private key to, and its public key to the file plus .pub")
+		if err := parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if *out == "" || fs.NArg() == 0 {
+			return errors.New("usage: keys combine -o <private key file> <share files>...")
+		}
+		return combineKeys(*out, fs.Args())
+
+	case "mnemonic", "recover":
+		if err := parse(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: keys %s <private key file>", args[0])
+		}
+		if args[0] == "mnemonic" {
+			return mnemonicKey(fs.Arg(0), stdout)
+		}
+		return recoverKey(fs.Arg(0), os.Stdin)
+	}
+	return fmt.Errorf("unknown keys command %q", args[0])
+}
+
+// splitKey writes n shares of the private key in path, any k of which
+// recover it, to path.share1 to path.shareN. Each file is meant for a
+// different custodian.
+func splitKey(path string, n, k int) error {
+	priv, _, err := secure.LoadKeyFile(path)
+	if err != nil {
+		return err
+	}
+	shares, err := secure.SplitKey(priv, n, k)
+	if err != nil {
+		return err
+	}
+
+	for i, share := range shares {
+		name := fmt.Sprintf("%s.share%d", path, i+1)
+		if err := ioutil.WriteFile(name, secure.EncodeKeyShare(share), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mnemonicKey writes a new private key derived from a fresh mnemonic
+// to path and its public key to path.pub, and prints the mnemonic, from
+// which recoverKey can derive the same key again.
+func mnemonicKey(path string, stdout io.Writer) error {
+	entropy := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, entropy); err != nil {
+		return err
+	}
+	mnemonic, err := secure.NewMnemonic(entropy)
+	if err != nil {
+		return err
+	}
+	if err := deriveKey(path, mnemonic); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, mnemonic)
+	return err
+}
+
+// recoverKey reads a mnemonic printed by mnemonicKey and
+// writes the private key derived from it to path
+func recoverKey(path string, stdin io.Reader) error {
+	phrase, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	return deriveKey(path, string(phrase))
+}
+
+func deriveKey(path, mnemonic string) error {
+	seed, err := secure.MnemonicSeed(mnemonic, "")
+	if err != nil {
+		return err
+	}
+	pub, priv, err := secure.DeriveKeyPair(seed)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(path, pub, priv)
+}
+
+// writeKeyPair writes priv unencrypted to path and pub to path.pub
+func writeKeyPair(path string, pub, priv *[secure.KeySize]byte) error {
+	data, err := secure.EncodePrivateKey(priv, nil)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".pub", secure.EncodePublicKey(pub), 0644)
+}
+
+// combineKeys recovers a private key from the share files
+// and writes it unencrypted to path and its public key to path.pub.
+func combineKeys(path string, files []string) error {
+	var shares [][]byte
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		share, err := secure.DecodeKeyShare(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		shares = append(shares, share)
+	}
+
+	priv, err := secure.CombineKeys(shares)
+	if err != nil {
+		return err
+	}
+
+	var pub [secure.KeySize]byte
+	curve25519.ScalarBaseMult(&pub, priv)
+	return writeKeyPair(path, &pub, priv)
+}
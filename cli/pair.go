@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/jboverfelt/secure"
+	"github.com/jboverfelt/secure/internal/qr"
+)
+
+// Scheme of the pairing URIs shown by -pubkey and accepted by -pair
+const pairScheme = "secure-pair"
+
+// pairURI returns the URI that tells a peer how to pair with the
+// holder of pub: the relay to meet at, the pairing code and the
+// fingerprint to expect. -pubkey shows it with its QR code,
+// for the peer to scan.
+func pairURI(via, code string, pub *[secure.KeySize]byte) string {
+	u := url.URL{
+		Scheme: pairScheme,
+		Host:   via,
+		RawQuery: url.Values{
+			"code": {code},
+			"fp":   {secure.Fingerprint(pub)},
+		}.Encode(),
+	}
+	return u.String()
+}
+
+// pairQR returns uri as a QR code to print on a terminal
+func pairQR(uri string) (string, error) {
+	code, err := qr.Encode([]byte(uri))
+	if err != nil {
+		return "", err
+	}
+	return code.String(), nil
+}
+
+// parsePairURI returns the relay address, pairing code and
+// fingerprint from a URI made by pairURI
+func parsePairURI(uri string) (via, code, fp string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	q := u.Query()
+	via, code, fp = u.Host, q.Get("code"), q.Get("fp")
+	if u.Scheme != pairScheme || via == "" || code == "" || fp == "" {
+		return "", "", "", fmt.Errorf("not a %s URI: %s", pairScheme, uri)
+	}
+	return via, code, fp, nil
+}
+
+// pair meets the peer described by a pairing URI and checks that
+// it holds the key whose fingerprint the URI carries.
+func (keys keyFlags) pair(uri string) (*secure.Conn, error) {
+	via, code, fp, err := parsePairURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	keys.code = code
+	conn, err := keys.rendezvous(via)
+	if err != nil {
+		return nil, err
+	}
+
+	if !secure.MatchFingerprint(conn.ConnectionState().PeerPublicKey, fp) {
+		conn.Close()
+		return nil, secure.ErrFingerprint
+	}
+	return conn, nil
+}
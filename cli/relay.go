@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// relayCommand runs the relay subcommand, which pairs up peers meeting
+// with -via and forwards their ciphertext without holding any keys:
+//
+//	challenge2 relay -wait 10m 9000
+//
+// It is the same relay as the -r flag, with its options.
+func relayCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	wait := fs.Duration("wait", secure.DefaultWaitTimeout, "Disconnect peers that waited this long for the other side (a negative duration waits forever)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: relay [-wait d] <port>")
+	}
+	port, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || port <= 0 {
+		return fmt.Errorf("invalid port %q", fs.Arg(0))
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return relay(l, *wait)
+}
+
+// relay serves as a relay on l
+func relay(l net.Listener, wait time.Duration) error {
+	log.Printf("Relaying on %v", l.Addr())
+	return (&secure.Relay{WaitTimeout: wait}).Serve(l)
+}
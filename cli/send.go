@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/jboverfelt/secure"
+)
+
+// sendCommand runs the send subcommand, which transfers a directory
+// as an encrypted tar stream, either to a peer running receive or to
+// an encrypted file for the holder of a private key, or transfers a
+// single file to a peer, resuming from what the peer already has:
+//
+//	challenge2 send -r photos localhost:9000
+//	challenge2 send -r photos -skip 42 localhost:9000
+//	challenge2 send -r photos -o photos.sec -to peer.key.pub
+//	challenge2 send -f backup.img localhost:9000
+func sendCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	var keys keyFlags
+	dir := fs.String("r", "", "Directory to send")
+	file := fs.String("f", "", "File to send instead of a directory")
+	out := fs.String("o", "", "Write an encrypted file instead of connecting to a peer")
+	to := fs.String("to", "", "With -o, public key file of the recipient")
+	skip := fs.Uint64("skip", 0, "Resume a transfer by skipping the entries the receiver reported complete")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *file != "" && *dir == "" && fs.NArg() == 1:
+		return keys.sendOne(*file, fs.Arg(0))
+	case *dir == "":
+	case *out != "" && *to != "" && fs.NArg() == 0:
+		return encryptDir(*dir, *out, *to)
+	case *out == "" && fs.NArg() == 1:
+		conn, err := keys.dial(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return secure.WriteArchive(conn, os.DirFS(*dir), *skip)
+	}
+	return errors.New("usage: send -r <dir> [-skip n] <addr> | send -r <dir> -o <file> -to <public key file> | send -f <file> <addr>")
+}
+
+// sendOne sends the file at path to the peer at addr
+func (keys keyFlags) sendOne(path, addr string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conn, err := keys.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = secure.SendFile(conn, f)
+	return err
+}
+
+// encryptDir writes dir to an encrypted file that only the holder
+// of the private key of the public key file to can read
+func encryptDir(dir, out, to string) error {
+	data, err := ioutil.ReadFile(to)
+	if err != nil {
+		return err
+	}
+	recipient, err := secure.DecodePublicKey(data)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := secure.NewFileWriter(f, recipient)
+	if err != nil {
+		return err
+	}
+	if err := secure.WriteArchive(w, os.DirFS(dir), 0); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// receiveCommand runs the receive subcommand, which extracts a
+// directory sent with send, from one connection or an encrypted file,
+// or receives a single file:
+//
+//	challenge2 receive -d photos -l 9000
+//	challenge2 receive -d photos -i photos.sec -key peer.key
+//	challenge2 receive -o backup.img -l 9000
+//
+// After an interrupted directory transfer, it reports the -skip to
+// resume with. An interrupted file transfer resumes by receiving into
+// the same file again.
+func receiveCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("receive", flag.ContinueOnError)
+	var keys keyFlags
+	dir := fs.String("d", "", "Directory to extract into")
+	file := fs.String("o", "", "File to receive into instead of a directory")
+	port := fs.Int("l", 0, "Port to accept the transfer on")
+	in := fs.String("i", "", "Encrypted file to extract instead of accepting a transfer")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if (*dir == "") == (*file == "") || (*port == 0) == (*in == "") || *file != "" && *in != "" || fs.NArg() != 0 {
+		return errors.New("usage: receive -d <dir> -l <port> | receive -d <dir> -i <file> -key <private key file> | receive -o <file> -l <port>")
+	}
+
+	if *in != "" {
+		return keys.decryptDir(*dir, *in, stdout)
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if *file != "" {
+		return keys.receiveOne(l, *file, stdout)
+	}
+	return keys.receive(l, *dir, stdout)
+}
+
+// accept accepts one connection from l for a transfer
+func (keys keyFlags) accept(l net.Listener) (*secure.Conn, error) {
+	pub, priv, err := keys.keyPair()
+	if err != nil {
+		return nil, err
+	}
+	info, err := keys.keyInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return secure.NewServerConn(conn, &secure.Config{
+		PrivateKey:  priv,
+		PublicKey:   pub,
+		PairingCode: []byte(keys.code),
+		KeyInfo:     info,
+	}), nil
+}
+
+// receiveOne receives the file sent on the first connection accepted
+// from l into path, keeping what path already holds of it
+func (keys keyFlags) receiveOne(l net.Listener, path string, stdout io.Writer) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c, err := keys.accept(l)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	offset, err := secure.ReceiveFile(c, f)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		fmt.Fprintf(stdout, "Resumed at %d bytes\n", offset)
+	}
+	return f.Close()
+}
+
+// receive extracts the directory sent on the first connection
+// accepted from l into dir
+func (keys keyFlags) receive(l net.Listener, dir string, stdout io.Writer) error {
+	c, err := keys.accept(l)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	n, err := secure.ExtractArchive(c, dir)
+	if err != nil {
+		fmt.Fprintf(stdout, "Received %d entries, resume with -skip %d\n", n, n)
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "Received %d entries\n", n)
+	return err
+}
+
+// decryptDir extracts the directory in the encrypted file
+// in into dir, using the -key file to decrypt it
+func (keys keyFlags) decryptDir(dir, in string, stdout io.Writer) error {
+	if keys.keyFile == "" {
+		return errors.New("receive -i needs the -key the file was encrypted for")
+	}
+	_, priv, err := keys.keyPair()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := secure.NewFileReader(f, priv)
+	if err != nil {
+		return err
+	}
+	n, err := secure.ExtractArchive(r, dir)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "Received %d entries\n", n)
+	return err
+}
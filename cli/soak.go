@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// soakSlack is how long past the end of a soak test
+// the last exchanges may take before they count as failed
+const soakSlack = 10 * time.Second
+
+// soakCommand runs the soak subcommand, which holds many concurrent
+// connections exchanging messages of mixed sizes with a server, one
+// of them serving with -l, and reports the error rates and the
+// resources used, to catch regressions in how servers scale:
+//
+//	challenge2 soak -l 9000
+//	challenge2 soak -conns 10000 -rate 100/s -duration 5m localhost:9000
+func soakCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("soak", flag.ContinueOnError)
+	var keys keyFlags
+	port := fs.Int("l", 0, "Serve soak tests on this port")
+	conns := fs.Int("conns", 100, "Number of concurrent connections")
+	rate := fs.String("rate", "100/s", "Rate at which connections are opened, per second or per minute with /m")
+	duration := fs.Duration("duration", time.Minute, "How long the test runs")
+	sizes := fs.String("sizes", "64,1k,16k", "Comma-separated sizes of the messages echoed")
+	maxErrors := fs.Float64("max-errors", 0, "Percentage of failed connections or messages tolerated")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the peer")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if *port != 0 && fs.NArg() == 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		return keys.benchServe(l)
+	}
+
+	if *port != 0 || fs.NArg() != 1 || *conns <= 0 || *duration <= 0 {
+		return errors.New("usage: soak -l <port> | soak [-conns n] [-rate r/s] [-duration d] [-sizes n,...] [-max-errors pct] <addr>")
+	}
+	perSecond, err := parseRate(*rate)
+	if err != nil {
+		return err
+	}
+	var mix []int
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := parseSize(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		mix = append(mix, n)
+	}
+
+	res, err := keys.soak(fs.Arg(0), *conns, perSecond, *duration, mix)
+	if err != nil {
+		return err
+	}
+	res.report(stdout)
+	if rate := res.errorRate(); rate > *maxErrors {
+		return fmt.Errorf("error rate %.2f%% over %.2f%%", rate, *maxErrors)
+	}
+	return nil
+}
+
+// parseRate parses a rate such as 100/s, 600/m or 100, per second
+func parseRate(s string) (float64, error) {
+	digits, per := s, 1.0
+	switch {
+	case strings.HasSuffix(s, "/s"):
+		digits = s[:len(s)-2]
+	case strings.HasSuffix(s, "/m"):
+		digits, per = s[:len(s)-2], 60
+	}
+	n, err := strconv.ParseFloat(digits, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: it must be a positive number per second (/s) or minute (/m)", s)
+	}
+	return n / per, nil
+}
+
+// soakResult counts what happened during a soak test. The counters
+// are updated atomically by the connections.
+type soakResult struct {
+	opened, dialFailed  uint64
+	messages, msgFailed uint64
+	bytes               uint64
+	active, peakActive  int64
+	peakGoroutines      int
+	peakHeap            uint64
+	elapsed             time.Duration
+}
+
+// soak opens up to conns connections to the server at addr, perSecond
+// at a time, and has each echo messages of the sizes in mix, picked at
+// random, until duration has passed
+func (keys keyFlags) soak(addr string, conns int, perSecond float64, duration time.Duration, mix []int) (*soakResult, error) {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(soakResult)
+	start := time.Now()
+	end := start.Add(duration)
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		res.sample(done)
+	}()
+
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / perSecond))
+	defer ticker.Stop()
+	timeout := time.NewTimer(duration)
+	defer timeout.Stop()
+open:
+	for i := 0; i < conns; i++ {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-timeout.C:
+				break open
+			}
+		}
+
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			res.run(addr, config, rand.New(rand.NewSource(seed)), mix, end)
+		}(start.UnixNano() + int64(i))
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	close(done)
+	<-sampled
+	return res, nil
+}
+
+// run is one connection of a soak test, echoing messages until end
+func (res *soakResult) run(addr string, config *secure.Config, r *rand.Rand, mix []int, end time.Time) {
+	conn, err := secure.Dial("tcp", addr, config)
+	if err != nil {
+		atomic.AddUint64(&res.dialFailed, 1)
+		return
+	}
+	defer conn.Close()
+	atomic.AddUint64(&res.opened, 1)
+	n := atomic.AddInt64(&res.active, 1)
+	for peak := atomic.LoadInt64(&res.peakActive); n > peak; peak = atomic.LoadInt64(&res.peakActive) {
+		if atomic.CompareAndSwapInt64(&res.peakActive, peak, n) {
+			break
+		}
+	}
+	defer atomic.AddInt64(&res.active, -1)
+
+	conn.SetDeadline(end.Add(soakSlack))
+	msg := make([]byte, secure.MaxMessageSize)
+	buf := make([]byte, secure.MaxMessageSize)
+	for time.Now().Before(end) {
+		size := mix[r.Intn(len(mix))]
+		r.Read(msg[:size])
+		if _, err := conn.Write(msg[:size]); err != nil {
+			atomic.AddUint64(&res.msgFailed, 1)
+			return
+		}
+		n, err := conn.Read(buf)
+		if err != nil || n != size || !bytes.Equal(buf[:n], msg[:size]) {
+			atomic.AddUint64(&res.msgFailed, 1)
+			return
+		}
+		atomic.AddUint64(&res.messages, 1)
+		atomic.AddUint64(&res.bytes, uint64(2*size))
+	}
+}
+
+// sample records the peak goroutines and heap until done is closed
+func (res *soakResult) sample(done chan struct{}) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if n := runtime.NumGoroutine(); n > res.peakGoroutines {
+			res.peakGoroutines = n
+		}
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.HeapInuse > res.peakHeap {
+			res.peakHeap = m.HeapInuse
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errorRate returns the percentage of connections and messages that
+// failed
+func (res *soakResult) errorRate() float64 {
+	failed := res.dialFailed + res.msgFailed
+	total := res.opened + res.dialFailed + res.messages + res.msgFailed
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(failed) / float64(total)
+}
+
+func (res *soakResult) report(w io.Writer) {
+	fmt.Fprintf(w, "Connections: %d opened, %d failed, %d at once\n", res.opened, res.dialFailed, res.peakActive)
+	fmt.Fprintf(w, "Messages: %d echoed, %d failed\n", res.messages, res.msgFailed)
+	fmt.Fprintf(w, "Error rate: %.2f%%\n", res.errorRate())
+	fmt.Fprintf(w, "Throughput: %.2f MB/s\n", float64(res.bytes)/res.elapsed.Seconds()/1e6)
+	fmt.Fprintf(w, "Peak usage: %d goroutines, %.1f MB heap\n", res.peakGoroutines, float64(res.peakHeap)/1e6)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+)
+
+// openTerminal opens the controlling terminal for prompts, which must
+// not mix with data on stdin and stdout. Both files are the same.
+func openTerminal() (in, out *os.File, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	return tty, tty, err
+}
+
+// setEcho turns the echoing of what is typed on the terminal on or off
+func setEcho(tty *os.File, on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// Console mode flag echoing input, from the Windows console API
+const enableEchoInput = 0x4
+
+// openTerminal opens the console for prompts, which must
+// not mix with data on stdin and stdout.
+func openTerminal() (in, out *os.File, err error) {
+	if in, err = os.OpenFile("CONIN$", os.O_RDWR, 0); err != nil {
+		return nil, nil, err
+	}
+	if out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0); err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// setEcho turns the echoing of what is typed on the console on or off
+func setEcho(console *os.File, on bool) error {
+	h := syscall.Handle(console.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return err
+	}
+	if on {
+		mode |= enableEchoInput
+	} else {
+		mode &^= enableEchoInput
+	}
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
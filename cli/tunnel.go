@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/jboverfelt/secure"
+)
+
+// tunnelCommand runs the tunnel subcommand, which carries TCP
+// connections over secure ones, like stunnel. One end accepts plain
+// connections and forwards them encrypted to the other, which
+// forwards them in the clear to their target:
+//
+//	challenge2 tunnel -serve 9000 -key server.key localhost:5432
+//	challenge2 tunnel -l 5432 db.example.com:9000
+func tunnelCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tunnel", flag.ContinueOnError)
+	var keys keyFlags
+	port := fs.Int("l", 0, "Accept plain connections on this port and forward them to the tunnel at the address")
+	servePort := fs.Int("serve", 0, "Accept tunneled connections on this port and forward them to the address")
+	fs.StringVar(&keys.keyFile, "key", "", "Private key file to use instead of a fresh key pair")
+	fs.StringVar(&keys.code, "code", "", "Pairing code shared with the other end")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*port == 0) == (*servePort == 0) {
+		return errors.New("usage: tunnel -l <port> <tunnel addr> | tunnel -serve <port> <target addr>")
+	}
+
+	listen := *port
+	if listen == 0 {
+		listen = *servePort
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", listen))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if *port != 0 {
+		return keys.tunnelOut(l, fs.Arg(0))
+	}
+	return keys.tunnelIn(l, fs.Arg(0))
+}
+
+// tunnelOut forwards every connection accepted on l
+// through the tunnel at addr
+func (keys keyFlags) tunnelOut(l net.Listener, addr string) error {
+	config, err := keys.clientConfig()
+	if err != nil {
+		return err
+	}
+	config.ChunkWrites = true
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			conn, err := secure.Dial("tcp", addr, config)
+			if err != nil {
+				log.Printf("Tunnel: cant reach %s: %v", addr, err)
+				return
+			}
+			defer conn.Close()
+			forward(c, conn)
+		}()
+	}
+}
+
+// tunnelIn serves tunneled connections on l,
+// forwarding each to addr
+func (keys keyFlags) tunnelIn(l net.Listener, addr string) error {
+	config, err := keys.serverConfig()
+	if err != nil {
+		return err
+	}
+	config.ChunkWrites = true
+
+	srv := &secure.Server{
+		Config: config,
+		Handler: secure.HandlerFunc(func(c *secure.Conn) {
+			target, err := net.Dial("tcp", addr)
+			if err != nil {
+				log.Printf("Tunnel: cant reach %s: %v", addr, err)
+				return
+			}
+			defer target.Close()
+			forward(c, target)
+		}),
+	}
+	return srv.Serve(l)
+}
+
+// forward copies between a and b both ways until both directions are
+// done, passing on the end of each as a half-close when possible
+func forward(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(b, a)
+		closeWrite(b)
+		close(done)
+	}()
+	io.Copy(a, b)
+	closeWrite(a)
+	<-done
+}
+
+// closeWrite shuts down the writing side of c, or
+// closes it if it cannot be half-closed
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
@@ -0,0 +1,38 @@
+package secure
+
+// ClientInfo describes a client to the server it connects to, such as
+// the service it belongs to and the tenant it acts for, so that a
+// server can route or authorize the connection without an application
+// handshake of its own. It is sent in the client's hello, so it is
+// encrypted and authenticated like any frame.
+type ClientInfo struct {
+	// UserAgent names the client's software and its version,
+	// such as "myclient/1.2".
+	UserAgent string
+
+	// Metadata holds whatever else the client wants to tell the
+	// server. Keys must be 1 to 255 bytes long.
+	Metadata map[string]string
+}
+
+// marshal encodes info as the user agent, prefixed with its length,
+// followed by the metadata
+func (info *ClientInfo) marshal() ([]byte, error) {
+	if len(info.UserAgent) > 255 {
+		return nil, ErrHello
+	}
+	data := append([]byte{byte(len(info.UserAgent))}, info.UserAgent...)
+	return appendMetadata(data, info.Metadata)
+}
+
+func (info *ClientInfo) unmarshal(data []byte) error {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return ErrHello
+	}
+	n := int(data[0])
+	info.UserAgent = string(data[1 : 1+n])
+
+	var err error
+	info.Metadata, err = parseMetadata(data[1+n:])
+	return err
+}
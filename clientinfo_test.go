@@ -0,0 +1,84 @@
+package secure
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientInfo(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv := &Server{
+		Authorize: func(c *Conn) error {
+			info := c.ConnectionState().PeerClientInfo
+			if info == nil || info.Metadata["tenant"] != "acme" {
+				return errors.New("unknown tenant")
+			}
+			return nil
+		},
+		Handler: HandlerFunc(func(c *Conn) {
+			info := c.ConnectionState().PeerClientInfo
+			c.Write([]byte(info.UserAgent + " " + info.Metadata["service"]))
+		}),
+	}
+	go srv.Serve(l)
+
+	info := &ClientInfo{UserAgent: "test/1.0", Metadata: map[string]string{"service": "billing", "tenant": "acme"}}
+	conn, err := Dial("tcp", l.Addr().String(), &Config{ClientInfo: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "test/1.0 billing" {
+		t.Fatalf("Unexpected reply %q, %v", buf[:n], err)
+	}
+
+	for _, config := range []*Config{nil, {ClientInfo: &ClientInfo{Metadata: map[string]string{"tenant": "other"}}}} {
+		conn, err := Dial("tcp", l.Addr().String(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatal("Expected the unauthorized connection to be closed")
+		}
+		conn.Close()
+	}
+	if n := srv.Stats().Unauthorized; n != 2 {
+		t.Fatalf("Expected 2 unauthorized connections, got %d", n)
+	}
+}
+
+func TestClientInfoEncoding(t *testing.T) {
+	for _, info := range []ClientInfo{
+		{},
+		{UserAgent: "x"},
+		{UserAgent: "y", Metadata: map[string]string{"tenant": "t", "service": ""}},
+	} {
+		data, err := info.marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got ClientInfo
+		if err := got.unmarshal(data); err != nil || !reflect.DeepEqual(got, info) {
+			t.Fatalf("ClientInfo %+v did not round trip: %+v, %v", info, got, err)
+		}
+	}
+
+	var got ClientInfo
+	if err := got.unmarshal([]byte{3, 'a'}); err != ErrHello {
+		t.Fatalf("Expected ErrHello for a truncated user agent, got %v", err)
+	}
+	info := ClientInfo{UserAgent: strings.Repeat("x", 256)}
+	if _, err := info.marshal(); err != ErrHello {
+		t.Fatalf("Expected ErrHello for an oversized user agent, got %v", err)
+	}
+}
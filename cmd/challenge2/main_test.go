@@ -1,137 +0,0 @@
-package main
-
-import (
-	"encoding/binary"
-	"fmt"
-	"io"
-
-	"net"
-	"testing"
-)
-
-func TestSecureEchoServer(t *testing.T) {
-	// Create a random listener
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer l.Close()
-
-	// Start the server
-	go serve(l)
-
-	conn, err := dial(l.Addr().String())
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer conn.Close()
-
-	expected := "hello world\n"
-	if _, err := fmt.Fprintf(conn, expected); err != nil {
-		t.Fatal(err)
-	}
-
-	buf := make([]byte, 2048)
-	n, err := conn.Read(buf)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if got := string(buf[:n]); got != expected {
-		t.Fatalf("Unexpected result:\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
-	}
-}
-
-func TestSecureServe(t *testing.T) {
-	// Create a random listener
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer l.Close()
-
-	// Start the server
-	go serve(l)
-
-	conn, err := net.Dial("tcp", l.Addr().String())
-	if err != nil {
-		t.Fatal(err)
-	}
-	unexpected := "hello world\n"
-	if _, err := fmt.Fprintf(conn, unexpected); err != nil {
-		t.Fatal(err)
-	}
-	buf := make([]byte, 2048)
-	n, err := conn.Read(buf)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if got := string(buf[:n]); got == unexpected {
-		t.Fatalf("Unexpected result:\nGot raw data instead of serialized key")
-	}
-}
-
-func TestSecureDial(t *testing.T) {
-	// Create a random listener
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer l.Close()
-
-	// Start the server
-	go func(l net.Listener) {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				return
-			}
-			go func(c net.Conn) {
-				defer c.Close()
-				// write server key
-				key := [32]byte{}
-				c.Write(key[:])
-				// read client's key
-				keyBuf := make([]byte, 32)
-				_, err := io.ReadFull(c, keyBuf)
-				if err != nil {
-					t.Fatal(err)
-				}
-
-				// read nonce
-				var nonce [24]byte
-				if _, err := io.ReadFull(c, nonce[:]); err != nil {
-					t.Fatal(err)
-				}
-
-				// Read the ciphertext size
-				var size uint16
-				if err := binary.Read(c, binary.LittleEndian, &size); err != nil {
-					t.Fatal(err)
-				}
-
-				// make a buffer large enough to handle
-				// the overhead associated with an encrypted message
-				enc := make([]byte, size)
-				if _, err := io.ReadFull(c, enc); err != nil {
-					t.Fatal(err)
-				}
-
-				if got := string(enc); got == "hello world\n" {
-					t.Fatal("Unexpected result. Got raw data instead of encrypted")
-				}
-			}(conn)
-		}
-	}(l)
-
-	conn, err := dial(l.Addr().String())
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer conn.Close()
-
-	expected := "hello world\n"
-	if _, err := fmt.Fprintf(conn, expected); err != nil {
-		t.Fatal(err)
-	}
-}
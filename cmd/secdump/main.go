@@ -0,0 +1,138 @@
+// Command secdump decrypts a captured secure connection using the
+// session keys recorded through Config.KeyLogWriter, and serves as the
+// reference for dissecting the wire format.
+//
+// It reads one direction of a TCP stream, such as saved from Wireshark's
+// "Follow TCP Stream" view in raw mode, skips the key exchange at the
+// start and prints every frame. The session key is found by trying each
+// key in the log against the first frame.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jboverfelt/secure"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// errNoKey means that no key in the log opened the first frame
+var errNoKey = errors.New("no session key in the key log matches the capture")
+
+// Names of the protocol frame types
+var frameNames = map[secure.FrameType]string{
+	secure.FrameData:    "data",
+	secure.FrameClose:   "close",
+	secure.FramePing:    "ping",
+	secure.FramePong:    "pong",
+	secure.FrameRekey:   "rekey",
+	secure.FrameHello:   "hello",
+	secure.FrameMessage: "message",
+}
+
+// parseKeyLog returns the shared keys recorded in a key log.
+// Lines with other labels are ignored.
+func parseKeyLog(r io.Reader) ([]*[secure.KeySize]byte, error) {
+	var keys []*[secure.KeySize]byte
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 4 || fields[0] != "SECURE_SESSION" {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[3])
+		if err != nil || len(b) != secure.KeySize {
+			return nil, fmt.Errorf("malformed key log line: %s", s.Text())
+		}
+		var key [secure.KeySize]byte
+		copy(key[:], b)
+		keys = append(keys, &key)
+	}
+
+	return keys, s.Err()
+}
+
+// dump prints every frame in stream, which must start at the first frame
+func dump(w io.Writer, stream io.Reader, keys []*[secure.KeySize]byte) error {
+	var key *[secure.KeySize]byte
+
+	frames := bufio.NewScanner(stream)
+	frames.Buffer(make([]byte, secure.MaxFrameSize), secure.MaxFrameSize)
+	frames.Split(secure.ScanFrames)
+	for i := 0; frames.Scan(); i++ {
+		var nonce [secure.NonceSize]byte
+		copy(nonce[:], frames.Bytes())
+		enc := frames.Bytes()[secure.HeaderSize:]
+
+		var plain []byte
+		ok := false
+		if key == nil {
+			for _, k := range keys {
+				if plain, ok = box.OpenAfterPrecomputation(nil, enc, &nonce, k); ok {
+					key = k
+					break
+				}
+			}
+			if key == nil {
+				return errNoKey
+			}
+		} else {
+			plain, ok = box.OpenAfterPrecomputation(nil, enc, &nonce, key)
+		}
+
+		if !ok || len(plain) == 0 {
+			fmt.Fprintf(w, "frame %d: %d bytes, failed to authenticate\n", i, len(enc))
+			continue
+		}
+
+		t, payload := secure.FrameType(plain[0]), plain[1:]
+		name, known := frameNames[t]
+		if !known {
+			name = fmt.Sprintf("type %#x", byte(t))
+		}
+		fmt.Fprintf(w, "frame %d: %s, %d bytes: %q\n", i, name, len(payload), payload)
+	}
+	return frames.Err()
+}
+
+func main() {
+	keyLog := flag.String("keylog", "", "Key log written through Config.KeyLogWriter")
+	skip := flag.Int64("skip", secure.KeySize, "Bytes of key exchange to skip at the start of the stream")
+	flag.Parse()
+
+	if *keyLog == "" || flag.NArg() != 1 {
+		log.Fatalf("Usage: %s -keylog <file> [-skip <bytes>] <stream>", os.Args[0])
+	}
+
+	f, err := os.Open(*keyLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := parseKeyLog(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stream, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stream.Close()
+
+	if _, err := io.CopyN(io.Discard, stream, *skip); err != nil {
+		log.Fatal(err)
+	}
+	if err := dump(os.Stdout, bufio.NewReader(stream), keys); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jboverfelt/secure"
+)
+
+// teeConn records everything read from a net.Conn
+type teeConn struct {
+	net.Conn
+	rec bytes.Buffer
+}
+
+func (c *teeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.rec.Write(p[:n])
+	return n, err
+}
+
+func TestDump(t *testing.T) {
+	l, err := secure.Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		n, err := c.Read(buf)
+		if err != nil {
+			return
+		}
+		c.Write(buf[:n])
+	}()
+
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee := &teeConn{Conn: raw}
+
+	var keyLog bytes.Buffer
+	conn := secure.NewClientConn(tee, &secure.Config{KeyLogWriter: &keyLog})
+	defer conn.Close()
+
+	msg := "hello world\n"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := parseKeyLog(strings.NewReader("# unrelated line\n" + keyLog.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Parsed %d keys from key log, expected 1:\n%s", len(keys), keyLog.String())
+	}
+
+	var out bytes.Buffer
+	stream := tee.rec.Bytes()[secure.KeySize:]
+	if err := dump(&out, bytes.NewReader(stream), keys); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "frame 0: hello, 0 bytes: \"\"\n" +
+		"frame 1: data, 12 bytes: \"hello world\\n\"\n"
+	if out.String() != expected {
+		t.Fatalf("Unexpected dump:\nGot:\t\t%s\nExpected:\t%s\n", out.String(), expected)
+	}
+
+	if err := dump(io.Discard, bytes.NewReader(stream), nil); err != errNoKey {
+		t.Fatalf("Expected errNoKey without keys, got %v", err)
+	}
+}
@@ -0,0 +1,1397 @@
+package secure
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A Config structure is used to configure a Conn.
+// A Config may be reused; the package will not modify it.
+// A nil Config is the same as the zero value.
+type Config struct {
+	// PrivateKey and PublicKey are this side's key pair, as generated
+	// by box.GenerateKey. If either is nil, a fresh pair is generated
+	// for every connection.
+	PrivateKey, PublicKey *[KeySize]byte
+
+	// KeyProvider, if set, takes the place of PrivateKey and PublicKey
+	// so that the private key never has to be held in memory.
+	KeyProvider KeyProvider
+
+	// PairingCode, if set, authenticates the key exchange using Pair.
+	// Without it the handshake trusts whatever public key the peer sends.
+	PairingCode []byte
+
+	// SendPreamble makes clients send the Preamble before the key
+	// exchange, so that servers with a Fallback can recognize them, and
+	// servers require it. Both sides must agree on it.
+	SendPreamble bool
+
+	// NextProtos is a list of supported application level protocols, in
+	// order of preference. Clients offer them during the handshake and
+	// servers select the first of their own that the client offered.
+	// The result is available from ConnectionState. If there is no
+	// overlap, no protocol is selected and the handshake still succeeds.
+	NextProtos []string
+
+	// Suites lists the suites this side supports besides SuiteBox, in
+	// order of preference. Clients offer them during the handshake and
+	// servers select the first of their own that the client offered,
+	// falling back to SuiteBox. The result is available from
+	// ConnectionState. Only suites other than SuiteBox support
+	// WriteWithAD.
+	Suites []Suite
+
+	// Certificate, if not nil, certifies PublicKey as belonging to an
+	// identity and is sent to the peer during the handshake.
+	Certificate *Certificate
+
+	// TrustedIdentities, if not empty, makes the handshake fail unless
+	// the peer presents a Certificate for its public key issued by one
+	// of these identities, so that any device of a trusted user is
+	// accepted. The certificate is available from ConnectionState.
+	TrustedIdentities []ed25519.PublicKey
+
+	// CertificateProof, if not nil, proves that Certificate is in a
+	// CertificateLog and is sent along with it.
+	CertificateProof *InclusionProof
+
+	// TrustedLogs, if not empty, makes the handshake fail with
+	// ErrNotLogged if the peer presents a Certificate without a proof
+	// of its inclusion in a CertificateLog signing with one of these
+	// keys, so that an identity cannot issue certificates that do not
+	// show up in the log. The proof is available from ConnectionState,
+	// for its tree head to be gossiped with VerifyConsistency.
+	TrustedLogs []ed25519.PublicKey
+
+	// ServerName is the name of the server a client means to reach,
+	// sent in its hello so that the server can check that it is the
+	// intended one: a client pinning the key that several services of
+	// an operator share cannot then be redirected from one to another.
+	// The hello is sealed with the session key, and servers that
+	// confirm the name have suites mix it into their keys. Dial sets
+	// it from the address if it is a host name.
+	ServerName string
+
+	// ServerNames, if not empty, are the names a server answers to.
+	// Handshakes with clients naming another server fail with
+	// ErrServerName; those naming none, such as older clients or
+	// ones dialing an IP address, are accepted.
+	ServerNames []string
+
+	// Banner, if not nil, is sent by servers in their hello for
+	// clients to learn about them from ConnectionState.
+	Banner *Banner
+
+	// ClientInfo, if not nil, is sent by clients in their hello for
+	// servers to learn about them from ConnectionState, which is
+	// available to Server.Authorize and to handlers once the
+	// handshake has finished.
+	ClientInfo *ClientInfo
+
+	// KeyInfo, if not nil, describes this side's key pair. Handshakes
+	// fail with ErrKeyExpired once the key has expired, and the key's ID
+	// is sent to the peer.
+	KeyInfo *KeyInfo
+
+	// MaxMessageSize is the largest message sent or accepted in one
+	// frame. Both sides should agree on it. If zero, MaxMessageSize
+	// is used.
+	MaxMessageSize int
+
+	// MaxSessionAge and MaxSessionBytes, if not zero, bound how long
+	// a session's key is used for and how many message bytes are
+	// sealed with it in each direction, so that a compromised key only
+	// exposes that much traffic. Once either is reached the connection
+	// sends a close frame and terminates, and Read and Write fail with
+	// ErrSessionLimit; reads of bytes the peer sent over the limit fail
+	// the same way. The application must then dial again for a new
+	// session. Both sides should agree on them. A resumed session
+	// starts its limits over.
+	MaxSessionAge   time.Duration
+	MaxSessionBytes int64
+
+	// ChunkWrites splits writes larger than MaxMessageSize across
+	// several frames instead of failing them with ErrFrameTooLarge.
+	// A Read still returns at most one frame's worth of data.
+	ChunkWrites bool
+
+	// HandshakeTimeout bounds how long the handshake may take, so that
+	// a peer sending it a byte at a time cannot hold a connection, and
+	// the goroutine serving it, forever. The handshake then fails with
+	// ErrHandshakeTimeout. If zero, DefaultHandshakeTimeout is used;
+	// if negative, the handshake is only bounded by the deadlines.
+	HandshakeTimeout time.Duration
+
+	// FrameTimeout bounds how long the rest of a frame may take to
+	// arrive once its first byte has, whatever the read deadline, so
+	// that a peer cannot hold a half-read frame and its buffer
+	// forever. The connection is then terminated, and Read fails with
+	// ErrFrameTimeout. Waiting for a frame to start is not bounded.
+	// If zero, DefaultFrameTimeout is used; if negative, frames are
+	// only bounded by the read deadline.
+	FrameTimeout time.Duration
+
+	// ChunkTimeout, if not zero, bounds how long each chunk of a
+	// chunked Write may take to be written, on top of the write
+	// deadline, so that a peer that stops reading fails a large Write
+	// with a timeout instead of holding it until the deadline.
+	ChunkTimeout time.Duration
+
+	// Strict makes connections fail closed: the first protocol anomaly
+	// (a frame that fails to parse or authenticate, a frame over the
+	// size limit, a Read buffer too small for the incoming message, a
+	// failed handshake, a control frame without a handler, data after
+	// the close frame) closes the underlying connection, and every
+	// later Read or Write returns the same error. Without Strict the
+	// error is only returned from the Read that hit it, and control
+	// frames without a handler are skipped.
+	Strict bool
+
+	// AuditHook, if not nil, is called with every protocol anomaly
+	// observed on a connection, whether or not Strict is set.
+	AuditHook func(AuditEvent)
+
+	// Recovery is what a Conn does with frames that fail to
+	// authenticate once the handshake is over. With SkipCorrupted
+	// they are dropped instead of failing the Read, even if Strict is
+	// set, and each is reported to the AuditHook as an ErrDecrypt
+	// that did not terminate the connection.
+	Recovery RecoveryPolicy
+
+	// MaxConnMemory, if not zero, bounds the memory a connection
+	// holds in buffers: a frame being read and one being written,
+	// each as ciphertext and plaintext, and the messages read ahead.
+	// ReadAhead is lowered until they fit, and if even one frame each
+	// way does not, so is MaxMessageSize, which the peer should then
+	// be told about, such as with a Banner.
+	MaxConnMemory int
+
+	// ReadAhead, if not zero, is how many messages a goroutine reads
+	// and decrypts ahead of Read, so that the next message is ready
+	// while the application handles the current one. Read deadlines
+	// then bound how long Read waits for a message rather than the
+	// transport's reads, and Ping and ExportSession fail with
+	// ErrReadAhead. Handlers must be registered before the handshake,
+	// as they are called from the goroutine. Each message read ahead
+	// takes a buffer of MaxMessageSize bytes, and the goroutine holds
+	// one more, so a connection buffers up to (ReadAhead+1) times
+	// MaxMessageSize bytes of decrypted data; MaxConnMemory bounds it.
+	ReadAhead int
+
+	// FrameTap, if not nil, is called with a copy of every frame sent
+	// or received after the key exchange, exactly as it appears on the
+	// wire, for traffic capture and debugging. Received frames are
+	// passed before they are authenticated. The hook may keep the
+	// slice. It is called from Read and Write and so must not block.
+	FrameTap func(dir TapDirection, frame []byte)
+
+	// SendMiddleware and ReceiveMiddleware, if not empty, are applied
+	// in order to every frame sent or received after the key
+	// exchange, hellos included, as with Writer.Use and Reader.Use.
+	// Both sides must configure matching chains.
+	SendMiddleware, ReceiveMiddleware []Middleware
+
+	// KeyLogWriter optionally specifies a destination for session keys,
+	// so that captures of the connection can be decrypted later by tools
+	// such as cmd/secdump. Each handshake appends one line
+	//
+	//	SECURE_SESSION <client public key> <server public key> <shared key>
+	//
+	// with every key in lowercase hex. Using KeyLogWriter compromises
+	// the security of the connection and should only be done when
+	// debugging.
+	KeyLogWriter io.Writer
+
+	// Rand provides the entropy for generated keys, nonces and hello
+	// randoms. If nil, crypto/rand.Reader is used. As with crypto/tls,
+	// setting it is meant for deterministic tests: anything but a
+	// cryptographically secure reader breaks the protocol's security.
+	Rand io.Reader
+
+	// Time returns the current time, against which KeyInfo expiry is
+	// checked. If nil, time.Now is used.
+	Time func() time.Time
+
+	// DisableNoDelay re-enables Nagle's algorithm on TCP connections.
+	// Go sets TCP_NODELAY by default, which together with the
+	// single write per frame keeps message latency low; bulk senders
+	// writing many tiny messages may prefer coalescing instead.
+	DisableNoDelay bool
+
+	// ReadBufferSize and WriteBufferSize, if non-zero, set the
+	// operating system's receive and send buffer sizes for TCP
+	// connections.
+	ReadBufferSize, WriteBufferSize int
+
+	// Tracer, if not nil, starts spans for dialing, handshakes and
+	// Send exchanges, such as for OpenTelemetry.
+	Tracer Tracer
+}
+
+// A KeyProvider holds a private key outside of this process, such as in
+// a hardware security module, and performs the key agreement with it.
+type KeyProvider interface {
+	// PublicKey returns the public half of the held key pair.
+	PublicKey() (*[KeySize]byte, error)
+
+	// SharedKey computes the shared key with peerPub that box.Precompute
+	// would compute from the held private key.
+	SharedKey(shared, peerPub *[KeySize]byte) error
+}
+
+// staticKeys is the KeyProvider for a key pair held in memory
+type staticKeys struct {
+	priv, pub *[KeySize]byte
+}
+
+func (k staticKeys) PublicKey() (*[KeySize]byte, error) {
+	return k.pub, nil
+}
+
+func (k staticKeys) SharedKey(shared, peerPub *[KeySize]byte) error {
+	box.Precompute(shared, peerPub, k.priv)
+	return nil
+}
+
+// ErrLinger means that SetLinger was called on a Conn whose
+// underlying connection has no linger setting
+var ErrLinger = errors.New("linger not supported by the underlying connection")
+
+// How long Close waits for the close frame to be written
+const closeTimeout = 5 * time.Second
+
+// Label of the lines written to Config.KeyLogWriter
+const keyLogLabel = "SECURE_SESSION"
+
+var emptyConfig Config
+
+func defaultConfig() *Config {
+	return &emptyConfig
+}
+
+// An AuditEvent describes a protocol anomaly observed on a Conn.
+type AuditEvent struct {
+	// RemoteAddr is the address of the peer.
+	RemoteAddr net.Addr
+
+	// Err is the error the anomaly produced.
+	Err error
+
+	// Terminated reports whether the connection was closed because of it.
+	Terminated bool
+}
+
+// A TapDirection tells a FrameTap which way a frame was going.
+type TapDirection int
+
+// Frame directions
+const (
+	// TapInbound frames were received from the peer.
+	TapInbound TapDirection = iota
+
+	// TapOutbound frames were sent to the peer.
+	TapOutbound
+)
+
+func (d TapDirection) String() string {
+	if d == TapInbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// keyID returns the ID of the configured key pair, if any
+func (c *Config) keyID() string {
+	if c.KeyInfo == nil {
+		return ""
+	}
+	return c.KeyInfo.ID
+}
+
+func (c *Config) randReader() io.Reader {
+	if c.Rand != nil {
+		return c.Rand
+	}
+	return rand.Reader
+}
+
+func (c *Config) now() time.Time {
+	if c.Time != nil {
+		return c.Time()
+	}
+	return time.Now()
+}
+
+// keyProvider returns the configured KeyProvider, falling back to
+// the configured key pair or a freshly generated one
+func (c *Config) keyProvider() (KeyProvider, error) {
+	if c.KeyProvider != nil {
+		return c.KeyProvider, nil
+	}
+
+	pub, priv := c.PublicKey, c.PrivateKey
+	if pub == nil || priv == nil {
+		var err error
+		if pub, priv, err = box.GenerateKey(c.randReader()); err != nil {
+			return nil, err
+		}
+	}
+
+	return staticKeys{priv, pub}, nil
+}
+
+// tune applies the socket options from c to conn
+func (c *Config) tune(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if c.DisableNoDelay {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if c.ReadBufferSize > 0 {
+		if err := tcp.SetReadBuffer(c.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+	if c.WriteBufferSize > 0 {
+		if err := tcp.SetWriteBuffer(c.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Conn represents a secured connection.
+// It implements the net.Conn interface.
+//
+// The handshake exchanges public keys: the server sends its key first,
+// then the client replies with its own. Each side then sends a hello
+// frame carrying handshake extensions. It runs on the first Read or
+// Write unless Handshake is called explicitly.
+type Conn struct {
+	// stats counts the frames read and written, for Stats. It comes
+	// first to keep its atomically updated words 64-bit aligned on
+	// 32-bit platforms.
+	stats connCounters
+
+	conn     net.Conn
+	config   *Config
+	isClient bool
+
+	handshakeMu  sync.Mutex
+	handshakeErr error
+	handshaked   bool
+
+	peerPub *[KeySize]byte
+	r       *Reader
+	w       *Writer
+
+	// failErr is the anomaly that terminated a Strict connection
+	failMu  sync.Mutex
+	failErr error
+
+	// frames enforces Config.FrameTimeout
+	frames frameClock
+
+	// writeMu keeps frames written from Read, such as pongs,
+	// from interleaving with application writes. Control frames
+	// waiting for it are counted in urgent, and chunked writes step
+	// aside for them between chunks by waiting on writeCond, so that
+	// bulk transfers do not hold them back.
+	writeMu   sync.Mutex
+	writeCond sync.Cond
+	urgent    int32
+
+	// activeCall counts the handshakes and application writes in
+	// progress in its upper bits, twice over, and has its lowest bit
+	// set once Close was called, as in crypto/tls
+	activeCall int32
+
+	// lifecycle is the ConnState, updated atomically
+	lifecycle int32
+
+	handlers map[FrameType]func([]byte) error
+
+	// stateMu guards connection details learned from hellos, which
+	// on the client may arrive during the first Read
+	stateMu        sync.Mutex
+	protocol       string
+	suite          Suite
+	peerCert       *Certificate
+	peerCertProof  *InclusionProof
+	peerKeyID      string
+	serverName     string
+	peerBanner     *Banner
+	peerClientInfo *ClientInfo
+
+	// waitHello makes a client read the server's hello during the
+	// handshake even if it does not need it to finish
+	waitHello bool
+
+	// clientRandom is the client's hello random, kept
+	// until the server's hello arrives
+	clientRandom []byte
+
+	// goroutines counts those started with Go, up to maxGoroutines
+	// if it is not zero. track, if not nil, is told of every one
+	// starting and exiting, for the Server's accounting.
+	goroutines    int32
+	maxGoroutines int32
+	track         func(delta int64)
+
+	// quota, if not nil, is charged for the messages read and
+	// written, as the usage of the peer named quotaKey
+	quota    *Quota
+	quotaKey string
+
+	// closed is closed by Close, to stop what waits on a timer
+	// rather than the transport, such as a throttled quota
+	closed chan struct{}
+
+	// sessionEnd is when the session reaches MaxSessionAge, and
+	// sessionTimer terminates it then. sessionSent and sessionRecv
+	// count the message bytes sealed with its key.
+	sessionEnd   time.Time
+	sessionTimer *time.Timer
+	sessionSent  int64
+	sessionRecv  int64
+
+	// ahead, if not nil, holds the messages read ahead with
+	// Config.ReadAhead. readDeadline is the last read deadline set,
+	// which it takes over from the transport. Both are guarded by
+	// stateMu, as is writeDeadline, the last write deadline set,
+	// which chunked writes go back to after setting their own.
+	ahead         *readAhead
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// spanCtx holds the span the handshake span is a child of,
+	// until the handshake has run
+	spanCtx context.Context
+}
+
+// ConnectionState records basic details about the connection.
+type ConnectionState struct {
+	// HandshakeComplete is true once the key exchange has finished.
+	HandshakeComplete bool
+
+	// PeerPublicKey is the public key presented by the peer.
+	PeerPublicKey *[KeySize]byte
+
+	// Protocol is the negotiated application protocol, or "" if none
+	// was selected. A client that offered no protocols only learns the
+	// server's choice on its first Read.
+	Protocol string
+
+	// Suite is the suite sealing the connection's frames.
+	Suite Suite
+
+	// PeerCertificate is the certificate the peer presented for its
+	// public key, if it presented a valid one. A client without
+	// TrustedIdentities only learns it on its first Read.
+	PeerCertificate *Certificate
+
+	// PeerCertificateProof is the proof that PeerCertificate is in a
+	// certificate log, if the peer sent one and it was verified
+	// against Config.TrustedLogs.
+	PeerCertificateProof *InclusionProof
+
+	// PeerKeyID is the ID the peer gave for its key pair, if any, so
+	// that a side holding several of the peer's rotated public keys can
+	// tell which one is in use. A client only learns it on its first
+	// Read unless the handshake waits for the server's hello.
+	PeerKeyID string
+
+	// ServerName is the name the client gave for the server, once
+	// the server has accepted it. A client learns it with PeerKeyID;
+	// it stays empty if the server does not check names.
+	ServerName string
+
+	// PeerBanner is the Banner the server sent, on clients. Like
+	// PeerKeyID, it is only known after the first Read unless the
+	// handshake waits for the server's hello.
+	PeerBanner *Banner
+
+	// PeerClientInfo is the ClientInfo the client sent, on servers.
+	PeerClientInfo *ClientInfo
+
+	// RTT is the smoothed round-trip time measured by Ping and
+	// SendPing, as in Stats, or zero if no ping was answered yet.
+	RTT time.Duration
+
+	// Lifecycle is where the connection is in its lifecycle.
+	Lifecycle ConnState
+}
+
+// NewClientConn returns a new secure client side connection
+// using conn as the underlying transport.
+func NewClientConn(conn net.Conn, config *Config) *Conn {
+	if config == nil {
+		config = defaultConfig()
+	}
+	c := &Conn{conn: conn, config: config, isClient: true, closed: make(chan struct{})}
+	c.writeCond.L = &c.writeMu
+	return c
+}
+
+// NewServerConn returns a new secure server side connection
+// using conn as the underlying transport.
+func NewServerConn(conn net.Conn, config *Config) *Conn {
+	if config == nil {
+		config = defaultConfig()
+	}
+	c := &Conn{conn: conn, config: config, closed: make(chan struct{})}
+	c.writeCond.L = &c.writeMu
+	return c
+}
+
+// Dial connects to the given network address using net.Dial
+// and then performs the handshake. The address may also be a secure
+// URI, which pins the server's public key as described for Dialer.
+func Dial(network, addr string, config *Config) (*Conn, error) {
+	return (&Dialer{Config: config}).dial(context.Background(), network, addr, false)
+}
+
+type listener struct {
+	net.Listener
+	config *Config
+}
+
+// Accept waits for and returns the next incoming secure connection.
+// The returned connection is of type *Conn. A connection whose socket
+// options cannot be applied is closed and skipped.
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.config.tune(c); err != nil {
+			c.Close()
+			continue
+		}
+
+		return NewServerConn(c, l.config), nil
+	}
+}
+
+// NewListener creates a Listener which accepts connections from an inner
+// Listener and wraps each connection with NewServerConn.
+func NewListener(inner net.Listener, config *Config) net.Listener {
+	if config == nil {
+		config = defaultConfig()
+	}
+	return &listener{inner, config}
+}
+
+// Listen creates a secure listener accepting connections on the
+// given network address using net.Listen.
+func Listen(network, laddr string, config *Config) (net.Listener, error) {
+	l, err := net.Listen(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(l, config), nil
+}
+
+// Handshake runs the key exchange if it has not yet been run.
+// Most uses of this package need not call Handshake explicitly:
+// the first Read or Write will call it automatically.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if c.handshaked || c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	if err := c.beginCall(); err != nil {
+		c.handshakeErr = err
+		return err
+	}
+	defer c.endCall()
+	c.setState(StateHandshaking)
+
+	ctx := c.spanCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.spanCtx = nil
+	_, span := c.config.startSpan(ctx, SpanHandshake)
+	span.SetAttribute("secure.client", c.isClient)
+
+	stop := c.startHandshakeTimer()
+	c.handshakeErr = c.closedErr(c.handshake())
+	if stop() && c.handshakeErr != nil {
+		c.handshakeErr = ErrHandshakeTimeout
+	}
+	c.handshaked = c.handshakeErr == nil
+	if a := alertFor(c.handshakeErr); a != nil {
+		// the peer hears why if the keys to seal it were agreed
+		c.sendAlert(a)
+	}
+	if !c.handshaked {
+		c.setState(StateClosed)
+	} else {
+		c.setState(StateEstablished)
+		c.startLimits()
+		c.setRecovery()
+		c.startReadAhead()
+		span.SetAttribute("secure.peer", Fingerprint(c.peerPub))
+		c.stateMu.Lock()
+		span.SetAttribute("secure.suite", c.suite.String())
+		span.SetAttribute("secure.protocol", c.protocol)
+		c.stateMu.Unlock()
+	}
+	span.End(c.handshakeErr)
+	if c.handshakeErr != nil && c.handshakeErr != net.ErrClosed {
+		c.anomaly(c.handshakeErr)
+	}
+	return c.handshakeErr
+}
+
+// anomaly reports a protocol anomaly to the audit hook
+// and, in Strict mode, terminates the connection
+func (c *Conn) anomaly(err error) {
+	if c.config.Strict {
+		c.failMu.Lock()
+		if c.failErr == nil {
+			c.failErr = err
+			c.conn.Close()
+		}
+		c.failMu.Unlock()
+		c.setState(StateClosed)
+	}
+
+	if c.config.AuditHook != nil {
+		c.config.AuditHook(AuditEvent{
+			RemoteAddr: c.conn.RemoteAddr(),
+			Err:        err,
+			Terminated: c.config.Strict,
+		})
+	}
+}
+
+// failed returns the anomaly that terminated the connection, if any
+func (c *Conn) failed() error {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+	return c.failErr
+}
+
+// isAnomaly reports whether err from a Reader means the peer
+// broke the protocol, rather than the transport failing
+func isAnomaly(err error) bool {
+	switch err {
+	case ErrDecrypt, ErrFrame, ErrFrameTooLarge, ErrFrameType, ErrHello, ErrTruncated, ErrTrailingData, ErrFrameOrder, ErrNonceExhausted, io.ErrShortBuffer:
+		return true
+	}
+	return false
+}
+
+func (c *Conn) handshake() error {
+	if info := c.config.KeyInfo; info != nil && info.Expired(c.config.now()) {
+		return ErrKeyExpired
+	}
+
+	kp, err := c.config.keyProvider()
+	if err != nil {
+		return err
+	}
+
+	if c.config.SendPreamble {
+		if err := c.preamble(); err != nil {
+			return err
+		}
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	peerPub, err := c.exchangeKeys(pub)
+	if err != nil {
+		return err
+	}
+
+	var shared [KeySize]byte
+	if err := kp.SharedKey(&shared, peerPub); err != nil {
+		return err
+	}
+
+	if err := c.logKey(pub, peerPub, &shared); err != nil {
+		return err
+	}
+
+	c.peerPub = peerPub
+	c.setupSession(&shared)
+
+	if c.isClient {
+		return c.clientHello()
+	}
+	return c.serverHello()
+}
+
+// setupSession creates the Reader and Writer sealing frames
+// with the shared key
+func (c *Conn) setupSession(shared *[KeySize]byte) {
+	maxMessage, _ := c.config.memoryLimits()
+	c.r = newSharedReader(c.conn, shared)
+	c.r.SetMaxMessageSize(maxMessage)
+	c.r.SetStrict(c.config.Strict)
+	c.w = newSharedWriter(c.conn, shared)
+	c.w.SetMaxMessageSize(maxMessage)
+	c.w.SetChunking(c.config.ChunkWrites)
+	c.w.nonces.rand = c.config.randReader()
+	c.r.dir, c.w.nonces.dir = dirFromServer, dirFromClient
+	if !c.isClient {
+		c.r.dir, c.w.nonces.dir = dirFromClient, dirFromServer
+	}
+	if tap := c.config.FrameTap; tap != nil {
+		c.r.tap = func(frame []byte) { tap(TapInbound, frame) }
+		c.w.tap = func(frame []byte) { tap(TapOutbound, frame) }
+	}
+
+	c.w.Use(c.config.SendMiddleware...)
+	c.r.Use(c.config.ReceiveMiddleware...)
+
+	if c.config.frameTimeout() > 0 {
+		c.r.onFrame = c.frameRead
+	}
+
+	c.r.count = func(size int) { c.countFrame(&c.stats.framesIn, &c.stats.bytesIn, size) }
+	c.w.count = func(size int) { c.countFrame(&c.stats.framesOut, &c.stats.bytesOut, size) }
+
+	c.r.Handle(FramePing, func(payload []byte) error {
+		return c.writeFrame(FramePong, payload)
+	})
+	c.r.Handle(FramePong, c.handlePong)
+	c.r.Handle(FrameRekey, c.handleRekey)
+	for t, fn := range c.handlers {
+		c.r.Handle(t, fn)
+	}
+}
+
+// logKey writes the session key to the KeyLogWriter, if any
+func (c *Conn) logKey(pub, peerPub, shared *[KeySize]byte) error {
+	if c.config.KeyLogWriter == nil {
+		return nil
+	}
+
+	client, server := pub, peerPub
+	if !c.isClient {
+		client, server = peerPub, pub
+	}
+
+	_, err := fmt.Fprintf(c.config.KeyLogWriter, "%s %x %x %x\n", keyLogLabel, client[:], server[:], shared[:])
+	return err
+}
+
+// exchangeKeys sends our public key and receives the peer's.
+// The server speaks first.
+func (c *Conn) exchangeKeys(pub *[KeySize]byte) (*[KeySize]byte, error) {
+	if len(c.config.PairingCode) > 0 {
+		return Pair(c.conn, c.config.PairingCode, pub, c.isClient)
+	}
+
+	var peerPub [KeySize]byte
+	if !c.isClient {
+		// send our public key
+		if _, err := c.conn.Write(pub[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// wait for the peer's public key
+	if _, err := io.ReadFull(c.conn, peerPub[:]); err != nil {
+		return nil, err
+	}
+
+	if c.isClient {
+		// send our public key
+		if _, err := c.conn.Write(pub[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &peerPub, nil
+}
+
+// PeerPublicKey returns the public key presented by the peer,
+// running the handshake first if necessary.
+func (c *Conn) PeerPublicKey() (*[KeySize]byte, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return c.peerPub, nil
+}
+
+// ConnectionState returns basic details about the connection.
+func (c *Conn) ConnectionState() ConnectionState {
+	c.handshakeMu.Lock()
+	state := ConnectionState{
+		HandshakeComplete: c.handshaked,
+		PeerPublicKey:     c.peerPub,
+	}
+	c.handshakeMu.Unlock()
+
+	c.stateMu.Lock()
+	state.Protocol = c.protocol
+	state.Suite = c.suite
+	state.PeerCertificate = c.peerCert
+	state.PeerCertificateProof = c.peerCertProof
+	state.PeerKeyID = c.peerKeyID
+	state.ServerName = c.serverName
+	state.PeerBanner = c.peerBanner
+	state.PeerClientInfo = c.peerClientInfo
+	c.stateMu.Unlock()
+	state.RTT = c.Stats().RTT
+	state.Lifecycle = c.state()
+	return state
+}
+
+// Read reads and decrypts one message from the connection.
+// A Conn holds up to Config.ReadAhead+1 messages of decrypted data:
+// the one being read and those decrypted ahead of Read in the
+// background. With the default ReadAhead of zero, frames are only read
+// and decrypted as Read is called, so a sender faster than the reader
+// is held back by the transport's own flow control, such as TCP's
+// receive window; with read-ahead, only once ReadAhead messages are
+// waiting for Read.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, _, err := c.ReadWithAD(p)
+	return n, err
+}
+
+// ReadWithAD is like Read, but also returns the additional data
+// the peer sent with the message using WriteWithAD, if any.
+func (c *Conn) ReadWithAD(p []byte) (int, []byte, error) {
+	n, ad, _, err := c.read(p)
+	return n, ad, err
+}
+
+// read reads one message along with its additional data and header
+func (c *Conn) read(p []byte) (int, []byte, MessageInfo, error) {
+	if c.closing() {
+		return 0, nil, MessageInfo{}, net.ErrClosed
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, nil, MessageInfo{}, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, nil, MessageInfo{}, err
+	}
+	c.assertSession("read")
+
+	if err := c.checkQuota(); err != nil {
+		return 0, nil, MessageInfo{}, err
+	}
+
+	var (
+		n    int
+		ad   []byte
+		info MessageInfo
+		err  error
+	)
+	if a := c.readAheadState(); a != nil {
+		n, ad, info, err = c.readBuffered(a, p)
+	} else {
+		n, ad, err = c.r.ReadWithAD(p)
+		info = c.r.info
+	}
+	err = c.closedErr(err)
+	if err != nil {
+		// the connection may have been terminated while Read waited
+		if ferr := c.failed(); ferr != nil {
+			return 0, nil, MessageInfo{}, ferr
+		}
+	}
+	if isAnomaly(err) {
+		c.anomaly(err)
+	}
+	if a, ok := err.(*AlertError); ok {
+		// the peer gave up on the connection, so Writes fail too
+		c.abort(a)
+	}
+	if err == io.EOF {
+		c.setState(StateDraining)
+	}
+	if err := c.limitRead(n); err != nil {
+		return 0, nil, MessageInfo{}, err
+	}
+	return n, ad, info, c.chargeQuota(context.Background(), false, n, c.exhausted(err))
+}
+
+// Write encrypts p and writes it to the connection as one message.
+// With Config.ChunkWrites, data larger than a message is split across
+// several, and control frames such as pongs and the close frame are
+// sent between them rather than after the whole of p.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.WriteContext(context.Background(), p)
+}
+
+// WriteContext is like Write, but gives up once ctx is done, returning
+// ctx.Err() and the number of bytes in the messages written in full.
+// A chunked write stops between chunks, leaving the connection usable,
+// or interrupts the chunk being written; as the peer cannot read past
+// the part of a frame already sent, that terminates the connection.
+func (c *Conn) WriteContext(ctx context.Context, p []byte) (int, error) {
+	if err := c.beginCall(); err != nil {
+		return 0, err
+	}
+	defer c.endCall()
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+
+	if err := c.checkQuota(); err != nil {
+		return 0, err
+	}
+	if err := c.limitWrite(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.write(ctx, p)
+	return n, c.chargeQuota(ctx, true, n, c.exhausted(c.closedErr(err)))
+}
+
+// write is WriteContext once the handshake is done
+func (c *Conn) write(ctx context.Context, p []byte) (int, error) {
+	c.assertSession("write")
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	stop := c.interruptWrites(ctx)
+	defer stop()
+	if len(p) <= c.w.max || !c.w.chunk {
+		n, err := c.w.Write(p)
+		return n, c.interrupted(ctx, time.Time{}, err)
+	}
+
+	n := 0
+	for n < len(p) {
+		c.yield()
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		chunk := p[n:]
+		if len(chunk) > c.w.max {
+			chunk = chunk[:c.w.max]
+		}
+		deadline := c.chunkDeadline(ctx)
+		m, err := c.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, c.interrupted(ctx, deadline, err)
+		}
+	}
+	return n, nil
+}
+
+// WriteWithAD encrypts p and writes it to the connection as one
+// message, together with the additional data ad, which is sent in the
+// clear but authenticated with p. It fails with ErrNoAD unless a suite
+// other than SuiteBox was negotiated.
+func (c *Conn) WriteWithAD(p, ad []byte) (int, error) {
+	if err := c.beginCall(); err != nil {
+		return 0, err
+	}
+	defer c.endCall()
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+
+	if err := c.checkQuota(); err != nil {
+		return 0, err
+	}
+	if err := c.limitWrite(len(p)); err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	n, err := c.w.WriteWithAD(p, ad)
+	c.writeMu.Unlock()
+	return n, c.chargeQuota(context.Background(), true, n, c.exhausted(c.closedErr(err)))
+}
+
+// WriteMessagev encrypts the concatenation of bufs and writes it to
+// the connection as one message, which the peer reads with one Read,
+// without the caller joining them first. It is never chunked, even
+// with Config.ChunkWrites.
+func (c *Conn) WriteMessagev(bufs [][]byte) (int, error) {
+	if err := c.beginCall(); err != nil {
+		return 0, err
+	}
+	defer c.endCall()
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	if err := c.checkQuota(); err != nil {
+		return 0, err
+	}
+	if err := c.limitWrite(n); err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	n, err := c.w.WriteMessagev(bufs)
+	c.writeMu.Unlock()
+	return n, c.chargeQuota(context.Background(), true, n, c.exhausted(c.closedErr(err)))
+}
+
+// Handle registers fn to be called from Read with the payload of
+// every application-defined control frame of type t, which must be
+// FrameControl or above. It must not be called concurrently with Read,
+// nor after the handshake with Config.ReadAhead.
+func (c *Conn) Handle(t FrameType, fn func(payload []byte) error) {
+	if c.handlers == nil {
+		c.handlers = make(map[FrameType]func([]byte) error)
+	}
+	c.handlers[t] = fn
+
+	if c.r != nil {
+		c.r.Handle(t, fn)
+	}
+}
+
+// WriteFrame sends an application-defined control frame of type t,
+// which must be FrameControl or above. Protocol frames are managed by
+// the Conn itself.
+func (c *Conn) WriteFrame(t FrameType, payload []byte) error {
+	if t < FrameControl {
+		return ErrFrameType
+	}
+	if err := c.beginCall(); err != nil {
+		return err
+	}
+	defer c.endCall()
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	if err := c.failed(); err != nil {
+		return err
+	}
+	return c.exhausted(c.closedErr(c.writeFrame(t, payload)))
+}
+
+func (c *Conn) writeFrame(t FrameType, payload []byte) error {
+	c.assertSession("write frame")
+	c.lockUrgent()
+	defer c.unlockUrgent()
+	return c.w.WriteFrame(t, payload)
+}
+
+// lockUrgent locks writeMu ahead of the remaining chunks
+// of any chunked Write in progress
+func (c *Conn) lockUrgent() {
+	atomic.AddInt32(&c.urgent, 1)
+	c.writeMu.Lock()
+}
+
+func (c *Conn) unlockUrgent() {
+	atomic.AddInt32(&c.urgent, -1)
+	c.writeMu.Unlock()
+	c.writeCond.Broadcast()
+}
+
+// yield lets the frames waiting in lockUrgent go first.
+// c.writeMu must be held.
+func (c *Conn) yield() {
+	for atomic.LoadInt32(&c.urgent) > 0 {
+		c.writeCond.Wait()
+	}
+}
+
+// interruptWrites expires the write deadline once ctx is done, like
+// handshakeContext, and returns a func that stops it and puts back the
+// deadline set with SetWriteDeadline. c.writeMu must be held.
+func (c *Conn) interruptWrites(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil && c.config.ChunkTimeout <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.conn.SetWriteDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+		c.stateMu.Lock()
+		c.conn.SetWriteDeadline(c.writeDeadline)
+		c.stateMu.Unlock()
+	}
+}
+
+// chunkDeadline sets the write deadline for the next chunk of a
+// chunked write from Config.ChunkTimeout and returns it, or the zero
+// time if there is none
+func (c *Conn) chunkDeadline(ctx context.Context) time.Time {
+	timeout := c.config.ChunkTimeout
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	deadline := time.Now().Add(timeout)
+	if d := c.writeDeadline; !d.IsZero() && d.Before(deadline) {
+		deadline = d
+	}
+	// ctx may have been done since it was checked, and its
+	// expired deadline must not be replaced
+	if ctx.Err() == nil {
+		c.conn.SetWriteDeadline(deadline)
+	}
+	return deadline
+}
+
+// interrupted returns the error for a write that failed with err. One
+// cut short by ctx or by the deadline leaves part of a frame written,
+// which the peer cannot read past, so it terminates the connection.
+func (c *Conn) interrupted(ctx context.Context, deadline time.Time, err error) error {
+	if err != ErrEncWrite {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	} else if !deadline.IsZero() && !time.Now().Before(deadline) {
+		err = os.ErrDeadlineExceeded
+	} else {
+		return err
+	}
+
+	c.failMu.Lock()
+	if c.failErr == nil {
+		c.failErr = err
+	}
+	c.failMu.Unlock()
+	c.conn.Close()
+	return err
+}
+
+// Close sends a close frame, unless CloseWrite already did, and closes
+// the underlying connection. The peer's Read then returns io.EOF.
+// Reads and writes blocked on the connection, and any made later,
+// fail with net.ErrClosed. A Close while a Write or the handshake is
+// in progress is taken to be breaking it off, as with crypto/tls, and
+// closes the underlying connection without waiting to send the close
+// frame. Closing a closed Conn does nothing.
+func (c *Conn) Close() error {
+	active, ok := c.markClosed()
+	if !ok {
+		return nil
+	}
+	if !active {
+		c.sendClose()
+	}
+	return c.conn.Close()
+}
+
+// CloseAbort closes the connection at once, without sending the close
+// frame, and resets TCP connections instead of shutting them down, so
+// that data not yet sent is dropped and the socket does not linger.
+// The peer's Read fails instead of returning io.EOF. It is meant for
+// shedding misbehaving peers without waiting on them. As with Close,
+// blocked reads and writes fail with net.ErrClosed, and aborting a
+// closed Conn does nothing.
+func (c *Conn) CloseAbort() error {
+	if _, ok := c.markClosed(); !ok {
+		return nil
+	}
+	if l, ok := c.conn.(interface{ SetLinger(sec int) error }); ok {
+		l.SetLinger(0)
+	}
+	return c.conn.Close()
+}
+
+// SetLinger sets how Close behaves with data still waiting to be sent
+// on the underlying connection, as for net.TCPConn: with sec < 0 (the
+// default) it is sent in the background, with sec == 0 it is dropped
+// and the connection reset, and with sec > 0 it is sent in the
+// background for up to sec seconds. It fails with ErrLinger if the
+// underlying connection has no such setting.
+func (c *Conn) SetLinger(sec int) error {
+	l, ok := c.conn.(interface{ SetLinger(sec int) error })
+	if !ok {
+		return ErrLinger
+	}
+	return l.SetLinger(sec)
+}
+
+// markClosed marks c closed and stops its timers and goroutines. It
+// reports whether a handshake or write was in progress, and fails if c
+// was already closed.
+func (c *Conn) markClosed() (active, ok bool) {
+	for {
+		x := atomic.LoadInt32(&c.activeCall)
+		if x&1 != 0 {
+			return false, false
+		}
+		if atomic.CompareAndSwapInt32(&c.activeCall, x, x|1) {
+			active = x != 0
+			break
+		}
+	}
+	c.setState(StateClosed)
+	close(c.closed)
+
+	c.stopLimits()
+	c.stopReadAhead()
+	return active, true
+}
+
+// beginCall counts a handshake or write in progress,
+// failing with net.ErrClosed once Close was called
+func (c *Conn) beginCall() error {
+	for {
+		x := atomic.LoadInt32(&c.activeCall)
+		if x&1 != 0 {
+			return net.ErrClosed
+		}
+		if atomic.CompareAndSwapInt32(&c.activeCall, x, x+2) {
+			return nil
+		}
+	}
+}
+
+func (c *Conn) endCall() {
+	atomic.AddInt32(&c.activeCall, -2)
+}
+
+// closing reports whether Close was called
+func (c *Conn) closing() bool {
+	return atomic.LoadInt32(&c.activeCall)&1 != 0
+}
+
+// closedErr turns the error of a read or write that Close cut short,
+// which the transport failing under it leaves as a frame error,
+// into net.ErrClosed
+func (c *Conn) closedErr(err error) error {
+	if err != nil && c.closing() {
+		return net.ErrClosed
+	}
+	return err
+}
+
+// CloseWrite sends a close frame and shuts down the writing side of the
+// underlying connection, if it supports that. It must only be called
+// once the handshake has completed. The peer's Read returns io.EOF
+// while this side can go on reading until the peer closes in turn.
+func (c *Conn) CloseWrite() error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	if err := c.sendClose(); err != nil {
+		return err
+	}
+	if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// sendClose writes the close frame if it has not been written yet.
+// Like crypto/tls, it bounds the write in case the peer stopped reading.
+func (c *Conn) sendClose() error {
+	c.handshakeMu.Lock()
+	handshaked := c.handshaked
+	c.handshakeMu.Unlock()
+	if !handshaked || c.failed() != nil {
+		return nil
+	}
+
+	c.lockUrgent()
+	defer c.unlockUrgent()
+	if c.w.closed {
+		return nil
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.setState(StateDraining)
+	return c.w.Close()
+}
+
+// NetConn returns the underlying connection that is wrapped by c.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated with the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline on the underlying connection.
+// With Config.ReadAhead it bounds how long Read waits for a message
+// once the handshake has completed.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.readDeadline = t
+	if c.ahead != nil {
+		c.ahead.setDeadline(t)
+		return nil
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.writeDeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,779 @@
+package secure
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jboverfelt/secure/securetest"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// echoServer accepts secure connections on l and echoes
+// every message back until the peer goes away.
+func echoServer(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(c net.Conn) {
+			defer c.Close()
+			buf := make([]byte, MaxMessageSize)
+			for {
+				n, err := c.Read(buf)
+				if err != nil {
+					return
+				}
+				if _, err := c.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestConn(t *testing.T) {
+	config := &Config{ReadBufferSize: 64 * 1024, WriteBufferSize: 64 * 1024}
+	l, err := Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"hello world\n", "goodbye\n"} {
+		if _, err := io.WriteString(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := string(buf[:n]); res != msg {
+			t.Fatalf("Unexpected result: %s != %s", res, msg)
+		}
+	}
+}
+
+// brokenListener closes the first broken connections it accepts before
+// returning them, so that applying socket options to them fails
+type brokenListener struct {
+	net.Listener
+	broken int
+}
+
+func (l *brokenListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil && l.broken > 0 {
+		l.broken--
+		c.Close()
+	}
+	return c, err
+}
+
+func TestAcceptTuneError(t *testing.T) {
+	config := &Config{DisableNoDelay: true}
+	for _, serve := range []func(net.Listener){
+		func(l net.Listener) { echoServer(NewListener(l, config)) },
+		func(l net.Listener) {
+			(&Server{Config: config, Handler: HandlerFunc(func(c *Conn) { io.Copy(c, c) })}).Serve(l)
+		},
+	} {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go serve(&brokenListener{inner, 1})
+
+		if _, err := Dial("tcp", inner.Addr().String(), config); err == nil {
+			t.Fatal("Expected the first connection to fail")
+		}
+
+		// the next connection is still served
+		conn, err := Dial("tcp", inner.Addr().String(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(conn, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("Unexpected echo: %q, %v", buf[:n], err)
+		}
+		conn.Close()
+		inner.Close()
+	}
+}
+
+func TestConnKeyProvider(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{KeyProvider: staticKeys{priv, pub}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if peerPub, _ := conn.PeerPublicKey(); *peerPub != *pub {
+		t.Fatal("Unexpected server public key")
+	}
+
+	if _, err := io.WriteString(conn, "hello world\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := string(buf[:n]); res != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", res, "hello world")
+	}
+}
+
+func benchmarkRoundTrip(b *testing.B, config *Config) {
+	l, err := Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := make([]byte, 64)
+	buf := make([]byte, MaxMessageSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Small request/response exchanges are where frame emission matters:
+// compare the default against Nagle's algorithm being enabled.
+func BenchmarkRoundTrip(b *testing.B) {
+	benchmarkRoundTrip(b, nil)
+}
+
+func BenchmarkRoundTripNagle(b *testing.B) {
+	benchmarkRoundTrip(b, &Config{DisableNoDelay: true})
+}
+
+// readRawFrame reads one complete frame from r without decrypting it
+func readRawFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	enc := make([]byte, binary.LittleEndian.Uint16(header[NonceSize:]))
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return nil, err
+	}
+	return append(header, enc...), nil
+}
+
+func TestConnStrict(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	var events []AuditEvent
+	conn := NewClientConn(client, &Config{
+		Strict:    true,
+		AuditHook: func(e AuditEvent) { events = append(events, e) },
+	})
+
+	// a peer that completes the handshake but then sends a forged frame
+	go func() {
+		server.Write(make([]byte, KeySize))
+		io.ReadFull(server, make([]byte, KeySize))
+		readRawFrame(server) // client hello
+		frame := make([]byte, SealedSize(12))
+		frame[NonceSize] = byte(len(frame) - HeaderSize)
+		server.Write(frame)
+	}()
+
+	buf := make([]byte, 1024)
+	if _, err := conn.Read(buf); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+	if len(events) != 1 || events[0].Err != ErrDecrypt || !events[0].Terminated {
+		t.Fatalf("Unexpected audit events: %v", events)
+	}
+
+	// the connection must stay failed and be closed underneath
+	if _, err := conn.Write([]byte("hello world\n")); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestConnNextProtos(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{NextProtos: []string{"echo/2", "echo/1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	for _, tc := range []struct {
+		offered  []string
+		expected string
+	}{
+		{[]string{"echo/1", "echo/2"}, "echo/2"},
+		{[]string{"echo/1"}, "echo/1"},
+		{[]string{"rpc/1"}, ""},
+		{nil, ""},
+	} {
+		conn, err := Dial("tcp", l.Addr().String(), &Config{NextProtos: tc.offered})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(conn, "hello world\n"); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if p := conn.ConnectionState().Protocol; p != tc.expected {
+			t.Fatalf("Unexpected protocol for %q: %q != %q", tc.offered, p, tc.expected)
+		}
+		conn.Close()
+	}
+}
+
+func TestConnFrameTap(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	var frames [2][][]byte
+	config := &Config{
+		FrameTap: func(dir TapDirection, frame []byte) {
+			frames[dir] = append(frames[dir], frame)
+		},
+	}
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := "hello world\n"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hello and a data frame each way
+	for dir, fs := range frames {
+		if len(fs) != 2 {
+			t.Fatalf("Tapped %d %v frames, expected 2", len(fs), TapDirection(dir))
+		}
+		for _, f := range fs {
+			if len(f) < HeaderSize || int(binary.LittleEndian.Uint16(f[NonceSize:])) != len(f)-HeaderSize {
+				t.Fatalf("Tapped a malformed %v frame: %x", TapDirection(dir), f)
+			}
+		}
+		if len(fs[1]) != SealedSize(len(msg)) {
+			t.Fatalf("Tapped %v data frame of %d bytes, expected %d", TapDirection(dir), len(fs[1]), SealedSize(len(msg)))
+		}
+	}
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := "hello world\n"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server sees io.EOF, closes in turn and the copy ends cleanly
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, conn); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != msg {
+		t.Fatalf("Unexpected result: %s != %s", got.String(), msg)
+	}
+}
+
+func TestConnSuites(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := &Config{Suites: []Suite{SuiteAES256GCM}}
+	go (&Server{Config: config, Handler: HandlerFunc(func(c *Conn) {
+		buf := make([]byte, 1024)
+		n, ad, err := c.ReadWithAD(buf)
+		if err != nil {
+			return
+		}
+		c.WriteWithAD(buf[:n], ad)
+	})}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if s := conn.ConnectionState().Suite; s != SuiteAES256GCM {
+		t.Fatalf("Unexpected suite: %v", s)
+	}
+	if _, err := conn.WriteWithAD([]byte("hello world\n"), []byte("id=1")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, ad, err := conn.ReadWithAD(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello world\n" || string(ad) != "id=1" {
+		t.Fatalf("Unexpected result: %q %q", buf[:n], ad)
+	}
+
+	// without a common suite, box is used and cannot carry additional data
+	conn, err = Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if s := conn.ConnectionState().Suite; s != SuiteBox {
+		t.Fatalf("Unexpected suite: %v", s)
+	}
+	if _, err := conn.WriteWithAD([]byte("hello world\n"), []byte("id=1")); err != ErrNoAD {
+		t.Fatalf("Expected ErrNoAD, got %v", err)
+	}
+}
+
+func TestConnServerName(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	go (&Server{
+		Config: &Config{Suites: []Suite{SuiteAES256GCM}, ServerNames: []string{"b.example"}},
+		Handler: HandlerFunc(func(c *Conn) {
+			if c.Handshake() == nil {
+				io.WriteString(c, "served "+c.ConnectionState().ServerName)
+			}
+		}),
+	}).Serve(l)
+
+	for _, tc := range []struct {
+		addr, name string
+		want       string
+	}{
+		{l.Addr().String(), "B.example.", "served B.example."},
+		{l.Addr().String(), "", "served "},
+		{l.Addr().String(), "a.example", ""},
+		// Dial names the server after the host it dials
+		{net.JoinHostPort("localhost", port), "", ""},
+	} {
+		for _, suites := range [][]Suite{nil, {SuiteAES256GCM}} {
+			config := &Config{ServerName: tc.name, Suites: suites}
+			conn, err := Dial("tcp", tc.addr, config)
+			if err == nil {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				var b []byte
+				b, err = ioutil.ReadAll(conn)
+				if err == nil && string(b) != tc.want {
+					t.Errorf("%s as %q: got %q, want %q", tc.addr, tc.name, b, tc.want)
+				}
+				if state := conn.ConnectionState(); err == nil && state.ServerName != tc.name {
+					t.Errorf("%s as %q: ServerName %q", tc.addr, tc.name, state.ServerName)
+				}
+				conn.Close()
+			}
+			if (err == nil) != (tc.want != "") {
+				t.Errorf("%s as %q with suites %v: %v", tc.addr, tc.name, suites, err)
+			}
+		}
+	}
+}
+
+func TestConnControlDuringChunkedWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const (
+		frameAsk    = FrameControl + 1
+		frameAnswer = FrameControl + 2
+		size        = 8 << 20
+	)
+
+	// the server asks as soon as the bulk transfer starts, and notes
+	// how much of it arrived before the answer
+	before := make(chan int, 1)
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		defer c.Close()
+		received, answered := 0, -1
+		c.Handle(frameAnswer, func([]byte) error {
+			answered = received
+			return nil
+		})
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				break
+			}
+			if received == 0 {
+				c.WriteFrame(frameAsk, nil)
+			}
+			received += n
+		}
+		before <- answered
+	})}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), &Config{ChunkWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Handle(frameAsk, func([]byte) error {
+		return conn.WriteFrame(frameAnswer, nil)
+	})
+	go io.Copy(ioutil.Discard, conn)
+
+	if _, err := conn.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if n := <-before; n < 0 || n >= size {
+		t.Fatalf("The answer waited for the bulk write: %d of %d bytes were sent before it", n, size)
+	}
+}
+
+// stallingConn is one end of a net.Pipe whose peer has stopped
+// reading. Writes block until their deadline, and once armed, the
+// next one closes writing as it starts blocking.
+type stallingConn struct {
+	net.Conn
+	armed   int32
+	writing chan struct{}
+}
+
+func (c *stallingConn) arm() <-chan struct{} {
+	c.writing = make(chan struct{})
+	atomic.StoreInt32(&c.armed, 1)
+	return c.writing
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	if atomic.CompareAndSwapInt32(&c.armed, 1, 0) {
+		close(c.writing)
+	}
+	return c.Conn.Write(p)
+}
+
+func TestConnStalledChunks(t *testing.T) {
+	stalled := make(chan struct{})
+	defer close(stalled)
+	// dial returns a Conn over a pipe whose peer handshakes
+	// and reads one message, then never reads again
+	dial := func(config *Config) (*Conn, *stallingConn) {
+		p1, p2 := net.Pipe()
+		server := NewServerConn(p2, nil)
+		go func() {
+			defer server.Close()
+			if err := server.Handshake(); err != nil {
+				return
+			}
+			server.Read(make([]byte, MaxMessageSize))
+			<-stalled
+		}()
+		stalling := &stallingConn{Conn: p1}
+		conn := NewClientConn(stalling, config)
+		// nothing reads the server's hello otherwise
+		conn.waitHello = true
+		if err := conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		return conn, stalling
+	}
+	config := &Config{ChunkWrites: true}
+	size := 4 * MaxMessageSize
+
+	// a done context stops the write before anything is sent,
+	// and the connection stays usable
+	conn, stalling := dial(config)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := conn.WriteContext(ctx, []byte("hello")); n != 0 || err != context.Canceled {
+		t.Fatalf("Unexpected write with a done context: %d, %v", n, err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelling interrupts the chunk stalled in the transport
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	writing := stalling.arm()
+	type result struct {
+		n   int
+		err error
+	}
+	wrote := make(chan result, 1)
+	go func() {
+		n, err := conn.WriteContext(ctx, make([]byte, size))
+		wrote <- result{n, err}
+	}()
+	<-writing
+	cancel()
+	res := <-wrote
+	if res.err != context.Canceled || res.n%MaxMessageSize != 0 || res.n >= size {
+		t.Fatalf("Unexpected cancelled write: %d, %v", res.n, res.err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != context.Canceled {
+		t.Fatalf("Expected the connection to be terminated, got %v", err)
+	}
+
+	// so does a chunk taking longer than ChunkTimeout
+	config.ChunkTimeout = 50 * time.Millisecond
+	conn, _ = dial(config)
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	n, err := conn.Write(make([]byte, size))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || n%MaxMessageSize != 0 || n >= size {
+		t.Fatalf("Unexpected stalled write: %d, %v", n, err)
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	var events int32
+	conn, err := Dial("tcp", l.Addr().String(), &Config{
+		AuditHook: func(AuditEvent) { atomic.AddInt32(&events, 1) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 64))
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from the blocked Read, got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Second Close failed: %v", err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from Write, got %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 64)); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from Read, got %v", err)
+	}
+	if n := atomic.LoadInt32(&events); n != 0 {
+		t.Fatalf("Closing reported %d anomalies", n)
+	}
+
+	// a Close breaks off a Write the peer is not reading
+	// without waiting to send the close frame
+	p1, p2 := net.Pipe()
+	client, server := NewClientConn(p1, nil), NewServerConn(p2, nil)
+	defer server.Close()
+	go server.Handshake()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	wrote := make(chan error)
+	go func() {
+		_, err := client.Write([]byte("hello"))
+		wrote <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	client.Close()
+	if err := <-wrote; err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from the blocked Write, got %v", err)
+	}
+	if d := time.Since(start); d > closeTimeout/2 {
+		t.Fatalf("Close waited %v for the blocked Write", d)
+	}
+}
+
+func TestConnCloseAbort(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	read := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, err = c.Read(make([]byte, 64))
+		read <- err
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetLinger(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.CloseAbort(); err != nil {
+		t.Fatal(err)
+	}
+	// no close frame was sent, and the connection was reset
+	if err := <-read; err == nil || err == io.EOF {
+		t.Fatalf("Expected the peer's Read to fail, got %v", err)
+	}
+	if err := conn.CloseAbort(); err != nil {
+		t.Fatalf("Second CloseAbort failed: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close after CloseAbort failed: %v", err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from Write, got %v", err)
+	}
+
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	if err := NewClientConn(p1, nil).SetLinger(0); err != ErrLinger {
+		t.Fatalf("Expected ErrLinger over a pipe, got %v", err)
+	}
+}
+
+func TestConnFaults(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+	go echoServer(NewListener(securetest.NewListener(inner, securetest.Faults{MaxWrite: 7, MaxRead: 2}), nil))
+
+	dial := func(faults securetest.Faults) *Conn {
+		raw, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.SetDeadline(time.Now().Add(time.Second))
+		return NewClientConn(securetest.NewConn(raw, faults), nil)
+	}
+	msg := bytes.Repeat([]byte("hello world\n"), 100)
+
+	// fragmented frames must be reassembled
+	conn := dial(securetest.Faults{MaxWrite: 5, MaxRead: 3})
+	defer conn.Close()
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxMessageSize)
+	if n, err := conn.Read(buf); err != nil || !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("Unexpected result: %d bytes, %v", n, err)
+	}
+
+	// corrupted frames must never be returned, whatever bits flip
+	for seed := int64(0); seed < 10; seed++ {
+		conn := dial(securetest.Faults{FlipRate: 0.001, Seed: seed})
+		if _, err := conn.Write(msg); err != nil {
+			conn.Close()
+			continue
+		}
+		n, err := conn.Read(buf)
+		if err == nil && !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("Seed %d: corrupted message returned", seed)
+		}
+		conn.Close()
+	}
+}
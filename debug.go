@@ -0,0 +1,131 @@
+package secure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Profile labels set on the goroutines serving
+// connections of a Server with ProfileLabels
+const (
+	// LabelConn numbers the connections of a Server, from 1.
+	LabelConn = "secure.conn"
+
+	// LabelPeer is the fingerprint of the client's public key.
+	LabelPeer = "secure.peer"
+)
+
+// labeled runs fn with the profile labels of c if the Server sets
+// them, handshaking first to learn who the peer is, so that the
+// handshake is accounted to the connection too
+func (srv *Server) labeled(c *Conn, fn func()) {
+	if !srv.ProfileLabels {
+		fn()
+		return
+	}
+
+	id := strconv.FormatUint(atomic.AddUint64(&srv.conns, 1), 10)
+	pprof.Do(context.Background(), pprof.Labels(LabelConn, id), func(ctx context.Context) {
+		if c.Handshake() != nil {
+			fn()
+			return
+		}
+		pprof.Do(ctx, pprof.Labels(LabelPeer, Fingerprint(c.peerPub)), func(context.Context) {
+			fn()
+		})
+	})
+}
+
+// debugStats is what the debug handler serves at /stats
+type debugStats struct {
+	ServerStats
+	Goroutines int
+}
+
+// DebugHandler returns an HTTP handler for operators, serving the
+// Server's Stats and goroutine count as JSON at /stats, and the result
+// of Healthz at /healthz, with status 503 if it fails. With
+// Profiling, it also serves the runtime profiles: a CPU profile of
+// the given number of seconds at /pprof/profile?seconds=n and those of
+// runtime/pprof, such as goroutine and heap, at /pprof/<name>, with
+// the debug parameter of pprof.Profile.WriteTo as ?debug=n. With
+// ProfileLabels the CPU and goroutine profiles can be broken down by
+// peer, to find the noisy ones:
+//
+//	go tool pprof -tagfocus secure.peer=<fingerprint> http://localhost:6060/debug/secure/pprof/profile
+//
+// It should only be reachable by operators:
+//
+//	http.Handle("/debug/secure/", http.StripPrefix("/debug/secure", srv.DebugHandler()))
+//	go http.ListenAndServe("localhost:6060", nil)
+func (srv *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(debugStats{srv.Stats(), srv.Goroutines()})
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.Healthz(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	if srv.Profiling {
+		mux.HandleFunc("/pprof/", serveProfile)
+	}
+	return mux
+}
+
+// serveProfile serves the profile named by the last element
+// of the request's path, or the list of profiles for none
+func serveProfile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "profile")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintln(w, p.Name())
+		}
+		return
+	}
+
+	if name == "profile" {
+		seconds, err := strconv.Atoi(r.FormValue("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = 30
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, "could not start CPU profile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+		return
+	}
+
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	p.WriteTo(w, debug)
+}
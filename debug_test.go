@@ -0,0 +1,93 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerProfileLabels(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serving, done := make(chan struct{}), make(chan struct{})
+	srv := &Server{
+		ProfileLabels: true,
+		Profiling:     true,
+		Handler: HandlerFunc(func(c *Conn) {
+			serving <- struct{}{}
+			<-done
+		}),
+	}
+	go srv.Serve(l)
+
+	pub, priv := mustGenerateKey(t, rand.Reader)
+	conn, err := Dial("tcp", l.Addr().String(), &Config{PublicKey: pub, PrivateKey: priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-serving
+	defer close(done)
+
+	debug := httptest.NewServer(srv.DebugHandler())
+	defer debug.Close()
+
+	profile := get(t, debug.URL+"/pprof/goroutine?debug=1")
+	for _, label := range []string{`"secure.conn":"1"`, `"secure.peer":"` + Fingerprint(pub) + `"`} {
+		if !strings.Contains(profile, label) {
+			t.Errorf("goroutine profile lacks label %s", label)
+		}
+	}
+
+	var stats debugStats
+	if err := json.Unmarshal([]byte(get(t, debug.URL+"/stats")), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines == 0 {
+		t.Error("no goroutines reported")
+	}
+	if !strings.Contains(get(t, debug.URL+"/pprof/"), "heap") {
+		t.Error("profile index lacks heap")
+	}
+}
+
+func TestDebugHandlerProfiling(t *testing.T) {
+	debug := httptest.NewServer((&Server{}).DebugHandler())
+	defer debug.Close()
+
+	resp, err := http.Get(debug.URL + "/pprof/heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("profiles served without Profiling: %s", resp.Status)
+	}
+}
+
+// get returns the body of the page at url
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	return string(body)
+}
@@ -0,0 +1,128 @@
+package secure
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// A Dialer contains options for connecting to an address and
+// performing the handshake, as net.Dialer does for the connection
+// alone. The zero value is the same as calling Dial with a nil Config.
+type Dialer struct {
+	// Timeout is the maximum amount of time a dial and handshake
+	// together will wait. The default is no timeout, although the
+	// operating system may time out the connection itself.
+	Timeout time.Duration
+
+	// KeepAlive and LocalAddr are passed on to net.Dialer.
+	KeepAlive time.Duration
+	LocalAddr net.Addr
+
+	// Config configures the connections. It may be nil.
+	Config *Config
+}
+
+// Dial connects to the given network address and performs the
+// handshake. The returned connection is of type *Conn. The address
+// may also be a secure URI, as returned by FormatURI, in which case
+// Dial fails with ErrFingerprint unless the server's public key
+// matches its fingerprint.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is like Dial, but stops dialing or handshaking when ctx
+// is done. Once it has returned a connection, ctx no longer affects it.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.dial(ctx, network, addr, false)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial dials and handshakes, optionally waiting for the server's
+// hello as part of the handshake
+func (d *Dialer) dial(ctx context.Context, network, addr string, waitHello bool) (c *Conn, err error) {
+	var fingerprint string
+	if isURI(addr) {
+		if addr, fingerprint, err = ParseURI(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	config := d.Config
+	if config == nil {
+		config = defaultConfig()
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil && host != "" && net.ParseIP(host) == nil {
+			c := *config
+			c.ServerName = host
+			config = &c
+		}
+	}
+	ctx, span := config.startSpan(ctx, SpanDial)
+	span.SetAttribute("net.peer.name", addr)
+	defer func() { span.End(err) }()
+
+	if d.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	nd := &net.Dialer{KeepAlive: d.KeepAlive, LocalAddr: d.LocalAddr}
+	conn, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.tune(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c = NewClientConn(conn, config)
+	c.waitHello = waitHello
+	c.spanCtx = ctx
+	if err := handshakeContext(ctx, c); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if fingerprint != "" && !MatchFingerprint(c.peerPub, fingerprint) {
+		conn.Close()
+		return nil, ErrFingerprint
+	}
+	return c, nil
+}
+
+// handshakeContext runs the handshake of c, interrupting it
+// by expiring the connection's deadline when ctx is done
+func handshakeContext(ctx context.Context, c *Conn) error {
+	if ctx.Done() == nil {
+		return c.Handshake()
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	err := c.Handshake()
+	close(done)
+	<-exited
+
+	// ctx may have interrupted the handshake, or been done right after
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
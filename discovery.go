@@ -0,0 +1,104 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Service and protocol of the SRV records LookupService resolves,
+// as in _secure._tcp.example.com
+const (
+	srvService = "secure"
+	srvProto   = "tcp"
+)
+
+// Prefix of the TXT records of the service that carry the fingerprint
+// of a server public key, as in "secure-fp=1a2b-3c4d-..."
+const txtFingerprint = "secure-fp="
+
+// ErrFingerprint means that a peer's public key did not match
+// any of the fingerprints it was expected to have
+var ErrFingerprint = errors.New("peer public key does not match the expected fingerprint")
+
+// ErrNoService means that DNS did not publish both the addresses
+// and the key fingerprints of a service
+var ErrNoService = errors.New("no secure service records")
+
+// A Resolver looks up DNS records. *net.Resolver implements it.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// A Service is a secure service discovered in DNS.
+type Service struct {
+	// Addrs are the addresses of the servers, in the order of
+	// their SRV records' priority and weight.
+	Addrs []string
+
+	// Fingerprints are those of the public keys the servers may
+	// present. There can be several while keys are being rotated.
+	Fingerprints []string
+}
+
+// LookupService resolves the servers of domain from the SRV records
+// of _secure._tcp.domain, and the fingerprints of their public keys
+// from its TXT records starting with "secure-fp=", so that clients can
+// learn both where to connect and which key to expect from DNS alone.
+// If r is nil, net.DefaultResolver is used.
+//
+// The keys are only as trustworthy as the answers: Go's resolver does
+// not validate DNSSEC, so deployments relying on this should resolve
+// through a validating resolver on a trusted path, such as one on
+// localhost, by passing a *net.Resolver that dials it.
+func LookupService(ctx context.Context, r Resolver, domain string) (*Service, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	cname, srvs, err := r.LookupSRV(ctx, srvService, srvProto, domain)
+	if err != nil {
+		return nil, err
+	}
+	txts, err := r.LookupTXT(ctx, cname)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(Service)
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		s.Addrs = append(s.Addrs, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, txtFingerprint) {
+			s.Fingerprints = append(s.Fingerprints, strings.TrimPrefix(txt, txtFingerprint))
+		}
+	}
+	if len(s.Addrs) == 0 || len(s.Fingerprints) == 0 {
+		return nil, ErrNoService
+	}
+	return s, nil
+}
+
+// Dial connects to one of the service's servers, failing over to
+// the next one if it cannot be reached, and fails with ErrFingerprint
+// unless the server's public key has one of the service's fingerprints.
+func (s *Service) Dial(config *Config) (*Conn, error) {
+	c, err := (&Failover{Network: "tcp", Addrs: s.Addrs, Config: config}).Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	pub := c.ConnectionState().PeerPublicKey
+	for _, want := range s.Fingerprints {
+		if MatchFingerprint(pub, want) {
+			return c, nil
+		}
+	}
+	c.Close()
+	return nil, ErrFingerprint
+}
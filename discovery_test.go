@@ -0,0 +1,79 @@
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// fakeResolver answers for _secure._tcp.example.com
+type fakeResolver struct {
+	srvs []*net.SRV
+	txts []string
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "secure" || proto != "tcp" || name != "example.com" {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "_secure._tcp.example.com.", r.srvs, nil
+}
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if name != "_secure._tcp.example.com." {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return r.txts, nil
+}
+
+func TestLookupService(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&Server{
+		Config:  &Config{PublicKey: pub, PrivateKey: priv},
+		Handler: HandlerFunc(func(c *Conn) { c.Write([]byte("hi")) }),
+	}).Serve(l)
+
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	r := fakeResolver{
+		srvs: []*net.SRV{{Target: "127.0.0.1.", Port: port}},
+		txts: []string{"v=spf1 -all", txtFingerprint + "0000-0000", txtFingerprint + Fingerprint(pub)},
+	}
+
+	s, err := LookupService(context.Background(), r, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Addrs) != 1 || s.Addrs[0] != "127.0.0.1:"+strconv.Itoa(int(port)) || len(s.Fingerprints) != 2 {
+		t.Fatalf("Unexpected service: %+v", s)
+	}
+	c, err := s.Dial(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	s.Fingerprints = s.Fingerprints[:1]
+	if _, err := s.Dial(nil); err != ErrFingerprint {
+		t.Fatalf("Expected ErrFingerprint, got %v", err)
+	}
+
+	r.txts = r.txts[:1]
+	if _, err := LookupService(context.Background(), r, "example.com"); err != ErrNoService {
+		t.Fatalf("Expected ErrNoService, got %v", err)
+	}
+	if _, err := LookupService(context.Background(), r, "example.org"); err == nil {
+		t.Fatal("Expected an error for a domain without records")
+	}
+}
@@ -0,0 +1,239 @@
+// Package embedded seals and opens frames in the wire format of package
+// secure's Reader and Writer without allocating, so that it can secure
+// microcontroller links built with TinyGo. Package secure itself needs
+// net, maps and goroutines, which such targets lack or can ill afford;
+// this package needs none of them, and reads and writes nothing: the
+// caller moves frames over its UART, radio or bus, and provides every
+// buffer, typically as global arrays sized at compile time:
+//
+//	var (
+//		scratch [embedded.MaxMessageSize + 1]byte
+//		frame   [embedded.MaxMessageSize + embedded.Overhead]byte
+//		session embedded.Session
+//	)
+//
+//	session.Init(&priv, &peerPub, scratch[:], nil)
+//	n, err := session.Seal(frame[:], embedded.FrameData, reading)
+//	uart.Write(frame[:n])
+//
+// Frames sealed here are read by a secure.Reader created with
+// secure.NewReader, and frames from a secure.Writer are opened here.
+// The Conn handshake is not supported: both sides must know each
+// other's public keys in advance.
+package embedded
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// KeySize is the size of keys, in bytes
+const KeySize = 32
+
+// NonceSize is the size of frame nonces, in bytes
+const NonceSize = 24
+
+// HeaderSize is the size of the nonce and length that precede the
+// ciphertext of a frame
+const HeaderSize = NonceSize + 2
+
+// Overhead is the number of bytes a frame adds to its payload
+const Overhead = HeaderSize + box.Overhead + frameTypeSize
+
+// MaxMessageSize is the largest payload the frame length can describe
+const MaxMessageSize = 1<<16 - 1 - box.Overhead - frameTypeSize
+
+// MessageHeaderSize is the size of the header of FrameMessage frames
+const MessageHeaderSize = 16
+
+// Size (in bytes) of the frame type prefixed to every plaintext
+const frameTypeSize = 1
+
+// The nonce layout is that of package secure: 15 random bytes, the
+// direction, which this package leaves unset, and the big endian frame
+// number
+const (
+	nonceDirOffset = NonceSize - 9
+	nonceSeqOffset = NonceSize - 8
+)
+
+// A FrameType identifies what a frame carries, with the values
+// of secure.FrameType.
+type FrameType byte
+
+// Frame types
+const (
+	// FrameData carries application data.
+	FrameData FrameType = 0
+
+	// FrameClose ends the stream. It carries the number of
+	// frames sent before it.
+	FrameClose FrameType = 1
+
+	// FrameMessage carries application data preceded by
+	// a message header. Open strips the header.
+	FrameMessage FrameType = 6
+
+	// FrameControl is the first application-defined type.
+	FrameControl FrameType = 0x80
+)
+
+// ErrDecrypt means that a frame failed authentication
+var ErrDecrypt = errors.New("decrypt error")
+
+// ErrFrame means that a frame is malformed
+var ErrFrame = errors.New("malformed frame")
+
+// ErrFrameOrder means that a frame arrived out of order,
+// or was duplicated or dropped
+var ErrFrameOrder = errors.New("frame reordered, duplicated or dropped")
+
+// ErrTruncated means that a close frame counts more frames
+// than were opened
+var ErrTruncated = errors.New("stream truncated")
+
+// ErrFrameTooLarge means that a payload does not fit the
+// Session's scratch buffer
+var ErrFrameTooLarge = errors.New("message too large for one frame")
+
+// ErrFrameType means that a frame of an unknown protocol type was opened
+var ErrFrameType = errors.New("unknown frame type")
+
+// A Session seals frames for a peer and opens the frames it sends.
+// The zero value is not usable; call Init first. A Session must not
+// be used concurrently.
+type Session struct {
+	shared [KeySize]byte
+	rand   io.Reader
+
+	// scratch holds the plaintext of the frame being sealed or opened
+	scratch []byte
+
+	sent, recv uint64
+}
+
+// Init keys s for exchanging frames between priv and the peer's
+// public key pub. scratch is the Session's only buffer: it bounds the
+// payloads s seals and opens to len(scratch)-1 bytes. rand provides
+// the random part of nonces; if nil, crypto/rand.Reader is used.
+func (s *Session) Init(priv, pub *[KeySize]byte, scratch []byte, rand io.Reader) {
+	box.Precompute(&s.shared, pub, priv)
+	s.scratch, s.rand = scratch, rand
+	s.sent, s.recv = 0, 0
+}
+
+// Seal seals payload as a frame of type t into dst and returns the
+// frame's size, Overhead bytes more than the payload. It fails with
+// io.ErrShortBuffer if dst is too small.
+func (s *Session) Seal(dst []byte, t FrameType, payload []byte) (int, error) {
+	if len(payload) >= len(s.scratch) || len(payload) > MaxMessageSize {
+		return 0, ErrFrameTooLarge
+	}
+	size := Overhead + len(payload)
+	if len(dst) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	random := s.rand
+	if random == nil {
+		random = rand.Reader
+	}
+	// the random bytes are read into dst, as a local array handed
+	// to an io.Reader would escape to the heap
+	if _, err := io.ReadFull(random, dst[:nonceDirOffset]); err != nil {
+		return 0, err
+	}
+	dst[nonceDirOffset] = 0
+	binary.BigEndian.PutUint64(dst[nonceSeqOffset:], s.sent)
+	var nonce [NonceSize]byte
+	copy(nonce[:], dst)
+	binary.LittleEndian.PutUint16(dst[NonceSize:], uint16(size-HeaderSize))
+
+	plain := s.scratch[:frameTypeSize+len(payload)]
+	plain[0] = byte(t)
+	copy(plain[frameTypeSize:], payload)
+	box.SealAfterPrecomputation(dst[HeaderSize:HeaderSize], plain, &nonce, &s.shared)
+	s.sent++
+	return size, nil
+}
+
+// SealClose seals the close frame ending the stream into dst and
+// returns its size. The peer's Reader then returns io.EOF.
+func (s *Session) SealClose(dst []byte) (int, error) {
+	var count [8]byte
+	binary.BigEndian.PutUint64(count[:], s.sent)
+	return s.Seal(dst, FrameClose, count[:])
+}
+
+// FrameSize returns the size of the frame starting with header,
+// which must hold at least HeaderSize bytes, so that the caller
+// knows how much more to receive before calling Open.
+func FrameSize(header []byte) (int, error) {
+	if len(header) < HeaderSize {
+		return 0, io.ErrShortBuffer
+	}
+	size := int(binary.LittleEndian.Uint16(header[NonceSize:]))
+	if size < box.Overhead+frameTypeSize {
+		return 0, ErrFrame
+	}
+	return HeaderSize + size, nil
+}
+
+// Open authenticates and decrypts frame, a whole frame as sized by
+// FrameSize, and copies its payload to dst. It returns the frame's
+// type and the size of the payload. The messages of FrameMessage
+// frames are returned as FrameData, without their header. A close
+// frame returns FrameClose and no payload once its count has been
+// checked. Frames of types from FrameControl up are returned for the
+// caller to act on or skip.
+//
+// Frames must be opened in the order they were sealed. If dst is too
+// small, Open fails with io.ErrShortBuffer, and the frame is lost.
+func (s *Session) Open(dst, frame []byte) (FrameType, int, error) {
+	size, err := FrameSize(frame)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size != len(frame) {
+		return 0, 0, ErrFrame
+	}
+	enc := frame[HeaderSize:]
+	if len(enc)-box.Overhead > len(s.scratch) {
+		return 0, 0, ErrFrameTooLarge
+	}
+
+	var nonce [NonceSize]byte
+	copy(nonce[:], frame)
+	plain, ok := box.OpenAfterPrecomputation(s.scratch[:0], enc, &nonce, &s.shared)
+	if !ok {
+		return 0, 0, ErrDecrypt
+	}
+	if binary.BigEndian.Uint64(nonce[nonceSeqOffset:]) != s.recv {
+		return 0, 0, ErrFrameOrder
+	}
+	s.recv++
+
+	t, payload := FrameType(plain[0]), plain[frameTypeSize:]
+	switch {
+	case t == FrameMessage:
+		if len(payload) < MessageHeaderSize {
+			return 0, 0, ErrFrame
+		}
+		t, payload = FrameData, payload[MessageHeaderSize:]
+	case t == FrameClose:
+		if len(payload) != 8 || binary.BigEndian.Uint64(payload) != s.recv-1 {
+			return 0, 0, ErrTruncated
+		}
+		return FrameClose, 0, nil
+	case t != FrameData && t < FrameControl:
+		return 0, 0, ErrFrameType
+	}
+	if len(dst) < len(payload) {
+		return 0, 0, io.ErrShortBuffer
+	}
+	return t, copy(dst, payload), nil
+}
@@ -0,0 +1,153 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/jboverfelt/secure"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func keys(t *testing.T) (pub1, priv1, pub2, priv2 *[KeySize]byte) {
+	pub1, priv1, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub2, priv2, err = box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub1, priv1, pub2, priv2
+}
+
+// readFrame reads one frame from r into buf
+func readFrame(t *testing.T, r io.Reader, buf []byte) []byte {
+	if _, err := io.ReadFull(r, buf[:HeaderSize]); err != nil {
+		t.Fatal(err)
+	}
+	size, err := FrameSize(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf[HeaderSize:size]); err != nil {
+		t.Fatal(err)
+	}
+	return buf[:size]
+}
+
+func TestSealToReader(t *testing.T) {
+	pub1, priv1, pub2, priv2 := keys(t)
+	var s Session
+	s.Init(priv1, pub2, make([]byte, 64), nil)
+
+	var wire bytes.Buffer
+	frame := make([]byte, 64+Overhead)
+	for _, msg := range []string{"temperature 21.5", "", "humidity 40"} {
+		n, err := s.Seal(frame, FrameData, []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wire.Write(frame[:n])
+	}
+	n, err := s.SealClose(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire.Write(frame[:n])
+
+	r := secure.NewReader(&wire, priv2, pub1)
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, r); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "temperature 21.5humidity 40" {
+		t.Fatalf("Unexpected result: %q", got.String())
+	}
+}
+
+func TestOpenFromWriter(t *testing.T) {
+	pub1, priv1, pub2, priv2 := keys(t)
+	var wire bytes.Buffer
+	w := secure.NewWriter(&wire, priv1, pub2)
+	w.Write([]byte("set led on"))
+	w.WriteFrame(secure.FrameControl+1, []byte("ext"))
+	w.WriteMessage([]byte("reboot"), secure.MessageInfo{ID: 7})
+	w.Close()
+
+	var s Session
+	s.Init(priv2, pub1, make([]byte, 64), nil)
+	buf, dst := make([]byte, 64+Overhead), make([]byte, 64)
+	want := []struct {
+		t   FrameType
+		msg string
+	}{{FrameData, "set led on"}, {FrameControl + 1, "ext"}, {FrameData, "reboot"}, {FrameClose, ""}}
+	for _, w := range want {
+		typ, n, err := s.Open(dst, readFrame(t, &wire, buf))
+		if err != nil || typ != w.t || string(dst[:n]) != w.msg {
+			t.Fatalf("Unexpected frame: %d %q, %v", typ, dst[:n], err)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	pub1, priv1, pub2, priv2 := keys(t)
+	var a, b Session
+	a.Init(priv1, pub2, make([]byte, 64), nil)
+	b.Init(priv2, pub1, make([]byte, 64), nil)
+
+	frame, dst := make([]byte, 64+Overhead), make([]byte, 64)
+	if _, err := a.Seal(frame, FrameData, make([]byte, 64)); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, err := a.Seal(frame[:Overhead], FrameData, []byte("x")); err != io.ErrShortBuffer {
+		t.Fatalf("Expected io.ErrShortBuffer, got %v", err)
+	}
+
+	n, _ := a.Seal(frame, FrameData, []byte("first"))
+	first := append([]byte(nil), frame[:n]...)
+	first[len(first)-1] ^= 1
+	if _, _, err := b.Open(dst, first); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+
+	n, _ = a.Seal(frame, FrameData, []byte("second"))
+	if _, _, err := b.Open(dst, frame[:n]); err != ErrFrameOrder {
+		t.Fatalf("Expected ErrFrameOrder, got %v", err)
+	}
+	if _, _, err := b.Open(dst, frame[:n-1]); err != ErrFrame {
+		t.Fatalf("Expected ErrFrame, got %v", err)
+	}
+}
+
+func TestNoAllocs(t *testing.T) {
+	pub1, priv1, pub2, priv2 := keys(t)
+	var a, b Session
+	a.Init(priv1, pub2, make([]byte, 256), zeroRand{})
+	b.Init(priv2, pub1, make([]byte, 256), nil)
+	msg, frame, dst := make([]byte, 200), make([]byte, 256+Overhead), make([]byte, 256)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		n, err := a.Seal(frame, FrameData, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := b.Open(dst, frame[:n]); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations, got %v", allocs)
+	}
+}
+
+// zeroRand reads as zero bytes without allocating
+type zeroRand struct{}
+
+func (zeroRand) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
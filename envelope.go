@@ -0,0 +1,255 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Envelope format version
+const envelopeVersion = 1
+
+// Envelope flags
+const envelopeSigned = 1 << 0
+
+// ErrEnvelope means that an envelope was malformed or its signature
+// did not verify
+var ErrEnvelope = errors.New("malformed envelope")
+
+// An Envelope is a single message sealed to a recipient's public key,
+// independent of any connection, for messages that are queued or
+// stored rather than streamed. Each envelope is sealed with a fresh
+// ephemeral key pair, so only the recipient can open it but nothing
+// in it identifies the sender unless it is signed.
+//
+// The binary encoding is a version byte, a flags byte, the ephemeral
+// public key, the nonce and the box, followed for signed envelopes by
+// the signer's Ed25519 public key and its signature over everything
+// before it. The box holds the flags byte again and, for signed
+// envelopes, the signer's public key, ahead of the message, so that a
+// signature cannot be stripped or replaced with another without the
+// recipient noticing.
+type Envelope struct {
+	EphemeralKey [KeySize]byte
+	Nonce        [NonceSize]byte
+	Ciphertext   []byte
+
+	// Signer and Signature are empty for unsigned envelopes.
+	Signer    ed25519.PublicKey
+	Signature []byte
+}
+
+// SealEnvelope seals msg into an envelope that only the holder of the
+// private key for recipient can open. If signer is not nil, the
+// envelope is also signed with it.
+func SealEnvelope(msg []byte, recipient *[KeySize]byte, signer ed25519.PrivateKey) ([]byte, error) {
+	e, err := NewEnvelope(msg, recipient, signer)
+	if err != nil {
+		return nil, err
+	}
+	return e.MarshalBinary()
+}
+
+// OpenEnvelope opens an envelope produced by SealEnvelope with the
+// recipient's private key. It returns the message and, for signed
+// envelopes, the verified public key of the signer.
+func OpenEnvelope(data []byte, priv *[KeySize]byte) (msg []byte, signer ed25519.PublicKey, err error) {
+	var e Envelope
+	if err := e.UnmarshalBinary(data); err != nil {
+		return nil, nil, err
+	}
+	return e.Open(priv)
+}
+
+// NewEnvelope is like SealEnvelope, but returns the Envelope itself.
+func NewEnvelope(msg []byte, recipient *[KeySize]byte, signer ed25519.PrivateKey) (*Envelope, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Envelope{EphemeralKey: *pub}
+	if _, err := io.ReadFull(rand.Reader, e.Nonce[:]); err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		e.Signer = signer.Public().(ed25519.PublicKey)
+	}
+	e.Ciphertext = box.Seal(nil, append(e.sealedPrefix(), msg...), &e.Nonce, recipient, priv)
+
+	if signer != nil {
+		e.Signature = ed25519.Sign(signer, e.signed())
+	}
+	return e, nil
+}
+
+// Open verifies the signature, if any, and decrypts the message
+// with the recipient's private key.
+func (e *Envelope) Open(priv *[KeySize]byte) (msg []byte, signer ed25519.PublicKey, err error) {
+	if e.Signer != nil {
+		if len(e.Signer) != ed25519.PublicKeySize || !ed25519.Verify(e.Signer, e.signed(), e.Signature) {
+			return nil, nil, ErrEnvelope
+		}
+	}
+
+	plain, ok := box.Open(nil, e.Ciphertext, &e.Nonce, &e.EphemeralKey, priv)
+	if !ok {
+		return nil, nil, ErrDecrypt
+	}
+
+	// the signer sealed inside must be the one that signed outside
+	prefix := e.sealedPrefix()
+	if !bytes.HasPrefix(plain, prefix) {
+		return nil, nil, ErrEnvelope
+	}
+	return plain[len(prefix):], e.Signer, nil
+}
+
+// flags returns the flags byte of the encoding
+func (e *Envelope) flags() byte {
+	flags := byte(0)
+	if e.Signer != nil {
+		flags |= envelopeSigned
+	}
+	return flags
+}
+
+// sealedPrefix returns what the box holds ahead of the message:
+// the flags byte and the signer's public key, if any
+func (e *Envelope) sealedPrefix() []byte {
+	return append([]byte{e.flags()}, e.Signer...)
+}
+
+// signed returns the encoding covered by the signature
+func (e *Envelope) signed() []byte {
+	var b bytes.Buffer
+	b.WriteByte(envelopeVersion)
+	b.WriteByte(e.flags())
+	b.Write(e.EphemeralKey[:])
+	b.Write(e.Nonce[:])
+	b.Write(e.Ciphertext)
+	b.Write(e.Signer)
+	return b.Bytes()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	return append(e.signed(), e.Signature...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	const header = 2 + KeySize + NonceSize
+	if len(data) < header+box.Overhead || data[0] != envelopeVersion || data[1]&^envelopeSigned != 0 {
+		return ErrEnvelope
+	}
+
+	*e = Envelope{}
+	copy(e.EphemeralKey[:], data[2:])
+	copy(e.Nonce[:], data[2+KeySize:])
+	rest := data[header:]
+
+	if data[1]&envelopeSigned != 0 {
+		trailer := ed25519.PublicKeySize + ed25519.SignatureSize
+		if len(rest) < box.Overhead+trailer {
+			return ErrEnvelope
+		}
+		sig := len(rest) - ed25519.SignatureSize
+		e.Signer = append(ed25519.PublicKey(nil), rest[sig-ed25519.PublicKeySize:sig]...)
+		e.Signature = append([]byte(nil), rest[sig:]...)
+		rest = rest[:sig-ed25519.PublicKeySize]
+	}
+
+	e.Ciphertext = append([]byte(nil), rest...)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. The compact text form
+// is the binary encoding in unpadded base64url, so it can travel
+// through HTTP headers, config files or QR codes as is.
+func (e *Envelope) MarshalText() ([]byte, error) {
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (e *Envelope) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, err := base64.RawURLEncoding.Decode(data, text)
+	if err != nil {
+		return ErrEnvelope
+	}
+	return e.UnmarshalBinary(data[:n])
+}
+
+// jsonEnvelope is the JSON form of an Envelope, in the style of JOSE:
+// an object of unpadded base64url members
+type jsonEnvelope struct {
+	Version    int    `json:"v"`
+	EPK        string `json:"epk"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	Signer     string `json:"signer,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *Envelope) MarshalJSON() ([]byte, error) {
+	enc := base64.RawURLEncoding.EncodeToString
+	return json.Marshal(jsonEnvelope{
+		Version:    envelopeVersion,
+		EPK:        enc(e.EphemeralKey[:]),
+		Nonce:      enc(e.Nonce[:]),
+		Ciphertext: enc(e.Ciphertext),
+		Signer:     enc(e.Signer),
+		Signature:  enc(e.Signature),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	var j jsonEnvelope
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Version != envelopeVersion {
+		return ErrEnvelope
+	}
+
+	dec := base64.RawURLEncoding.DecodeString
+	epk, err1 := dec(j.EPK)
+	nonce, err2 := dec(j.Nonce)
+	ct, err3 := dec(j.Ciphertext)
+	signer, err4 := dec(j.Signer)
+	sig, err5 := dec(j.Signature)
+	for _, err := range []error{err1, err2, err3, err4, err5} {
+		if err != nil {
+			return ErrEnvelope
+		}
+	}
+	if len(epk) != KeySize || len(nonce) != NonceSize || len(ct) < box.Overhead {
+		return ErrEnvelope
+	}
+	if len(signer) > 0 && (len(signer) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize) {
+		return ErrEnvelope
+	}
+
+	*e = Envelope{Ciphertext: ct}
+	copy(e.EphemeralKey[:], epk)
+	copy(e.Nonce[:], nonce)
+	if len(signer) > 0 {
+		e.Signer, e.Signature = signer, sig
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestEnvelope(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello world\n")
+
+	for _, signer := range []ed25519.PrivateKey{nil, signPriv} {
+		env, err := SealEnvelope(msg, pub, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, from, err := OpenEnvelope(env, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Unexpected result: %q != %q", got, msg)
+		}
+		if signer == nil && from != nil || signer != nil && !bytes.Equal(from, signPub) {
+			t.Fatalf("Unexpected signer: %x", from)
+		}
+
+		// any change is detected
+		for i := range env {
+			env[i] ^= 1
+			if _, _, err := OpenEnvelope(env, priv); err == nil {
+				t.Fatalf("Tampered byte %d went unnoticed", i)
+			}
+			env[i] ^= 1
+		}
+	}
+
+	// the signature can be neither stripped nor replaced
+	_, otherSigner, _ := ed25519.GenerateKey(rand.Reader)
+	e, err := NewEnvelope(msg, pub, signPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Signer, e.Signature = nil, nil
+	if _, _, err := e.Open(priv); err != ErrEnvelope {
+		t.Fatalf("Expected ErrEnvelope for a stripped signature, got %v", err)
+	}
+	e.Signer = otherSigner.Public().(ed25519.PublicKey)
+	e.Signature = ed25519.Sign(otherSigner, e.signed())
+	if _, _, err := e.Open(priv); err != ErrEnvelope {
+		t.Fatalf("Expected ErrEnvelope for a replaced signature, got %v", err)
+	}
+
+	// only the recipient can open it
+	_, other, _ := box.GenerateKey(rand.Reader)
+	env, _ := SealEnvelope(msg, pub, nil)
+	if _, _, err := OpenEnvelope(env, other); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+	if _, _, err := OpenEnvelope(env[:10], priv); err != ErrEnvelope {
+		t.Fatalf("Expected ErrEnvelope, got %v", err)
+	}
+}
+
+func TestEnvelopeText(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello world\n")
+
+	for _, signer := range []ed25519.PrivateKey{nil, signPriv} {
+		e, err := NewEnvelope(msg, pub, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		text, err := e.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fromText, fromJSON Envelope
+		if err := fromText.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, decoded := range []*Envelope{&fromText, &fromJSON} {
+			got, _, err := decoded.Open(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("Unexpected result: %q != %q", got, msg)
+			}
+		}
+	}
+
+	var e Envelope
+	if err := e.UnmarshalText([]byte("not an envelope!")); err != ErrEnvelope {
+		t.Fatalf("Expected ErrEnvelope, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"v":2}`), &e); err != ErrEnvelope {
+		t.Fatalf("Expected ErrEnvelope, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package secure
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultFailoverBackoff is how long a Failover avoids an address
+// after failing to connect to it if its Backoff is zero
+const DefaultFailoverBackoff = 30 * time.Second
+
+// ErrNoAddrs means that a Failover has no addresses to dial
+var ErrNoAddrs = errors.New("no addresses to dial")
+
+// A Failover dials the replicas of a service. Successive calls to Dial
+// start from successive addresses, spreading connections round-robin,
+// and each call moves on to the next address when one fails, so that
+// it only fails if every replica does. Addresses that failed are tried
+// after the others until Backoff has passed. A Failover is safe for
+// concurrent use and must not be copied after use.
+type Failover struct {
+	// Network and Addrs are passed to Dial along with Config,
+	// which may be nil.
+	Network string
+	Addrs   []string
+	Config  *Config
+
+	// Backoff is how long an address that failed is tried last.
+	// If zero, DefaultFailoverBackoff is used.
+	Backoff time.Duration
+
+	mu   sync.Mutex
+	next int
+	down map[string]time.Time
+}
+
+// Dial connects to one of the addresses and performs the handshake.
+// If all of them fail, it returns the error of the last one tried.
+func (f *Failover) Dial() (*Conn, error) {
+	err := ErrNoAddrs
+	for _, addr := range f.order() {
+		var c *Conn
+		if c, err = Dial(f.Network, addr, f.Config); err == nil {
+			f.mark(addr, true)
+			return c, nil
+		}
+		f.mark(addr, false)
+	}
+	return nil, err
+}
+
+// order returns the addresses to try: healthy ones first, both
+// groups starting from the next address in round-robin order
+func (f *Failover) order() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := len(f.Addrs)
+	if n == 0 {
+		return nil
+	}
+	start := f.next % n
+	f.next = start + 1
+
+	backoff := f.Backoff
+	if backoff == 0 {
+		backoff = DefaultFailoverBackoff
+	}
+	var healthy, failed []string
+	for i := 0; i < n; i++ {
+		addr := f.Addrs[(start+i)%n]
+		if t, ok := f.down[addr]; ok && time.Since(t) < backoff {
+			failed = append(failed, addr)
+		} else {
+			healthy = append(healthy, addr)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// mark records whether dialing addr succeeded
+func (f *Failover) mark(addr string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ok {
+		delete(f.down, addr)
+		return
+	}
+	if f.down == nil {
+		f.down = make(map[string]time.Time)
+	}
+	f.down[addr] = time.Now()
+}
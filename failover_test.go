@@ -0,0 +1,57 @@
+package secure
+
+import (
+	"net"
+	"testing"
+)
+
+// namedServer serves connections on a new listener
+// by sending them name
+func namedServer(t *testing.T, name string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		c.Write([]byte(name))
+	})}).Serve(l)
+	return l
+}
+
+func TestFailover(t *testing.T) {
+	a, b := namedServer(t, "a"), namedServer(t, "b")
+	defer a.Close()
+	defer b.Close()
+
+	dead := namedServer(t, "dead")
+	dead.Close()
+
+	f := &Failover{Network: "tcp", Addrs: []string{dead.Addr().String(), a.Addr().String(), b.Addr().String()}}
+	var got string
+	for i := 0; i < 4; i++ {
+		c, err := f.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 8)
+		n, err := c.Read(buf)
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(buf[:n])
+	}
+	// the dead address is skipped, and then tried last
+	if got != "aaba" {
+		t.Fatalf("Unexpected order of servers: %q", got)
+	}
+
+	a.Close()
+	b.Close()
+	if _, err := f.Dial(); err == nil {
+		t.Fatal("Expected an error once every address failed")
+	}
+	if _, err := new(Failover).Dial(); err != ErrNoAddrs {
+		t.Fatalf("Expected ErrNoAddrs, got %v", err)
+	}
+}
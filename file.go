@@ -0,0 +1,237 @@
+package secure
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Files written by NewFileWriter start with this magic and version,
+// followed by the writer's ephemeral public key. The rest is a stream
+// of frames sealed between that key and the recipient's, each holding
+// fileChunkSize bytes except the last, and ending with a close frame,
+// so that the offset of any byte can be computed without reading the
+// frames before it.
+const fileMagic = "SECF\x01"
+
+// Size (in bytes) of the header of a file
+const fileHeaderSize = len(fileMagic) + KeySize
+
+// Size (in bytes) of the plaintext in every frame of a file but the last
+const fileChunkSize = MaxMessageSize
+
+// Size (in bytes) of a frame holding a full chunk
+const fileFrameSize = fileChunkSize + Overhead
+
+// Size (in bytes) of the close frame ending a file
+const fileCloseSize = 8 + Overhead
+
+// ErrFileFormat means that data is not an encrypted file
+var ErrFileFormat = errors.New("not an encrypted file")
+
+// ErrFileSeek means that an encrypted file was asked to seek,
+// but the file under it cannot
+var ErrFileSeek = errors.New("encrypted file cannot seek")
+
+// NewFileWriter returns a writer that encrypts a file for recipient,
+// such that only the holder of its private key can read it, using
+// NewFileReader or NewFS. The file is only complete once the writer
+// is closed.
+func NewFileWriter(w io.Writer, recipient *[KeySize]byte) (io.WriteCloser, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(fileMagic), pub[:]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &fileWriter{w: NewWriter(w, priv, recipient)}, nil
+}
+
+// A fileWriter collects writes into full chunks
+type fileWriter struct {
+	w   *Writer
+	buf []byte
+}
+
+func (f *fileWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := fileChunkSize - len(f.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		f.buf = append(f.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(f.buf) == fileChunkSize {
+			if _, err := f.w.Write(f.buf); err != nil {
+				return written, err
+			}
+			f.buf = f.buf[:0]
+		}
+	}
+	return written, nil
+}
+
+// Close writes the last chunk and the close frame.
+func (f *fileWriter) Close() error {
+	if len(f.buf) > 0 {
+		if _, err := f.w.Write(f.buf); err != nil {
+			return err
+		}
+		f.buf = nil
+	}
+	return f.w.Close()
+}
+
+// NewFileReader returns a reader that decrypts a file written by
+// NewFileWriter for the holder of priv. A file that was cut short
+// fails with io.ErrUnexpectedEOF or ErrTruncated. If r is an
+// io.Seeker, so is the returned reader.
+func NewFileReader(r io.Reader, priv *[KeySize]byte) (io.ReadSeeker, error) {
+	var header [fileHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrFileFormat
+		}
+		return nil, err
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		return nil, ErrFileFormat
+	}
+
+	var pub [KeySize]byte
+	copy(pub[:], header[len(fileMagic):])
+	return &fileReader{src: r, r: NewReader(r, priv, &pub), header: int64(fileHeaderSize), size: -1}, nil
+}
+
+// fileSize returns the size of the plaintext of a file
+// whose encrypted size is size
+func fileSize(size int64) (int64, error) {
+	return frameStreamSize(size - int64(fileHeaderSize))
+}
+
+// frameStreamSize returns the size of the plaintext of the frames
+// of a file, whose size is size, after its header
+func frameStreamSize(size int64) (int64, error) {
+	body := size - int64(fileCloseSize)
+	if body < 0 {
+		return 0, ErrFileFormat
+	}
+	frames := (body + fileFrameSize - 1) / fileFrameSize
+	plain := body - frames*Overhead
+	if plain < 0 || frames > 0 && plain <= (frames-1)*fileChunkSize {
+		return 0, ErrFileFormat
+	}
+	return plain, nil
+}
+
+// A fileReader buffers the chunks of a file, so that its reads
+// need not match the frames
+type fileReader struct {
+	src    io.Reader
+	r      *Reader
+	header int64 // size of the file's header
+	buf    [fileChunkSize]byte
+	rest   []byte
+
+	pos  int64
+	size int64 // of the plaintext, or -1 until known
+
+	// a seek takes effect on the next read
+	seeked bool
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	if f.seeked {
+		if err := f.seek(); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(f.rest) == 0 {
+		n, err := f.r.Read(f.buf[:])
+		if err != nil {
+			return 0, err
+		}
+		f.rest = f.buf[:n]
+	}
+
+	n := copy(p, f.rest)
+	f.rest = f.rest[n:]
+	f.pos += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker if the underlying file does.
+func (f *fileReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.src.(io.Seeker)
+	if !ok {
+		return 0, ErrFileSeek
+	}
+	if f.size < 0 {
+		end, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		if f.size, err = frameStreamSize(end - f.header); err != nil {
+			return 0, err
+		}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	// the underlying file is repositioned on the next read
+	f.pos, f.rest, f.seeked = offset, nil, true
+	return offset, nil
+}
+
+// seek moves the underlying file to the frame holding f.pos and skips
+// the bytes of it before f.pos. Past the end of the plaintext, it moves
+// to the close frame, so that reads still check it.
+func (f *fileReader) seek() error {
+	f.seeked = false
+
+	chunk := f.pos / fileChunkSize
+	offset := f.header + chunk*fileFrameSize
+	if f.pos >= f.size {
+		chunk = (f.size + fileChunkSize - 1) / fileChunkSize
+		offset = f.header + f.size + chunk*Overhead
+	}
+	if _, err := f.src.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	f.r.recv, f.r.eof = uint64(chunk), false
+	if f.pos >= f.size {
+		return nil
+	}
+
+	n, err := f.r.Read(f.buf[:])
+	if err != nil {
+		return err
+	}
+	skip := int(f.pos % fileChunkSize)
+	if skip > n {
+		return ErrTruncated
+	}
+	f.rest = f.buf[skip:n]
+	return nil
+}
@@ -0,0 +1,125 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func encryptFile(t *testing.T, data []byte, recipient *[KeySize]byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewFileWriter(&buf, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// uneven writes still make full chunks
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFile(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, fileChunkSize - 1, fileChunkSize, fileChunkSize + 1, 3*fileChunkSize - 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+		enc := encryptFile(t, data, pub)
+
+		if plain, err := fileSize(int64(len(enc))); err != nil || plain != int64(size) {
+			t.Fatalf("Unexpected size for %d bytes: %d, %v", size, plain, err)
+		}
+
+		r, err := NewFileReader(bytes.NewReader(enc), priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Unexpected result for %d bytes", size)
+		}
+
+		// a file cut at a frame boundary is detected
+		if size > fileChunkSize {
+			cut := fileHeaderSize + fileFrameSize
+			r, _ := NewFileReader(bytes.NewReader(enc[:cut]), priv)
+			if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+				t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+			}
+		}
+	}
+
+	_, other, _ := box.GenerateKey(rand.Reader)
+	r, err := NewFileReader(bytes.NewReader(encryptFile(t, []byte("hello"), pub)), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+	if _, err := NewFileReader(bytes.NewReader([]byte("hello world, in plaintext and long enough")), priv); err != ErrFileFormat {
+		t.Fatalf("Expected ErrFileFormat, got %v", err)
+	}
+}
+
+func TestFileSeek(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*fileChunkSize+100)
+	rand.Read(data)
+	enc := encryptFile(t, data, pub)
+
+	r, err := NewFileReader(bytes.NewReader(enc), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int64{fileChunkSize + 7, 3, 2 * fileChunkSize, int64(len(data)) - 1, 0} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 50)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], data[off:off+int64(n)]) {
+			t.Fatalf("Unexpected data at offset %d", off)
+		}
+	}
+
+	if end, err := r.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+		t.Fatalf("Unexpected end: %d, %v", end, err)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Fatalf("Expected io.EOF at the end, got %d, %v", n, err)
+	}
+
+	// without an underlying seeker
+	r, _ = NewFileReader(io.MultiReader(bytes.NewReader(enc)), priv)
+	if _, err := r.Seek(0, io.SeekStart); err != ErrFileSeek {
+		t.Fatalf("Expected ErrFileSeek, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package secure
+
+import "errors"
+
+// A FrameType identifies what a frame carries. It is the first byte of
+// every sealed plaintext, so it is authenticated along with the payload.
+//
+// Types below FrameControl belong to the protocol. A Reader that receives
+// one it does not know fails with ErrFrameType, since ignoring it could
+// change the meaning of the stream. Types from FrameControl up are
+// application-defined control frames: they are passed to the handler
+// registered for them with Handle, and silently skipped otherwise, so
+// peers can add optional extensions without breaking older readers.
+// A strict Reader fails with ErrFrameType on those too.
+type FrameType byte
+
+// Protocol frame types
+const (
+	// FrameData carries application data returned by Read.
+	FrameData FrameType = iota
+
+	// FrameClose announces that the sender will send no more frames.
+	// It carries the number of frames sent before it as a big endian
+	// uint64, so that the receiver can tell if any were dropped.
+	FrameClose
+
+	// FramePing asks the peer to reply with a FramePong carrying
+	// the same payload.
+	FramePing
+
+	// FramePong answers a FramePing.
+	FramePong
+
+	// FrameRekey switches the frames its sender seals after it to the
+	// key of the suite salted with its payload. A Conn resuming a
+	// session sends one before anything else, so that it never seals
+	// under the nonces of the Conn the session was exported from.
+	FrameRekey
+
+	// FrameHello is the first frame sent by each side of a Conn and
+	// carries the handshake extensions.
+	FrameHello
+
+	// FrameMessage carries application data like FrameData, preceded
+	// by a message header.
+	FrameMessage
+
+	// FrameAlert ends the stream in place of FrameClose when the
+	// sender gives up on the connection. It carries an AlertCode
+	// followed by a reason for humans, returned as an *AlertError.
+	FrameAlert
+)
+
+// FrameControl is the first frame type available for
+// application-defined control frames.
+const FrameControl FrameType = 0x80
+
+// Size (in bytes) of the frame type prefixed to every plaintext
+const frameTypeSize = 1
+
+// ErrFrameType means that a frame of an unknown protocol type was received
+var ErrFrameType = errors.New("unknown frame type")
+
+// Handle registers fn to be called with the payload of every frame of
+// type t the Reader encounters. Read never returns such frames itself;
+// if fn returns an error, Read returns it. The payload is only valid
+// during the call.
+//
+// Handle is meant for control frames of types FrameControl and up, and
+// for protocol types that the Reader does not act on itself, such as
+// FramePing. Handlers for FrameData, FrameClose and FrameAlert are
+// never called. Handle must not be called concurrently with Read.
+func (s *Reader) Handle(t FrameType, fn func(payload []byte) error) {
+	if s.handlers == nil {
+		s.handlers = make(map[FrameType]func([]byte) error)
+	}
+	s.handlers[t] = fn
+}
@@ -0,0 +1,200 @@
+package secure
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrameTypes(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.WriteFrame(FrameControl, []byte("route=a"))
+	secureW.WriteFrame(FrameControl+1, []byte("ignored"))
+	secureW.Write([]byte("hello world\n"))
+	secureW.Close()
+
+	var control []string
+	secureR := NewReader(&wire, priv, pub)
+	secureR.Handle(FrameControl, func(payload []byte) error {
+		control = append(control, string(payload))
+		return nil
+	})
+
+	// control frames are handled or skipped on the way to the data
+	buf := make([]byte, 1024)
+	n, err := secureR.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := string(buf[:n]); res != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", res, "hello world")
+	}
+	if len(control) != 1 || control[0] != "route=a" {
+		t.Fatalf("Unexpected control frames: %q", control)
+	}
+
+	// the end of the stream is sticky
+	for i := 0; i < 2; i++ {
+		if _, err := secureR.Read(buf); err != io.EOF {
+			t.Fatalf("Expected io.EOF after a close frame, got %v", err)
+		}
+	}
+	if _, err := secureW.Write([]byte("late")); err != ErrWriterClosed {
+		t.Fatalf("Expected ErrWriterClosed, got %v", err)
+	}
+
+	// unknown protocol frames must not be skipped
+	wire.Reset()
+	secureW = NewWriter(&wire, priv, pub)
+	secureW.WriteFrame(FrameRekey, nil)
+	secureR = NewReader(&wire, priv, pub)
+	if _, err := secureR.Read(buf); err != ErrFrameType {
+		t.Fatalf("Expected ErrFrameType, got %v", err)
+	}
+
+	// a stream cut off between frames may have been truncated
+	if _, err := secureR.Read(buf); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.SetChunking(true)
+	data := bytes.Repeat([]byte("0123456789"), MaxMessageSize/4)
+	if _, err := secureW.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := secureW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// io.Copy ends cleanly at the close frame
+	var got bytes.Buffer
+	n, err := io.Copy(&got, NewReader(&wire, priv, pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("Unexpected result. Data was lost or corrupted.")
+	}
+}
+
+func TestCloseFrameCount(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// a close frame claiming more frames than were sent
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.Write([]byte("one"))
+	secureW.WriteFrame(FrameClose, []byte{0, 0, 0, 0, 0, 0, 0, 2})
+
+	secureR := NewReader(&wire, priv, pub)
+	buf := make([]byte, 1024)
+	if _, err := secureR.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secureR.Read(buf); err != ErrTruncated {
+		t.Fatalf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestReaderStrict(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	buf := make([]byte, 1024)
+
+	// control frames without a handler are not skipped
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.WriteFrame(FrameControl, []byte("route=a"))
+	secureW.WriteFrame(FrameControl+1, []byte("unhandled"))
+	secureW.Write([]byte("hello world\n"))
+
+	secureR := NewReader(&wire, priv, pub)
+	secureR.SetStrict(true)
+	secureR.Handle(FrameControl, func(payload []byte) error { return nil })
+	if _, err := secureR.Read(buf); err != ErrFrameType {
+		t.Fatalf("Expected ErrFrameType, got %v", err)
+	}
+
+	// nor is anything after the close frame
+	wire.Reset()
+	secureW = NewWriter(&wire, priv, pub)
+	secureW.Write([]byte("hello world\n"))
+	secureW.Close()
+	end := wire.Len()
+	wire.WriteString("garbage")
+
+	secureR = NewReader(bytes.NewReader(wire.Bytes()), priv, pub)
+	secureR.SetStrict(true)
+	if _, err := secureR.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secureR.Read(buf); err != ErrTrailingData {
+		t.Fatalf("Expected ErrTrailingData, got %v", err)
+	}
+
+	// while a stream ending with the close frame ends cleanly
+	secureR = NewReader(bytes.NewReader(wire.Bytes()[:end]), priv, pub)
+	secureR.SetStrict(true)
+	if _, err := secureR.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secureR.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestFrameOrder(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var frames [3]bytes.Buffer
+	secureW := NewWriter(nil, priv, pub)
+	for i := range frames {
+		secureW.w = &frames[i]
+		secureW.Write([]byte{byte('0' + i)})
+	}
+
+	for _, tc := range []struct {
+		name  string
+		order []int
+	}{
+		{"dropped", []int{0, 2}},
+		{"reordered", []int{1, 0}},
+		{"duplicated", []int{0, 0}},
+	} {
+		var wire []byte
+		for _, i := range tc.order {
+			wire = append(wire, frames[i].Bytes()...)
+		}
+
+		secureR := NewReader(bytes.NewReader(wire), priv, pub)
+		buf := make([]byte, 1024)
+		var err error
+		for range tc.order {
+			if _, err = secureR.Read(buf); err != nil {
+				break
+			}
+		}
+		if err != ErrFrameOrder {
+			t.Fatalf("Expected ErrFrameOrder for a %s frame, got %v", tc.name, err)
+		}
+	}
+
+	// a Conn's Reader also rejects its own frames reflected back
+	secureW = NewWriter(&frames[0], priv, pub)
+	secureW.nonces.dir = dirFromClient
+	frames[0].Reset()
+	secureW.Write([]byte("reflected"))
+	secureR := NewReader(&frames[0], priv, pub)
+	secureR.dir = dirFromServer
+	if _, err := secureR.Read(make([]byte, 1024)); err != ErrFrameOrder {
+		t.Fatalf("Expected ErrFrameOrder for a reflected frame, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package secure
+
+import (
+	"io"
+	"io/fs"
+)
+
+// NewFS returns a view of fsys in which every file, written by
+// NewFileWriter for the holder of priv, reads decrypted, so that
+// encrypted static assets or configuration trees can be used with
+// standard APIs such as fs.ReadFile, fs.WalkDir and http.FS. Names and
+// directories are unchanged. Files report their plaintext size, and
+// can seek if the files of fsys can. Files that are not encrypted fail
+// to open with ErrFileFormat.
+func NewFS(fsys fs.FS, priv *[KeySize]byte) fs.FS {
+	return &fileFS{fsys, priv}
+}
+
+type fileFS struct {
+	fsys fs.FS
+	priv *[KeySize]byte
+}
+
+func (fsys *fileFS) Open(name string) (fs.File, error) {
+	f, err := fsys.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{f}, nil
+	}
+
+	r, err := NewFileReader(f, fsys.priv)
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{r, f, plainInfo{info}}, nil
+}
+
+// A file is an open encrypted file
+type file struct {
+	io.ReadSeeker
+	f    fs.File
+	info fs.FileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return f.f.Close()
+}
+
+// A dirFile is an open directory whose entries report
+// the plaintext size of the files in it
+type dirFile struct {
+	fs.File
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rd, ok := d.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Err: fs.ErrInvalid}
+	}
+	entries, err := rd.ReadDir(n)
+	for i, e := range entries {
+		entries[i] = dirEntry{e}
+	}
+	return entries, err
+}
+
+type dirEntry struct {
+	fs.DirEntry
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return plainInfo{info}, nil
+}
+
+// plainInfo reports the plaintext size of an encrypted file. The size
+// of a file that is not encrypted is reported as it is.
+type plainInfo struct {
+	fs.FileInfo
+}
+
+func (info plainInfo) Size() int64 {
+	size := info.FileInfo.Size()
+	if !info.Mode().IsRegular() {
+		return size
+	}
+	if plain, err := fileSize(size); err == nil {
+		return plain
+	}
+	return size
+}
@@ -0,0 +1,59 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestFS(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big := make([]byte, fileChunkSize*2+10)
+	rand.Read(big)
+	files := map[string][]byte{
+		"index.html":      []byte("<h1>hello</h1>"),
+		"empty.txt":       nil,
+		"assets/app.js":   big,
+		"config/a/b.json": []byte(`{"b": true}`),
+	}
+
+	encrypted := fstest.MapFS{}
+	for name, data := range files {
+		encrypted[name] = &fstest.MapFile{Data: encryptFile(t, data, pub), Mode: 0644}
+	}
+	fsys := NewFS(encrypted, priv)
+
+	for name, data := range files {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Unexpected contents of %s", name)
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Fatalf("Unexpected size of %s: %d", name, info.Size())
+		}
+	}
+
+	if err := fstest.TestFS(fsys, "index.html", "empty.txt", "assets/app.js", "config/a/b.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted["plain.txt"] = &fstest.MapFile{Data: []byte("not encrypted, but long enough to have a header")}
+	if _, err := fs.ReadFile(fsys, "plain.txt"); err == nil {
+		t.Fatal("A plaintext file was opened")
+	}
+}
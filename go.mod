@@ -1,6 +1,7 @@
 module github.com/jboverfelt/secure
 
 require (
+	filippo.io/edwards25519 v1.0.0
 	golang.org/x/crypto v0.0.0-20190228161510-8dd112bcdc25
 	golang.org/x/sys v0.0.0-20190302025703-b6889370fb10 // indirect
 )
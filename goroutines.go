@@ -0,0 +1,43 @@
+package secure
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrGoroutineLimit means that a connection already runs as many
+// goroutines as its Server allows
+var ErrGoroutineLimit = errors.New("too many goroutines for the connection")
+
+// Go runs fn on a new goroutine accounted to c, such as a pump reading
+// from the connection or a keepalive timer, so that a Server can count
+// and bound the goroutines its connections run. It fails with
+// ErrGoroutineLimit if the connection's Server sets MaxConnGoroutines
+// and that many are running. fn must return once c is closed.
+func (c *Conn) Go(fn func()) error {
+	n := atomic.AddInt32(&c.goroutines, 1)
+	if c.maxGoroutines > 0 && n > c.maxGoroutines {
+		atomic.AddInt32(&c.goroutines, -1)
+		return ErrGoroutineLimit
+	}
+	if c.track != nil {
+		c.track(1)
+	}
+
+	go func() {
+		defer func() {
+			atomic.AddInt32(&c.goroutines, -1)
+			if c.track != nil {
+				c.track(-1)
+			}
+		}()
+		fn()
+	}()
+	return nil
+}
+
+// Goroutines returns the number of goroutines started with Go
+// that are still running.
+func (c *Conn) Goroutines() int {
+	return int(atomic.LoadInt32(&c.goroutines))
+}
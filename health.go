@@ -0,0 +1,161 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPingData means that the peer sent data while Ping was
+// waiting for it to answer
+var ErrPingData = errors.New("data received while waiting for pong")
+
+// errPong ends the Read that Ping waits in once the pong arrives
+var errPong = errors.New("pong received")
+
+// healthzTimeout bounds the self-test Healthz runs
+const healthzTimeout = 5 * time.Second
+
+// Pings sent by SendPing carry this marker and the time they were sent,
+// which tells their pongs apart from those answering Ping, whose
+// payload is 8 random bytes
+const timedPingMarker = 't'
+
+// Size (in bytes) of the payload of a ping sent by SendPing
+const timedPingSize = 1 + 8
+
+// pingEpoch is what SendPing measures times from, so that
+// they come from the monotonic clock
+var pingEpoch = time.Now()
+
+// Ping sends the peer a ping and waits for its pong, running the
+// handshake first if necessary, and returns the round-trip time.
+// Pongs are authenticated like any frame and echo a random payload,
+// so a successful Ping proves that the peer holds the session key and
+// is reading. Ping reads from the connection and so must not be called
+// concurrently with Read; if the peer sends data before answering,
+// the data is lost and Ping fails with ErrPingData. It is meant for
+// checking a connection before or between exchanges, such as in
+// health checks. With Config.ReadAhead it fails with ErrReadAhead;
+// SendPing works with it.
+func (c *Conn) Ping() (time.Duration, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+	if c.readAheadState() != nil {
+		return 0, ErrReadAhead
+	}
+
+	payload := make([]byte, 8)
+	if _, err := io.ReadFull(c.config.randReader(), payload); err != nil {
+		return 0, err
+	}
+	c.r.Handle(FramePong, func(p []byte) error {
+		if bytes.Equal(p, payload) {
+			return errPong
+		}
+		return c.handlePong(p)
+	})
+	defer c.r.Handle(FramePong, c.handlePong)
+
+	start := time.Now()
+	if err := c.writeFrame(FramePing, payload); err != nil {
+		return 0, err
+	}
+	switch _, _, err := c.ReadWithAD(nil); err {
+	case errPong:
+		rtt := time.Since(start)
+		c.recordRTT(rtt)
+		return rtt, nil
+	case nil, io.ErrShortBuffer:
+		return 0, ErrPingData
+	default:
+		return 0, err
+	}
+}
+
+// SendPing sends the peer a ping without waiting for its pong, running
+// the handshake first if necessary. The pong is handled by a later
+// Read, which folds the round-trip time into the RTT reported by Stats
+// and ConnectionState, so that SendPing can be called from a timer
+// concurrently with the reads and writes of an exchange, to keep the
+// estimate current for adaptive timeouts.
+func (c *Conn) SendPing() error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	if err := c.failed(); err != nil {
+		return err
+	}
+
+	payload := make([]byte, timedPingSize)
+	payload[0] = timedPingMarker
+	binary.BigEndian.PutUint64(payload[1:], uint64(time.Since(pingEpoch)))
+	return c.writeFrame(FramePing, payload)
+}
+
+// handlePong records the round-trip time of pongs
+// answering SendPing and ignores others
+func (c *Conn) handlePong(payload []byte) error {
+	if len(payload) != timedPingSize || payload[0] != timedPingMarker {
+		return nil
+	}
+	sent := time.Duration(binary.BigEndian.Uint64(payload[1:]))
+	if rtt := time.Since(pingEpoch) - sent; rtt >= 0 && sent >= 0 {
+		c.recordRTT(rtt)
+		atomic.StoreInt64(&c.stats.ponged, int64(sent))
+	}
+	return nil
+}
+
+// Healthz checks that the Server can accept connections, by running
+// a handshake and a Ping between its Config, or the one given to
+// Reload, and a copy of it over an in-memory pipe. This exercises the
+// key pair or KeyProvider, KeyInfo expiry, certificates and suites the
+// way a client connection would, without going through the network.
+// It returns nil if the Server is healthy, for load balancer health
+// probes; DebugHandler serves it at /healthz.
+func (srv *Server) Healthz() error {
+	config := srv.currentConfig()
+	// the client side is a copy, so that the self-test
+	// is not logged or tapped twice
+	client := *config
+	client.KeyLogWriter, client.FrameTap, client.Tracer = nil, nil, nil
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+	deadline := time.Now().Add(healthzTimeout)
+	cc.SetDeadline(deadline)
+	sc.SetDeadline(deadline)
+
+	server := NewServerConn(sc, config)
+	done := make(chan error, 1)
+	go func() {
+		// answer the ping, then wait for the close
+		_, err := server.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	conn := NewClientConn(cc, &client)
+	// a pipe has no buffer, so the client must read the server's
+	// hello before sending the ping
+	conn.waitHello = true
+	if _, err := conn.Ping(); err != nil {
+		return err
+	}
+	if err := conn.Close(); err != nil {
+		return err
+	}
+	if err := <-done; err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package secure
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		buf := make([]byte, 64)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				return
+			}
+		}
+	})}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		rtt, err := conn.Ping()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtt <= 0 {
+			t.Fatalf("round-trip time %v", rtt)
+		}
+	}
+	if _, err := conn.Write([]byte("still usable")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPingData(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		io.WriteString(c, "hello")
+		c.Read(make([]byte, 64))
+	})}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Ping(); err != ErrPingData {
+		t.Fatalf("Ping = %v, want ErrPingData", err)
+	}
+}
+
+func TestSendPing(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the pong comes in with the echo, without getting in its way
+	if err := conn.SendPing(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected read: %q, %v", buf[:n], err)
+	}
+	rtt := conn.Stats().RTT
+	if rtt <= 0 || conn.ConnectionState().RTT != rtt {
+		t.Fatalf("Unexpected RTT: %v, %v", rtt, conn.ConnectionState().RTT)
+	}
+
+	// pongs answering something else are ignored
+	conn.handlePong([]byte("12345678"))
+	if got := conn.Stats().RTT; got != rtt {
+		t.Fatalf("RTT changed to %v for a foreign pong", got)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	pub, priv := mustGenerateKey(t, rand.Reader)
+	srv := &Server{Config: &Config{
+		PublicKey:   pub,
+		PrivateKey:  priv,
+		PairingCode: []byte("health"),
+		Suites:      []Suite{SuiteAES256GCM},
+	}}
+	if err := srv.Healthz(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Server{}).Healthz(); err != nil {
+		t.Fatal(err)
+	}
+
+	debug := httptest.NewServer(srv.DebugHandler())
+	defer debug.Close()
+	if body := get(t, debug.URL+"/healthz"); body != "ok\n" {
+		t.Fatalf("/healthz = %q", body)
+	}
+
+	srv.Config.KeyInfo = &KeyInfo{Expires: time.Now().Add(-time.Hour)}
+	if err := srv.Healthz(); err != ErrKeyExpired {
+		t.Fatalf("Healthz with an expired key = %v, want ErrKeyExpired", err)
+	}
+	resp, err := debug.Client().Get(debug.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("/healthz with an expired key: %s", resp.Status)
+	}
+}
@@ -0,0 +1,73 @@
+package secure
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDeadPeer means that the peer stopped answering a Server's
+// heartbeats and the connection was reaped
+var ErrDeadPeer = errors.New("peer stopped answering heartbeats")
+
+// heartbeatGrace returns how long a peer may leave a heartbeat
+// unanswered
+func (srv *Server) heartbeatGrace() time.Duration {
+	if srv.HeartbeatGrace > 0 {
+		return srv.HeartbeatGrace
+	}
+	return 3 * srv.Heartbeat
+}
+
+// heartbeat pings c every Heartbeat, as SendPing does, and reaps it
+// once a ping has gone unanswered for longer than the grace period,
+// from a goroutine started with c.Go. It returns the function
+// stopping the heartbeats.
+func (srv *Server) heartbeat(c *Conn) (stop func(), err error) {
+	done := make(chan struct{})
+	grace := srv.heartbeatGrace()
+	err = c.Go(func() {
+		ticker := time.NewTicker(srv.Heartbeat)
+		defer ticker.Stop()
+
+		// the oldest ping that is not answered yet, if any
+		var pending time.Duration = -1
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if pending >= 0 && time.Duration(atomic.LoadInt64(&c.stats.ponged)) >= pending {
+				pending = -1
+			}
+			if pending >= 0 {
+				if time.Since(pingEpoch)-pending > grace {
+					srv.reap(c)
+					return
+				}
+				continue
+			}
+			pending = time.Since(pingEpoch)
+			if err := c.SendPing(); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { close(done) }, nil
+}
+
+// reap terminates c, whose peer is gone, without sending it a close
+// frame, and reports it to the AuditHook
+func (srv *Server) reap(c *Conn) {
+	c.abort(ErrDeadPeer)
+
+	srv.count(&srv.stats.Reaped)
+	if hook := c.config.AuditHook; hook != nil {
+		hook(AuditEvent{RemoteAddr: c.conn.RemoteAddr(), Err: ErrDeadPeer, Terminated: true})
+	}
+}
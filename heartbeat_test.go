@@ -0,0 +1,121 @@
+package secure
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerHeartbeat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	var events []AuditEvent
+	served := make(chan error, 2)
+	srv := &Server{
+		Config: &Config{AuditHook: func(e AuditEvent) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		}},
+		Heartbeat:      10 * time.Millisecond,
+		HeartbeatGrace: 50 * time.Millisecond,
+		Handler: HandlerFunc(func(c *Conn) {
+			var err error
+			for err == nil {
+				_, err = c.Read(make([]byte, 64))
+			}
+			served <- err
+		}),
+	}
+	go srv.Serve(l)
+
+	// a client that keeps reading answers the heartbeats
+	live, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	go live.Read(make([]byte, 64))
+
+	// one that stops reading, as if it vanished, is reaped
+	dead, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+	if _, err := dead.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-served:
+		if err != ErrDeadPeer {
+			t.Fatalf("Expected ErrDeadPeer, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The dead client was not reaped")
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-served:
+		t.Fatalf("The live client was reaped: %v", err)
+	default:
+	}
+
+	if n := srv.Stats().Reaped; n != 1 {
+		t.Fatalf("Expected 1 reaped connection, got %d", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].Err != ErrDeadPeer || !events[0].Terminated {
+		t.Fatalf("Unexpected audit events %+v", events)
+	}
+}
+
+func TestServerHeartbeatGoroutines(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	counts := make(chan [2]int, 1)
+	srv := &Server{Heartbeat: time.Hour, MaxConnGoroutines: 1}
+	srv.Handler = HandlerFunc(func(c *Conn) {
+		counts <- [2]int{c.Goroutines(), srv.Goroutines()}
+		c.Read(make([]byte, 64))
+	})
+	go srv.Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the heartbeats, and the handler
+	if got := <-counts; got != [2]int{1, 2} {
+		t.Fatalf("Expected 1 goroutine for the connection and 2 for the Server, got %v", got)
+	}
+
+	// read-ahead leaves no room for the heartbeats
+	srv.Reload(&Config{ReadAhead: 1})
+	refused, err := Dial("tcp", l.Addr().String(), nil)
+	if err == nil {
+		_, err = refused.Read(make([]byte, 1))
+		refused.Close()
+	}
+	if err == nil {
+		t.Fatal("Expected the connection without room for heartbeats to be refused")
+	}
+	select {
+	case got := <-counts:
+		t.Fatalf("The refused connection was served with %v goroutines", got)
+	default:
+	}
+}
@@ -0,0 +1,416 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Hello extension ids
+const (
+	extProtocols byte = 1
+	extSuites    byte = 2
+	extRandom    byte = 3
+	extCert      byte = 4
+	extKeyID     byte = 5
+	extServer    byte = 6
+	extCertProof byte = 7
+	extBanner    byte = 8
+	extClient    byte = 9
+)
+
+// Size (in bytes) of the random values exchanged to salt suite keys
+const helloRandomSize = 32
+
+// ErrHello means that a hello frame was malformed or unexpected
+var ErrHello = errors.New("malformed or unexpected hello")
+
+// ErrServerName means that a client named a server other than
+// those listed in the server's Config.ServerNames
+var ErrServerName = errors.New("server name mismatch")
+
+// A hello is the first frame each side sends once the keys have been
+// exchanged, so everything in it is encrypted and authenticated. It is
+// a sequence of extensions, each a one byte id followed by a big endian
+// uint16 length and that many bytes of data. Unknown extensions are
+// skipped so that new ones can be added without breaking older peers.
+type hello struct {
+	// protocols offered by the client, in preference order,
+	// or the single protocol selected by the server
+	protocols []string
+
+	// suites offered by the client, in preference order,
+	// or the single suite selected by the server
+	suites []Suite
+
+	// random salts the key of the selected suite
+	random []byte
+
+	// cert certifies the sender's public key
+	cert *Certificate
+
+	// certProof proves that cert is in a certificate log
+	certProof *InclusionProof
+
+	// keyID names the sender's key
+	keyID string
+
+	// serverName is the server the client intended to reach,
+	// echoed by the server once it has checked it
+	serverName string
+
+	// banner describes the server
+	banner *Banner
+
+	// client describes the client
+	client *ClientInfo
+}
+
+func (h *hello) marshal() ([]byte, error) {
+	var b []byte
+
+	if len(h.protocols) > 0 {
+		var protos []byte
+		for _, p := range h.protocols {
+			protos = append(protos, byte(len(p)))
+			protos = append(protos, p...)
+		}
+		b = appendExtension(b, extProtocols, protos)
+	}
+
+	if len(h.suites) > 0 {
+		suites := make([]byte, len(h.suites))
+		for i, s := range h.suites {
+			suites[i] = byte(s)
+		}
+		b = appendExtension(b, extSuites, suites)
+	}
+
+	if len(h.random) > 0 {
+		b = appendExtension(b, extRandom, h.random)
+	}
+
+	if h.cert != nil {
+		cert, err := h.cert.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendExtension(b, extCert, cert)
+	}
+
+	if h.cert != nil && h.certProof != nil {
+		proof, err := h.certProof.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendExtension(b, extCertProof, proof)
+	}
+
+	if h.keyID != "" {
+		b = appendExtension(b, extKeyID, []byte(h.keyID))
+	}
+
+	if h.serverName != "" {
+		b = appendExtension(b, extServer, []byte(h.serverName))
+	}
+
+	if h.banner != nil {
+		banner, err := h.banner.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = appendExtension(b, extBanner, banner)
+	}
+
+	if h.client != nil {
+		client, err := h.client.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = appendExtension(b, extClient, client)
+	}
+
+	return b, nil
+}
+
+func appendExtension(b []byte, id byte, data []byte) []byte {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(data)))
+	b = append(b, id)
+	b = append(b, size[:]...)
+	return append(b, data...)
+}
+
+func (h *hello) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return ErrHello
+		}
+		id, size := b[0], int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+size {
+			return ErrHello
+		}
+		data := b[3 : 3+size]
+		b = b[3+size:]
+
+		switch id {
+		case extProtocols:
+			for len(data) > 0 {
+				n := int(data[0])
+				if n == 0 || len(data) < 1+n {
+					return ErrHello
+				}
+				h.protocols = append(h.protocols, string(data[1:1+n]))
+				data = data[1+n:]
+			}
+		case extSuites:
+			for _, s := range data {
+				h.suites = append(h.suites, Suite(s))
+			}
+		case extRandom:
+			if len(data) != helloRandomSize {
+				return ErrHello
+			}
+			h.random = data
+		case extCert:
+			h.cert = new(Certificate)
+			if err := h.cert.UnmarshalBinary(data); err != nil {
+				return ErrHello
+			}
+		case extCertProof:
+			h.certProof = new(InclusionProof)
+			if err := h.certProof.UnmarshalBinary(data); err != nil {
+				return ErrHello
+			}
+		case extKeyID:
+			h.keyID = string(data)
+		case extServer:
+			h.serverName = string(data)
+		case extBanner:
+			h.banner = new(Banner)
+			if err := h.banner.unmarshal(data); err != nil {
+				return err
+			}
+		case extClient:
+			h.client = new(ClientInfo)
+			if err := h.client.unmarshal(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchServerName reports whether name is one of names,
+// ignoring case and a trailing dot
+func matchServerName(names []string, name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSuffix(n, "."), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectProtocol picks the first of the server's protocols
+// that the client offered, or "" if there is none
+func selectProtocol(server, client []string) string {
+	for _, s := range server {
+		for _, c := range client {
+			if s == c {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// clientHello sends the client's hello. The server's reply is read
+// right away if the client needs it to finish the handshake, and
+// otherwise picked up by the first Read.
+func (c *Conn) clientHello() error {
+	for _, p := range c.config.NextProtos {
+		if len(p) == 0 || len(p) > 255 {
+			return ErrHello
+		}
+	}
+
+	h := hello{
+		protocols:  c.config.NextProtos,
+		suites:     c.config.Suites,
+		cert:       c.config.Certificate,
+		certProof:  c.config.CertificateProof,
+		keyID:      c.config.keyID(),
+		serverName: c.config.ServerName,
+		client:     c.config.ClientInfo,
+	}
+	if len(h.suites) > 0 {
+		h.random = make([]byte, helloRandomSize)
+		if _, err := io.ReadFull(c.config.randReader(), h.random); err != nil {
+			return err
+		}
+		c.clientRandom = h.random
+	}
+	b, err := h.marshal()
+	if err != nil {
+		return err
+	}
+	if err := c.w.WriteFrame(FrameHello, b); err != nil {
+		return err
+	}
+
+	// frames can only be sealed once the suite is known, and
+	// nothing should be sent to an unverified server
+	if !c.waitHello && len(c.config.NextProtos) == 0 && len(c.config.Suites) == 0 && len(c.config.TrustedIdentities) == 0 && len(c.config.TrustedLogs) == 0 {
+		c.r.Handle(FrameHello, c.handleServerHello)
+		return nil
+	}
+
+	t, payload, err := c.r.readFrame()
+	if err != nil {
+		return err
+	}
+	if t == FrameAlert {
+		return parseAlert(payload)
+	}
+	if t != FrameHello {
+		return ErrHello
+	}
+	return c.handleServerHello(payload)
+}
+
+func (c *Conn) handleServerHello(payload []byte) error {
+	// only one server hello is allowed
+	c.r.Handle(FrameHello, nil)
+
+	var h hello
+	if err := h.unmarshal(payload); err != nil {
+		return err
+	}
+	if len(h.protocols) > 1 || len(h.suites) > 1 {
+		return ErrHello
+	}
+	// a server may only confirm the name it was given
+	if h.serverName != "" && h.serverName != c.config.ServerName {
+		return ErrHello
+	}
+	if err := c.verifyPeerCertificate(h.cert, h.certProof); err != nil {
+		return err
+	}
+
+	if len(h.suites) == 1 && h.suites[0] != SuiteBox {
+		// the server must pick one of ours
+		if selectSuite(c.config.Suites, h.suites) != h.suites[0] || h.random == nil {
+			return ErrHello
+		}
+		if err := c.useSuite(h.suites[0], c.clientRandom, h.random, h.serverName); err != nil {
+			return err
+		}
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.peerKeyID = h.keyID
+	c.serverName = h.serverName
+	c.peerBanner = h.banner
+	if len(h.protocols) == 1 {
+		c.protocol = h.protocols[0]
+	}
+	return nil
+}
+
+// serverHello reads the client's hello and answers it
+func (c *Conn) serverHello() error {
+	t, payload, err := c.r.readFrame()
+	if err != nil {
+		return err
+	}
+	if t == FrameAlert {
+		return parseAlert(payload)
+	}
+	if t != FrameHello {
+		return ErrHello
+	}
+
+	var ch hello
+	if err := ch.unmarshal(payload); err != nil {
+		return err
+	}
+	if err := c.verifyPeerCertificate(ch.cert, ch.certProof); err != nil {
+		return err
+	}
+	if ch.serverName != "" && len(c.config.ServerNames) > 0 && !matchServerName(c.config.ServerNames, ch.serverName) {
+		return ErrServerName
+	}
+	c.stateMu.Lock()
+	c.peerKeyID = ch.keyID
+	c.serverName = ch.serverName
+	c.peerClientInfo = ch.client
+	c.stateMu.Unlock()
+
+	sh := hello{cert: c.config.Certificate, certProof: c.config.CertificateProof, keyID: c.config.keyID(), serverName: ch.serverName}
+	if c.config.Banner != nil {
+		banner := *c.config.Banner
+		if banner.MaxMessageSize == 0 {
+			banner.MaxMessageSize = c.r.max
+		}
+		sh.banner = &banner
+	}
+	if p := selectProtocol(c.config.NextProtos, ch.protocols); p != "" {
+		sh.protocols = []string{p}
+		c.stateMu.Lock()
+		c.protocol = p
+		c.stateMu.Unlock()
+	}
+
+	suite := selectSuite(c.config.Suites, ch.suites)
+	if suite != SuiteBox {
+		if ch.random == nil {
+			return ErrHello
+		}
+		sh.suites = []Suite{suite}
+		sh.random = make([]byte, helloRandomSize)
+		if _, err := io.ReadFull(c.config.randReader(), sh.random); err != nil {
+			return err
+		}
+	}
+
+	// the hellos themselves are always sealed with box
+	b, err := sh.marshal()
+	if err != nil {
+		return err
+	}
+	if err := c.w.WriteFrame(FrameHello, b); err != nil {
+		return err
+	}
+	if suite != SuiteBox {
+		return c.useSuite(suite, ch.random, sh.random, sh.serverName)
+	}
+	return nil
+}
+
+// useSuite switches the connection to suite for all further frames,
+// salting its key with the client's and the server's hello randoms,
+// and the hash of the server name both confirmed, if any, so that
+// the keys are bound to the server the client meant to reach
+func (c *Conn) useSuite(suite Suite, clientRandom, serverRandom []byte, serverName string) error {
+	salt := append(append([]byte(nil), clientRandom...), serverRandom...)
+	if serverName != "" {
+		h := sha256.Sum256([]byte(serverName))
+		salt = append(salt, h[:]...)
+	}
+	aead, err := suite.aead(&c.r.shared, salt)
+	if err != nil {
+		return err
+	}
+	c.r.aead, c.w.aead = aead, aead
+	c.r.salt, c.w.salt = salt, salt
+
+	c.stateMu.Lock()
+	c.suite = suite
+	c.stateMu.Unlock()
+	return nil
+}
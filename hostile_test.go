@@ -0,0 +1,246 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// The hostile peer simulator plays byte sequences against a server's
+// handshake, as a client that sends truncated keys, garbage preambles,
+// forged frames or drips its bytes in slowly would, and checks that the
+// server rejects them in time and without allocating much. The sequences
+// are mutations of a genuine client's, drawn from a seed, so a failing
+// seed reproduces with
+//
+//	go test -run TestHostileHandshake -hostile.seed <seed>
+//
+// FuzzServerHandshake plays sequences from the fuzzer instead:
+//
+//	go test -run '^$' -fuzz FuzzServerHandshake
+var hostileSeed = flag.Int64("hostile.seed", 0, "Run the hostile peer simulator with this seed only")
+
+const (
+	// hostileQuiet is how long the hostile client waits for the
+	// server once the server has read everything, before hanging up
+	hostileQuiet = 100 * time.Millisecond
+
+	// hostileHandshakeTimeout is the HandshakeTimeout
+	// of a server a client drips bytes into
+	hostileHandshakeTimeout = 50 * time.Millisecond
+
+	// hostileTimeout bounds how long the server may take to give
+	// up once the client hung up or the handshake timed out
+	hostileTimeout = time.Second
+
+	// hostileAlloc bounds the memory a server handshake allocates,
+	// whatever the client sends
+	hostileAlloc = 1 << 20
+)
+
+// A hostileScript is what a hostile client sends during the handshake.
+type hostileScript struct {
+	// data is sent as is, or drip bytes at a time,
+	// a millisecond apart, if drip is not zero
+	data []byte
+	drip int
+
+	// preamble makes the server expect the Preamble
+	preamble bool
+}
+
+// A hostileServer is the server side of the simulator, with a fixed key
+// pair so that a genuine client's handshake can be replayed against it.
+type hostileServer struct {
+	config     *Config
+	transcript []byte
+}
+
+// newHostileServer generates the server's keys and records a genuine
+// client's handshake with it, preamble included
+func newHostileServer(tb testing.TB) *hostileServer {
+	pub, priv := mustGenerateKey(tb, newSimRand(1))
+	s := &hostileServer{config: &Config{PublicKey: pub, PrivateKey: priv}}
+
+	clientConn, serverConn := simPipe(1)
+	client := NewClientConn(clientConn, &Config{SendPreamble: true, NextProtos: []string{"hostile"}})
+	server := NewServerConn(serverConn, &Config{PublicKey: pub, PrivateKey: priv, SendPreamble: true})
+	done := make(chan error, 1)
+	go func() { done <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		tb.Fatal(err)
+	}
+	s.transcript = clientConn.out.wire
+	return s
+}
+
+// play runs script against a server handshake and checks that the
+// handshake ends in time and within the allocation bound. It returns
+// the handshake's error.
+func (s *hostileServer) play(tb testing.TB, script hostileScript) error {
+	config := *s.config
+	config.SendPreamble = script.preamble
+	if script.drip > 0 {
+		config.HandshakeTimeout = hostileHandshakeTimeout
+	}
+	cc, sc := net.Pipe()
+	defer cc.Close()
+
+	// drain the server's key and hello
+	go io.Copy(ioutil.Discard, cc)
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	handshaked := make(chan error, 1)
+	go func() {
+		server := NewServerConn(sc, &config)
+		handshaked <- server.Handshake()
+		sc.Close()
+	}()
+
+	// the pipe has no buffer, so the client stops sending once
+	// the server stops reading, and is done once it read it all
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		data := script.data
+		for len(data) > 0 {
+			n := len(data)
+			if script.drip > 0 && n > script.drip {
+				n = script.drip
+			}
+			if _, err := cc.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+			if script.drip > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	var err error
+	select {
+	case err = <-handshaked:
+		return checkHostileAlloc(tb, &before, script, err)
+	case <-sent:
+	case <-time.After(hostileTimeout + time.Duration(len(script.data))*time.Millisecond):
+		tb.Fatalf("The server stopped reading a %d byte handshake (drip %d)", len(script.data), script.drip)
+	}
+	select {
+	case err = <-handshaked:
+		return checkHostileAlloc(tb, &before, script, err)
+	case <-time.After(hostileQuiet):
+	}
+
+	// hang up on a server waiting for more
+	cc.Close()
+	select {
+	case err = <-handshaked:
+	case <-time.After(hostileTimeout):
+		tb.Fatalf("The server did not give up on a %d byte handshake (drip %d)", len(script.data), script.drip)
+	}
+	return checkHostileAlloc(tb, &before, script, err)
+}
+
+// checkHostileAlloc checks what the server allocated since before
+// for a handshake ending with err, and returns err
+func checkHostileAlloc(tb testing.TB, before *runtime.MemStats, script hostileScript, err error) error {
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > hostileAlloc {
+		tb.Fatalf("The server allocated %d bytes for a %d byte handshake", alloc, len(script.data))
+	}
+	return err
+}
+
+// mutate returns a hostile variant of the genuine transcript
+func (s *hostileServer) mutate(r *rand.Rand) hostileScript {
+	genuine := s.transcript
+	body := genuine[len(Preamble):]
+	switch r.Intn(7) {
+	case 0:
+		// a truncated key or hello
+		return hostileScript{data: body[:r.Intn(len(body))]}
+	case 1:
+		// an oversize or garbled preamble
+		junk := make([]byte, r.Intn(4*len(Preamble)))
+		r.Read(junk)
+		return hostileScript{data: append(junk, body...), preamble: true}
+	case 2:
+		// flipped bits
+		data := append([]byte(nil), genuine...)
+		for i := 1 + r.Intn(4); i > 0; i-- {
+			data[r.Intn(len(data))] ^= 1 << uint(r.Intn(8))
+		}
+		return hostileScript{data: data, preamble: true}
+	case 3:
+		// a frame claiming to be larger than any message
+		data := append([]byte(nil), body[:KeySize+NonceSize]...)
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(MaxMessageSize+r.Intn(1<<16-MaxMessageSize)))
+		return hostileScript{data: append(data, size[:]...)}
+	case 4:
+		// random bytes
+		data := make([]byte, r.Intn(2*len(body)))
+		r.Read(data)
+		return hostileScript{data: data}
+	case 5:
+		// the genuine handshake, dripped in slowly
+		return hostileScript{data: body, drip: 1 + r.Intn(4)}
+	default:
+		// the genuine handshake, to check the harness itself
+		return hostileScript{data: genuine, preamble: true}
+	}
+}
+
+func TestHostileHandshake(t *testing.T) {
+	s := newHostileServer(t)
+	if err := s.play(t, hostileScript{data: s.transcript, preamble: true}); err != nil {
+		t.Fatalf("The genuine handshake failed: %v", err)
+	}
+	if err := s.play(t, hostileScript{data: s.transcript, drip: 1, preamble: true}); err != ErrHandshakeTimeout {
+		t.Fatalf("Expected ErrHandshakeTimeout for a drip-fed handshake, got %v", err)
+	}
+
+	seeds := []int64{*hostileSeed}
+	if *hostileSeed == 0 {
+		seeds = nil
+		n := int64(100)
+		if testing.Short() {
+			n = 10
+		}
+		for seed := int64(1); seed <= n; seed++ {
+			seeds = append(seeds, seed)
+		}
+	}
+	for _, seed := range seeds {
+		script := s.mutate(rand.New(rand.NewSource(seed)))
+		err := s.play(t, script)
+		if err == nil && !bytes.Equal(script.data, s.transcript) && !bytes.Equal(script.data, s.transcript[len(Preamble):]) {
+			t.Fatalf("Seed %d: the server accepted a hostile handshake", seed)
+		}
+	}
+}
+
+func FuzzServerHandshake(f *testing.F) {
+	s := newHostileServer(f)
+	f.Add(s.transcript, byte(0), true)
+	f.Add(s.transcript[len(Preamble):], byte(0), false)
+	f.Add(s.transcript[:len(Preamble)+KeySize/2], byte(0), true)
+	f.Add(s.transcript[len(Preamble):], byte(1), false)
+	f.Add(bytes.Repeat([]byte{0xff}, 64), byte(0), true)
+	f.Fuzz(func(t *testing.T, data []byte, drip byte, preamble bool) {
+		s.play(t, hostileScript{data: data, drip: int(drip % 8), preamble: preamble})
+	})
+}
@@ -0,0 +1,195 @@
+package secure
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+)
+
+// PEM block type of an encoded Certificate
+const pemCertificate = "SECURE DEVICE CERTIFICATE"
+
+// Version of the binary encoding of a Certificate
+const certificateVersion = 1
+
+// Prefix of the message an identity signs, so that its signatures
+// over device keys cannot be confused with anything else it signs
+const certificateContext = "secure device certificate\x00"
+
+// ErrCertificate means that a device certificate was malformed, its
+// signature did not verify, it did not match the key it was presented
+// with or it was not issued by a trusted identity
+var ErrCertificate = errors.New("invalid or untrusted device certificate")
+
+// A Certificate binds the encryption key of one device to an identity:
+// a long-lived ed25519 key, kept offline or on a single machine, that
+// stands for a user. Each device has its own key pair and certificate,
+// so a user can run clients on several machines, and a peer that trusts
+// the identity accepts any of them. See Config.Certificate and
+// Config.TrustedIdentities.
+type Certificate struct {
+	// Identity is the public key of the identity that issued the
+	// certificate.
+	Identity ed25519.PublicKey
+
+	// Device names the device, such as "laptop". It is informational
+	// and at most 255 bytes long.
+	Device string
+
+	// Key is the device's public key.
+	Key [KeySize]byte
+
+	// Signature is the identity's signature over the other fields.
+	Signature []byte
+}
+
+// Certify issues a certificate for a device's public key,
+// signed with the private key of an identity.
+func Certify(identity ed25519.PrivateKey, device string, key *[KeySize]byte) (*Certificate, error) {
+	if len(identity) != ed25519.PrivateKeySize || len(device) > 255 {
+		return nil, ErrCertificate
+	}
+
+	c := &Certificate{
+		Identity: identity.Public().(ed25519.PublicKey),
+		Device:   device,
+		Key:      *key,
+	}
+	c.Signature = ed25519.Sign(identity, c.signed())
+	return c, nil
+}
+
+// signed returns the message the identity signs
+func (c *Certificate) signed() []byte {
+	b := []byte(certificateContext)
+	b = append(b, c.Identity...)
+	b = append(b, byte(len(c.Device)))
+	b = append(b, c.Device...)
+	return append(b, c.Key[:]...)
+}
+
+// Verify checks that the certificate is well formed, that its
+// signature is valid and, if there are any trusted identities, that
+// it was issued by one of them.
+func (c *Certificate) Verify(trusted ...ed25519.PublicKey) error {
+	if len(c.Identity) != ed25519.PublicKeySize || len(c.Device) > 255 ||
+		!ed25519.Verify(c.Identity, c.signed(), c.Signature) {
+		return ErrCertificate
+	}
+	if len(trusted) == 0 {
+		return nil
+	}
+	for _, id := range trusted {
+		if subtle.ConstantTimeCompare(id, c.Identity) == 1 {
+			return nil
+		}
+	}
+	return ErrCertificate
+}
+
+// VerifyKey is like Verify, but also checks that the
+// certificate was issued for key.
+func (c *Certificate) VerifyKey(key *[KeySize]byte, trusted ...ed25519.PublicKey) error {
+	if subtle.ConstantTimeCompare(c.Key[:], key[:]) != 1 {
+		return ErrCertificate
+	}
+	return c.Verify(trusted...)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (c *Certificate) MarshalBinary() ([]byte, error) {
+	if len(c.Identity) != ed25519.PublicKeySize || len(c.Device) > 255 ||
+		len(c.Signature) != ed25519.SignatureSize {
+		return nil, ErrCertificate
+	}
+
+	b := []byte{certificateVersion}
+	b = append(b, c.Identity...)
+	b = append(b, byte(len(c.Device)))
+	b = append(b, c.Device...)
+	b = append(b, c.Key[:]...)
+	return append(b, c.Signature...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It does not
+// verify the certificate.
+func (c *Certificate) UnmarshalBinary(data []byte) error {
+	const fixed = 1 + ed25519.PublicKeySize + 1 + KeySize + ed25519.SignatureSize
+	if len(data) < fixed || data[0] != certificateVersion {
+		return ErrCertificate
+	}
+	n := int(data[1+ed25519.PublicKeySize])
+	if len(data) != fixed+n {
+		return ErrCertificate
+	}
+
+	b := data[1:]
+	c.Identity = append(ed25519.PublicKey(nil), b[:ed25519.PublicKeySize]...)
+	b = b[ed25519.PublicKeySize+1:]
+	c.Device = string(b[:n])
+	b = b[n:]
+	copy(c.Key[:], b)
+	c.Signature = append([]byte(nil), b[KeySize:]...)
+	return nil
+}
+
+// EncodeCertificate returns the PEM encoding of c.
+func EncodeCertificate(c *Certificate) ([]byte, error) {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemCertificate, Bytes: b}), nil
+}
+
+// DecodeCertificate parses the first certificate in PEM encoded data.
+// It does not verify the certificate.
+func DecodeCertificate(data []byte) (*Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemCertificate {
+		return nil, ErrCertificate
+	}
+
+	c := new(Certificate)
+	if err := c.UnmarshalBinary(block.Bytes); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// verifyPeerCertificate checks the certificate sent in the peer's
+// hello, if any, and the proof of its inclusion in a log, and records
+// them. The certificate is required if the Config lists trusted
+// identities, and the proof if it lists trusted logs.
+func (c *Conn) verifyPeerCertificate(cert *Certificate, proof *InclusionProof) error {
+	trusted := c.config.TrustedIdentities
+	if cert == nil {
+		if len(trusted) > 0 {
+			return ErrCertificate
+		}
+		return nil
+	}
+	if err := cert.VerifyKey(c.peerPub, trusted...); err != nil {
+		return err
+	}
+	// an unverified proof is not worth keeping
+	var logged *InclusionProof
+	if logs := c.config.TrustedLogs; len(logs) > 0 {
+		for _, key := range logs {
+			if proof != nil && proof.Verify(cert, key) == nil {
+				logged = proof
+				break
+			}
+		}
+		if logged == nil {
+			return ErrNotLogged
+		}
+	}
+
+	c.stateMu.Lock()
+	c.peerCert = cert
+	c.peerCertProof = logged
+	c.stateMu.Unlock()
+	return nil
+}
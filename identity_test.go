@@ -0,0 +1,134 @@
+package secure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestCertificate(t *testing.T) {
+	idPub, idPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, _, _ := ed25519.GenerateKey(rand.Reader)
+	pub, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := Certify(idPriv, "laptop", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyKey(pub, idPub); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Verify(otherID); err != ErrCertificate {
+		t.Fatalf("Expected ErrCertificate for an untrusted identity, got %v", err)
+	}
+	other, _, _ := box.GenerateKey(rand.Reader)
+	if err := cert.VerifyKey(other); err != ErrCertificate {
+		t.Fatalf("Expected ErrCertificate for another key, got %v", err)
+	}
+
+	data, err := EncodeCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeCertificate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Device != "laptop" || decoded.Key != *pub {
+		t.Fatalf("Unexpected certificate: %+v", decoded)
+	}
+	if err := decoded.Verify(idPub); err != nil {
+		t.Fatal(err)
+	}
+
+	// any change is detected
+	b, _ := cert.MarshalBinary()
+	for i := 1; i < len(b); i++ {
+		b[i] ^= 1
+		var c Certificate
+		if c.UnmarshalBinary(b) == nil && c.Verify(idPub) == nil {
+			t.Fatalf("Tampered byte %d went unnoticed", i)
+		}
+		b[i] ^= 1
+	}
+}
+
+func TestConnTrustedIdentities(t *testing.T) {
+	idPub, idPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server greets each device by name
+	go (&Server{
+		Config: &Config{TrustedIdentities: []ed25519.PublicKey{idPub}},
+		Handler: HandlerFunc(func(c *Conn) {
+			if c.Handshake() != nil {
+				return
+			}
+			c.Write([]byte(c.ConnectionState().PeerCertificate.Device))
+		}),
+	}).Serve(l)
+
+	device := func(identity ed25519.PrivateKey, name string) *Config {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := Certify(identity, name, pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Config{PublicKey: pub, PrivateKey: priv, Certificate: cert}
+	}
+
+	read := func(config *Config) (string, error) {
+		conn, err := Dial("tcp", l.Addr().String(), config)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		return string(buf[:n]), err
+	}
+
+	for _, name := range []string{"laptop", "phone"} {
+		got, err := read(device(idPriv, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != name {
+			t.Fatalf("Unexpected result: %q != %q", got, name)
+		}
+	}
+
+	stolen := device(idPriv, "laptop")
+	stolen.PublicKey, stolen.PrivateKey, _ = box.GenerateKey(rand.Reader)
+	for name, config := range map[string]*Config{
+		"no certificate":             nil,
+		"untrusted identity":         device(otherPriv, "laptop"),
+		"certificate of another key": stolen,
+	} {
+		if _, err := read(config); err == nil {
+			t.Fatalf("The server accepted a client with %s", name)
+		}
+	}
+}
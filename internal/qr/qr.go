@@ -0,0 +1,502 @@
+// Package qr encodes QR codes (ISO/IEC 18004), for the command line
+// tool to show pairing URIs that a peer can scan. It encodes bytes only,
+// at error correction level M, in versions 1 to 10, which hold up to
+// 213 bytes: enough for a URI, and small enough to fit a terminal.
+package qr
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrTooLong means that the data does not fit in a version 10 code
+var ErrTooLong = errors.New("qr: data too long")
+
+// Largest version encoded
+const maxVersion = 10
+
+// Error correction codewords per block and number of blocks
+// at level M, indexed by version
+var (
+	eccPerBlock = [maxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	numBlocks   = [maxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// Format bits of level M
+const levelM = 0
+
+// A Code is a QR code: a square of dark and light modules.
+type Code struct {
+	// Size is the number of modules on each side.
+	Size int
+
+	modules  []bool
+	function []bool // modules not carrying data, left alone by masks
+}
+
+// Black reports whether the module at column x and row y is dark.
+// Modules outside the code are light, as is the quiet zone around it.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// Encode returns the smallest code holding data.
+func Encode(data []byte) (*Code, error) {
+	version := 1
+	for ; ; version++ {
+		if version > maxVersion {
+			return nil, ErrTooLong
+		}
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version) {
+			break
+		}
+	}
+
+	// byte mode, then the data, a terminator of up to four zero bits,
+	// zero bits up to a byte and the pad bytes up to the capacity
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := 8 * dataCodewords(version)
+	terminator := capacity - bb.n
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-bb.n%8)%8)
+	for pad := 0xec; bb.n < capacity; pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	c := &Code{Size: 4*version + 17}
+	c.modules = make([]bool, c.Size*c.Size)
+	c.function = make([]bool, c.Size*c.Size)
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addECC(bb.bytes, version))
+
+	// keep the mask that leaves the fewest patterns confusing scanners
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // masks undo themselves
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	return c, nil
+}
+
+// String renders the code for a terminal, two rows of modules per line
+// of half blocks, in black on white with a quiet zone of four modules
+// so that it scans on dark terminals too.
+func (c *Code) String() string {
+	const quiet = 4
+	var b strings.Builder
+	for y := -quiet; y < c.Size+quiet; y += 2 {
+		b.WriteString("\x1b[30;47m")
+		for x := -quiet; x < c.Size+quiet; x++ {
+			switch top, bottom := c.Black(x, y), c.Black(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// countBits returns the size of the character count of byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules returns the number of modules of a version
+// left for data and error correction codewords
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// dataCodewords returns the number of data codewords of a version
+func dataCodewords(version int) int {
+	return rawModules(version)/8 - eccPerBlock[version]*numBlocks[version]
+}
+
+// alignmentPositions returns the rows and columns
+// the alignment patterns of a version are centred on
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*4 + 4 + 2*n - 3) / (2*n - 2) * 2 // rounded up to even
+	pos := make([]int, n)
+	pos[0] = 6
+	for i := n - 1; i > 0; i-- {
+		pos[i] = version*4 + 10 - (n-1-i)*step
+	}
+	return pos
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int) {
+	// timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	// finder patterns with their separators
+	for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				d := ring(dx, dy)
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// alignment patterns, except where they would cover a finder
+	pos := alignmentPositions(version)
+	for i, y := range pos {
+		for j, x := range pos {
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, ring(dx, dy) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format bits, drawn once the mask is chosen
+	c.drawFormatBits(0)
+
+	// version information, from version 7
+	if version >= 7 {
+		bits := versionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 != 0
+			a, b := c.Size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// versionBits returns the 18 version information bits of a version
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	return version<<12 | rem
+}
+
+// formatBits returns the 15 format bits of level M and a mask
+func formatBits(mask int) int {
+	data := levelM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 != 0 }
+
+	// around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	// split between the other two
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true) // always dark
+}
+
+// drawCodewords lays out the codewords in columns two modules wide,
+// zigzagging up and down from the bottom right, around the function
+// patterns. The remainder bits some versions have are left light.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*c.Size+x] || i >= 8*len(codewords) {
+					continue
+				}
+				c.modules[y*c.Size+x] = codewords[i/8]>>uint(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty scores the patterns of the code that hinder scanning:
+// long runs, 2×2 blocks, lookalikes of the finder patterns and an
+// uneven balance of dark and light modules
+func (c *Code) penalty() int {
+	p, dark := 0, 0
+	for i := 0; i < c.Size; i++ {
+		p += c.linePenalty(func(j int) bool { return c.Black(j, i) })
+		p += c.linePenalty(func(j int) bool { return c.Black(i, j) })
+	}
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			d := c.Black(x, y)
+			if d {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size && d == c.Black(x+1, y) && d == c.Black(x, y+1) && d == c.Black(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+	total := c.Size * c.Size
+	return p + (abs(dark*20-total*10)+total-1)/total*10 - 10
+}
+
+// linePenalty scores the runs and finder lookalikes of one row or column
+func (c *Code) linePenalty(black func(int) bool) int {
+	p, run := 0, 0
+	for j := 0; j < c.Size; j++ {
+		if j > 0 && black(j) == black(j-1) {
+			run++
+		} else {
+			run = 1
+		}
+		if run == 5 {
+			p += 3
+		} else if run > 5 {
+			p++
+		}
+	}
+
+	// 1:1:3:1:1 with four light modules on either side,
+	// the quiet zone counting as light
+	finder := []bool{true, false, true, true, true, false, true}
+	for j := -4; j < c.Size; j++ {
+		match := true
+		for k, want := range finder {
+			if black(j+4+k) != want || j+4+k >= c.Size {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		before, after := true, true
+		for k := 0; k < 4; k++ {
+			before = before && !black(j+k)
+			after = after && !black(j+11+k)
+		}
+		if before || after {
+			p += 40
+		}
+	}
+	return p
+}
+
+// addECC splits the data codewords of a version into its blocks,
+// computes their error correction codewords and interleaves them
+func addECC(data []byte, version int) []byte {
+	blocks, ecc := numBlocks[version], eccPerBlock[version]
+	raw := rawModules(version) / 8
+	short := blocks - raw%blocks
+	shortLen := raw/blocks - ecc
+
+	divisor := rsDivisor(ecc)
+	var dataBlocks, eccBlocks [][]byte
+	for i := 0; i < blocks; i++ {
+		n := shortLen
+		if i >= short {
+			n++
+		}
+		dataBlocks = append(dataBlocks, data[:n])
+		eccBlocks = append(eccBlocks, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	out := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < ecc; i++ {
+		for _, b := range eccBlocks {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading term, highest powers first
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2⁸) modulo x⁸ + x⁴ + x³ + x² + 1
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z&0x80 != 0
+		z <<= 1
+		if carry {
+			z ^= 0x1d
+		}
+		if y>>uint(i)&1 != 0 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// bitBuffer accumulates bits, most significant first
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (bb *bitBuffer) append(v, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if bb.n%8 == 0 {
+			bb.bytes = append(bb.bytes, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			bb.bytes[bb.n/8] |= 0x80 >> uint(bb.n%8)
+		}
+		bb.n++
+	}
+}
+
+// ring returns which square ring around a pattern's centre
+// the offset dx, dy is on
+func ring(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+	return abs(dy)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
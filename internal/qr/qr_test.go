@@ -0,0 +1,195 @@
+package qr
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" at version 1-M, from the worked example
+	// at thonky.com/qr-code-tutorial
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Fatalf("Unexpected result: %v != %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	want := []int{
+		0x5412, // 101010000010010
+		0x5125, // 101000100100101
+		0x5e7c, // 101111001111100
+		0x5b4b, // 101101101001011
+		0x45f9, // 100010111111001
+		0x40ce, // 100000011001110
+		0x4f97, // 100111110010111
+		0x4aa0, // 100101010100000
+	}
+	for mask, w := range want {
+		if got := formatBits(mask); got != w {
+			t.Fatalf("Unexpected format bits for mask %d: %015b != %015b", mask, got, w)
+		}
+	}
+	if got := versionBits(7); got != 0x07c94 {
+		t.Fatalf("Unexpected version bits: %018b", got)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	codewords := []int{0, 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	data := []int{0, 16, 28, 44, 64, 86, 108, 124, 154, 182, 216}
+	for v := 1; v <= maxVersion; v++ {
+		if rawModules(v)/8 != codewords[v] || dataCodewords(v) != data[v] {
+			t.Fatalf("Unexpected capacity of version %d: %d, %d", v, rawModules(v)/8, dataCodewords(v))
+		}
+	}
+	if got := alignmentPositions(10); !reflect.DeepEqual(got, []int{6, 28, 50}) {
+		t.Fatalf("Unexpected alignment patterns: %v", got)
+	}
+
+	if _, err := Encode(make([]byte, 213)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Encode(make([]byte, 214)); err != ErrTooLong {
+		t.Fatalf("Expected ErrTooLong, got %v", err)
+	}
+}
+
+// decode reads the data of a code back, checking its
+// format bits and error correction codewords
+func decode(t *testing.T, c *Code) []byte {
+	version := (c.Size - 17) / 4
+
+	var format int
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(c.Black(14-i, 8))
+	}
+	format = format<<1 | bit(c.Black(7, 8))
+	format = format<<1 | bit(c.Black(8, 8))
+	format = format<<1 | bit(c.Black(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(c.Black(8, i))
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("Unexpected format bits %015b", format)
+	}
+
+	// unmask a copy of the code laid out with the same function
+	// patterns and read its data modules in the order they were drawn
+	layout := &Code{Size: c.Size, modules: make([]bool, len(c.modules)), function: make([]bool, len(c.modules))}
+	layout.drawFunctionPatterns(version)
+	copy(layout.modules, c.modules)
+	raw := make([]byte, rawModules(version)/8)
+	var bb bitBuffer
+	layout.applyMask(mask)
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for x := right; x >= right-1; x-- {
+				if layout.function[y*c.Size+x] || bb.n >= 8*len(raw) {
+					continue
+				}
+				bb.append(bit(layout.modules[y*c.Size+x]), 1)
+			}
+		}
+	}
+	copy(raw, bb.bytes)
+
+	// undo the interleaving and check each block
+	blocks, ecc := numBlocks[version], eccPerBlock[version]
+	short := blocks - len(raw)%blocks
+	shortLen := len(raw)/blocks - ecc
+	dataBlocks := make([][]byte, blocks)
+	i := 0
+	for j := 0; j <= shortLen; j++ {
+		for b := range dataBlocks {
+			if j < shortLen || b >= short {
+				dataBlocks[b] = append(dataBlocks[b], raw[i])
+				i++
+			}
+		}
+	}
+	var data []byte
+	for b, block := range dataBlocks {
+		var got []byte
+		for j := 0; j < ecc; j++ {
+			got = append(got, raw[i+j*blocks+b])
+		}
+		if want := rsRemainder(block, rsDivisor(ecc)); !bytes.Equal(got, want) {
+			t.Fatalf("Unexpected error correction codewords of block %d", b)
+		}
+		data = append(data, block...)
+	}
+
+	// byte mode, the count and the bytes
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("Unexpected mode %x", data[0]>>4)
+	}
+	n, off := int(data[0]&0xf)<<4|int(data[1]>>4), 12
+	if countBits(version) == 16 {
+		n, off = int(data[0]&0xf)<<12|int(data[1])<<4|int(data[2]>>4), 20
+	}
+	out := make([]byte, n)
+	for k := range out {
+		b := off + 8*k
+		out[k] = data[b/8]<<uint(b%8) | data[b/8+1]>>uint(8-b%8)
+	}
+	return out
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+func TestEncode(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"secure",
+		"secure-pair://relay.example:9000?code=7-alpha-bravo&fp=0b5e-1c2d-3e4f-5a6b-7c8d-9e0f-1a2b-3c4d",
+		strings.Repeat("x", 200),
+	} {
+		c, err := Encode([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decode(t, c); string(got) != msg {
+			t.Fatalf("Unexpected result: %q != %q", got, msg)
+		}
+
+		// the finder patterns are in three corners
+		for _, p := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+			if !c.Black(p[0], p[1]) || c.Black(p[0]+1, p[1]+1) || !c.Black(p[0]+3, p[1]+3) {
+				t.Fatalf("Missing finder pattern at %v", p)
+			}
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	c, err := Encode([]byte("secure"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(c.String(), "\n"), "\n")
+	if len(lines) != (c.Size+9)/2 {
+		t.Fatalf("Unexpected number of lines: %d", len(lines))
+	}
+}
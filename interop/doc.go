@@ -0,0 +1,17 @@
+// Package interop holds the wire format interoperability tests for
+// package secure. It has no API of its own.
+//
+// testdata/vectors.json holds frames sealed by the reference
+// construction, NaCl's crypto_box (crypto_box_easy in libsodium), under
+// fixed keys and nonces, laid out as the nonce, the little endian
+// uint16 ciphertext length and the ciphertext of the frame type byte
+// followed by the payload. Each nonce ends with the frame's direction
+// byte and its big endian number in the stream. The tests check that package secure reads
+// them, that what it writes opens with the reference construction, and
+// that a Conn completes a handshake against a replayed server capture.
+// Any change that breaks them changes the wire format.
+//
+// The vectors are regenerated with
+//
+//	go run testdata/gen.go > testdata/vectors.json
+package interop
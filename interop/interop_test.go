@@ -0,0 +1,281 @@
+package interop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/jboverfelt/secure"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+type frame struct {
+	Type    secure.FrameType
+	Payload []byte
+	Wire    []byte
+}
+
+type vectors struct {
+	clientPriv, clientPub *[secure.KeySize]byte
+	serverPriv, serverPub *[secure.KeySize]byte
+	frames                []frame
+}
+
+func loadVectors(t *testing.T) *vectors {
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		ClientPrivateKey string `json:"client_private_key"`
+		ServerPrivateKey string `json:"server_private_key"`
+		Frames           []struct {
+			Type    byte   `json:"type"`
+			Payload string `json:"payload"`
+			Wire    string `json:"wire"`
+		} `json:"frames"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &vectors{
+		clientPriv: decodeKey(t, raw.ClientPrivateKey),
+		serverPriv: decodeKey(t, raw.ServerPrivateKey),
+		clientPub:  new([secure.KeySize]byte),
+		serverPub:  new([secure.KeySize]byte),
+	}
+	curve25519.ScalarBaseMult(v.clientPub, v.clientPriv)
+	curve25519.ScalarBaseMult(v.serverPub, v.serverPriv)
+
+	for _, f := range raw.Frames {
+		v.frames = append(v.frames, frame{
+			Type:    secure.FrameType(f.Type),
+			Payload: decodeHex(t, f.Payload),
+			Wire:    decodeHex(t, f.Wire),
+		})
+	}
+	return v
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decodeKey(t *testing.T, s string) *[secure.KeySize]byte {
+	var key [secure.KeySize]byte
+	if copy(key[:], decodeHex(t, s)) != secure.KeySize {
+		t.Fatalf("Short key in vectors: %s", s)
+	}
+	return &key
+}
+
+// capture is everything the server sends after the key exchange
+func (v *vectors) capture() []byte {
+	var b []byte
+	for _, f := range v.frames {
+		b = append(b, f.Wire...)
+	}
+	return b
+}
+
+// openFrame reads one frame from r and opens it with the reference construction
+func openFrame(r io.Reader, peerPub, priv *[secure.KeySize]byte) (secure.FrameType, []byte, error) {
+	var header [secure.HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	var nonce [secure.NonceSize]byte
+	copy(nonce[:], header[:])
+
+	enc := make([]byte, binary.LittleEndian.Uint16(header[secure.NonceSize:]))
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return 0, nil, err
+	}
+
+	plain, ok := box.Open(nil, enc, &nonce, peerPub, priv)
+	if !ok || len(plain) == 0 {
+		return 0, nil, secure.ErrDecrypt
+	}
+	return secure.FrameType(plain[0]), plain[1:], nil
+}
+
+func TestReadVectors(t *testing.T) {
+	v := loadVectors(t)
+
+	r := secure.NewReader(bytes.NewReader(v.capture()), v.clientPriv, v.serverPub)
+	var control []string
+	r.Handle(secure.FrameHello, func(payload []byte) error {
+		control = append(control, "hello:"+string(payload))
+		return nil
+	})
+	r.Handle(secure.FramePing, func(payload []byte) error {
+		control = append(control, "ping:"+string(payload))
+		return nil
+	})
+
+	var msgs []string
+	buf := make([]byte, secure.MaxMessageSize)
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+
+	var expectedMsgs, expectedControl []string
+	for _, f := range v.frames {
+		switch f.Type {
+		case secure.FrameData:
+			expectedMsgs = append(expectedMsgs, string(f.Payload))
+		case secure.FrameHello:
+			expectedControl = append(expectedControl, "hello:"+string(f.Payload))
+		case secure.FramePing:
+			expectedControl = append(expectedControl, "ping:"+string(f.Payload))
+		}
+	}
+
+	if !equal(msgs, expectedMsgs) {
+		t.Fatalf("Unexpected messages: %q != %q", msgs, expectedMsgs)
+	}
+	if !equal(control, expectedControl) {
+		t.Fatalf("Unexpected control frames: %q != %q", control, expectedControl)
+	}
+}
+
+func TestWriteVectors(t *testing.T) {
+	v := loadVectors(t)
+
+	var buf bytes.Buffer
+	w := secure.NewWriter(&buf, v.serverPriv, v.clientPub)
+	for _, f := range v.frames {
+		if err := w.WriteFrame(f.Type, f.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, f := range v.frames {
+		typ, payload, err := openFrame(&buf, v.serverPub, v.clientPriv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != f.Type || !bytes.Equal(payload, f.Payload) {
+			t.Fatalf("Unexpected frame: %d %q != %d %q", typ, payload, f.Type, f.Payload)
+		}
+		if size := secure.SealedSize(len(payload)); size != len(f.Wire) {
+			t.Fatalf("Unexpected frame size: %d != %d", size, len(f.Wire))
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d unexpected trailing bytes", buf.Len())
+	}
+}
+
+// TestSessionCapture runs a client Conn against a
+// server that replays the captured vectors.
+func TestSessionCapture(t *testing.T) {
+	v := loadVectors(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		done <- replayServer(server, v)
+	}()
+
+	conn := secure.NewClientConn(client, &secure.Config{
+		PrivateKey: v.clientPriv,
+		PublicKey:  v.clientPub,
+	})
+
+	buf := make([]byte, secure.MaxMessageSize)
+	for _, f := range v.frames {
+		if f.Type != secure.FrameData {
+			continue
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], f.Payload) {
+			t.Fatalf("Unexpected message: %q != %q", buf[:n], f.Payload)
+		}
+	}
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the close frame, got %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replayServer plays the server side of the captured session and
+// checks that the client's frames open with the reference construction
+func replayServer(conn net.Conn, v *vectors) error {
+	if _, err := conn.Write(v.serverPub[:]); err != nil {
+		return err
+	}
+
+	var clientPub [secure.KeySize]byte
+	if _, err := io.ReadFull(conn, clientPub[:]); err != nil {
+		return err
+	}
+	if clientPub != *v.clientPub {
+		return secure.ErrHello
+	}
+
+	if typ, _, err := openFrame(conn, v.clientPub, v.serverPriv); err != nil || typ != secure.FrameHello {
+		return secure.ErrHello
+	}
+
+	// the client answers pings while we are still writing
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(v.capture())
+		written <- err
+	}()
+
+	for _, f := range v.frames {
+		if f.Type != secure.FramePing {
+			continue
+		}
+		typ, payload, err := openFrame(conn, v.clientPub, v.serverPriv)
+		if err != nil {
+			return err
+		}
+		if typ != secure.FramePong || !bytes.Equal(payload, f.Payload) {
+			return secure.ErrFrameType
+		}
+	}
+	return <-written
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
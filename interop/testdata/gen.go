@@ -0,0 +1,76 @@
+//go:build ignore
+// +build ignore
+
+// gen writes the interop test vectors using only the reference
+// crypto_box construction, not package secure.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+type frame struct {
+	Type    byte   `json:"type"`
+	Payload string `json:"payload"`
+	Wire    string `json:"wire"`
+}
+
+type vectors struct {
+	ClientPrivateKey string  `json:"client_private_key"`
+	ServerPrivateKey string  `json:"server_private_key"`
+	Frames           []frame `json:"frames"`
+}
+
+func main() {
+	clientPriv := sha256.Sum256([]byte("secure interop client"))
+	serverPriv := sha256.Sum256([]byte("secure interop server"))
+	var clientPub [32]byte
+	curve25519.ScalarBaseMult(&clientPub, &clientPriv)
+
+	v := vectors{
+		ClientPrivateKey: hex.EncodeToString(clientPriv[:]),
+		ServerPrivateKey: hex.EncodeToString(serverPriv[:]),
+	}
+
+	// what a server sends after the key exchange: its hello,
+	// two messages, a ping and a close counting the frames before it
+	for i, f := range []struct {
+		t       byte
+		payload string
+	}{
+		{5, ""},
+		{0, "hello world\n"},
+		{2, "ping"},
+		{0, "goodbye\n"},
+		{1, "\x00\x00\x00\x00\x00\x00\x00\x04"},
+	} {
+		// the random part of the nonce is left zero, then comes the
+		// direction (sent by the server) and the frame number
+		var nonce [24]byte
+		nonce[15] = 2
+		binary.BigEndian.PutUint64(nonce[16:], uint64(i))
+
+		enc := box.Seal(nil, append([]byte{f.t}, f.payload...), &nonce, &clientPub, &serverPriv)
+		wire := append(nonce[:], byte(len(enc)), byte(len(enc)>>8))
+		wire = append(wire, enc...)
+
+		v.Frames = append(v.Frames, frame{
+			Type:    f.t,
+			Payload: hex.EncodeToString([]byte(f.payload)),
+			Wire:    hex.EncodeToString(wire),
+		})
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "\t")
+	if err := e.Encode(v); err != nil {
+		panic(err)
+	}
+}
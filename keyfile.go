@@ -0,0 +1,330 @@
+package secure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// PEM block types used in key files
+const (
+	pemPublicKey           = "SECURE PUBLIC KEY"
+	pemPrivateKey          = "SECURE PRIVATE KEY"
+	pemEncryptedPrivateKey = "SECURE ENCRYPTED PRIVATE KEY"
+)
+
+// PEM headers holding a KeyInfo
+const (
+	headerKeyID   = "Key-ID"
+	headerCreated = "Created"
+	headerExpires = "Expires"
+)
+
+// ErrKeyFile means that a key file could not be parsed
+var ErrKeyFile = errors.New("malformed key file")
+
+// ErrKeyExpired means that a key was used after its expiry time
+var ErrKeyExpired = errors.New("key expired")
+
+// A KeyWrapper protects private keys stored on disk with a key it holds
+// elsewhere, such as in a cloud KMS, using envelope encryption: each
+// private key is sealed with a fresh data key and only that data key is
+// sent to the KeyWrapper.
+type KeyWrapper interface {
+	// URI identifies the wrapping key. It is stored in the key file so
+	// the matching KeyWrapper can be found with OpenKeyWrapper later.
+	URI() string
+
+	// Wrap encrypts a data key.
+	Wrap(key []byte) ([]byte, error)
+
+	// Unwrap decrypts a data key returned by Wrap.
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+var (
+	keyWrappersMu sync.RWMutex
+	keyWrappers   = make(map[string]func(uri string) (KeyWrapper, error))
+)
+
+// RegisterKeyWrapper makes KeyWrappers for URIs with the given scheme
+// (such as "awskms" or "gcpkms") available to OpenKeyWrapper. This
+// package does not depend on any cloud SDK; the packages that do
+// register themselves, typically from an init function.
+// If RegisterKeyWrapper is called twice with the same scheme, it panics.
+func RegisterKeyWrapper(scheme string, open func(uri string) (KeyWrapper, error)) {
+	keyWrappersMu.Lock()
+	defer keyWrappersMu.Unlock()
+
+	if _, dup := keyWrappers[scheme]; dup {
+		panic("secure: RegisterKeyWrapper called twice for scheme " + scheme)
+	}
+	keyWrappers[scheme] = open
+}
+
+// OpenKeyWrapper returns the KeyWrapper for uri
+// using the opener registered for its scheme.
+func OpenKeyWrapper(uri string) (KeyWrapper, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	keyWrappersMu.RLock()
+	open, ok := keyWrappers[u.Scheme]
+	keyWrappersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("secure: no key wrapper registered for scheme %q", u.Scheme)
+	}
+	return open(uri)
+}
+
+// Fingerprint returns a short digest of pub for comparing keys out of
+// band: the first 16 bytes of its SHA-256 hash in lowercase hex,
+// in groups of four digits separated by dashes.
+func Fingerprint(pub *[KeySize]byte) string {
+	sum := sha256.Sum256(pub[:])
+	var b []byte
+	for i := 0; i < fingerprintSize; i += 2 {
+		if i > 0 {
+			b = append(b, '-')
+		}
+		b = append(b, fmt.Sprintf("%02x%02x", sum[i], sum[i+1])...)
+	}
+	return string(b)
+}
+
+// MatchFingerprint reports whether fingerprint, as returned by
+// Fingerprint or FormatFingerprint, is that of pub. The comparison
+// takes the same time whichever character differs, so that a peer
+// probing for an expected fingerprint learns nothing from how long
+// the check took.
+func MatchFingerprint(pub *[KeySize]byte, fingerprint string) bool {
+	if digest, err := ParseFingerprint(fingerprint); err == nil {
+		sum := sha256.Sum256(pub[:])
+		return subtle.ConstantTimeCompare(sum[:fingerprintSize], digest) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(Fingerprint(pub)), []byte(fingerprint)) == 1
+}
+
+// KeyID returns the default ID of pub: the first 8 bytes
+// of its SHA-256 hash in lowercase hex.
+func KeyID(pub *[KeySize]byte) string {
+	sum := sha256.Sum256(pub[:])
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// KeyInfo is the rotation metadata of a key pair, stored in the
+// headers of its key files. When keys are rotated, the ID lets a
+// peer holding several of them pick the right one, and the expiry
+// time stops an old key from being used for too long.
+type KeyInfo struct {
+	// ID names the key. It is sent to the peer during the handshake
+	// (see Config.KeyInfo) and must be at most 255 bytes long.
+	ID string
+
+	// Created is when the key was generated.
+	Created time.Time
+
+	// Expires is when the key stops being valid.
+	// The zero value means it never does.
+	Expires time.Time
+}
+
+// NewKeyInfo returns the KeyInfo of a key pair created now. Its ID is
+// KeyID(pub), and it expires after lifetime unless that is zero.
+func NewKeyInfo(pub *[KeySize]byte, lifetime time.Duration) *KeyInfo {
+	now := time.Now().UTC().Truncate(time.Second)
+	info := &KeyInfo{ID: KeyID(pub), Created: now}
+	if lifetime > 0 {
+		info.Expires = now.Add(lifetime)
+	}
+	return info
+}
+
+// Expired reports whether the key has expired at time now.
+func (k *KeyInfo) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// AddKeyInfo returns data, the PEM encoding of a public or private key,
+// with info stored in the headers of its first block.
+func AddKeyInfo(data []byte, info *KeyInfo) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || len(info.ID) > 255 {
+		return nil, ErrKeyFile
+	}
+
+	if block.Headers == nil {
+		block.Headers = make(map[string]string)
+	}
+	if info.ID != "" {
+		block.Headers[headerKeyID] = info.ID
+	}
+	if !info.Created.IsZero() {
+		block.Headers[headerCreated] = info.Created.Format(time.RFC3339)
+	}
+	if !info.Expires.IsZero() {
+		block.Headers[headerExpires] = info.Expires.Format(time.RFC3339)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// DecodeKeyInfo parses the KeyInfo stored with the first key in a PEM
+// encoded key file. Files written without one yield an empty KeyInfo.
+func DecodeKeyInfo(data []byte) (*KeyInfo, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrKeyFile
+	}
+
+	info := &KeyInfo{ID: block.Headers[headerKeyID]}
+	for header, t := range map[string]*time.Time{headerCreated: &info.Created, headerExpires: &info.Expires} {
+		v, ok := block.Headers[header]
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, ErrKeyFile
+		}
+		*t = parsed
+	}
+	return info, nil
+}
+
+// EncodePublicKey returns the PEM encoding of pub.
+func EncodePublicKey(pub *[KeySize]byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: pub[:]})
+}
+
+// DecodePublicKey parses the first public key in a PEM encoded key file.
+func DecodePublicKey(data []byte) (*[KeySize]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemPublicKey || len(block.Bytes) != KeySize {
+		return nil, ErrKeyFile
+	}
+
+	var pub [KeySize]byte
+	copy(pub[:], block.Bytes)
+	return &pub, nil
+}
+
+// EncodePrivateKey returns the PEM encoding of priv. If kw is not nil
+// the key is envelope encrypted so it can only be decoded with the
+// help of the same KeyWrapper.
+func EncodePrivateKey(priv *[KeySize]byte, kw KeyWrapper) ([]byte, error) {
+	if kw == nil {
+		return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: priv[:]}), nil
+	}
+
+	var dataKey [KeySize]byte
+	if _, err := io.ReadFull(rand.Reader, dataKey[:]); err != nil {
+		return nil, err
+	}
+	wrapped, err := kw.Wrap(dataKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// a fresh data key is only ever used once, so the nonce can be fixed
+	var nonce [NonceSize]byte
+	block := &pem.Block{
+		Type: pemEncryptedPrivateKey,
+		Headers: map[string]string{
+			"Key-Wrapper": kw.URI(),
+			"Wrapped-Key": base64.StdEncoding.EncodeToString(wrapped),
+		},
+		Bytes: secretbox.Seal(nil, priv[:], &nonce, &dataKey),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// DecodePrivateKey parses the first private key in a PEM encoded key
+// file and derives its public key. Envelope encrypted keys are
+// decrypted using the KeyWrapper registered for the URI they name.
+func DecodePrivateKey(data []byte) (priv, pub *[KeySize]byte, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, ErrKeyFile
+	}
+
+	key := block.Bytes
+	switch block.Type {
+	case pemPrivateKey:
+	case pemEncryptedPrivateKey:
+		if key, err = unwrapPrivateKey(block); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, ErrKeyFile
+	}
+
+	if len(key) != KeySize {
+		return nil, nil, ErrKeyFile
+	}
+
+	priv, pub = new([KeySize]byte), new([KeySize]byte)
+	copy(priv[:], key)
+	curve25519.ScalarBaseMult(pub, priv)
+	return priv, pub, nil
+}
+
+func unwrapPrivateKey(block *pem.Block) ([]byte, error) {
+	kw, err := OpenKeyWrapper(block.Headers["Key-Wrapper"])
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(block.Headers["Wrapped-Key"])
+	if err != nil {
+		return nil, ErrKeyFile
+	}
+	key, err := kw.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, ErrKeyFile
+	}
+
+	var dataKey [KeySize]byte
+	var nonce [NonceSize]byte
+	copy(dataKey[:], key)
+	priv, ok := secretbox.Open(nil, block.Bytes, &nonce, &dataKey)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return priv, nil
+}
+
+// LoadKeyFile reads and decodes a private key file.
+func LoadKeyFile(path string) (priv, pub *[KeySize]byte, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return DecodePrivateKey(data)
+}
+
+// LoadKeyInfo reads the KeyInfo stored in a key file.
+func LoadKeyInfo(path string) (*KeyInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeKeyInfo(data)
+}
@@ -0,0 +1,195 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// xorWrapper stands in for a KMS by XORing data keys with a fixed pad
+type xorWrapper struct {
+	uri string
+}
+
+func (kw xorWrapper) URI() string {
+	return kw.uri
+}
+
+func (kw xorWrapper) Wrap(key []byte) ([]byte, error) {
+	wrapped := make([]byte, len(key))
+	for i := range key {
+		wrapped[i] = key[i] ^ 0x5c
+	}
+	return wrapped, nil
+}
+
+func (kw xorWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	return kw.Wrap(wrapped)
+}
+
+func init() {
+	RegisterKeyWrapper("testkms", func(uri string) (KeyWrapper, error) {
+		return xorWrapper{uri}, nil
+	})
+}
+
+func TestKeyFile(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedPub, err := DecodePublicKey(EncodePublicKey(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decodedPub != *pub {
+		t.Fatal("Unexpected public key")
+	}
+
+	for _, kw := range []KeyWrapper{nil, xorWrapper{"testkms://keys/server"}} {
+		data, err := EncodePrivateKey(priv, kw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := base64.StdEncoding.EncodeToString(priv[:])
+		if kw != nil && bytes.Contains(data, []byte(plain)) {
+			t.Fatal("Unexpected result. The private key is not encrypted.")
+		}
+
+		decodedPriv, decodedPub, err := DecodePrivateKey(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *decodedPriv != *priv || *decodedPub != *pub {
+			t.Fatal("Unexpected key pair")
+		}
+	}
+}
+
+func TestKeyFileUnknownWrapper(t *testing.T) {
+	_, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := EncodePrivateKey(priv, xorWrapper{"nokms://keys/server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DecodePrivateKey(data); err == nil || !strings.Contains(err.Error(), "nokms") {
+		t.Fatalf("Expected an error naming the unknown scheme, got %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	pub := &[KeySize]byte{'p', 'u', 'b'}
+
+	fp := Fingerprint(pub)
+	if len(fp) != 39 || strings.Count(fp, "-") != 7 {
+		t.Fatalf("Unexpected fingerprint format: %s", fp)
+	}
+	if Fingerprint(pub) != fp {
+		t.Fatal("Unexpected result. The fingerprint is not stable.")
+	}
+	if Fingerprint(&[KeySize]byte{'p', 'u', 'c'}) == fp {
+		t.Fatal("Unexpected result. Different keys share a fingerprint.")
+	}
+
+	if !MatchFingerprint(pub, fp) {
+		t.Fatal("Unexpected result. The key does not match its fingerprint.")
+	}
+	for _, other := range []string{"", fp[:38], fp + "0", Fingerprint(&[KeySize]byte{'p', 'u', 'c'})} {
+		if MatchFingerprint(pub, other) {
+			t.Fatalf("Unexpected match with %q", other)
+		}
+	}
+}
+
+func TestKeyInfo(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := NewKeyInfo(pub, time.Hour)
+	if info.ID != KeyID(pub) || info.Expired(time.Now()) || !info.Expired(time.Now().Add(2*time.Hour)) {
+		t.Fatalf("Unexpected key info: %+v", info)
+	}
+
+	for _, kw := range []KeyWrapper{nil, xorWrapper{"testkms://keys/server"}} {
+		data, err := EncodePrivateKey(priv, kw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err = AddKeyInfo(data, info); err != nil {
+			t.Fatal(err)
+		}
+
+		// the key itself is unchanged
+		if _, decodedPub, err := DecodePrivateKey(data); err != nil || *decodedPub != *pub {
+			t.Fatalf("Unexpected key pair: %v", err)
+		}
+		decoded, err := DecodeKeyInfo(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.ID != info.ID || !decoded.Created.Equal(info.Created) || !decoded.Expires.Equal(info.Expires) {
+			t.Fatalf("Unexpected key info: %+v != %+v", decoded, info)
+		}
+	}
+
+	// files without key info still decode
+	decoded, err := DecodeKeyInfo(EncodePublicKey(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != "" || decoded.Expired(time.Now()) {
+		t.Fatalf("Unexpected key info: %+v", decoded)
+	}
+}
+
+func TestConnKeyInfo(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server answers with the ID of the client's key
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		if c.Handshake() == nil {
+			c.Write([]byte(c.ConnectionState().PeerKeyID))
+		}
+	})}).Serve(l)
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{PublicKey: pub, PrivateKey: priv, KeyInfo: NewKeyInfo(pub, time.Hour)}
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != KeyID(pub) {
+		t.Fatalf("Unexpected key ID: %q", got)
+	}
+
+	config.KeyInfo = &KeyInfo{Expires: time.Now().Add(-time.Minute)}
+	if _, err := Dial("tcp", l.Addr().String(), config); err != ErrKeyExpired {
+		t.Fatalf("Expected ErrKeyExpired, got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrKeyString means that a public key or fingerprint string was
+// malformed or failed its checksum
+var ErrKeyString = errors.New("malformed key string")
+
+// A KeyFormat is a text encoding of public keys and fingerprints for
+// pasting into configuration files, DNS records or chat messages. Every
+// format carries a checksum, so that a mistyped or truncated key is
+// rejected instead of silently naming another one.
+type KeyFormat int
+
+// Key formats
+const (
+	// KeyBech32 is the Bech32 encoding of BIP 173, with the prefix
+	// "secpub" for public keys and "secfp" for fingerprints. It is
+	// case-insensitive and guaranteed to detect up to four mistyped
+	// characters.
+	KeyBech32 KeyFormat = iota
+
+	// KeyBase58 is Base58Check, as used for Bitcoin addresses: the
+	// shortest of the formats, with no characters that look alike.
+	KeyBase58
+
+	// KeyHex is lowercase hex followed by the hex of 4 bytes of the
+	// SHA-256 digest of the key, for tools that only handle hex.
+	KeyHex
+)
+
+func (f KeyFormat) String() string {
+	switch f {
+	case KeyBech32:
+		return "bech32"
+	case KeyBase58:
+		return "base58"
+	case KeyHex:
+		return "hex"
+	}
+	return "unknown"
+}
+
+// fingerprintSize is how many bytes of the SHA-256 digest
+// of a public key make up its fingerprint
+const fingerprintSize = 16
+
+// Bech32 prefixes and Base58Check versions
+// of public keys and fingerprints
+const (
+	publicKeyHRP   = "secpub"
+	fingerprintHRP = "secfp"
+
+	publicKeyVersion   = 0x3f
+	fingerprintVersion = 0x40
+)
+
+// FormatPublicKey returns pub encoded in format, for ParsePublicKey.
+func FormatPublicKey(pub *[KeySize]byte, format KeyFormat) string {
+	return formatKeyString(pub[:], format, publicKeyHRP, publicKeyVersion)
+}
+
+// ParsePublicKey parses a public key returned by FormatPublicKey in any
+// format, ignoring surrounding white space.
+func ParsePublicKey(s string) (*[KeySize]byte, error) {
+	b, err := parseKeyString(s, KeySize, publicKeyHRP, publicKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	var pub [KeySize]byte
+	copy(pub[:], b)
+	return &pub, nil
+}
+
+// FormatFingerprint returns the digest of pub that Fingerprint shows,
+// encoded in format with a checksum, for ParseFingerprint and
+// MatchFingerprint.
+func FormatFingerprint(pub *[KeySize]byte, format KeyFormat) string {
+	sum := sha256.Sum256(pub[:])
+	return formatKeyString(sum[:fingerprintSize], format, fingerprintHRP, fingerprintVersion)
+}
+
+// ParseFingerprint parses a fingerprint returned by FormatFingerprint
+// in any format, ignoring surrounding white space, and returns its
+// digest.
+func ParseFingerprint(s string) ([]byte, error) {
+	return parseKeyString(s, fingerprintSize, fingerprintHRP, fingerprintVersion)
+}
+
+func formatKeyString(b []byte, format KeyFormat, hrp string, version byte) string {
+	switch format {
+	case KeyBase58:
+		return base58CheckEncode(version, b)
+	case KeyHex:
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(b) + hex.EncodeToString(sum[:4])
+	}
+	return bech32Encode(hrp, b)
+}
+
+// parseKeyString decodes a string of size bytes in whichever format
+// it is in: Bech32 strings start with their prefix, and hex strings
+// are twice as long as Base58Check ones
+func parseKeyString(s string, size int, hrp string, version byte) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToLower(s), hrp+"1") {
+		prefix, b, ok := bech32Decode(s)
+		if !ok || prefix != hrp || len(b) != size {
+			return nil, ErrKeyString
+		}
+		return b, nil
+	}
+
+	if len(s) == 2*(size+4) {
+		raw, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, ErrKeyString
+		}
+		b, check := raw[:size], raw[size:]
+		sum := sha256.Sum256(b)
+		if string(check) != string(sum[:4]) {
+			return nil, ErrKeyString
+		}
+		return b, nil
+	}
+
+	v, b, ok := base58CheckDecode(s)
+	if !ok || v != version || len(b) != size {
+		return nil, ErrKeyString
+	}
+	return b, nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Polymod computes the BCH checksum of BIP 173
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+func bech32Encode(hrp string, b []byte) string {
+	values, _ := convertBits(b, 8, 5, true)
+	mod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := uint(0); i < 6; i++ {
+		values = append(values, byte(mod>>(5*(5-i)))&31)
+	}
+
+	out := []byte(hrp + "1")
+	for _, v := range values {
+		out = append(out, bech32Charset[v])
+	}
+	return string(out)
+}
+
+// bech32Decode decodes a Bech32 string, which must not mix cases
+func bech32Decode(s string) (string, []byte, bool) {
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, false
+	}
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 1 || sep+7 > len(lower) {
+		return "", nil, false
+	}
+
+	hrp := lower[:sep]
+	values := make([]byte, 0, len(lower)-sep-1)
+	for i := sep + 1; i < len(lower); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v < 0 {
+			return "", nil, false
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, false
+	}
+
+	b, ok := convertBits(values[:len(values)-6], 5, 8, false)
+	return hrp, b, ok
+}
+
+// convertBits regroups the bits of data from groups of from bits into
+// groups of to bits, padding the last group with zeros if pad is set
+// and otherwise failing unless the leftover bits are zero padding
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	var acc uint32
+	var bits uint
+	max := uint32(1)<<to - 1
+	out := make([]byte, 0, (len(data)*int(from)+int(to)-1)/int(to))
+	for _, v := range data {
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&max))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&max))
+		}
+	} else if bits >= from || acc<<(to-bits)&max != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58CheckEncode encodes version and b followed by the first 4
+// bytes of their double SHA-256 digest
+func base58CheckEncode(version byte, b []byte) string {
+	data := append([]byte{version}, b...)
+	sum := sha256.Sum256(data)
+	sum = sha256.Sum256(sum[:])
+	data = append(data, sum[:4]...)
+
+	// digits holds the number in base 58, least significant first
+	var digits []byte
+	for _, c := range data {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	var out []byte
+	for _, c := range data {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, base58Alphabet[digits[i]])
+	}
+	return string(out)
+}
+
+func base58CheckDecode(s string) (byte, []byte, bool) {
+	// bytes holds the number in base 256, least significant first
+	var bytes []byte
+	for i := 0; i < len(s); i++ {
+		carry := strings.IndexByte(base58Alphabet, s[i])
+		if carry < 0 {
+			return 0, nil, false
+		}
+		for j := range bytes {
+			carry += int(bytes[j]) * 58
+			bytes[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			bytes = append(bytes, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	var data []byte
+	for i := 0; i < len(s) && s[i] == base58Alphabet[0]; i++ {
+		data = append(data, 0)
+	}
+	for i := len(bytes) - 1; i >= 0; i-- {
+		data = append(data, bytes[i])
+	}
+	if len(data) < 5 {
+		return 0, nil, false
+	}
+
+	payload, check := data[:len(data)-4], data[len(data)-4:]
+	sum := sha256.Sum256(payload)
+	sum = sha256.Sum256(sum[:])
+	if string(check) != string(sum[:4]) {
+		return 0, nil, false
+	}
+	return payload[0], payload[1:], true
+}
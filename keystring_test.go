@@ -0,0 +1,94 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestKeyStrings(t *testing.T) {
+	pub, _ := mustGenerateKey(t, rand.Reader)
+	other, _ := mustGenerateKey(t, rand.Reader)
+	sum := sha256.Sum256(pub[:])
+
+	for _, format := range []KeyFormat{KeyBech32, KeyBase58, KeyHex} {
+		s := FormatPublicKey(pub, format)
+		got, err := ParsePublicKey(" " + s + "\n")
+		if err != nil || *got != *pub {
+			t.Fatalf("%v: public key %q did not round trip: %v", format, s, err)
+		}
+
+		fp := FormatFingerprint(pub, format)
+		digest, err := ParseFingerprint(fp)
+		if err != nil || !bytes.Equal(digest, sum[:fingerprintSize]) {
+			t.Fatalf("%v: fingerprint %q did not round trip: %v", format, fp, err)
+		}
+		if !MatchFingerprint(pub, fp) || MatchFingerprint(other, fp) {
+			t.Fatalf("%v: fingerprint %q matched the wrong keys", format, fp)
+		}
+
+		// a key is not a fingerprint, nor the other way round
+		if _, err := ParseFingerprint(s); err != ErrKeyString {
+			t.Fatalf("%v: expected ErrKeyString parsing a key as a fingerprint, got %v", format, err)
+		}
+		if _, err := ParsePublicKey(fp); err != ErrKeyString {
+			t.Fatalf("%v: expected ErrKeyString parsing a fingerprint as a key, got %v", format, err)
+		}
+
+		// every mistyped character is caught
+		for i := 0; i < len(s); i++ {
+			typo := []byte(s)
+			if typo[i] == '2' {
+				typo[i] = '3'
+			} else {
+				typo[i] = '2'
+			}
+			if _, err := ParsePublicKey(string(typo)); err != ErrKeyString {
+				t.Fatalf("%v: expected ErrKeyString for %q, got %v", format, typo, err)
+			}
+		}
+		if _, err := ParsePublicKey(s[:len(s)-1]); err != ErrKeyString {
+			t.Fatalf("%v: expected ErrKeyString for a truncated key, got %v", format, err)
+		}
+	}
+
+	s := FormatPublicKey(pub, KeyBech32)
+	if !strings.HasPrefix(s, "secpub1") || !strings.HasPrefix(FormatFingerprint(pub, KeyBech32), "secfp1") {
+		t.Fatalf("Unexpected Bech32 prefixes: %q", s)
+	}
+	if got, err := ParsePublicKey(strings.ToUpper(s)); err != nil || *got != *pub {
+		t.Fatalf("Upper case Bech32 key did not parse: %v", err)
+	}
+	if _, err := ParsePublicKey(strings.ToUpper(s[:10]) + s[10:]); err != ErrKeyString {
+		t.Fatalf("Expected ErrKeyString for mixed case, got %v", err)
+	}
+	if !MatchFingerprint(pub, Fingerprint(pub)) {
+		t.Fatal("Fingerprint no longer matches")
+	}
+}
+
+func TestBech32Vectors(t *testing.T) {
+	// valid checksums from BIP 173
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		if _, _, ok := bech32Decode(s); !ok {
+			t.Fatalf("Valid Bech32 string %q failed to decode", s)
+		}
+	}
+	for _, s := range []string{"pzry9x0s0muk", "1pzry9x0s0muk", "x1b4n0q5v", "li1dgmt3", "A1G7SGD8", "10a06t8", "1qzzfhee"} {
+		if _, _, ok := bech32Decode(s); ok {
+			t.Fatalf("Invalid Bech32 string %q decoded", s)
+		}
+	}
+
+	if s := bech32Encode("a", nil); s != "a12uel5l" {
+		t.Fatalf("Unexpected encoding of an empty payload: %q", s)
+	}
+}
@@ -0,0 +1,90 @@
+package secure
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSessionLimit means that a session reached its Config.MaxSessionAge
+// or Config.MaxSessionBytes and the connection was terminated
+var ErrSessionLimit = errors.New("session limit reached")
+
+// startLimits starts the clock of the session's age limit
+func (c *Conn) startLimits() {
+	age := c.config.MaxSessionAge
+	if age <= 0 {
+		return
+	}
+	c.sessionEnd = c.config.now().Add(age)
+	// a Read blocked on an idle connection is ended too
+	c.sessionTimer = time.AfterFunc(age, func() { c.terminate(ErrSessionLimit) })
+}
+
+// stopLimits stops the clock started by startLimits
+func (c *Conn) stopLimits() {
+	if c.sessionTimer != nil {
+		c.sessionTimer.Stop()
+	}
+}
+
+// limitWrite checks that n more bytes may be sealed with the session
+// key, terminating the connection if not, and counts them
+func (c *Conn) limitWrite(n int) error {
+	max := c.config.MaxSessionBytes
+	if c.sessionExpired() || (max > 0 && atomic.AddInt64(&c.sessionSent, int64(n)) > max) {
+		c.terminate(ErrSessionLimit)
+		return ErrSessionLimit
+	}
+	return nil
+}
+
+// limitRead counts n bytes read, terminating the connection
+// if the peer went over the limits
+func (c *Conn) limitRead(n int) error {
+	max := c.config.MaxSessionBytes
+	if c.sessionExpired() || (max > 0 && atomic.AddInt64(&c.sessionRecv, int64(n)) > max) {
+		c.terminate(ErrSessionLimit)
+		return ErrSessionLimit
+	}
+	return nil
+}
+
+func (c *Conn) sessionExpired() bool {
+	return !c.sessionEnd.IsZero() && !c.config.now().Before(c.sessionEnd)
+}
+
+// terminate ends the session because of err: the close frame tells
+// the peer, and every later Read or Write fails with err
+func (c *Conn) terminate(err error) {
+	c.sendClose()
+	c.failMu.Lock()
+	if c.failErr == nil {
+		c.failErr = err
+	}
+	c.failMu.Unlock()
+	c.setState(StateClosed)
+	c.conn.Close()
+}
+
+// abort ends the session because of err without telling the peer,
+// which is gone or hostile, and every later Read or Write fails
+// with err
+func (c *Conn) abort(err error) {
+	c.failMu.Lock()
+	if c.failErr == nil {
+		c.failErr = err
+	}
+	c.failMu.Unlock()
+	c.setState(StateClosed)
+	c.conn.Close()
+}
+
+// exhausted terminates the connection if err means that its
+// nonces ran out, as there is no new key to continue with
+func (c *Conn) exhausted(err error) error {
+	if err == ErrNonceExhausted {
+		c.terminate(err)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package secure
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionBytes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server holds its peer to the limit as well
+	serverErr := make(chan error, 1)
+	go (&Server{
+		Config: &Config{MaxSessionBytes: 10},
+		Handler: HandlerFunc(func(c *Conn) {
+			buf := make([]byte, 64)
+			for {
+				n, err := c.Read(buf)
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				if _, err := c.Write(buf[:n]); err != nil {
+					serverErr <- err
+					return
+				}
+			}
+		}),
+	}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), &Config{MaxSessionBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	if _, err := conn.Write([]byte("123456")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := conn.Read(buf); err != nil || n != 6 {
+		t.Fatalf("Unexpected read: %d, %v", n, err)
+	}
+	if _, err := conn.Write([]byte("12345")); err != ErrSessionLimit {
+		t.Fatalf("Expected ErrSessionLimit, got %v", err)
+	}
+	if _, err := conn.Read(buf); err != ErrSessionLimit {
+		t.Fatalf("Expected ErrSessionLimit from Read, got %v", err)
+	}
+	if err := <-serverErr; err != io.EOF {
+		t.Fatalf("Expected the server to see the close frame, got %v", err)
+	}
+
+	// a peer without the limit is cut off when it goes over it
+	conn, err = Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, msg := range []string{"123456", "12345"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-serverErr; err != ErrSessionLimit {
+		t.Fatalf("Expected ErrSessionLimit on the server, got %v", err)
+	}
+	if n, err := conn.Read(buf); err != nil || n != 6 {
+		t.Fatalf("Unexpected read: %d, %v", n, err)
+	}
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestSessionAge(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	// calls after the session's end fail
+	now := time.Now()
+	clock := func() time.Time { return now }
+	conn, err := Dial("tcp", l.Addr().String(), &Config{MaxSessionAge: time.Hour, Time: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := conn.Write([]byte("hello")); err != ErrSessionLimit {
+		t.Fatalf("Expected ErrSessionLimit, got %v", err)
+	}
+
+	// and so do those blocked on an idle connection
+	conn, err = Dial("tcp", l.Addr().String(), &Config{MaxSessionAge: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Fatal("Read outlived the session")
+	}
+	if _, err := conn.Write([]byte("hello")); err != ErrSessionLimit {
+		t.Fatalf("Expected ErrSessionLimit, got %v", err)
+	}
+}
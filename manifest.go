@@ -0,0 +1,177 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ManifestChunkSize is the size (in bytes) of the chunks a Manifest
+// hashes separately, so that a mismatch can be located
+const ManifestChunkSize = 1 << 20
+
+// FrameManifest is the control frame SendFile uses to send the
+// Manifest of the file after its contents, split across as many
+// frames as it needs.
+const FrameManifest FrameType = 0xfe
+
+// The most chunks a received manifest may list, bounding the memory
+// a peer can make the receiver allocate for it to 32MiB
+const maxManifestChunks = 1 << 20
+
+// ErrManifest means that a manifest was malformed,
+// or missing at the end of a transfer
+var ErrManifest = errors.New("malformed or missing manifest")
+
+// A Manifest lists the SHA-256 of every ManifestChunkSize chunk of
+// a file and of the whole file, so that a copy can be checked for
+// corruption after a transfer.
+type Manifest struct {
+	Size   int64
+	Chunks [][sha256.Size]byte
+	Sum    [sha256.Size]byte
+}
+
+// A ManifestError reports where data did not match a Manifest.
+type ManifestError struct {
+	// Chunk is the index of the first chunk that differs,
+	// or -1 if only the size or the whole file hash did
+	Chunk  int
+	Offset int64
+}
+
+func (e *ManifestError) Error() string {
+	if e.Chunk < 0 {
+		return "data does not match the manifest"
+	}
+	return fmt.Sprintf("chunk %d at offset %d does not match the manifest", e.Chunk, e.Offset)
+}
+
+// NewManifest returns the Manifest of everything read from r.
+func NewManifest(r io.Reader) (*Manifest, error) {
+	m := new(Manifest)
+	total := sha256.New()
+	chunk := sha256.New()
+	for {
+		chunk.Reset()
+		n, err := io.CopyN(io.MultiWriter(total, chunk), r, ManifestChunkSize)
+		if n > 0 || m.Size == 0 && len(m.Chunks) == 0 {
+			var sum [sha256.Size]byte
+			copy(sum[:], chunk.Sum(nil))
+			m.Chunks = append(m.Chunks, sum)
+			m.Size += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	copy(m.Sum[:], total.Sum(nil))
+	return m, nil
+}
+
+// Verify checks that everything read from r matches the Manifest,
+// and returns a *ManifestError for the first chunk that does not.
+func (m *Manifest) Verify(r io.Reader) error {
+	got, err := NewManifest(r)
+	if err != nil {
+		return err
+	}
+	for i, sum := range m.Chunks {
+		if i >= len(got.Chunks) || got.Chunks[i] != sum {
+			return &ManifestError{Chunk: i, Offset: int64(i) * ManifestChunkSize}
+		}
+	}
+	if got.Size != m.Size || len(got.Chunks) != len(m.Chunks) || got.Sum != m.Sum {
+		return &ManifestError{Chunk: -1}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the Manifest as the big endian size,
+// the hash of the file and the hashes of its chunks.
+func (m *Manifest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 8, 8+sha256.Size*(1+len(m.Chunks)))
+	binary.BigEndian.PutUint64(b, uint64(m.Size))
+	b = append(b, m.Sum[:]...)
+	for _, sum := range m.Chunks {
+		b = append(b, sum[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a Manifest encoded by MarshalBinary.
+func (m *Manifest) UnmarshalBinary(b []byte) error {
+	if len(b) < 8+sha256.Size {
+		return ErrManifest
+	}
+	size := int64(binary.BigEndian.Uint64(b))
+	chunks := b[8+sha256.Size:]
+	count := int64(len(chunks) / sha256.Size)
+	if size < 0 || len(chunks)%sha256.Size != 0 || count != manifestChunks(size) {
+		return ErrManifest
+	}
+
+	m.Size = size
+	copy(m.Sum[:], b[8:])
+	m.Chunks = make([][sha256.Size]byte, count)
+	for i := range m.Chunks {
+		copy(m.Chunks[i][:], chunks[i*sha256.Size:])
+	}
+	return nil
+}
+
+// manifestChunks returns the number of chunks hashed for a file of
+// size bytes. An empty file still has the hash of its empty chunk.
+func manifestChunks(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + ManifestChunkSize - 1) / ManifestChunkSize
+}
+
+// writeManifest sends m in as many FrameManifest frames as it needs
+func writeManifest(w frameWriter, m *Manifest) error {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		n := len(b)
+		if n > MaxMessageSize {
+			n = MaxMessageSize
+		}
+		if err := w.WriteFrame(FrameManifest, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// A manifestReceiver collects the FrameManifest frames of a transfer
+type manifestReceiver struct {
+	buf bytes.Buffer
+}
+
+func (r *manifestReceiver) handle(payload []byte) error {
+	if r.buf.Len()+len(payload) > 8+sha256.Size*(1+maxManifestChunks) {
+		return ErrManifest
+	}
+	r.buf.Write(payload)
+	return nil
+}
+
+// manifest returns the Manifest received
+func (r *manifestReceiver) manifest() (*Manifest, error) {
+	m := new(Manifest)
+	if err := m.UnmarshalBinary(r.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,47 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	for _, size := range []int{0, 1, ManifestChunkSize, 2*ManifestChunkSize + ManifestChunkSize/2} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		m, err := NewManifest(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Size != int64(size) || int64(len(m.Chunks)) != manifestChunks(int64(size)) {
+			t.Fatalf("Unexpected manifest of %d bytes: size %d, %d chunks", size, m.Size, len(m.Chunks))
+		}
+
+		b, _ := m.MarshalBinary()
+		var decoded Manifest
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalBinary(b[:len(b)-1]); err != ErrManifest {
+			t.Fatalf("Expected ErrManifest, got %v", err)
+		}
+
+		if size == 0 {
+			continue
+		}
+		if err := m.Verify(bytes.NewReader(data[:size-1])); err == nil {
+			t.Fatalf("Truncated data of %d bytes went unnoticed", size)
+		}
+		data[size-1] ^= 1
+		err = m.Verify(bytes.NewReader(data))
+		chunk := (size - 1) / ManifestChunkSize
+		if e, ok := err.(*ManifestError); !ok || e.Chunk != chunk || e.Offset != int64(chunk)*ManifestChunkSize {
+			t.Fatalf("Expected a ManifestError for chunk %d, got %v", chunk, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package secure
+
+// Buffers a connection holds besides those of read-ahead: the
+// ciphertext and plaintext of the frame being read, and of the
+// frame being written
+const connFrameBuffers = 4
+
+// memoryLimits returns the largest message and the read-ahead depth
+// of connections with c, reduced so that their buffers fit in
+// MaxConnMemory
+func (c *Config) memoryLimits() (maxMessage, readAhead int) {
+	maxMessage, readAhead = clampMessageSize(c.MaxMessageSize), c.ReadAhead
+	if readAhead < 0 {
+		readAhead = 0
+	}
+	if c.MaxConnMemory <= 0 {
+		return maxMessage, readAhead
+	}
+
+	frames := c.MaxConnMemory / SealedSize(maxMessage)
+	if frames < connFrameBuffers {
+		// not even one frame each way fits, so frames shrink
+		maxMessage = c.MaxConnMemory/connFrameBuffers - Overhead
+		if maxMessage < minMessageSize {
+			maxMessage = minMessageSize
+		}
+		return maxMessage, 0
+	}
+	// read-ahead holds one buffer more than its depth
+	if readAhead > 0 && connFrameBuffers+readAhead+1 > frames {
+		readAhead = frames - connFrameBuffers - 1
+	}
+	return maxMessage, readAhead
+}
+
+// connMemory returns the most buffer memory a connection
+// with c holds at once
+func (c *Config) connMemory() int64 {
+	maxMessage, readAhead := c.memoryLimits()
+	frames := connFrameBuffers
+	if readAhead > 0 {
+		frames += readAhead + 1
+	}
+	return int64(frames) * int64(SealedSize(maxMessage))
+}
+
+// reserveMemory reserves n bytes of the Server's MaxMemory,
+// reporting whether they were available. srv.mu must be held.
+func (srv *Server) reserveMemory(n int64) bool {
+	if srv.MaxMemory > 0 && srv.memory+n > srv.MaxMemory {
+		return false
+	}
+	srv.memory += n
+	return true
+}
@@ -0,0 +1,97 @@
+package secure
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimits(t *testing.T) {
+	frame := SealedSize(MaxMessageSize)
+	for _, tt := range []struct {
+		config            Config
+		maxMessage, depth int
+	}{
+		{Config{}, MaxMessageSize, 0},
+		{Config{ReadAhead: 8}, MaxMessageSize, 8},
+		{Config{ReadAhead: 8, MaxConnMemory: 100 * frame}, MaxMessageSize, 8},
+		{Config{ReadAhead: 8, MaxConnMemory: 7 * frame}, MaxMessageSize, 2},
+		{Config{ReadAhead: 8, MaxConnMemory: 5 * frame}, MaxMessageSize, 0},
+		{Config{ReadAhead: 8, MaxConnMemory: 4 * 1024}, 1024 - Overhead, 0},
+		{Config{MaxConnMemory: 1}, minMessageSize, 0},
+	} {
+		maxMessage, depth := tt.config.memoryLimits()
+		if maxMessage != tt.maxMessage || depth != tt.depth {
+			t.Fatalf("Unexpected limits for %+v: %d, %d", tt.config, maxMessage, depth)
+		}
+		if mem := tt.config.connMemory(); tt.config.MaxConnMemory > SealedSize(minMessageSize)*connFrameBuffers && mem > int64(tt.config.MaxConnMemory) {
+			t.Fatalf("Connections with %+v hold %d bytes", tt.config, mem)
+		}
+	}
+}
+
+func TestServerMaxMemory(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := &Config{MaxConnMemory: 64 << 10}
+	served := make(chan struct{}, 3)
+	srv := &Server{
+		Config:    config,
+		MaxMemory: config.connMemory(),
+		Handler: HandlerFunc(func(c *Conn) {
+			served <- struct{}{}
+			// hold the connection's memory until the client closes it
+			buf := make([]byte, MaxMessageSize)
+			for {
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+			}
+		}),
+	}
+	go srv.Serve(l)
+
+	first, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Write([]byte("hello"))
+	<-served
+
+	// a second connection does not fit
+	second, err := Dial("tcp", l.Addr().String(), nil)
+	if err == nil {
+		_, err = second.Read(make([]byte, 1))
+		second.Close()
+	}
+	if err == nil {
+		t.Fatal("Expected the connection over budget to be closed")
+	}
+	if n := srv.Stats().OverMemory; n != 1 {
+		t.Fatalf("Expected 1 connection over budget, got %d", n)
+	}
+
+	// until the first one is done
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		third, err := Dial("tcp", l.Addr().String(), nil)
+		if err == nil {
+			third.Write([]byte("hello"))
+			select {
+			case <-served:
+				third.Close()
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			third.Close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The memory of a closed connection was not released")
+		}
+	}
+}
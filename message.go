@@ -0,0 +1,94 @@
+package secure
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Size (in bytes) of the header of a FrameMessage: the big endian
+// send time in nanoseconds since the Unix epoch and message ID
+const MessageHeaderSize = 16
+
+// MessageInfo is the authenticated header sent with a message by
+// WriteMessage, for measuring latency or recognizing duplicates.
+type MessageInfo struct {
+	// ID is chosen by the sender, typically a sequence number.
+	ID uint64
+
+	// Time is when the message was sent, by the sender's clock.
+	Time time.Time
+}
+
+func (m MessageInfo) appendHeader(b []byte) []byte {
+	var header [MessageHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:8], uint64(m.Time.UnixNano()))
+	binary.BigEndian.PutUint64(header[8:], m.ID)
+	return append(b, header[:]...)
+}
+
+func parseMessageHeader(b []byte) MessageInfo {
+	return MessageInfo{
+		ID:   binary.BigEndian.Uint64(b[8:]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))),
+	}
+}
+
+// WriteMessage encrypts p and writes it as one message with a header
+// carrying info. If info.Time is zero the current time is sent.
+// p must leave room for the header within the maximum message size.
+func (s *Writer) WriteMessage(p []byte, info MessageInfo) (int, error) {
+	if len(p)+MessageHeaderSize > s.max {
+		return 0, ErrFrameTooLarge
+	}
+	if info.Time.IsZero() {
+		info.Time = time.Now()
+	}
+
+	payload := info.appendHeader(make([]byte, 0, MessageHeaderSize+len(p)))
+	if err := s.WriteFrame(FrameMessage, append(payload, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadMessageInfo is like Read, but also returns the header sent with
+// the message by WriteMessage. Messages sent without one have a zero
+// MessageInfo.
+func (s *Reader) ReadMessageInfo(p []byte) (int, MessageInfo, error) {
+	n, _, err := s.ReadWithAD(p)
+	return n, s.info, err
+}
+
+// WriteMessage encrypts p and writes it to the connection as one
+// message with a header carrying info. If info.Time is zero the
+// current time is sent.
+func (c *Conn) WriteMessage(p []byte, info MessageInfo) (int, error) {
+	if err := c.beginCall(); err != nil {
+		return 0, err
+	}
+	defer c.endCall()
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.failed(); err != nil {
+		return 0, err
+	}
+	if err := c.limitWrite(len(p)); err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	n, err := c.w.WriteMessage(p, info)
+	c.writeMu.Unlock()
+	return n, c.exhausted(c.closedErr(err))
+}
+
+// ReadMessageInfo is like Read, but also returns the header the peer
+// sent with the message using WriteMessage, if any.
+func (c *Conn) ReadMessageInfo(p []byte) (int, MessageInfo, error) {
+	n, _, info, err := c.read(p)
+	if err != nil {
+		return n, MessageInfo{}, err
+	}
+	return n, info, nil
+}
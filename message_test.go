@@ -0,0 +1,51 @@
+package secure
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMessageInfo(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	sent := time.Unix(1500000000, 123)
+	secureW.WriteMessage([]byte("hello world\n"), MessageInfo{ID: 42, Time: sent})
+	secureW.Write([]byte("plain"))
+	before := time.Now()
+	secureW.WriteMessage([]byte("now"), MessageInfo{ID: 43})
+
+	if _, err := secureW.WriteMessage(make([]byte, MaxMessageSize), MessageInfo{}); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+
+	secureR := NewReader(&wire, priv, pub)
+	buf := make([]byte, 1024)
+
+	n, info, err := secureR.ReadMessageInfo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello world\n" || info.ID != 42 || !info.Time.Equal(sent) {
+		t.Fatalf("Unexpected result: %q %+v", buf[:n], info)
+	}
+
+	n, info, err = secureR.ReadMessageInfo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "plain" || info != (MessageInfo{}) {
+		t.Fatalf("Unexpected result: %q %+v", buf[:n], info)
+	}
+
+	// plain Reads skip the header
+	n, err = secureR.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "now" || secureR.info.ID != 43 || secureR.info.Time.Before(before.Truncate(time.Second)) {
+		t.Fatalf("Unexpected result: %q %+v", buf[:n], secureR.info)
+	}
+}
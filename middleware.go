@@ -0,0 +1,63 @@
+package secure
+
+import "bytes"
+
+// A Frame is the plaintext of one frame: its type and its payload.
+type Frame struct {
+	Type    FrameType
+	Payload []byte
+}
+
+// A Middleware transforms frames between framing and sealing, so that
+// features such as compression, padding or metrics can be composed
+// around a Reader or Writer. A Writer's middleware sees each frame
+// before it is sealed, and a Reader's each frame once it has been
+// opened and authenticated. Both sides must use matching chains: a
+// Writer compressing and then padding needs a Reader removing the
+// padding and then decompressing.
+//
+// Close and alert frames are never passed to middleware, so that the
+// end of a stream is always recognized. A Middleware may return a frame of
+// another type, but must not keep the payload it is passed. An error
+// fails the Write or Read.
+type Middleware func(Frame) (Frame, error)
+
+// Use appends mw to the Writer's middleware, which is applied to each
+// frame in order before it is sealed. Frames that grow past the
+// maximum message size fail with ErrFrameTooLarge.
+func (s *Writer) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// Use appends mw to the Reader's middleware, which is applied to each
+// frame in order once it has been opened.
+func (s *Reader) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// applyMiddleware passes f through chain
+func applyMiddleware(chain []Middleware, f Frame) (Frame, error) {
+	for _, mw := range chain {
+		var err error
+		if f, err = mw(f); err != nil {
+			return Frame{}, err
+		}
+	}
+	return f, nil
+}
+
+// sendMiddleware applies the Writer's middleware to a frame of type t
+// carrying the concatenation of parts
+func (s *Writer) sendMiddleware(t FrameType, parts [][]byte) (FrameType, [][]byte, error) {
+	if len(s.middleware) == 0 || t == FrameClose || t == FrameAlert || t == FrameRekey {
+		return t, parts, nil
+	}
+	f, err := applyMiddleware(s.middleware, Frame{Type: t, Payload: bytes.Join(parts, nil)})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(f.Payload) > s.max {
+		return 0, nil, ErrFrameTooLarge
+	}
+	return f.Type, [][]byte{f.Payload}, nil
+}
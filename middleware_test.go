@@ -0,0 +1,133 @@
+package secure
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// compress and decompress deflate data frames
+func compress(f Frame) (Frame, error) {
+	if f.Type != FrameData {
+		return f, nil
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(f.Payload)
+	w.Close()
+	return Frame{Type: f.Type, Payload: buf.Bytes()}, nil
+}
+
+func decompress(f Frame) (Frame, error) {
+	if f.Type != FrameData {
+		return f, nil
+	}
+	p, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(f.Payload)))
+	return Frame{Type: f.Type, Payload: p}, err
+}
+
+// pad and unpad prefix payloads with their length and pad them to a
+// multiple of 64 bytes
+func pad(f Frame) (Frame, error) {
+	p := make([]byte, 2+len(f.Payload), (2+len(f.Payload)+63)/64*64)
+	binary.BigEndian.PutUint16(p, uint16(len(f.Payload)))
+	copy(p[2:], f.Payload)
+	return Frame{Type: f.Type, Payload: p[:cap(p)]}, nil
+}
+
+func unpad(f Frame) (Frame, error) {
+	if len(f.Payload) < 2 || len(f.Payload) < 2+int(binary.BigEndian.Uint16(f.Payload)) {
+		return Frame{}, ErrFrame
+	}
+	return Frame{Type: f.Type, Payload: f.Payload[2 : 2+binary.BigEndian.Uint16(f.Payload)]}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	msg := bytes.Repeat([]byte("compressible "), 100)
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.Use(compress, pad)
+	if _, err := secureW.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := secureW.WriteFrame(FrameControl, []byte("route=a")); err != nil {
+		t.Fatal(err)
+	}
+	secureW.Close()
+	if wire.Len() >= len(msg) || (wire.Len()-3*Overhead-8)%64 != 0 {
+		t.Fatalf("Frames were not compressed and padded: %d bytes", wire.Len())
+	}
+
+	var control string
+	secureR := NewReader(&wire, priv, pub)
+	secureR.Use(unpad, decompress)
+	secureR.Handle(FrameControl, func(payload []byte) error {
+		control = string(payload)
+		return nil
+	})
+	buf := make([]byte, len(msg))
+	n, err := secureR.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("Unexpected message %q, %v", buf[:n], err)
+	}
+	if _, err := secureR.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	if control != "route=a" {
+		t.Fatalf("Unexpected control frame %q", control)
+	}
+
+	// middleware errors fail the Write or Read
+	fail := errors.New("middleware failed")
+	secureW = NewWriter(ioutil.Discard, priv, pub)
+	secureW.Use(func(Frame) (Frame, error) { return Frame{}, fail })
+	if _, err := secureW.Write(msg); err != fail {
+		t.Fatalf("Expected the middleware's error, got %v", err)
+	}
+
+	// frames must still fit once transformed
+	secureW.middleware = nil
+	secureW.SetMaxMessageSize(64)
+	secureW.Use(pad)
+	if _, err := secureW.Write(make([]byte, 63)); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestConnMiddleware(t *testing.T) {
+	config := &Config{SendMiddleware: []Middleware{pad}, ReceiveMiddleware: []Middleware{unpad}}
+	l, err := Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	var sizes []int
+	client := *config
+	client.FrameTap = func(dir TapDirection, frame []byte) { sizes = append(sizes, len(frame)) }
+	conn, err := Dial("tcp", l.Addr().String(), &client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected reply %q, %v", buf[:n], err)
+	}
+	for _, size := range sizes {
+		if (size-Overhead)%64 != 0 {
+			t.Fatalf("Expected padded frames, got one of %d bytes", size)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// MinSeedSize is the smallest seed (in bytes) DeriveKeyPair accepts.
+const MinSeedSize = 16
+
+// ErrSeed means that a seed was too short to derive a key pair from
+var ErrSeed = errors.New("seed too short")
+
+// ErrKeyLabel means that a subkey label was empty or
+// had an empty component
+var ErrKeyLabel = errors.New("invalid subkey label")
+
+// ErrMnemonic means that a mnemonic had an unknown word, the wrong
+// number of words or a bad checksum
+var ErrMnemonic = errors.New("invalid mnemonic")
+
+var (
+	wordsOnce sync.Once
+	words     []string
+	wordIndex map[string]int
+)
+
+func mnemonicWordList() ([]string, map[string]int) {
+	wordsOnce.Do(func() {
+		words = strings.Fields(mnemonicWords)
+		wordIndex = make(map[string]int, len(words))
+		for i, w := range words {
+			wordIndex[w] = i
+		}
+	})
+	return words, wordIndex
+}
+
+// DeriveKeyPair deterministically derives a key pair from seed, which
+// must hold at least MinSeedSize bytes of entropy, so that a long-term
+// identity can be regenerated from an escrowed secret, such as the
+// seed of a mnemonic, after losing its key file.
+func DeriveKeyPair(seed []byte) (pub, priv *[KeySize]byte, err error) {
+	if len(seed) < MinSeedSize {
+		return nil, nil, ErrSeed
+	}
+
+	priv, pub = new([KeySize]byte), new([KeySize]byte)
+	kdf := hkdf.New(sha256.New, seed, nil, []byte("secure key pair"))
+	if _, err := io.ReadFull(kdf, priv[:]); err != nil {
+		return nil, nil, err
+	}
+	curve25519.ScalarBaseMult(pub, priv)
+	return pub, priv, nil
+}
+
+// DeriveSubKey derives the secret of a subkey from root, which must hold
+// at least MinSeedSize bytes of entropy, so that one root secret can
+// stand in for many independent keys, one per service or peer. Pass the
+// result to DeriveKeyPair for the subkey's key pair.
+//
+// Labels are hierarchical: components separated by slashes are derived
+// in turn, so DeriveSubKey(root, "api/eu") equals DeriveSubKey of
+// DeriveSubKey(root, "api") with "eu", and the holder of a subkey can
+// derive the keys below it, but not its siblings or the root.
+func DeriveSubKey(root []byte, label string) ([]byte, error) {
+	if len(root) < MinSeedSize {
+		return nil, ErrSeed
+	}
+
+	key := root
+	for _, component := range strings.Split(label, "/") {
+		if component == "" {
+			return nil, ErrKeyLabel
+		}
+		sub := make([]byte, KeySize)
+		kdf := hkdf.New(sha256.New, key, nil, []byte("secure subkey "+component))
+		if _, err := io.ReadFull(kdf, sub); err != nil {
+			return nil, err
+		}
+		key = sub
+	}
+	return key, nil
+}
+
+// NewMnemonic encodes entropy as a BIP39 mnemonic: a phrase of English
+// words that also carries a checksum. entropy must be 16 to 32 bytes
+// long, in steps of 4, giving 12 to 24 words. Any wallet or tool that
+// speaks BIP39 can check or store the phrase.
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", ErrSeed
+	}
+	list, _ := mnemonicWordList()
+
+	// the checksum is the first bit of the entropy's hash
+	// for every 4 bytes of entropy
+	sum := sha256.Sum256(entropy)
+	bits := append(append([]byte(nil), entropy...), sum[0])
+	n := (len(entropy)*8 + len(entropy)/4) / 11
+
+	phrase := make([]string, n)
+	for i := range phrase {
+		var index int
+		for b := i * 11; b < (i+1)*11; b++ {
+			index = index<<1 | int(bits[b/8]>>(7-b%8)&1)
+		}
+		phrase[i] = list[index]
+	}
+	return strings.Join(phrase, " "), nil
+}
+
+// MnemonicEntropy decodes a mnemonic returned by NewMnemonic back into
+// its entropy, checking its checksum.
+func MnemonicEntropy(mnemonic string) ([]byte, error) {
+	phrase := strings.Fields(mnemonic)
+	if len(phrase) < 12 || len(phrase) > 24 || len(phrase)%3 != 0 {
+		return nil, ErrMnemonic
+	}
+	_, index := mnemonicWordList()
+
+	bits := make([]byte, (len(phrase)*11+7)/8)
+	for i, w := range phrase {
+		v, ok := index[strings.ToLower(w)]
+		if !ok {
+			return nil, ErrMnemonic
+		}
+		for b := 0; b < 11; b++ {
+			if v>>(10-b)&1 == 1 {
+				pos := i*11 + b
+				bits[pos/8] |= 1 << (7 - pos%8)
+			}
+		}
+	}
+
+	size := len(phrase) * 11 * 32 / 33 / 8
+	entropy, checksum := bits[:size], bits[size]
+	sum := sha256.Sum256(entropy)
+	mask := byte(0xff) << (8 - size/4)
+	if sum[0]&mask != checksum&mask {
+		return nil, ErrMnemonic
+	}
+	return entropy, nil
+}
+
+// MnemonicSeed returns the BIP39 seed of a mnemonic, protected by an
+// optional passphrase, for use with DeriveKeyPair. It checks the
+// mnemonic first.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	phrase := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key([]byte(phrase), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
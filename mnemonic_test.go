@@ -0,0 +1,139 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestMnemonicWords(t *testing.T) {
+	list, _ := mnemonicWordList()
+	sum := sha256.Sum256([]byte(strings.Join(list, "\n") + "\n"))
+	if got := hex.EncodeToString(sum[:]); got != "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda" {
+		t.Fatalf("Unexpected word list hash: %s", got)
+	}
+}
+
+// Vectors from the BIP39 reference implementation,
+// whose seeds use the passphrase "TREZOR"
+var mnemonicVectors = []struct {
+	entropy, mnemonic, seed string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+		"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad",
+	},
+}
+
+func TestMnemonic(t *testing.T) {
+	for _, v := range mnemonicVectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Fatalf("Unexpected mnemonic:\nGot:\t\t%s\nExpected:\t%s", mnemonic, v.mnemonic)
+		}
+
+		decoded, err := MnemonicEntropy(mnemonic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, entropy) {
+			t.Fatalf("Unexpected entropy: %x", decoded)
+		}
+
+		seed, err := MnemonicSeed(mnemonic, "TREZOR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(seed) != v.seed {
+			t.Fatalf("Unexpected seed: %x", seed)
+		}
+	}
+
+	for _, bad := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon aboutt",
+	} {
+		if _, err := MnemonicEntropy(bad); err != ErrMnemonic {
+			t.Fatalf("Expected ErrMnemonic for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestDeriveKeyPair(t *testing.T) {
+	seed, err := MnemonicSeed(mnemonicVectors[1].mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := DeriveKeyPair(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub2, priv2, _ := DeriveKeyPair(seed)
+	if *pub != *pub2 || *priv != *priv2 {
+		t.Fatal("Unexpected result. The derivation is not deterministic.")
+	}
+
+	seed[0] ^= 1
+	other, _, _ := DeriveKeyPair(seed)
+	if *other == *pub {
+		t.Fatal("Unexpected result. Different seeds share a key pair.")
+	}
+	if _, _, err := DeriveKeyPair(seed[:MinSeedSize-1]); err != ErrSeed {
+		t.Fatalf("Expected ErrSeed, got %v", err)
+	}
+}
+
+func TestDeriveSubKey(t *testing.T) {
+	root := bytes.Repeat([]byte{7}, 32)
+
+	api, err := DeriveSubKey(root, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eu, err := DeriveSubKey(root, "api/eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nested, _ := DeriveSubKey(api, "eu"); !bytes.Equal(nested, eu) {
+		t.Fatal("Unexpected result. Labels are not hierarchical.")
+	}
+
+	seen := map[string]bool{string(root): true}
+	for _, label := range []string{"api", "api/eu", "api/us", "mail", "apieu"} {
+		sub, err := DeriveSubKey(root, label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[string(sub)] {
+			t.Fatalf("Subkey %q is not unique", label)
+		}
+		seen[string(sub)] = true
+	}
+
+	for _, label := range []string{"", "api/", "/api", "api//eu"} {
+		if _, err := DeriveSubKey(root, label); err != ErrKeyLabel {
+			t.Fatalf("Expected ErrKeyLabel for %q, got %v", label, err)
+		}
+	}
+	if _, err := DeriveSubKey(root[:MinSeedSize-1], "api"); err != ErrSeed {
+		t.Fatalf("Expected ErrSeed, got %v", err)
+	}
+}
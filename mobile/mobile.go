@@ -0,0 +1,181 @@
+// Package mobile is a facade over package secure that can be bound
+// into Android and iOS apps with gomobile bind:
+//
+//	gomobile bind -target=android github.com/jboverfelt/secure/mobile
+//
+// gomobile cannot bind arrays, so keys are passed as byte slices and
+// key pairs as *KeyPair handles, and a Conn reads whole messages
+// rather than into a buffer. The wire protocol is the same as that of
+// package secure, so apps using this package talk to Go peers as is.
+package mobile
+
+import (
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/jboverfelt/secure"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// ErrKeySize means that a key was not KeySize bytes long
+var ErrKeySize = errors.New("key must be 32 bytes")
+
+// KeySize is the size of private and public keys, in bytes.
+const KeySize = secure.KeySize
+
+// A KeyPair is a private key and its public key.
+type KeyPair struct {
+	priv, pub *[secure.KeySize]byte
+}
+
+// GenerateKeyPair returns a new random key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{priv, pub}, nil
+}
+
+// NewKeyPair returns the key pair of the private key priv,
+// which must be KeySize bytes long.
+func NewKeyPair(priv []byte) (*KeyPair, error) {
+	if len(priv) != secure.KeySize {
+		return nil, ErrKeySize
+	}
+	kp := &KeyPair{new([secure.KeySize]byte), new([secure.KeySize]byte)}
+	copy(kp.priv[:], priv)
+	curve25519.ScalarBaseMult(kp.pub, kp.priv)
+	return kp, nil
+}
+
+// DecodeKeyPair returns the key pair of a PEM encoded
+// private key file, as written by EncodePrivateKey.
+func DecodeKeyPair(data []byte) (*KeyPair, error) {
+	priv, pub, err := secure.DecodePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{priv, pub}, nil
+}
+
+// PrivateKey returns a copy of the private key.
+func (kp *KeyPair) PrivateKey() []byte {
+	return append([]byte(nil), kp.priv[:]...)
+}
+
+// PublicKey returns a copy of the public key.
+func (kp *KeyPair) PublicKey() []byte {
+	return append([]byte(nil), kp.pub[:]...)
+}
+
+// Fingerprint returns the fingerprint of the public key,
+// for comparing keys out of band.
+func (kp *KeyPair) Fingerprint() string {
+	return secure.Fingerprint(kp.pub)
+}
+
+// EncodePrivateKey returns the PEM encoding of the private key,
+// for storing it in the app's keychain or keystore.
+func (kp *KeyPair) EncodePrivateKey() ([]byte, error) {
+	return secure.EncodePrivateKey(kp.priv, nil)
+}
+
+// Options configures Dial. The zero value dials with a fresh key
+// pair, no pairing code and no timeout.
+type Options struct {
+	// Keys is this side's key pair. If nil, a fresh one is
+	// generated for the connection.
+	Keys *KeyPair
+
+	// PairingCode, if not empty, authenticates the key exchange.
+	// The peer must use the same code.
+	PairingCode string
+
+	// TimeoutMillis, if not zero, bounds in milliseconds dialing
+	// together with the handshake, and every Read and Write.
+	TimeoutMillis int64
+}
+
+// NewOptions returns Options with the defaults, for
+// languages that cannot create Go structs themselves.
+func NewOptions() *Options {
+	return new(Options)
+}
+
+// A Conn is a secure connection.
+type Conn struct {
+	c       *secure.Conn
+	timeout time.Duration
+	buf     []byte
+}
+
+// Dial connects to addr, given as host:port, over TCP and
+// performs the handshake. opts may be nil.
+func Dial(addr string, opts *Options) (*Conn, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	config := &secure.Config{PairingCode: []byte(opts.PairingCode)}
+	if opts.Keys != nil {
+		config.PrivateKey, config.PublicKey = opts.Keys.priv, opts.Keys.pub
+	}
+	timeout := time.Duration(opts.TimeoutMillis) * time.Millisecond
+
+	nc, err := (&secure.Dialer{Timeout: timeout, Config: config}).Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{c: nc.(*secure.Conn), timeout: timeout}, nil
+}
+
+// deadline applies the timeout, if any, to the next operation
+func (c *Conn) deadline() error {
+	if c.timeout == 0 {
+		return nil
+	}
+	return c.c.SetDeadline(time.Now().Add(c.timeout))
+}
+
+// Write sends msg as one message.
+func (c *Conn) Write(msg []byte) error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	_, err := c.c.Write(msg)
+	return err
+}
+
+// Read returns the next message. It fails with an error reading
+// "EOF" once the peer has closed the connection.
+func (c *Conn) Read() ([]byte, error) {
+	if err := c.deadline(); err != nil {
+		return nil, err
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, secure.MaxFrameMessageSize)
+	}
+	n, err := c.c.Read(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), c.buf[:n]...), nil
+}
+
+// PeerPublicKey returns the public key the peer presented.
+func (c *Conn) PeerPublicKey() []byte {
+	return append([]byte(nil), c.c.ConnectionState().PeerPublicKey[:]...)
+}
+
+// PeerFingerprint returns the fingerprint of the peer's public key,
+// for comparing it with the one the user expects.
+func (c *Conn) PeerFingerprint() string {
+	return secure.Fingerprint(c.c.ConnectionState().PeerPublicKey)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
@@ -0,0 +1,84 @@
+package mobile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jboverfelt/secure"
+)
+
+func TestKeyPair(t *testing.T) {
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kp.PrivateKey()) != KeySize || len(kp.PublicKey()) != KeySize {
+		t.Fatal("Unexpected key sizes")
+	}
+
+	again, err := NewKeyPair(kp.PrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.PublicKey(), kp.PublicKey()) || again.Fingerprint() != kp.Fingerprint() {
+		t.Fatal("Unexpected result. The public key was not derived from the private key.")
+	}
+
+	data, err := kp.EncodePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := DecodeKeyPair(data); err != nil || !bytes.Equal(decoded.PublicKey(), kp.PublicKey()) {
+		t.Fatalf("Unexpected decoded key pair: %v", err)
+	}
+
+	if _, err := NewKeyPair(make([]byte, 31)); err != ErrKeySize {
+		t.Fatalf("Expected ErrKeySize, got %v", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	serverKeys, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &secure.Config{PrivateKey: serverKeys.priv, PublicKey: serverKeys.pub, PairingCode: []byte("7-crossover-clockwork")}
+	l, err := secure.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, secure.MaxMessageSize)
+		n, _ := c.Read(buf)
+		c.Write(buf[:n])
+	}()
+
+	opts := NewOptions()
+	opts.PairingCode = "7-crossover-clockwork"
+	opts.TimeoutMillis = 5000
+	c, err := Dial(l.Addr().String(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.PeerFingerprint() != serverKeys.Fingerprint() || !bytes.Equal(c.PeerPublicKey(), serverKeys.PublicKey()) {
+		t.Fatal("Unexpected peer key")
+	}
+	if err := c.Write([]byte("hello from the app")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := c.Read(); err != nil || string(msg) != "hello from the app" {
+		t.Fatalf("Unexpected result: %q, %v", msg, err)
+	}
+	if _, err := c.Read(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
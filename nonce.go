@@ -0,0 +1,81 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrNonceExhausted means that a stream sealed as many frames as its
+// nonces can number, so that the next one would reuse a nonce
+var ErrNonceExhausted = errors.New("nonces exhausted")
+
+// The frame numbers of a stream run from zero up to lastFrame, which
+// is kept for the close frame so that a stream can always be ended
+// properly. Numbers never wrap around.
+const lastFrame = math.MaxUint64 - 1
+
+// debugNonces makes every nonceSequence remember the nonces it handed
+// out and panic if one comes up twice. Building with -tags securedebug
+// turns it on. It costs memory for every frame sent, so it is meant
+// for tests and debugging only.
+var debugNonces = false
+
+// A nonceSequence hands out the nonces of the frames of one stream,
+// laid out as described above nonceDirOffset. Sealing code gets nonces
+// only from next, which numbers them in the order it is called, so a
+// frame number is never handed out twice and the random bytes keep
+// nonces apart across streams under the same key.
+type nonceSequence struct {
+	// rand provides the random bytes. If nil, crypto/rand.Reader
+	// is used.
+	rand io.Reader
+
+	// dir is the direction recorded in every nonce
+	dir byte
+
+	// seq is the number of the next frame
+	seq uint64
+
+	// seen holds the nonces handed out, with debugNonces
+	seen map[[NonceSize]byte]bool
+}
+
+// next returns the nonce of the next frame, failing with
+// ErrNonceExhausted once every frame number has been used
+func (n *nonceSequence) next() (*[NonceSize]byte, error) {
+	if n.seq > lastFrame {
+		return nil, ErrNonceExhausted
+	}
+	random := n.rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	nonce := new([NonceSize]byte)
+	if _, err := io.ReadFull(random, nonce[:nonceDirOffset]); err != nil {
+		return nil, errors.New("secure: cant generate random nonce: " + err.Error())
+	}
+	nonce[nonceDirOffset] = n.dir
+	binary.BigEndian.PutUint64(nonce[nonceSeqOffset:], n.seq)
+	n.seq++
+
+	if debugNonces {
+		if n.seen == nil {
+			n.seen = make(map[[NonceSize]byte]bool)
+		}
+		if n.seen[*nonce] {
+			panic("secure: nonce reused")
+		}
+		n.seen[*nonce] = true
+	}
+	return nonce, nil
+}
+
+// exhausted reports whether only the number kept
+// for the close frame is left
+func (n *nonceSequence) exhausted() bool {
+	return n.seq >= lastFrame
+}
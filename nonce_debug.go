@@ -0,0 +1,11 @@
+//go:build securedebug
+// +build securedebug
+
+// Building with -tags securedebug checks that no stream ever seals two
+// frames under the same nonce, panicking if one does.
+
+package secure
+
+func init() {
+	debugNonces = true
+}
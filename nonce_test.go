@@ -0,0 +1,136 @@
+package secure
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// zeroReader reads as endless zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestNonceSequence(t *testing.T) {
+	n := nonceSequence{dir: dirFromServer, seq: 7}
+	prev := new([NonceSize]byte)
+	for want := uint64(7); want < 10; want++ {
+		nonce, err := n.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq := binary.BigEndian.Uint64(nonce[nonceSeqOffset:]); seq != want {
+			t.Fatalf("Expected frame number %d, got %d", want, seq)
+		}
+		if nonce[nonceDirOffset] != dirFromServer {
+			t.Fatalf("Unexpected direction %d", nonce[nonceDirOffset])
+		}
+		if *nonce == *prev {
+			t.Fatal("Unexpected result. The nonce was handed out twice.")
+		}
+		prev = nonce
+	}
+}
+
+func TestNonceSequenceDebug(t *testing.T) {
+	defer func(debug bool) { debugNonces = debug }(debugNonces)
+	debugNonces = true
+
+	// with no randomness, only the frame number tells nonces apart
+	n := nonceSequence{rand: zeroReader{}}
+	for i := 0; i < 3; i++ {
+		if _, err := n.next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic on a reused nonce")
+		}
+	}()
+	n.seq = 1
+	n.next()
+}
+
+func TestNonceSequenceExhausted(t *testing.T) {
+	n := nonceSequence{seq: lastFrame - 1}
+	if _, err := n.next(); err != nil {
+		t.Fatal(err)
+	}
+	if !n.exhausted() {
+		t.Fatal("Expected the sequence to be down to the close frame's number")
+	}
+	nonce, err := n.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := binary.BigEndian.Uint64(nonce[nonceSeqOffset:]); seq != lastFrame {
+		t.Fatalf("Expected frame number %d, got %d", uint64(lastFrame), seq)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := n.next(); err != ErrNonceExhausted {
+			t.Fatalf("Expected ErrNonceExhausted, got %v", err)
+		}
+	}
+}
+
+func TestConnNonceExhausted(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		server := c.(*Conn)
+		if server.Handshake() != nil {
+			return
+		}
+		accepted <- server
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// start both ends of the stream two frames short of the last number
+	start := uint64(lastFrame - 2)
+	client.w.sent, client.w.nonces.seq, server.r.recv = start, start, start
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Write([]byte("hello")); err != ErrNonceExhausted {
+			t.Fatalf("Expected ErrNonceExhausted, got %v", err)
+		}
+	}
+
+	// the peer gets what was sent, and the stream ends properly
+	buf := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		if n, err := server.Read(buf); err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("Unexpected read: %q, %v", buf[:n], err)
+		}
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
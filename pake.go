@@ -0,0 +1,167 @@
+package secure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Size (in bytes) of a SPAKE2 group element on the wire
+const pakeElementSize = 32
+
+// SPAKE2 blinding points for edwards25519 from RFC 9382. Nobody knows
+// their discrete logarithms, which is what makes offline guessing
+// impossible.
+const (
+	pakeM = "d048032c6ea0b6d697ddc2e86bda85a33adac920f1bf18e1b0c6d166a5cecdaf"
+	pakeN = "d3bfb518f44f3430f29d0c92af503865a1ed3281dc69b35dd868ba85f886c4ab"
+)
+
+// ErrPairing means that the peer did not prove knowledge of the pairing code
+var ErrPairing = errors.New("pairing failed: code mismatch or tampering")
+
+// Pair establishes trust in the peer's public key using only a short,
+// shared pairing code. It runs a SPAKE2 password-authenticated key exchange
+// over rw and then exchanges public keys sealed under the resulting
+// high-entropy session key, so a man in the middle who does not know the
+// code cannot substitute its own key and gets a single online guess per
+// attempt. The group is edwards25519, whose operations take the same time
+// whatever the code.
+//
+// Exactly one side must set initiator; the initiator speaks first.
+// The returned key can be passed to NewReader and NewWriter as usual.
+func Pair(rw io.ReadWriter, code []byte, pub *[KeySize]byte, initiator bool) (*[KeySize]byte, error) {
+	m := mustDecodePoint(pakeM)
+	n := mustDecodePoint(pakeN)
+
+	// our blinding point and the peer's
+	o, p := m, n
+	if !initiator {
+		o, p = n, m
+	}
+
+	h := sha512.Sum512(append([]byte("secure pairing code"), code...))
+	w, err := edwards25519.NewScalar().SetUniformBytes(h[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var seed [64]byte
+	if _, err := io.ReadFull(rand.Reader, seed[:]); err != nil {
+		return nil, err
+	}
+	x, err := edwards25519.NewScalar().SetUniformBytes(seed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// T = x*G + w*O
+	t := new(edwards25519.Point).ScalarBaseMult(x)
+	t.Add(t, new(edwards25519.Point).ScalarMult(w, o))
+	ours := t.Bytes()
+
+	theirs := make([]byte, pakeElementSize)
+	if initiator {
+		if _, err := rw.Write(ours); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rw, theirs); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.ReadFull(rw, theirs); err != nil {
+			return nil, err
+		}
+		if _, err := rw.Write(ours); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := new(edwards25519.Point).SetBytes(theirs)
+	if err != nil {
+		return nil, ErrPairing
+	}
+
+	// K = h*x*(S - w*P), where the cofactor h clears any small-order
+	// component the peer mixed into S
+	k := new(edwards25519.Point).ScalarMult(w, p)
+	k.Subtract(s, k)
+	k.MultByCofactor(k)
+	k.ScalarMult(x, k)
+	if k.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, ErrPairing
+	}
+
+	// the transcript is always ordered initiator first
+	pA, pB := ours, theirs
+	if !initiator {
+		pA, pB = theirs, ours
+	}
+	transcript := sha256.New()
+	for _, b := range [][]byte{pA, pB, k.Bytes(), w.Bytes()} {
+		binary.Write(transcript, binary.LittleEndian, uint64(len(b)))
+		transcript.Write(b)
+	}
+
+	var key [KeySize]byte
+	kdf := hkdf.New(sha256.New, transcript.Sum(nil), nil, []byte("secure pairing"))
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return nil, err
+	}
+
+	// Exchanging the public keys under the session key doubles as key
+	// confirmation: a peer with the wrong code cannot open or forge them.
+	var ourNonce, peerNonce [NonceSize]byte
+	ourNonce[0], peerNonce[0] = 1, 2
+	if !initiator {
+		ourNonce, peerNonce = peerNonce, ourNonce
+	}
+	sealed := secretbox.Seal(nil, pub[:], &ourNonce, &key)
+	peerSealed := make([]byte, KeySize+secretbox.Overhead)
+
+	if initiator {
+		if _, err := rw.Write(sealed); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rw, peerSealed); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.ReadFull(rw, peerSealed); err != nil {
+			return nil, err
+		}
+	}
+
+	var peerPub [KeySize]byte
+	if _, ok := secretbox.Open(peerPub[:0], peerSealed, &peerNonce, &key); !ok {
+		return nil, ErrPairing
+	}
+
+	if !initiator {
+		if _, err := rw.Write(sealed); err != nil {
+			return nil, err
+		}
+	}
+
+	return &peerPub, nil
+}
+
+func mustDecodePoint(s string) *edwards25519.Point {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	p, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
@@ -0,0 +1,49 @@
+package secure
+
+import (
+	"net"
+	"testing"
+)
+
+// pair runs both sides of Pair and returns the initiator's
+// and responder's results in that order.
+func pair(codeA, codeB string) ([2]*[KeySize]byte, [2]error) {
+	a, b := net.Pipe()
+	pubA, pubB := &[KeySize]byte{'a'}, &[KeySize]byte{'b'}
+
+	type result struct {
+		peer *[KeySize]byte
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		peer, err := Pair(b, []byte(codeB), pubB, false)
+		b.Close()
+		done <- result{peer, err}
+	}()
+
+	peerA, errA := Pair(a, []byte(codeA), pubA, true)
+	a.Close()
+	res := <-done
+	return [2]*[KeySize]byte{peerA, res.peer}, [2]error{errA, res.err}
+}
+
+func TestPair(t *testing.T) {
+	peers, errs := pair("7-crossover-clockwork", "7-crossover-clockwork")
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if peers[0][0] != 'b' || peers[1][0] != 'a' {
+		t.Fatalf("Unexpected peer keys: %q %q", peers[0][:1], peers[1][:1])
+	}
+}
+
+func TestPairWrongCode(t *testing.T) {
+	_, errs := pair("7-crossover-clockwork", "7-crossover-clockworm")
+	if errs[0] == nil || errs[1] != ErrPairing {
+		t.Fatalf("Expected pairing to fail, got %v and %v", errs[0], errs[1])
+	}
+}
@@ -0,0 +1,148 @@
+package secure
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sync"
+)
+
+// A ParallelWriter is an io.Writer for bulk transfers which splits
+// the data into maximum size messages and seals them on several
+// goroutines at once. Frames are written to the wrapped io.Writer in
+// the order their data was written, so the output can be read by an
+// ordinary Reader.
+//
+// Writes return as soon as their data is queued; errors from sealing
+// or writing are reported by a later Write or by Close. Close must be
+// called to flush the remaining frames.
+type ParallelWriter struct {
+	sw    *Writer
+	jobs  chan sealJob
+	queue chan chan sealResult
+	done  chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+type sealJob struct {
+	p     []byte
+	nonce *[NonceSize]byte
+	out   chan sealResult
+}
+
+type sealResult struct {
+	frame net.Buffers
+	err   error
+}
+
+// NewParallelWriter instantiates a new ParallelWriter sealing on
+// the given number of goroutines, or one per CPU if workers <= 0.
+// priv and pub should be keys generated with box.GenerateKey
+func NewParallelWriter(w io.Writer, priv, pub *[KeySize]byte, workers int) *ParallelWriter {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pw := &ParallelWriter{
+		sw:    NewWriter(w, priv, pub),
+		jobs:  make(chan sealJob, workers),
+		queue: make(chan chan sealResult, 2*workers),
+		done:  make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go pw.seal()
+	}
+	go pw.emit()
+
+	return pw
+}
+
+func (pw *ParallelWriter) seal() {
+	for job := range pw.jobs {
+		job.out <- sealResult{frame: pw.sw.seal(FrameData, job.p, nil, job.nonce)}
+	}
+}
+
+// emit writes sealed frames in queue order, which is write order
+func (pw *ParallelWriter) emit() {
+	defer close(pw.done)
+
+	for out := range pw.queue {
+		res := <-out
+		if pw.error() != nil {
+			continue
+		}
+
+		if res.err == nil {
+			if _, err := res.frame.WriteTo(pw.sw.w); err != nil {
+				res.err = ErrEncWrite
+			}
+			pw.sw.sent++
+		}
+		if res.err != nil {
+			pw.mu.Lock()
+			pw.err = res.err
+			pw.mu.Unlock()
+		}
+	}
+}
+
+func (pw *ParallelWriter) error() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+// Write queues p to be sealed and written.
+// p is copied, so it may be reused as soon as Write returns.
+func (pw *ParallelWriter) Write(p []byte) (int, error) {
+	if err := pw.error(); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(p); i += pw.sw.max {
+		end := i + pw.sw.max
+		if end > len(p) {
+			end = len(p)
+		}
+
+		// nonces are taken in write order, which numbers the frames
+		nonce, err := pw.sw.nonces.next()
+		if err != nil {
+			return i, err
+		}
+		job := sealJob{
+			p:     append([]byte(nil), p[i:end]...),
+			nonce: nonce,
+			out:   make(chan sealResult, 1),
+		}
+		pw.queue <- job.out
+		pw.jobs <- job
+	}
+
+	return len(p), nil
+}
+
+// Close waits for all queued frames to be written, stops the sealing
+// goroutines and ends the stream with a close frame. It does not close
+// the wrapped io.Writer.
+func (pw *ParallelWriter) Close() error {
+	pw.mu.Lock()
+	closing := !pw.closed
+	if closing {
+		pw.closed = true
+		close(pw.jobs)
+		close(pw.queue)
+	}
+	pw.mu.Unlock()
+
+	<-pw.done
+	if err := pw.error(); err != nil || !closing {
+		return err
+	}
+	return pw.sw.Close()
+}
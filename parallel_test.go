@@ -0,0 +1,73 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParallelWriter(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	data := make([]byte, 10*MaxMessageSize+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var wire bytes.Buffer
+	pw := NewParallelWriter(&wire, priv, pub, 4)
+	// write in odd sized pieces to exercise chunking across writes
+	for i := 0; i < len(data); i += 50000 {
+		end := i + 50000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := pw.Write(data[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the close frame ends the copy cleanly
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, NewReader(&wire, priv, pub)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("Unexpected result. Data was reordered or corrupted.")
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	data := make([]byte, MaxMessageSize)
+	secureW := NewWriter(ioutil.Discard, priv, pub)
+
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := secureW.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelWriter(b *testing.B) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+	data := make([]byte, MaxMessageSize)
+	pw := NewParallelWriter(ioutil.Discard, priv, pub, 0)
+
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := pw.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
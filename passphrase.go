@@ -0,0 +1,120 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Files written by NewPassphraseWriter start with this magic and
+// version, followed by the argon2id parameters and salt the key was
+// derived with. The rest is laid out as in files from NewFileWriter,
+// sealed with secretbox under the derived key.
+const passphraseMagic = "SECP\x01"
+
+// Size (in bytes) of the salt of a passphrase file
+const passphraseSaltSize = 16
+
+// Size (in bytes) of the header of a passphrase file: the magic, the
+// time and memory parameters as big endian uint32s, the number of
+// threads and the salt
+const passphraseHeaderSize = len(passphraseMagic) + 4 + 4 + 1 + passphraseSaltSize
+
+// Bounds on the parameters accepted from a file, so that a crafted one
+// cannot make its reader spend unbounded memory or time
+const (
+	maxArgon2Time   = 64
+	maxArgon2Memory = 4 << 20 // KiB
+)
+
+// ErrPassphrase means that a passphrase was empty
+var ErrPassphrase = errors.New("empty passphrase")
+
+// Argon2Params are the argon2id parameters a key is derived from a
+// passphrase with.
+type Argon2Params struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Memory is the size of the memory in KiB.
+	Memory uint32
+
+	// Threads is the number of threads the derivation runs on.
+	Threads uint8
+}
+
+// DefaultArgon2Params are the parameters NewPassphraseWriter uses if
+// none are given, the second recommendation of RFC 9106: three passes
+// over 64 MiB.
+var DefaultArgon2Params = Argon2Params{Time: 3, Memory: 64 << 10, Threads: 4}
+
+func (p *Argon2Params) key(passphrase, salt []byte) *[KeySize]byte {
+	key := new([KeySize]byte)
+	copy(key[:], argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, KeySize))
+	return key
+}
+
+// NewPassphraseWriter returns a writer that encrypts a file with a key
+// derived from passphrase with argon2id, so that it can be read with
+// NewPassphraseReader without any key file. params are stored in the
+// file; if nil, DefaultArgon2Params are used. The file is only
+// complete once the writer is closed.
+func NewPassphraseWriter(w io.Writer, passphrase []byte, params *Argon2Params) (io.WriteCloser, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphrase
+	}
+	if params == nil {
+		params = &DefaultArgon2Params
+	}
+	if params.Time == 0 || params.Time > maxArgon2Time || params.Memory > maxArgon2Memory || params.Threads == 0 {
+		return nil, errors.New("secure: invalid argon2 parameters")
+	}
+
+	header := make([]byte, passphraseHeaderSize)
+	b := header[copy(header, passphraseMagic):]
+	binary.BigEndian.PutUint32(b, params.Time)
+	binary.BigEndian.PutUint32(b[4:], params.Memory)
+	b[8] = params.Threads
+	salt := b[9:]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &fileWriter{w: NewSecretWriter(w, params.key(passphrase, salt))}, nil
+}
+
+// NewPassphraseReader returns a reader that decrypts a file written by
+// NewPassphraseWriter with the same passphrase. A wrong passphrase
+// fails the first read with ErrDecrypt, and a file that was cut short
+// fails with io.ErrUnexpectedEOF or ErrTruncated. If r is an
+// io.Seeker, so is the returned reader.
+func NewPassphraseReader(r io.Reader, passphrase []byte) (io.ReadSeeker, error) {
+	var header [passphraseHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrFileFormat
+		}
+		return nil, err
+	}
+	if string(header[:len(passphraseMagic)]) != passphraseMagic {
+		return nil, ErrFileFormat
+	}
+
+	b := header[len(passphraseMagic):]
+	params := Argon2Params{
+		Time:    binary.BigEndian.Uint32(b),
+		Memory:  binary.BigEndian.Uint32(b[4:]),
+		Threads: b[8],
+	}
+	if params.Time == 0 || params.Time > maxArgon2Time || params.Memory > maxArgon2Memory || params.Threads == 0 {
+		return nil, ErrFileFormat
+	}
+	key := params.key(passphrase, b[9:])
+	return &fileReader{src: r, r: NewSecretReader(r, key), header: int64(passphraseHeaderSize), size: -1}, nil
+}
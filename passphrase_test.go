@@ -0,0 +1,77 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPassphraseFile(t *testing.T) {
+	// cheap parameters keep the test fast
+	params := &Argon2Params{Time: 1, Memory: 64, Threads: 1}
+	passphrase := []byte("correct horse battery staple")
+
+	data := make([]byte, 2*fileChunkSize+100)
+	rand.Read(data)
+	var buf bytes.Buffer
+	w, err := NewPassphraseWriter(&buf, passphrase, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+
+	r, err := NewPassphraseReader(bytes.NewReader(enc), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Unexpected result. The data did not survive the round trip.")
+	}
+
+	// seeking works as with key files
+	if _, err := r.Seek(fileChunkSize+10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 20)
+	if _, err := io.ReadFull(r, part); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, data[fileChunkSize+10:fileChunkSize+30]) {
+		t.Fatal("Unexpected result after seeking")
+	}
+
+	r, err = NewPassphraseReader(bytes.NewReader(enc), []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt for a wrong passphrase, got %v", err)
+	}
+
+	if _, err := NewPassphraseWriter(&buf, nil, params); err != ErrPassphrase {
+		t.Fatalf("Expected ErrPassphrase, got %v", err)
+	}
+
+	// the reader does not take any parameters a file asks for
+	crafted := append([]byte(nil), enc...)
+	binary.BigEndian.PutUint32(crafted[len(passphraseMagic)+4:], 1<<31)
+	if _, err := NewPassphraseReader(bytes.NewReader(crafted), passphrase); err != ErrFileFormat {
+		t.Fatalf("Expected ErrFileFormat for excessive memory, got %v", err)
+	}
+	if _, err := NewPassphraseReader(bytes.NewReader(enc[:10]), passphrase); err != ErrFileFormat {
+		t.Fatalf("Expected ErrFileFormat for a short file, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPoolSize is the number of idle connections
+// a Pool keeps if its Size is zero
+const DefaultPoolSize = 2
+
+// ErrPoolClosed means that a connection was asked of a closed Pool
+var ErrPoolClosed = errors.New("pool closed")
+
+// A Pool keeps handshaked connections to one server ready for
+// request/response workloads, so that each request need not pay for
+// a fresh TCP connection and key exchange. Get takes a connection from
+// the Pool, or dials one if none is idle, and Put hands it back once
+// the response has been read. A Pool must not be copied after use.
+type Pool struct {
+	// Network and Addr are passed to Dial along with Config,
+	// which may be nil.
+	Network, Addr string
+	Config        *Config
+
+	// Size is the number of idle connections the Pool keeps warm:
+	// Warm dials that many in advance, Get dials a replacement in the
+	// background for every connection it hands out, and Put closes
+	// connections beyond it. If zero, DefaultPoolSize is used.
+	Size int
+
+	// MaxIdleTime, if not zero, is how long a connection may stay
+	// idle before Get closes it instead of handing it out.
+	MaxIdleTime time.Duration
+
+	// HealthCheck, if not nil, is called on an idle connection before
+	// Get hands it out, and the connection is closed if it fails. Get
+	// always checks that the server has not closed the connection.
+	HealthCheck func(c *Conn) error
+
+	mu      sync.Mutex
+	idle    []idleConn
+	warming bool
+	closed  bool
+}
+
+type idleConn struct {
+	c     *Conn
+	since time.Time
+}
+
+func (p *Pool) size() int {
+	if p.Size > 0 {
+		return p.Size
+	}
+	return DefaultPoolSize
+}
+
+func (p *Pool) dial() (*Conn, error) {
+	// the server's hello is read right away,
+	// so that idle connections have nothing left to read
+	return (&Dialer{Config: p.Config}).dial(context.Background(), p.Network, p.Addr, true)
+}
+
+// Get returns an idle connection that passes the health checks,
+// or a new one if there is none.
+func (p *Pool) Get() (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// the most recently used connection is the least likely
+		// to have been closed by the server
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.healthy(ic) {
+			go p.Warm()
+			return ic.c, nil
+		}
+		ic.c.Close()
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	go p.Warm()
+	return c, nil
+}
+
+// healthy reports whether an idle connection can be handed out
+func (p *Pool) healthy(ic idleConn) bool {
+	if p.MaxIdleTime > 0 && time.Since(ic.since) > p.MaxIdleTime {
+		return false
+	}
+	if !quiet(ic.c) {
+		return false
+	}
+	return p.HealthCheck == nil || p.HealthCheck(ic.c) == nil
+}
+
+// quiet reports whether nothing has arrived on an idle connection.
+// Anything that did is a close frame or the end of the transport,
+// since no request is outstanding.
+func quiet(c *Conn) bool {
+	if c.failed() != nil {
+		return false
+	}
+	if err := c.conn.SetReadDeadline(time.Now()); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := c.conn.Read(b[:])
+	c.conn.SetReadDeadline(time.Time{})
+
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Put returns a connection taken with Get to the Pool once it is
+// idle, that is once every response to a request sent on it has been
+// read. Connections that failed, and those that would exceed the
+// Pool's Size, are closed instead.
+func (p *Pool) Put(c *Conn) {
+	if c.failed() != nil || !p.add(c) {
+		c.Close()
+	}
+}
+
+// add adds c to the idle connections if there is room
+func (p *Pool) add(c *Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.size() {
+		return false
+	}
+	p.idle = append(p.idle, idleConn{c, time.Now()})
+	return true
+}
+
+// Warm dials connections until Size of them are idle, and returns the
+// first error. Only one call dials at a time; others return at once.
+func (p *Pool) Warm() error {
+	p.mu.Lock()
+	if p.warming || p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.warming = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.warming = false
+		p.mu.Unlock()
+	}()
+
+	for {
+		p.mu.Lock()
+		full := p.closed || len(p.idle) >= p.size()
+		p.mu.Unlock()
+		if full {
+			return nil
+		}
+
+		c, err := p.dial()
+		if err != nil {
+			return err
+		}
+		if !p.add(c) {
+			c.Close()
+		}
+	}
+}
+
+// Close closes the idle connections. Connections handed out by Get
+// are closed when they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+
+	for _, ic := range idle {
+		ic.c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package secure
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server echoes requests, and hangs up after a "bye"
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		buf := make([]byte, 64)
+		for {
+			n, err := c.Read(buf)
+			if err != nil || string(buf[:n]) == "bye" {
+				return
+			}
+			c.Write(buf[:n])
+		}
+	})}).Serve(l)
+
+	p := &Pool{Network: "tcp", Addr: l.Addr().String()}
+	defer p.Close()
+	if err := p.Warm(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(c *Conn, msg string) {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("Unexpected result: %q != %q", buf[:n], msg)
+		}
+	}
+
+	c, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request(c, "hello")
+	p.Put(c)
+	if again, err := p.Get(); err != nil || again != c {
+		t.Fatalf("Expected the idle connection back, got %p, %v", again, err)
+	}
+
+	// a connection the server closed is not handed out again
+	if _, err := c.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	p.Put(c)
+	fresh, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == c {
+		t.Fatal("Got back a connection the server closed")
+	}
+	request(fresh, "still there")
+
+	// nor is one that was idle for too long
+	p.MaxIdleTime = time.Nanosecond
+	p.Put(fresh)
+	if stale, err := p.Get(); err != nil || stale == fresh {
+		t.Fatalf("Got back a stale connection: %v", err)
+	}
+
+	p.Close()
+	if _, err := p.Get(); err != ErrPoolClosed {
+		t.Fatalf("Expected ErrPoolClosed, got %v", err)
+	}
+}
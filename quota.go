@@ -0,0 +1,307 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrQuota means that a peer has used up its connection or byte quota
+var ErrQuota = errors.New("quota exceeded")
+
+// Usage is what a peer has used of its quota.
+type Usage struct {
+	// Conns is the number of connections the peer has open.
+	Conns int64
+
+	// Bytes is the number of message bytes the peer has sent
+	// and received.
+	Bytes int64
+}
+
+// A QuotaStore keeps the usage counters of peers. Implementations
+// backed by a shared database let several servers enforce one quota
+// together, and decide when counters are reset, such as monthly.
+type QuotaStore interface {
+	// Add adds conns and bytes, either of which may be negative or
+	// zero, to the usage of the peer named key and returns the
+	// result.
+	Add(key string, conns, bytes int64) (Usage, error)
+}
+
+// MemoryQuotaStore is a QuotaStore kept in memory. Its counters
+// last until they are reset. The zero value is ready to use.
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]Usage
+}
+
+// Add implements QuotaStore.
+func (s *MemoryQuotaStore) Add(key string, conns, bytes int64) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage == nil {
+		s.usage = make(map[string]Usage)
+	}
+	u := s.usage[key]
+	u.Conns += conns
+	u.Bytes += bytes
+	if u == (Usage{}) {
+		delete(s.usage, key)
+	} else {
+		s.usage[key] = u
+	}
+	return u, nil
+}
+
+// Usage returns the usage of every peer that has any, by key, for
+// exporting to a monitoring system.
+func (s *MemoryQuotaStore) Usage() map[string]Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := make(map[string]Usage, len(s.usage))
+	for key, u := range s.usage {
+		usage[key] = u
+	}
+	return usage
+}
+
+// ResetBytes sets the byte counts of every peer back to zero,
+// to start a new accounting period.
+func (s *MemoryQuotaStore) ResetBytes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, u := range s.usage {
+		if u.Conns == 0 {
+			delete(s.usage, key)
+		} else {
+			s.usage[key] = Usage{Conns: u.Conns}
+		}
+	}
+}
+
+// A Quota bounds what each peer of a Server or Relay may use. Server
+// peers are named by the fingerprint of their public key, and Relay
+// peers by their rendezvous channel.
+type Quota struct {
+	// MaxConns, if not zero, is how many connections a peer may
+	// have open at once. Further ones are closed unserved.
+	MaxConns int64
+
+	// MaxBytes, if not zero, is how many message bytes a peer may
+	// send and receive, as counted by Store.
+	MaxBytes int64
+
+	// Throttle, if not zero, lets peers over MaxBytes go on at that
+	// many bytes per second, with bursts of up to a second's worth
+	// after a pause. Otherwise their reads and writes fail with
+	// ErrQuota, and new connections are closed unserved. A throttled
+	// read or write waits no longer than the deadline of the
+	// connection, the context of WriteContext or Close.
+	Throttle int64
+
+	// Store keeps the counters. If nil, they are kept in memory,
+	// in the MemoryQuotaStore Quota.Memory returns.
+	Store QuotaStore
+
+	once   sync.Once
+	memory *MemoryQuotaStore
+
+	// buckets holds the bytes per second that throttled peers
+	// have left, by key
+	bucketsMu sync.Mutex
+	buckets   map[string]*bucket
+}
+
+// A bucket is a token bucket of bytes, filled at the Throttle rate.
+// It starts empty, as its peer has just used up its quota.
+type bucket struct {
+	tokens float64
+	filled time.Time
+}
+
+func (q *Quota) store() QuotaStore {
+	if q.Store != nil {
+		return q.Store
+	}
+	return q.Memory()
+}
+
+// Memory returns the store used when Store is nil.
+func (q *Quota) Memory() *MemoryQuotaStore {
+	q.once.Do(func() { q.memory = new(MemoryQuotaStore) })
+	return q.memory
+}
+
+// Usage returns the usage of the peer named key.
+func (q *Quota) Usage(key string) (Usage, error) {
+	return q.store().Add(key, 0, 0)
+}
+
+// overBytes reports whether u has used up the byte quota
+// of a peer that is not throttled
+func (q *Quota) overBytes(u Usage) bool {
+	return q.MaxBytes > 0 && u.Bytes >= q.MaxBytes && q.Throttle <= 0
+}
+
+// admit counts a new connection of the peer named key,
+// failing with ErrQuota if the peer is over quota
+func (q *Quota) admit(key string) error {
+	u, err := q.store().Add(key, 1, 0)
+	if err != nil {
+		return err
+	}
+	if (q.MaxConns > 0 && u.Conns > q.MaxConns) || q.overBytes(u) {
+		q.release(key)
+		return ErrQuota
+	}
+	return nil
+}
+
+// release uncounts a connection admitted for the peer named key
+func (q *Quota) release(key string) {
+	u, err := q.store().Add(key, -1, 0)
+	if err == nil && u.Conns <= 0 {
+		q.bucketsMu.Lock()
+		delete(q.buckets, key)
+		q.bucketsMu.Unlock()
+	}
+}
+
+// allowance returns how many bytes, up to max, the peer named key may
+// still send before it is over its byte quota, failing with ErrQuota
+// if it already is. Throttled peers may always send max.
+func (q *Quota) allowance(key string, max int64) (int64, error) {
+	if q.MaxBytes <= 0 || q.Throttle > 0 {
+		return max, nil
+	}
+	u, err := q.Usage(key)
+	if err != nil {
+		return 0, err
+	}
+	if q.overBytes(u) {
+		return 0, ErrQuota
+	}
+	if left := q.MaxBytes - u.Bytes; left < max {
+		return left, nil
+	}
+	return max, nil
+}
+
+// check fails with ErrQuota if the peer named key
+// is over its byte quota and not throttled
+func (q *Quota) check(key string) error {
+	if q.MaxBytes <= 0 || q.Throttle > 0 {
+		return nil
+	}
+	u, err := q.Usage(key)
+	if err != nil {
+		return err
+	}
+	if q.overBytes(u) {
+		return ErrQuota
+	}
+	return nil
+}
+
+// charge counts n bytes for the peer named key. Once the peer is over
+// MaxBytes, it waits until the peer's bucket holds n bytes, unless
+// deadline passes, ctx is done or done is closed first, failing with
+// os.ErrDeadlineExceeded, the error of ctx or net.ErrClosed.
+func (q *Quota) charge(ctx context.Context, key string, n int, deadline time.Time, done <-chan struct{}) error {
+	if n == 0 {
+		return nil
+	}
+	u, err := q.store().Add(key, 0, int64(n))
+	if err != nil {
+		return err
+	}
+	if q.MaxBytes <= 0 || u.Bytes <= q.MaxBytes || q.Throttle <= 0 {
+		return nil
+	}
+
+	wait := q.take(key, n)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.NewTimer(time.Until(deadline))
+		defer d.Stop()
+		expired = d.C
+	}
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return net.ErrClosed
+	}
+}
+
+// take takes n bytes from the bucket of the peer named key and
+// returns how long it takes to refill what the bucket lacked
+func (q *Quota) take(key string, n int) time.Duration {
+	q.bucketsMu.Lock()
+	defer q.bucketsMu.Unlock()
+
+	now := time.Now()
+	b := q.buckets[key]
+	if b == nil {
+		if q.buckets == nil {
+			q.buckets = make(map[string]*bucket)
+		}
+		b = &bucket{filled: now}
+		q.buckets[key] = b
+	}
+
+	rate := float64(q.Throttle)
+	b.tokens += now.Sub(b.filled).Seconds() * rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.filled = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// checkQuota fails with ErrQuota if the peer of c
+// has used up its byte quota
+func (c *Conn) checkQuota() error {
+	if c.quota == nil {
+		return nil
+	}
+	return c.quota.check(c.quotaKey)
+}
+
+// chargeQuota charges the peer of c for n bytes read, or written if
+// write is set, and returns err, or the error charging them. Waiting
+// for a throttled quota is bounded by ctx and the deadline in effect.
+func (c *Conn) chargeQuota(ctx context.Context, write bool, n int, err error) error {
+	if c.quota == nil {
+		return err
+	}
+	c.stateMu.Lock()
+	deadline := c.readDeadline
+	if write {
+		deadline = c.writeDeadline
+	}
+	c.stateMu.Unlock()
+	if qerr := c.quota.charge(ctx, c.quotaKey, n, deadline, c.closed); err == nil {
+		err = qerr
+	}
+	return err
+}
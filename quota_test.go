@@ -0,0 +1,158 @@
+package secure
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryQuotaStore(t *testing.T) {
+	var s MemoryQuotaStore
+	s.Add("a", 1, 100)
+	s.Add("a", 0, 50)
+	s.Add("b", 1, 0)
+	if u, _ := s.Add("b", -1, 0); u != (Usage{}) {
+		t.Fatalf("Unexpected usage %+v", u)
+	}
+	if usage := s.Usage(); len(usage) != 1 || usage["a"] != (Usage{1, 150}) {
+		t.Fatalf("Unexpected usage %v", usage)
+	}
+	s.ResetBytes()
+	if usage := s.Usage(); usage["a"] != (Usage{Conns: 1}) {
+		t.Fatalf("Unexpected usage after reset %v", usage)
+	}
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	q := &Quota{MaxBytes: 10, Throttle: 1000}
+	start := time.Now()
+	q.charge(context.Background(), "a", 10, time.Time{}, nil)
+	if time.Since(start) > 5*time.Millisecond {
+		t.Fatal("Throttled within the quota")
+	}
+	start = time.Now()
+	if err := q.check("a"); err != nil {
+		t.Fatal(err)
+	}
+	q.charge(context.Background(), "a", 20, time.Time{}, nil)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("Expected 20 bytes to take 20ms over quota, took %v", d)
+	}
+}
+
+func TestQuotaThrottleWait(t *testing.T) {
+	q := &Quota{MaxBytes: 10, Throttle: 1}
+	q.charge(context.Background(), "a", 10, time.Time{}, nil)
+
+	// a second per byte over quota, unless the wait is cut short
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	for _, tt := range []struct {
+		ctx      context.Context
+		deadline time.Time
+		stop     func()
+		want     error
+	}{
+		{context.Background(), time.Now().Add(10 * time.Millisecond), func() {}, os.ErrDeadlineExceeded},
+		{ctx, time.Time{}, cancel, context.Canceled},
+		{context.Background(), time.Time{}, func() { close(done) }, net.ErrClosed},
+	} {
+		time.AfterFunc(10*time.Millisecond, tt.stop)
+		start := time.Now()
+		if err := q.charge(tt.ctx, "a", 1000, tt.deadline, done); err != tt.want {
+			t.Fatalf("Expected %v, got %v", tt.want, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("Waited %v for the throttle", d)
+		}
+	}
+}
+
+func TestServerQuota(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	quota := &Quota{MaxConns: 1, MaxBytes: 10}
+	served := make(chan error, 1)
+	srv := &Server{Quota: quota, Handler: HandlerFunc(func(c *Conn) {
+		buf := make([]byte, MaxMessageSize)
+		var err error
+		for err == nil {
+			_, err = c.Read(buf)
+		}
+		served <- err
+	})}
+	go srv.Serve(l)
+
+	pub, priv := mustGenerateKey(t, nil)
+	config := &Config{PublicKey: pub, PrivateKey: priv}
+	first, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// a second connection of the same client is closed unserved
+	second, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the connection over quota to be closed")
+	}
+	if n := srv.Stats().OverQuota; n != 1 {
+		t.Fatalf("Expected 1 connection over quota, got %d", n)
+	}
+
+	// reading past the byte quota fails
+	first.Write([]byte("0123456789"))
+	first.Write([]byte("over"))
+	if err := <-served; err != ErrQuota {
+		t.Fatalf("Expected ErrQuota, got %v", err)
+	}
+	if u, _ := quota.Usage(Fingerprint(pub)); u.Bytes != 10 {
+		t.Fatalf("Unexpected usage %+v", u)
+	}
+}
+
+func TestRelayQuota(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	quota := &Quota{MaxBytes: 10}
+	go (&Relay{Quota: quota}).Serve(l)
+
+	first := make(chan net.Conn)
+	go func() {
+		conn, _ := rendezvous(t, l.Addr().String(), "metered")
+		first <- conn
+	}()
+	b, _ := rendezvous(t, l.Addr().String(), "metered")
+	defer b.Close()
+	a := <-first
+	defer a.Close()
+
+	a.Write([]byte("0123456789"))
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the channel is over quota, so the relay hangs up
+	a.Write([]byte("over"))
+	if _, err := b.Read(buf); err == nil {
+		t.Fatal("Expected the relay to disconnect the channel")
+	}
+	if u, _ := quota.Usage("metered"); u.Bytes != 10 {
+		t.Fatalf("Unexpected usage %+v", u)
+	}
+}
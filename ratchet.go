@@ -0,0 +1,362 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// MaxRatchetSkip is the largest number of message keys a Ratchet keeps
+// for messages that have not arrived yet, bounding the work and memory
+// a peer can cause by claiming to have sent many messages.
+const MaxRatchetSkip = 1000
+
+// Size (in bytes) of a ratchet message header: the sender's ratchet
+// public key, the length of its previous sending chain and the number
+// of the message in the current one, both big endian uint32
+const ratchetHeaderSize = KeySize + 4 + 4
+
+// ErrRatchetSkip means that a ratchet message claimed more skipped
+// messages than MaxRatchetSkip
+var ErrRatchetSkip = errors.New("too many skipped ratchet messages")
+
+// ErrRatchetState means that a Ratchet cannot seal a message yet, as is
+// the case for a responder before the first message arrives, or that
+// its stored state is malformed
+var ErrRatchetState = errors.New("ratchet state does not allow the operation")
+
+// A Ratchet is one side of a Double Ratchet session, as used by Signal,
+// for protecting a sequence of messages that are queued or stored
+// rather than sent over a Conn. Every message is sealed with a fresh
+// key that is deleted once used, and the keys are renewed with a new
+// Diffie-Hellman exchange whenever the direction of the conversation
+// changes, so that the compromise of the current state exposes neither
+// earlier messages nor, once the peer has replied, later ones.
+//
+// The session is rooted in the X25519 keys of the two sides: the
+// initiator must know the responder's public key, and the responder's
+// key pair serves as its first ratchet key. Messages may arrive out of
+// order. A Ratchet is not safe for concurrent use, and its state must
+// be persisted with MarshalBinary after every Seal and Open.
+type Ratchet struct {
+	dhPriv, dhPub [KeySize]byte // our current ratchet key pair
+	peerPub       [KeySize]byte // the peer's current ratchet public key
+	hasPeer       bool
+
+	rootKey         [32]byte
+	sendKey, recKey [32]byte
+	hasSend, hasRec bool
+
+	sent, received, prevSent uint32
+
+	// keys for messages skipped in earlier chains
+	skipped map[skippedKey][32]byte
+}
+
+type skippedKey struct {
+	pub [KeySize]byte
+	n   uint32
+}
+
+// NewRatchetInitiator starts a session with the holder of peerPub,
+// using the initiator's own key pair to root it. The initiator must
+// send the first message.
+func NewRatchetInitiator(priv, peerPub *[KeySize]byte) (*Ratchet, error) {
+	r := &Ratchet{peerPub: *peerPub, hasPeer: true}
+	box.Precompute(&r.rootKey, peerPub, priv)
+
+	pub, ratchetPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	r.dhPriv, r.dhPub = *ratchetPriv, *pub
+	r.rootKey, r.sendKey = r.kdfRoot(&r.peerPub)
+	r.hasSend = true
+	return r, nil
+}
+
+// NewRatchetResponder accepts a session from the holder of peerPub.
+// priv and pub are the key pair the initiator knows the public half of.
+func NewRatchetResponder(priv, pub, peerPub *[KeySize]byte) *Ratchet {
+	r := &Ratchet{dhPriv: *priv, dhPub: *pub}
+	box.Precompute(&r.rootKey, peerPub, priv)
+	return r
+}
+
+// kdfRoot mixes a Diffie-Hellman output between our current ratchet key
+// and peerPub into the root key, returning the new root and chain keys
+func (r *Ratchet) kdfRoot(peerPub *[KeySize]byte) (root, chain [32]byte) {
+	var dh [KeySize]byte
+	curve25519.ScalarMult(&dh, &r.dhPriv, peerPub)
+
+	kdf := hkdf.New(sha256.New, dh[:], r.rootKey[:], []byte("secure ratchet root"))
+	io.ReadFull(kdf, root[:])
+	io.ReadFull(kdf, chain[:])
+	return root, chain
+}
+
+// kdfChain advances a chain key, returning the
+// next chain key and the message key
+func kdfChain(chain *[32]byte) (next, message [32]byte) {
+	mac := hmac.New(sha256.New, chain[:])
+	mac.Write([]byte{1})
+	copy(message[:], mac.Sum(nil))
+
+	mac = hmac.New(sha256.New, chain[:])
+	mac.Write([]byte{2})
+	copy(next[:], mac.Sum(nil))
+	return next, message
+}
+
+// messageAEAD returns the AEAD and nonce for a message key, which is
+// only ever used once
+func messageAEAD(key *[32]byte) (cipher.AEAD, []byte, error) {
+	var material [32 + 12]byte
+	kdf := hkdf.New(sha256.New, key[:], nil, []byte("secure ratchet message"))
+	if _, err := io.ReadFull(kdf, material[:]); err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(material[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, material[32:], err
+}
+
+// Seal encrypts msg as the next message of the session. The result
+// carries a header in the clear, which is authenticated with msg.
+func (r *Ratchet) Seal(msg []byte) ([]byte, error) {
+	if !r.hasSend {
+		// the responder only sends once the initiator has spoken
+		return nil, ErrRatchetState
+	}
+
+	var key [32]byte
+	r.sendKey, key = kdfChain(&r.sendKey)
+
+	header := make([]byte, ratchetHeaderSize)
+	copy(header, r.dhPub[:])
+	binary.BigEndian.PutUint32(header[KeySize:], r.prevSent)
+	binary.BigEndian.PutUint32(header[KeySize+4:], r.sent)
+	r.sent++
+
+	aead, nonce, err := messageAEAD(&key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, msg, header), nil
+}
+
+// Open decrypts a message sealed by the peer's Ratchet. Messages may
+// be opened in any order. The state only changes if Open succeeds.
+func (r *Ratchet) Open(data []byte) ([]byte, error) {
+	if len(data) < ratchetHeaderSize {
+		return nil, ErrDecrypt
+	}
+	header, enc := data[:ratchetHeaderSize], data[ratchetHeaderSize:]
+
+	var pub [KeySize]byte
+	copy(pub[:], header)
+	prev := binary.BigEndian.Uint32(header[KeySize:])
+	n := binary.BigEndian.Uint32(header[KeySize+4:])
+
+	if key, ok := r.skipped[skippedKey{pub, n}]; ok {
+		msg, err := openMessage(&key, header, enc)
+		if err == nil {
+			delete(r.skipped, skippedKey{pub, n})
+		}
+		return msg, err
+	}
+
+	// work on a copy, so a forged message cannot disturb the session
+	next := r.clone()
+	if !next.hasPeer || pub != next.peerPub {
+		if err := next.skip(prev); err != nil {
+			return nil, err
+		}
+		if err := next.step(&pub); err != nil {
+			return nil, err
+		}
+	}
+	if err := next.skip(n); err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	next.recKey, key = kdfChain(&next.recKey)
+	next.received++
+
+	msg, err := openMessage(&key, header, enc)
+	if err != nil {
+		return nil, err
+	}
+	*r = *next
+	return msg, nil
+}
+
+func openMessage(key *[32]byte, header, enc []byte) ([]byte, error) {
+	aead, nonce, err := messageAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := aead.Open(nil, nonce, enc, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return msg, nil
+}
+
+// skip stores the keys of the messages of the current receiving
+// chain up to, but not including, message until
+func (r *Ratchet) skip(until uint32) error {
+	if !r.hasRec {
+		return nil
+	}
+	if until < r.received {
+		// an old message whose key is gone
+		return ErrDecrypt
+	}
+	if until-r.received > MaxRatchetSkip || len(r.skipped)+int(until-r.received) > MaxRatchetSkip {
+		return ErrRatchetSkip
+	}
+
+	for r.received < until {
+		var key [32]byte
+		r.recKey, key = kdfChain(&r.recKey)
+		if r.skipped == nil {
+			r.skipped = make(map[skippedKey][32]byte)
+		}
+		r.skipped[skippedKey{r.peerPub, r.received}] = key
+		r.received++
+	}
+	return nil
+}
+
+// step performs a Diffie-Hellman ratchet step on
+// receiving a new ratchet public key from the peer
+func (r *Ratchet) step(peerPub *[KeySize]byte) error {
+	r.prevSent, r.sent, r.received = r.sent, 0, 0
+	r.peerPub, r.hasPeer = *peerPub, true
+
+	r.rootKey, r.recKey = r.kdfRoot(peerPub)
+	r.hasRec = true
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	r.dhPriv, r.dhPub = *priv, *pub
+	r.rootKey, r.sendKey = r.kdfRoot(peerPub)
+	r.hasSend = true
+	return nil
+}
+
+func (r *Ratchet) clone() *Ratchet {
+	c := *r
+	c.skipped = make(map[skippedKey][32]byte, len(r.skipped))
+	for k, v := range r.skipped {
+		c.skipped[k] = v
+	}
+	return &c
+}
+
+// Version of the encoding of a Ratchet's state
+const ratchetStateVersion = 1
+
+// Ratchet state flags
+const (
+	ratchetHasPeer = 1 << iota
+	ratchetHasSend
+	ratchetHasRec
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding
+// holds the session's secret keys and must be stored as carefully as
+// a private key.
+func (r *Ratchet) MarshalBinary() ([]byte, error) {
+	var flags byte
+	if r.hasPeer {
+		flags |= ratchetHasPeer
+	}
+	if r.hasSend {
+		flags |= ratchetHasSend
+	}
+	if r.hasRec {
+		flags |= ratchetHasRec
+	}
+
+	b := []byte{ratchetStateVersion, flags}
+	for _, key := range [][]byte{r.dhPriv[:], r.dhPub[:], r.peerPub[:], r.rootKey[:], r.sendKey[:], r.recKey[:]} {
+		b = append(b, key...)
+	}
+
+	var counters [4 * 4]byte
+	binary.BigEndian.PutUint32(counters[0:], r.sent)
+	binary.BigEndian.PutUint32(counters[4:], r.received)
+	binary.BigEndian.PutUint32(counters[8:], r.prevSent)
+	binary.BigEndian.PutUint32(counters[12:], uint32(len(r.skipped)))
+	b = append(b, counters[:]...)
+
+	for k, key := range r.skipped {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], k.n)
+		b = append(b, k.pub[:]...)
+		b = append(b, n[:]...)
+		b = append(b, key[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (r *Ratchet) UnmarshalBinary(data []byte) error {
+	const fixed = 2 + 6*32 + 4*4
+	const entry = KeySize + 4 + 32
+	if len(data) < fixed || data[0] != ratchetStateVersion {
+		return ErrRatchetState
+	}
+
+	*r = Ratchet{}
+	flags := data[1]
+	r.hasPeer = flags&ratchetHasPeer != 0
+	r.hasSend = flags&ratchetHasSend != 0
+	r.hasRec = flags&ratchetHasRec != 0
+
+	rest := data[2:]
+	for _, key := range []*[32]byte{&r.dhPriv, &r.dhPub, &r.peerPub, &r.rootKey, &r.sendKey, &r.recKey} {
+		copy(key[:], rest)
+		rest = rest[32:]
+	}
+
+	r.sent = binary.BigEndian.Uint32(rest[0:])
+	r.received = binary.BigEndian.Uint32(rest[4:])
+	r.prevSent = binary.BigEndian.Uint32(rest[8:])
+	count := int(binary.BigEndian.Uint32(rest[12:]))
+	rest = rest[16:]
+
+	if count > MaxRatchetSkip || len(rest) != count*entry {
+		return ErrRatchetState
+	}
+	if count > 0 {
+		r.skipped = make(map[skippedKey][32]byte, count)
+	}
+	for ; len(rest) > 0; rest = rest[entry:] {
+		var k skippedKey
+		var key [32]byte
+		copy(k.pub[:], rest)
+		k.n = binary.BigEndian.Uint32(rest[KeySize:])
+		copy(key[:], rest[KeySize+4:])
+		r.skipped[k] = key
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func newRatchetPair(t *testing.T) (alice, bob *Ratchet) {
+	alicePub, alicePriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPub, bobPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err = NewRatchetInitiator(alicePriv, bobPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, NewRatchetResponder(bobPriv, bobPub, alicePub)
+}
+
+func TestRatchet(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+
+	if _, err := bob.Seal([]byte("too early")); err != ErrRatchetState {
+		t.Fatalf("Expected ErrRatchetState, got %v", err)
+	}
+
+	// several turns of conversation, each with a few messages
+	from, to := alice, bob
+	for turn := 0; turn < 4; turn++ {
+		for i := 0; i < 3; i++ {
+			msg := []byte(fmt.Sprintf("turn %d message %d", turn, i))
+			sealed, err := from.Seal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := to.Open(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("Unexpected result: %q != %q", got, msg)
+			}
+			if _, err := to.Open(sealed); err == nil {
+				t.Fatal("A message was opened twice")
+			}
+		}
+		from, to = to, from
+	}
+}
+
+func TestRatchetOutOfOrder(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+
+	var first [][]byte
+	for i := 0; i < 3; i++ {
+		sealed, _ := alice.Seal([]byte{byte(i)})
+		first = append(first, sealed)
+	}
+
+	// bob answers after only the last message, then alice writes
+	// again, so the delayed ones belong to an earlier chain
+	if _, err := bob.Open(first[2]); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := bob.Seal([]byte("reply"))
+	if _, err := alice.Open(reply); err != nil {
+		t.Fatal(err)
+	}
+	later, _ := alice.Seal([]byte("later"))
+	if _, err := bob.Open(later); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{1, 0} {
+		got, err := bob.Open(first[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte{byte(i)}) {
+			t.Fatalf("Unexpected result for message %d: %x", i, got)
+		}
+	}
+	if len(bob.skipped) != 0 {
+		t.Fatalf("%d skipped keys were kept after use", len(bob.skipped))
+	}
+}
+
+func TestRatchetTampering(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	sealed, _ := alice.Seal([]byte("hello world\n"))
+
+	for i := range sealed {
+		sealed[i] ^= 1
+		if _, err := bob.Open(sealed); err == nil {
+			t.Fatalf("Tampered byte %d went unnoticed", i)
+		}
+		sealed[i] ^= 1
+	}
+
+	// the failures left the state alone
+	if _, err := bob.Open(sealed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRatchetSkipLimit(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	first, _ := alice.Seal(nil)
+	if _, err := bob.Open(first); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= MaxRatchetSkip; i++ {
+		alice.Seal(nil)
+	}
+	sealed, _ := alice.Seal(nil)
+	if _, err := bob.Open(sealed); err != ErrRatchetSkip {
+		t.Fatalf("Expected ErrRatchetSkip, got %v", err)
+	}
+}
+
+func TestRatchetMarshal(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	lost, _ := alice.Seal([]byte("delayed"))
+	sealed, _ := alice.Seal([]byte("hello"))
+	if _, err := bob.Open(sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := bob.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := new(Ratchet)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the skipped key survived the round trip
+	if got, err := restored.Open(lost); err != nil || string(got) != "delayed" {
+		t.Fatalf("Unexpected result: %q, %v", got, err)
+	}
+	reply, err := restored.Seal([]byte("reply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := alice.Open(reply); err != nil || string(got) != "reply" {
+		t.Fatalf("Unexpected result: %q, %v", got, err)
+	}
+
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err != ErrRatchetState {
+		t.Fatalf("Expected ErrRatchetState, got %v", err)
+	}
+}
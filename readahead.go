@@ -0,0 +1,189 @@
+package secure
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReadAhead means that an operation that reads from the connection
+// itself, such as Ping, was attempted with Config.ReadAhead set
+var ErrReadAhead = errors.New("not supported with read-ahead")
+
+// readResult is one message read ahead of the application
+type readResult struct {
+	buf  []byte
+	data []byte
+	ad   []byte
+	info MessageInfo
+	err  error
+}
+
+// readAhead is the state of a Conn reading with Config.ReadAhead
+type readAhead struct {
+	results chan readResult
+	free    chan []byte
+	done    chan struct{}
+	stop    sync.Once
+
+	// err ends the stream once results is drained
+	err error
+
+	// deadline bounds Reads waiting for results, and wake is
+	// closed and replaced whenever it changes
+	mu       sync.Mutex
+	deadline time.Time
+	wake     chan struct{}
+}
+
+// startReadAhead starts the goroutine reading ahead, if configured,
+// with Go, so that it counts towards the Server's goroutines. The
+// transport's read deadline moves onto the Reads waiting for it, so
+// that a deadline expiring while the application is busy does not
+// cut a frame short. A connection already running as many goroutines
+// as MaxConnGoroutines allows reads without read-ahead instead.
+func (c *Conn) startReadAhead() {
+	_, depth := c.config.memoryLimits()
+	if depth <= 0 {
+		return
+	}
+
+	a := &readAhead{
+		results: make(chan readResult, depth),
+		free:    make(chan []byte, depth+1),
+		done:    make(chan struct{}),
+		wake:    make(chan struct{}),
+	}
+	c.stateMu.Lock()
+	a.deadline = c.readDeadline
+	c.ahead = a
+	c.stateMu.Unlock()
+	c.conn.SetReadDeadline(time.Time{})
+
+	if err := c.Go(func() { c.readAhead(a) }); err != nil {
+		c.stateMu.Lock()
+		c.ahead = nil
+		c.stateMu.Unlock()
+		c.conn.SetReadDeadline(a.deadline)
+	}
+}
+
+// stopReadAhead ends the goroutine started by startReadAhead
+func (c *Conn) stopReadAhead() {
+	if a := c.readAheadState(); a != nil {
+		a.stop.Do(func() { close(a.done) })
+	}
+}
+
+func (c *Conn) readAheadState() *readAhead {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.ahead
+}
+
+// readAhead reads and decrypts messages into a.results until the
+// stream ends or fails. Anomalies that do not terminate the
+// connection are passed on and reading carries on after them, as it
+// would with Reads.
+func (c *Conn) readAhead(a *readAhead) {
+	defer close(a.results)
+	for {
+		var buf []byte
+		select {
+		case buf = <-a.free:
+		default:
+			buf = make([]byte, c.r.max)
+		}
+
+		n, ad, err := c.r.ReadWithAD(buf)
+		res := readResult{buf: buf, data: buf[:n], info: c.r.info, err: err}
+		if ad != nil {
+			res.ad = append([]byte(nil), ad...)
+		}
+		if err != nil && (!isAnomaly(err) || c.config.Strict || c.closing()) {
+			a.err = err
+			return
+		}
+
+		select {
+		case a.results <- res:
+		case <-a.done:
+			a.err = net.ErrClosed
+			return
+		}
+	}
+}
+
+// next returns the next message read ahead, waiting for it
+// until the read deadline
+func (a *readAhead) next() (readResult, error) {
+	for {
+		a.mu.Lock()
+		deadline, wake := a.deadline, a.wake
+		a.mu.Unlock()
+
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return readResult{}, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+
+		select {
+		case res, ok := <-a.results:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				return readResult{}, a.err
+			}
+			return res, res.err
+		case <-expired:
+			return readResult{}, os.ErrDeadlineExceeded
+		case <-wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// release hands a result's buffer back for the next read
+func (a *readAhead) release(res readResult) {
+	select {
+	case a.free <- res.buf:
+	default:
+	}
+}
+
+func (a *readAhead) setDeadline(t time.Time) {
+	a.mu.Lock()
+	a.deadline = t
+	close(a.wake)
+	a.wake = make(chan struct{})
+	a.mu.Unlock()
+}
+
+// readBuffered is ReadWithAD with read-ahead
+func (c *Conn) readBuffered(a *readAhead, p []byte) (int, []byte, MessageInfo, error) {
+	res, err := a.next()
+	if res.buf != nil {
+		defer a.release(res)
+	}
+	if err != nil {
+		return 0, nil, MessageInfo{}, err
+	}
+	// as without read-ahead, the message is dropped
+	// if it does not fit
+	if len(p) < len(res.data) {
+		return 0, nil, MessageInfo{}, io.ErrShortBuffer
+	}
+	return copy(p, res.data), res.ad, res.info, nil
+}
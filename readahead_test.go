@@ -0,0 +1,116 @@
+package secure
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadAhead(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		conn := c.(*Conn)
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, info, err := conn.ReadMessageInfo(buf)
+			if err != nil {
+				return
+			}
+			if _, err := conn.WriteMessage(buf[:n], info); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), &Config{ReadAhead: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		if _, err := conn.WriteMessage([]byte(msg), MessageInfo{ID: uint64(len(msg))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 64)
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		n, info, err := conn.ReadMessageInfo(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg || info.ID != uint64(len(msg)) {
+			t.Fatalf("Unexpected message: %q, %+v", buf[:n], info)
+		}
+	}
+
+	// a deadline ends a Read without breaking the stream
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil || !err.(net.Error).Timeout() {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Unexpected read after a timeout: %q, %v", buf[:n], err)
+	}
+
+	// messages too large for p are dropped
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(buf[:2]); err != io.ErrShortBuffer {
+		t.Fatalf("Expected io.ErrShortBuffer, got %v", err)
+	}
+
+	if _, err := conn.Ping(); err != ErrReadAhead {
+		t.Fatalf("Expected ErrReadAhead from Ping, got %v", err)
+	}
+	if _, err := conn.ExportSession(); err != ErrReadAhead {
+		t.Fatalf("Expected ErrReadAhead from ExportSession, got %v", err)
+	}
+}
+
+func TestReadAheadEOF(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("bye"))
+		c.Close()
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), &Config{ReadAhead: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "bye" {
+		t.Fatalf("Unexpected read: %q, %v", buf[:n], err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Read(buf); err != io.EOF {
+			t.Fatalf("Expected io.EOF, got %v", err)
+		}
+	}
+}
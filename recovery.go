@@ -0,0 +1,60 @@
+package secure
+
+import "errors"
+
+// A RecoveryPolicy decides what a Reader does with a frame that fails
+// to authenticate, such as one corrupted by an unreliable transport.
+type RecoveryPolicy int
+
+const (
+	// FailClosed fails the Read with ErrDecrypt. It is the default.
+	FailClosed RecoveryPolicy = iota
+
+	// SkipCorrupted drops the frame, counts it and reads on, so that a
+	// pipeline such as telemetry tolerates noise while still measuring
+	// it. The frame is taken to be the one expected next, so frames
+	// dropped or reordered on the way still fail with ErrFrameOrder,
+	// and corruption of a frame's length, which loses the position of
+	// the frames after it, still fails the Read.
+	SkipCorrupted
+)
+
+// errSkipped means that readFrame skipped a corrupted frame
+var errSkipped = errors.New("corrupted frame skipped")
+
+// SetRecovery sets what the Reader does with frames that fail to
+// authenticate. With SkipCorrupted, onSkip, if not nil, is called
+// with the number of frames skipped so far every time one is.
+func (s *Reader) SetRecovery(policy RecoveryPolicy, onSkip func(skipped uint64)) {
+	s.recovery, s.onSkip = policy, onSkip
+}
+
+// Skipped returns the number of corrupted frames
+// the Reader skipped.
+func (s *Reader) Skipped() uint64 {
+	return s.skipped
+}
+
+// skip counts a corrupted frame, which took the expected frame number
+func (s *Reader) skip() error {
+	s.recv++
+	s.skipped++
+	if s.onSkip != nil {
+		s.onSkip(s.skipped)
+	}
+	return errSkipped
+}
+
+// setRecovery applies Config.Recovery once the handshake is over, so
+// that no hello is ever skipped, reporting each skipped frame to the
+// AuditHook
+func (c *Conn) setRecovery() {
+	if c.config.Recovery != SkipCorrupted {
+		return
+	}
+	c.r.SetRecovery(SkipCorrupted, func(uint64) {
+		if c.config.AuditHook != nil {
+			c.config.AuditHook(AuditEvent{RemoteAddr: c.conn.RemoteAddr(), Err: ErrDecrypt})
+		}
+	})
+}
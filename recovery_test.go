@@ -0,0 +1,73 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestReaderSkipCorrupted(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, priv, pub)
+	var ends []int
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		if _, err := w.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the ciphertext of the second and fourth frames
+	stream := buf.Bytes()
+	stream[ends[1]-1] ^= 1
+	stream[ends[3]-1] ^= 1
+
+	r := NewReader(bytes.NewReader(stream), priv, pub)
+	if _, err := r.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 64)); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt by default, got %v", err)
+	}
+
+	var reported []uint64
+	r = NewReader(bytes.NewReader(stream), priv, pub)
+	r.SetRecovery(SkipCorrupted, func(skipped uint64) { reported = append(reported, skipped) })
+	var got []string
+	for {
+		p := make([]byte, 64)
+		n, err := r.Read(p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(p[:n]))
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "three" {
+		t.Fatalf("Unexpected messages: %q", got)
+	}
+	if r.Skipped() != 2 || len(reported) != 2 || reported[1] != 2 {
+		t.Fatalf("Unexpected count of skipped frames: %d, %v", r.Skipped(), reported)
+	}
+
+	// frames missing altogether are still detected
+	r = NewReader(bytes.NewReader(append(stream[:ends[0]:ends[0]], stream[ends[1]:]...)), priv, pub)
+	r.SetRecovery(SkipCorrupted, nil)
+	r.Read(make([]byte, 64))
+	if _, err := r.Read(make([]byte, 64)); err != ErrFrameOrder {
+		t.Fatalf("Expected ErrFrameOrder for a dropped frame, got %v", err)
+	}
+}
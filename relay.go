@@ -0,0 +1,309 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Size (in bytes) of the longest rendezvous channel name
+const MaxChannelSize = 255
+
+// Size (in bytes) of the most a Relay with a Quota forwards
+// between two looks at the quota
+const relayChunk = 32 * 1024
+
+// Roles assigned by the relay once both peers of a channel have arrived
+const (
+	roleInitiator byte = 'A'
+	roleResponder byte = 'B'
+)
+
+// ErrChannel means that a rendezvous channel name was empty or too long
+var ErrChannel = errors.New("invalid rendezvous channel")
+
+// A Relay lets two peers that cannot reach each other directly meet
+// on a named channel. Both connect outbound to the relay, which pairs
+// them up and then blindly forwards bytes in both directions. The relay
+// never holds any keys: peers are expected to run Pair end-to-end over
+// the forwarded connection, so all the relay ever sees is ciphertext.
+type Relay struct {
+	// WaitTimeout is how long the first peer on a channel waits for
+	// the second before it is disconnected, DefaultWaitTimeout if
+	// zero and without limit if negative. A peer that disconnects
+	// while waiting frees its channel for the next one either way.
+	WaitTimeout time.Duration
+
+	// ChannelTimeout is how long a peer may take to name its channel
+	// once connected, DefaultHandshakeTimeout if zero and without
+	// limit if negative.
+	ChannelTimeout time.Duration
+
+	// Quota, if not nil, bounds the connections and bytes of each
+	// channel. Peers over quota are disconnected, or slowed down if
+	// it throttles. Bytes are then forwarded in chunks of at most
+	// 32 KiB, each charged once it has been forwarded.
+	Quota *Quota
+
+	mu      sync.Mutex
+	waiting map[string]*waiter
+}
+
+// A waiter is a peer waiting for the other side of its channel.
+// Peers send nothing while they wait, so its goroutine reads from
+// conn to notice it disconnecting, until the other side arrives.
+type waiter struct {
+	conn  net.Conn
+	timer *time.Timer
+
+	// watched is closed once the waiter's goroutine stopped reading
+	watched chan struct{}
+}
+
+// Serve accepts connections on l and pairs them up by channel.
+// It only returns when l fails to accept.
+func (r *Relay) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go r.handleConnection(conn)
+	}
+}
+
+func (r *Relay) handleConnection(c net.Conn) {
+	if timeout := timeoutOrDefault(r.ChannelTimeout, DefaultHandshakeTimeout); timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+	}
+	channel, err := readChannel(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	if r.Quota != nil {
+		if err := r.Quota.admit(channel); err != nil {
+			c.Close()
+			return
+		}
+		c = &quotaConn{Conn: c, quota: r.Quota, key: channel, closed: make(chan struct{})}
+	}
+
+	for {
+		peer, w := r.meet(channel, c)
+		if peer == nil {
+			// first to arrive waits for the other side
+			r.watch(channel, w)
+			return
+		}
+
+		if _, err := peer.Write([]byte{roleInitiator}); err != nil {
+			// the waiting peer left, so c waits in its place
+			peer.Close()
+			continue
+		}
+		if _, err := c.Write([]byte{roleResponder}); err != nil {
+			peer.Close()
+			c.Close()
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			splice(peer, c)
+			close(done)
+		}()
+		splice(c, peer)
+		<-done
+		c.Close()
+		peer.Close()
+		return
+	}
+}
+
+// meet returns the peer waiting on channel, once it has stopped
+// watching it, or registers c as waiting there and returns nil and
+// its waiter if there is none
+func (r *Relay) meet(channel string, c net.Conn) (net.Conn, *waiter) {
+	r.mu.Lock()
+	if w, ok := r.waiting[channel]; ok {
+		delete(r.waiting, channel)
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		r.mu.Unlock()
+
+		w.conn.SetReadDeadline(time.Unix(1, 0))
+		<-w.watched
+		w.conn.SetReadDeadline(time.Time{})
+		return w.conn, nil
+	}
+	defer r.mu.Unlock()
+
+	if r.waiting == nil {
+		r.waiting = make(map[string]*waiter)
+	}
+	w := &waiter{conn: c, watched: make(chan struct{})}
+	if timeout := timeoutOrDefault(r.WaitTimeout, DefaultWaitTimeout); timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() {
+			r.mu.Lock()
+			if r.waiting[channel] == w {
+				delete(r.waiting, channel)
+				c.Close()
+			}
+			r.mu.Unlock()
+		})
+	}
+	r.waiting[channel] = w
+	return nil, w
+}
+
+// watch reads from the waiter w until the other side of channel
+// interrupts it, or w disconnects, when it is closed and its channel
+// freed for the next peer
+func (r *Relay) watch(channel string, w *waiter) {
+	defer close(w.watched)
+	var buf [1]byte
+	w.conn.Read(buf[:])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiting[channel] == w {
+		delete(r.waiting, channel)
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		w.conn.Close()
+	}
+}
+
+// splice copies src to dst until src is exhausted, then shuts down
+// the writing side of dst, so that a peer's CloseWrite reaches the
+// other while it can still answer. If either fails, or dst cannot be
+// half closed, both are closed.
+// Between TCP connections io.Copy uses splice(2) on Linux, so the
+// forwarded bytes never enter user space. Quota conns are unwrapped
+// for it, and charged by the chunk. Compare BenchmarkRelay with
+// BenchmarkWriter to see how much slower sealing is than forwarding.
+func splice(dst, src net.Conn) {
+	w := dst
+	if q, ok := dst.(*quotaConn); ok {
+		w = q.Conn
+	}
+
+	var err error
+	if q, ok := src.(*quotaConn); ok {
+		err = q.forward(w)
+	} else {
+		_, err = io.Copy(w, src)
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		cw.CloseWrite()
+		return
+	}
+	dst.Close()
+	src.Close()
+}
+
+func readChannel(r io.Reader) (string, error) {
+	var size [1]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	if size[0] == 0 {
+		return "", ErrChannel
+	}
+
+	channel := make([]byte, size[0])
+	if _, err := io.ReadFull(r, channel); err != nil {
+		return "", err
+	}
+
+	return string(channel), nil
+}
+
+// Rendezvous asks the relay on the other end of rw to connect us with
+// the other peer on channel. It blocks until that peer arrives and then
+// reports whether this side should act as the initiator of Pair.
+// Everything read from or written to rw afterwards goes to the peer.
+func Rendezvous(rw io.ReadWriter, channel string) (initiator bool, err error) {
+	if len(channel) == 0 || len(channel) > MaxChannelSize {
+		return false, ErrChannel
+	}
+
+	if _, err := rw.Write(append([]byte{byte(len(channel))}, channel...)); err != nil {
+		return false, err
+	}
+
+	var role [1]byte
+	if _, err := io.ReadFull(rw, role[:]); err != nil {
+		return false, err
+	}
+
+	return role[0] == roleInitiator, nil
+}
+
+// A quotaConn charges the bytes read from it to the quota of the
+// channel named key, and releases its connection on Close, which
+// also ends any wait for a throttled quota
+type quotaConn struct {
+	net.Conn
+	quota  *Quota
+	key    string
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *quotaConn) Read(p []byte) (int, error) {
+	if err := c.quota.check(c.key); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	if qerr := c.quota.charge(context.Background(), c.key, n, time.Time{}, c.closed); err == nil {
+		err = qerr
+	}
+	return n, err
+}
+
+// forward copies from the underlying connection to dst until it is
+// exhausted, as io.Copy would, but no more than the quota allows at a
+// time, charging every chunk once it has been copied. It returns nil
+// at the end of the connection.
+func (c *quotaConn) forward(dst io.Writer) error {
+	for {
+		n, err := c.quota.allowance(c.key, relayChunk)
+		if err != nil {
+			return err
+		}
+		copied, err := io.CopyN(dst, c.Conn, n)
+		if qerr := c.quota.charge(context.Background(), c.key, int(copied), time.Time{}, c.closed); qerr != nil {
+			return qerr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *quotaConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *quotaConn) Close() error {
+	c.once.Do(func() {
+		c.quota.release(c.key)
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
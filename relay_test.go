@@ -0,0 +1,265 @@
+package secure
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go new(Relay).Serve(l)
+
+	type result struct {
+		conn      net.Conn
+		initiator bool
+		err       error
+	}
+	done := make(chan result)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			initiator, err := Rendezvous(conn, "7")
+			done <- result{conn, initiator, err}
+		}()
+	}
+
+	a, b := <-done, <-done
+	if a.err != nil {
+		t.Fatal(a.err)
+	}
+	if b.err != nil {
+		t.Fatal(b.err)
+	}
+	defer a.conn.Close()
+	defer b.conn.Close()
+
+	if a.initiator == b.initiator {
+		t.Fatal("Expected exactly one initiator")
+	}
+
+	if _, err := a.conn.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(b.conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if res := string(buf); res != "hello world\n" {
+		t.Fatalf("Unexpected result: %s != %s", res, "hello world")
+	}
+}
+
+func BenchmarkRelay(b *testing.B) {
+	benchmarkRelay(b, new(Relay))
+}
+
+func BenchmarkRelayQuota(b *testing.B) {
+	benchmarkRelay(b, &Relay{Quota: &Quota{MaxBytes: 1 << 62}})
+}
+
+func benchmarkRelay(b *testing.B, r *Relay) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	go r.Serve(l)
+
+	conns := make(chan net.Conn, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Error(err)
+				conns <- nil
+				return
+			}
+			if _, err := Rendezvous(conn, "bench"); err != nil {
+				b.Error(err)
+			}
+			conns <- conn
+		}()
+	}
+	src, dst := <-conns, <-conns
+	if src == nil || dst == nil {
+		b.FailNow()
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	data := make([]byte, MaxMessageSize)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			src.Write(data)
+		}
+	}()
+	if _, err := io.CopyN(ioutil.Discard, dst, int64(b.N*len(data))); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// rendezvous connects to the relay at addr on channel
+func rendezvous(t *testing.T, addr, channel string) (net.Conn, bool) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator, err := Rendezvous(conn, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, initiator
+}
+
+func TestRelayHalfClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go new(Relay).Serve(l)
+
+	pub, priv := mustGenerateKey(t, nil)
+	done := make(chan error, 1)
+	go func() {
+		conn, _ := rendezvous(t, l.Addr().String(), "half")
+		c := NewServerConn(conn, &Config{PublicKey: pub, PrivateKey: priv})
+		defer c.Close()
+		msg, err := ioutil.ReadAll(c)
+		if err == nil {
+			_, err = c.Write(msg)
+		}
+		done <- err
+	}()
+
+	conn, _ := rendezvous(t, l.Addr().String(), "half")
+	c := NewClientConn(conn, nil)
+	defer c.Close()
+	if _, err := c.Write([]byte("sent before closing")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer sees the end of the stream and still answers
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxMessageSize)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "sent before closing" {
+		t.Fatalf("Unexpected result: %q, %v", buf[:n], err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayWaitTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&Relay{WaitTimeout: 20 * time.Millisecond}).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := Rendezvous(conn, "alone"); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestRelayWaiterLeft(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r := new(Relay)
+	go r.Serve(l)
+
+	// the first peer gives up while waiting
+	left, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	left.Write([]byte("\x04left"))
+	waiting := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.waiting)
+	}
+	for deadline := time.Now().Add(time.Second); waiting() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The first peer is not waiting")
+		}
+	}
+	left.Close()
+	for deadline := time.Now().Add(time.Second); waiting() != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The peer that left still holds its channel")
+		}
+	}
+
+	// so the next two meet each other
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				done <- err
+				return
+			}
+			defer conn.Close()
+			if _, err = Rendezvous(conn, "left"); err == nil {
+				// wait for the other to be done with the relay
+				_, err = conn.Write([]byte("x"))
+				conn.Read(make([]byte, 1))
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRelayChannelTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&Relay{ChannelTimeout: 20 * time.Millisecond}).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a peer that never names its channel is disconnected
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
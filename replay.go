@@ -0,0 +1,104 @@
+package secure
+
+// Largest replay window a FrameState accepts, in frames
+const maxReplayWindow = 1 << 16
+
+// ReplayStats counts the frames that a FrameState with a replay window
+// opened late or turned away.
+type ReplayStats struct {
+	// Late is the number of frames accepted after frames
+	// sealed after them.
+	Late uint64
+
+	// Duplicate is the number of frames rejected
+	// because they had been opened already.
+	Duplicate uint64
+
+	// OutOfWindow is the number of frames rejected because they
+	// were older than the window, whether duplicates or not.
+	OutOfWindow uint64
+}
+
+// SetReplayWindow makes OpenFrame accept frames out of order, as long
+// as they were sealed at most size frames before the newest one opened,
+// for transports that lose or reorder frames, such as datagrams. Frames
+// may also go missing. Frames opened before, and frames older than the
+// window, whose openings can no longer be told apart, fail with
+// ErrFrameOrder and are counted in ReplayStats. The window takes one
+// bit of memory per frame and is capped at 65536 frames. A close frame
+// still fails with ErrTruncated unless every frame before it was
+// opened. A size of zero, the default, requires frames in order.
+func (s *FrameState) SetReplayWindow(size int) {
+	if size <= 0 {
+		s.r.window = nil
+		return
+	}
+	if size > maxReplayWindow {
+		size = maxReplayWindow
+	}
+	s.r.window = &replayWindow{size: uint64(size), seen: make([]uint64, (size+63)/64)}
+}
+
+// ReplayStats returns the counts of frames opened late or turned away
+// by the replay window.
+func (s *FrameState) ReplayStats() ReplayStats {
+	if s.r.window == nil {
+		return ReplayStats{}
+	}
+	return s.r.window.stats
+}
+
+// A replayWindow remembers which of the last size frame numbers up to
+// the newest were opened, in a ring of bits indexed by number modulo
+// size
+type replayWindow struct {
+	size   uint64
+	seen   []uint64
+	newest uint64
+	opened uint64 // frames accepted
+	stats  ReplayStats
+}
+
+// accept reports whether the frame numbered n may be opened,
+// and records it if so
+func (w *replayWindow) accept(n uint64) bool {
+	switch {
+	case w.opened == 0 || n > w.newest:
+		// forget the numbers the window slides past
+		if w.opened == 0 || n-w.newest >= w.size {
+			for i := range w.seen {
+				w.seen[i] = 0
+			}
+		} else {
+			for i := w.newest + 1; i < n; i++ {
+				w.set(i, false)
+			}
+		}
+		w.newest = n
+	case w.newest-n >= w.size:
+		w.stats.OutOfWindow++
+		return false
+	case w.get(n):
+		w.stats.Duplicate++
+		return false
+	default:
+		w.stats.Late++
+	}
+	w.set(n, true)
+	w.opened++
+	return true
+}
+
+func (w *replayWindow) get(n uint64) bool {
+	i := n % w.size
+	return w.seen[i/64]&(1<<(i%64)) != 0
+}
+
+func (w *replayWindow) set(n uint64, on bool) {
+	i := n % w.size
+	if on {
+		w.seen[i/64] |= 1 << (i % 64)
+	} else {
+		w.seen[i/64] &^= 1 << (i % 64)
+	}
+}
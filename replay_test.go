@@ -0,0 +1,78 @@
+package secure
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestReplayWindow(t *testing.T) {
+	pub1, priv1 := mustGenerateKey(t, nil)
+	pub2, priv2 := mustGenerateKey(t, nil)
+
+	sealer := NewFrameState(priv1, pub2)
+	var frames [][]byte
+	for i := 0; i < 12; i++ {
+		frame, err := SealFrame(nil, FrameData, []byte(fmt.Sprint(i)), sealer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	closeFrame, err := SealCloseFrame(nil, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opener := NewFrameState(priv2, pub1)
+	opener.SetReplayWindow(4)
+	for _, step := range []struct {
+		frame int
+		ok    bool
+	}{
+		{0, true},
+		{2, true},
+		{1, true},  // late
+		{1, false}, // duplicate
+		{0, false}, // duplicate
+		{7, true},  // 3 to 6 went missing
+		{4, true},  // late
+		{3, false}, // out of the window
+		{11, true},
+		{7, false}, // out of the window
+		{8, true},  // late
+		{8, false}, // duplicate
+	} {
+		_, msg, err := OpenFrame(nil, frames[step.frame], opener)
+		if step.ok && (err != nil || string(msg) != fmt.Sprint(step.frame)) {
+			t.Fatalf("Frame %d: unexpected result %q, %v", step.frame, msg, err)
+		}
+		if !step.ok && err != ErrFrameOrder {
+			t.Fatalf("Frame %d: expected ErrFrameOrder, got %v", step.frame, err)
+		}
+	}
+	if stats := opener.ReplayStats(); stats != (ReplayStats{Late: 3, Duplicate: 3, OutOfWindow: 2}) {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if _, _, err := OpenFrame(nil, closeFrame, opener); err != ErrTruncated {
+		t.Fatalf("Expected ErrTruncated with frames missing, got %v", err)
+	}
+
+	// reordering within the window loses nothing
+	opener = NewFrameState(priv2, pub1)
+	opener.SetReplayWindow(4)
+	for _, i := range []int{1, 0, 3, 2, 4, 7, 5, 6, 8, 9, 11, 10} {
+		if _, _, err := OpenFrame(nil, frames[i], opener); err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+	}
+	if _, _, err := OpenFrame(nil, closeFrame, opener); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+
+	// without a window frames must come in order
+	opener = NewFrameState(priv2, pub1)
+	if _, _, err := OpenFrame(nil, frames[1], opener); err != ErrFrameOrder {
+		t.Fatalf("Expected ErrFrameOrder, got %v", err)
+	}
+}
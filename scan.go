@@ -0,0 +1,71 @@
+package secure
+
+import (
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// MaxFrameSize is the size of the largest frame a Writer using
+// SuiteBox emits. A bufio.Scanner splitting with ScanFrames needs
+// a buffer this large, which is more than its default maximum.
+// Frames of AEAD suites can be up to 1<<16+1 bytes larger.
+const MaxFrameSize = HeaderSize + 1<<16 - 1
+
+// ScanFrames is a bufio.SplitFunc that returns each frame of a stream
+// written by a Writer using SuiteBox, the default, as a token, header
+// included. It lets proxies and recorders relay or store frames without
+// decrypting them. A stream that ends within a frame fails with
+// io.ErrUnexpectedEOF, and a length too short to hold a sealed frame
+// with ErrFrame. Set the scanner's buffer to hold MaxFrameSize bytes:
+//
+//	s := bufio.NewScanner(conn)
+//	s.Buffer(make([]byte, secure.MaxFrameSize), secure.MaxFrameSize)
+//	s.Split(secure.ScanFrames)
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return scanFrames(data, atEOF, false)
+}
+
+// ScanAEADFrames is like ScanFrames, for streams written with an AEAD
+// suite, whose frames carry the additional data after the length.
+func ScanAEADFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return scanFrames(data, atEOF, true)
+}
+
+func scanFrames(data []byte, atEOF, aead bool) (int, []byte, error) {
+	header := HeaderSize
+	if aead {
+		header += 2
+	}
+	if len(data) < header {
+		return endOfFrames(data, atEOF)
+	}
+
+	size := int(binary.LittleEndian.Uint16(data[NonceSize:]))
+	if size < box.Overhead+frameTypeSize {
+		return 0, nil, ErrFrame
+	}
+	size += header
+	if aead {
+		if n := int(binary.LittleEndian.Uint16(data[HeaderSize:])); n != rekeySealed {
+			size += n
+		}
+	}
+	if len(data) < size {
+		return endOfFrames(data, atEOF)
+	}
+	return size, data[:size], nil
+}
+
+// endOfFrames asks for more data, or ends the scan
+// if there is none, as the remaining data is no frame
+func endOfFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if !atEOF {
+		return 0, nil, nil
+	}
+	if len(data) > 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,89 @@
+package secure
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// scanAll splits stream with split, reading it a byte at a time
+func scanAll(stream []byte, split bufio.SplitFunc) ([][]byte, error) {
+	s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(stream)))
+	s.Buffer(make([]byte, 64), MaxFrameSize+1<<16+1)
+	s.Split(split)
+	var frames [][]byte
+	for s.Scan() {
+		frames = append(frames, append([]byte(nil), s.Bytes()...))
+	}
+	return frames, s.Err()
+}
+
+func TestScanFrames(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var frames [][]byte
+	var wire bytes.Buffer
+	w := NewWriter(&wire, priv, pub)
+	w.SetMaxMessageSize(MaxFrameMessageSize)
+	w.tap = func(frame []byte) { frames = append(frames, frame) }
+	w.Write([]byte("hello"))
+	w.Write(nil)
+	w.Write(make([]byte, MaxFrameMessageSize))
+	w.Close()
+	stream := wire.Bytes()
+
+	got, err := scanAll(stream, ScanFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("Expected %d frames, got %d", len(frames), len(got))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], frames[i]) {
+			t.Fatalf("Frame %d split wrong", i)
+		}
+	}
+	if len(frames[2]) != MaxFrameSize {
+		t.Fatalf("Expected the largest frame to be %d bytes, got %d", MaxFrameSize, len(frames[2]))
+	}
+
+	// relaying the frames as split keeps the stream readable
+	r := NewReader(bytes.NewReader(bytes.Join(got, nil)), priv, pub)
+	r.SetMaxMessageSize(MaxFrameMessageSize)
+	if n, err := io.Copy(ioutil.Discard, r); err != nil || n != 5+MaxFrameMessageSize {
+		t.Fatalf("Unexpected result: %d, %v", n, err)
+	}
+
+	if _, err := scanAll(stream[:len(stream)-1], ScanFrames); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := scanAll(make([]byte, HeaderSize), ScanFrames); err != ErrFrame {
+		t.Fatalf("Expected ErrFrame, got %v", err)
+	}
+}
+
+func TestScanAEADFrames(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	w := NewWriter(&wire, priv, pub)
+	aead, err := SuiteAES256GCM.aead(&w.shared, []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.aead = aead
+	w.WriteWithAD([]byte("hello"), []byte("route=a"))
+	w.Write([]byte("no additional data"))
+
+	got, err := scanAll(wire.Bytes(), ScanAEADFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || len(got[0]) != SealedSize(5)+2+7 || len(got[1]) != SealedSize(18)+2 {
+		t.Fatalf("Unexpected frames: %d", len(got))
+	}
+}
@@ -0,0 +1,109 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// A FrameState holds what SealFrame and OpenFrame need between frames:
+// the shared key and the numbers of the frames sealed and opened so
+// far, from which every nonce is derived. It plays the part of a
+// Writer and a Reader without their io.Writer and io.Reader, for
+// callers that move frames themselves, such as event loops built on
+// io_uring or netpoll libraries. A FrameState must not be used
+// concurrently.
+type FrameState struct {
+	w   *Writer
+	r   *Reader
+	src bytes.Reader
+}
+
+// NewFrameState returns the state of a stream between priv and the
+// peer's public key pub, interoperating with NewWriter and NewReader.
+// priv and pub should be keys generated with box.GenerateKey
+func NewFrameState(priv, pub *[KeySize]byte) *FrameState {
+	s := &FrameState{w: NewWriter(nil, priv, pub), r: NewReader(nil, priv, pub)}
+	s.r.r = &s.src
+	return s
+}
+
+// SealFrame encrypts payload as the next frame of type t of the stream
+// and appends it to dst, in the format a Writer emits, returning the
+// extended buffer. Type FrameData carries messages a Reader returns;
+// other types are sent as with Writer.WriteFrame.
+func SealFrame(dst []byte, t FrameType, payload []byte, state *FrameState) ([]byte, error) {
+	w := state.w
+	if w.closed {
+		return dst, ErrWriterClosed
+	}
+	if len(payload) > w.max {
+		return dst, ErrFrameTooLarge
+	}
+	if t != FrameClose && w.nonces.exhausted() {
+		return dst, ErrNonceExhausted
+	}
+	nonce, err := w.nonces.next()
+	if err != nil {
+		return dst, err
+	}
+	for _, b := range w.seal(t, payload, nil, nonce) {
+		dst = append(dst, b...)
+	}
+	w.sent++
+	return dst, nil
+}
+
+// SealCloseFrame appends the close frame ending the stream to dst,
+// after which SealFrame fails with ErrWriterClosed.
+func SealCloseFrame(dst []byte, state *FrameState) ([]byte, error) {
+	var count [8]byte
+	binary.BigEndian.PutUint64(count[:], state.w.sent)
+	dst, err := SealFrame(dst, FrameClose, count[:], state)
+	state.w.closed = true
+	return dst, err
+}
+
+// OpenFrame authenticates and decrypts frame, which must be the next
+// complete frame of the stream, and appends its payload to dst,
+// returning its type and the extended buffer. The messages of
+// FrameMessage frames are returned as FrameData, without their header.
+// A close frame fails with io.EOF, or ErrTruncated if frames went
+// missing before it, and an alert frame with an *AlertError. Frames of
+// other types are returned for the caller to act on; it should fail on
+// protocol types below FrameControl it does not know, as a Reader does.
+func OpenFrame(dst, frame []byte, state *FrameState) (FrameType, []byte, error) {
+	r := state.r
+	if r.eof {
+		return 0, dst, io.EOF
+	}
+	if len(frame) < HeaderSize || int(binary.LittleEndian.Uint16(frame[NonceSize:])) != len(frame)-HeaderSize {
+		return 0, dst, ErrFrame
+	}
+
+	state.src.Reset(frame)
+	t, payload, err := r.readFrame()
+	if err != nil {
+		return 0, dst, err
+	}
+	switch t {
+	case FrameMessage:
+		if len(payload) < MessageHeaderSize {
+			return 0, dst, ErrFrame
+		}
+		t, payload = FrameData, payload[MessageHeaderSize:]
+	case FrameClose:
+		opened := r.recv
+		if r.window != nil {
+			opened = r.window.opened
+		}
+		if len(payload) != 8 || binary.BigEndian.Uint64(payload) != opened-1 {
+			return 0, dst, ErrTruncated
+		}
+		r.eof = true
+		return FrameClose, dst, io.EOF
+	case FrameAlert:
+		return FrameAlert, dst, parseAlert(payload)
+	}
+	return t, append(dst, payload...), nil
+}
@@ -0,0 +1,76 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestSealFrame(t *testing.T) {
+	pub1, priv1 := mustGenerateKey(t, nil)
+	pub2, priv2 := mustGenerateKey(t, nil)
+
+	// frames sealed by hand are read by a Reader
+	sealer := NewFrameState(priv1, pub2)
+	var wire []byte
+	var err error
+	for _, msg := range []string{"first", "second"} {
+		if wire, err = SealFrame(wire, FrameData, []byte(msg), sealer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wire, err = SealCloseFrame(wire, sealer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SealFrame(nil, FrameData, nil, sealer); err != ErrWriterClosed {
+		t.Fatalf("Expected ErrWriterClosed, got %v", err)
+	}
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, NewReader(bytes.NewReader(wire), priv2, pub1)); err != nil || got.String() != "firstsecond" {
+		t.Fatalf("Unexpected result: %q, %v", got.String(), err)
+	}
+
+	// and frames from a Writer are opened by hand
+	var stream bytes.Buffer
+	w := NewWriter(&stream, priv1, pub2)
+	w.Write([]byte("hello"))
+	w.WriteFrame(FrameControl, []byte("ext"))
+	w.WriteMessage([]byte("with header"), MessageInfo{ID: 1})
+	w.Close()
+
+	opener := NewFrameState(priv2, pub1)
+	want := []struct {
+		t   FrameType
+		msg string
+	}{{FrameData, "hello"}, {FrameControl, "ext"}, {FrameData, "with header"}}
+	for _, w := range want {
+		size := HeaderSize + int(binary.LittleEndian.Uint16(stream.Bytes()[NonceSize:]))
+		typ, msg, err := OpenFrame(nil, stream.Next(size), opener)
+		if err != nil || typ != w.t || string(msg) != w.msg {
+			t.Fatalf("Unexpected frame: %d %q, %v", typ, msg, err)
+		}
+	}
+	if _, _, err := OpenFrame(nil, stream.Bytes(), opener); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestOpenFrameErrors(t *testing.T) {
+	pub1, priv1 := mustGenerateKey(t, nil)
+	pub2, priv2 := mustGenerateKey(t, nil)
+	sealer, opener := NewFrameState(priv1, pub2), NewFrameState(priv2, pub1)
+
+	first, _ := SealFrame(nil, FrameData, []byte("first"), sealer)
+	second, _ := SealFrame(nil, FrameData, []byte("second"), sealer)
+	if _, _, err := OpenFrame(nil, first[:len(first)-1], opener); err != ErrFrame {
+		t.Fatalf("Expected ErrFrame, got %v", err)
+	}
+	if _, _, err := OpenFrame(nil, second, opener); err != ErrFrameOrder {
+		t.Fatalf("Expected ErrFrameOrder, got %v", err)
+	}
+	first[len(first)-1] ^= 1
+	if _, _, err := OpenFrame(nil, first, opener); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package secure
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// GenerateSecretKey returns a random key for NewSecretWriter and
+// NewSecretReader, read from r, or from crypto/rand.Reader if r is nil.
+func GenerateSecretKey(r io.Reader) (*[KeySize]byte, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	key := new([KeySize]byte)
+	if _, err := io.ReadFull(r, key[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewSecretWriter instantiates a secure Writer sealing frames with
+// secretbox under key, such as for data at rest that the writer reads
+// back itself, so that no peer key pair has to be made up. The stream
+// is framed like any other, and read with NewSecretReader and the same
+// key. The random part of every nonce keeps streams apart, so one key
+// may seal many of them.
+func NewSecretWriter(w io.Writer, key *[KeySize]byte) *Writer {
+	// sealing with a precomputed box key is secretbox under that key
+	return newSharedWriter(w, key)
+}
+
+// NewSecretReader instantiates a secure Reader opening
+// the frames a Writer from NewSecretWriter sealed with key.
+func NewSecretReader(r io.Reader, key *[KeySize]byte) *Reader {
+	return newSharedReader(r, key)
+}
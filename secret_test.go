@@ -0,0 +1,70 @@
+package secure
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestSecretWriter(t *testing.T) {
+	key, err := GenerateSecretKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := NewSecretWriter(&buf, key)
+	w.SetChunking(true)
+	data := bytes.Repeat([]byte("at rest "), MaxMessageSize/4)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	got, err := readSecret(stream, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Unexpected result. The data did not survive the round trip.")
+	}
+
+	// every frame is plain secretbox under the key
+	var nonce [NonceSize]byte
+	copy(nonce[:], stream)
+	size := int(stream[NonceSize]) | int(stream[NonceSize+1])<<8
+	plain, ok := secretbox.Open(nil, stream[HeaderSize:HeaderSize+size], &nonce, key)
+	if !ok || FrameType(plain[0]) != FrameData || !bytes.Equal(plain[frameTypeSize:], data[:MaxMessageSize]) {
+		t.Fatal("Unexpected result. The first frame is not secretbox under the key.")
+	}
+
+	other, _ := GenerateSecretKey(nil)
+	if _, err := NewSecretReader(bytes.NewReader(stream), other).Read(make([]byte, MaxMessageSize)); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt for another key, got %v", err)
+	}
+	if _, err := readSecret(stream[:len(stream)-fileCloseSize], key); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF for a truncated stream, got %v", err)
+	}
+}
+
+// readSecret reads all the messages of a stream sealed under key
+func readSecret(stream []byte, key *[KeySize]byte) ([]byte, error) {
+	r := NewSecretReader(bytes.NewReader(stream), key)
+	buf := make([]byte, MaxMessageSize)
+	var data []byte
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package secure
 
 import (
-	"crypto/rand"
+	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"io"
+	"net"
 
 	"golang.org/x/crypto/nacl/box"
 )
@@ -15,9 +17,31 @@ const NonceSize = 24
 // Size (in bytes) of the key for NaCl box seal/open
 const KeySize = 32
 
-// Size (in bytes) of the max message size supported by this package
+// Size (in bytes) of the default max message size of Readers and Writers
 const MaxMessageSize = 32 * 1024
 
+// Size (in bytes) of the largest message the uint16 length field can describe
+const MaxFrameMessageSize = 1<<16 - 1 - box.Overhead - frameTypeSize
+
+// Size (in bytes) of the smallest max message size, that of the
+// close frame's payload, so that every stream can be closed
+const minMessageSize = 8
+
+// Size (in bytes) of the frame header: the nonce followed by
+// the little endian uint16 length of the ciphertext
+const HeaderSize = NonceSize + 2
+
+// AEAD suites seal FrameRekey with box under the shared key rather
+// than with their own key, and mark it with this additional data size,
+// which no frame can have. The box nonce is random enough for a
+// resumed session to announce a fresh key without risking the reuse
+// of a nonce of the key it is leaving.
+const rekeySealed = 0xffff
+
+// Size (in bytes) added to every message on the wire by SuiteBox.
+// Other suites add more; see Suite.SealedSize.
+const Overhead = HeaderSize + box.Overhead + frameTypeSize
+
 // ErrNonceSize means that the source of randomness did not provide
 // enough bytes for a complete nonce
 var ErrNonceSize = errors.New("not enough bytes read for nonce")
@@ -28,101 +52,613 @@ var ErrDecrypt = errors.New("decrypt error")
 // ErrEncWrite means that a complete encrypted message was unable to be written
 var ErrEncWrite = errors.New("failed to write complete encrypted message")
 
+// ErrFrame means that a frame was truncated or its length field did not
+// match its contents
+var ErrFrame = errors.New("malformed frame")
+
+// ErrFrameOrder means that a frame arrived out of order, twice, after
+// a dropped frame or reflected back at its sender
+var ErrFrameOrder = errors.New("frame reordered, duplicated or dropped")
+
+// ErrTruncated means that the stream ended before all the frames the
+// peer sent had been received
+var ErrTruncated = errors.New("stream truncated")
+
+// ErrWriterClosed means that a Writer was written to after Close
+var ErrWriterClosed = errors.New("write after close")
+
+// ErrFrameTooLarge means that a message is larger than the maximum
+// message size in effect
+var ErrFrameTooLarge = errors.New("message too large for one frame")
+
+// ErrTrailingData means that a strict Reader found more data after
+// the close frame
+var ErrTrailingData = errors.New("data after close frame")
+
+// Every frame's nonce is made of 15 random bytes, the direction the
+// frame travels in and the big endian number of the frame in its
+// stream, counting from zero. The nonce is authenticated with the box,
+// so a Reader that checks the number and direction detects any frame
+// that was reordered, duplicated, dropped or reflected.
+const (
+	nonceDirOffset = NonceSize - 9
+	nonceSeqOffset = NonceSize - 8
+)
+
+// Frame directions recorded in nonces. Readers and Writers that are
+// not part of a Conn use dirAny and do not check the direction.
+const (
+	dirAny byte = iota
+	dirFromClient
+	dirFromServer
+)
+
 // A Reader is an io.Reader that can be used to read streams
 // of encrypted data that was encrypted using the Writer from this package.
 // The Reader will decrypt and return the plaintext from
 // the provided io.Reader.
 type Reader struct {
-	r         io.Reader
-	priv, pub *[KeySize]byte
-	shared    [KeySize]byte
+	r          io.Reader
+	priv, pub  *[KeySize]byte
+	shared     [KeySize]byte
+	max        int
+	buf        []byte
+	handlers   map[FrameType]func(payload []byte) error
+	tap        func(frame []byte)
+	eof        bool
+	alert      error
+	recv       uint64
+	dir        byte
+	aead       cipher.AEAD
+	salt       []byte
+	ad         []byte
+	info       MessageInfo
+	strict     bool
+	recovery   RecoveryPolicy
+	onSkip     func(skipped uint64)
+	skipped    uint64
+	window     *replayWindow
+	count      func(size int)
+	middleware []Middleware
+
+	// onFrame, if not nil, is called with true once the first byte
+	// of a frame has arrived and with false once the frame was read
+	onFrame func(started bool)
+}
+
+// SetMaxMessageSize sets the largest message the Reader accepts,
+// bounding the memory a peer can make it allocate for one frame.
+// Larger frames fail with ErrFrameTooLarge. The default is
+// MaxMessageSize and n is capped at MaxFrameMessageSize and raised to
+// at least 8 bytes, the size of the close frame.
+func (s *Reader) SetMaxMessageSize(n int) {
+	s.max = clampMessageSize(n)
+}
+
+// SetStrict makes the Reader fail on what it otherwise lets through:
+// a control frame without a handler fails with ErrFrameType rather
+// than being skipped, and the close frame must end the underlying
+// stream, so the Read that gets to it waits for the stream to end
+// and fails with ErrTrailingData if more data follows.
+func (s *Reader) SetStrict(strict bool) {
+	s.strict = strict
 }
 
 // Read decrypts a stream encrypted with box.Seal.
 // It expects the nonce used to be prepended
-// to the ciphertext
-func (s Reader) Read(p []byte) (int, error) {
-	// Read the nonce from the stream
-	var nonce [NonceSize]byte
-	if _, err := io.ReadFull(s.r, nonce[:]); err != nil {
-		return 0, ErrDecrypt
+// to the ciphertext. Control frames are passed to their
+// handlers and a close frame ends the stream with io.EOF, an alert
+// frame with an *AlertError.
+// A stream that ends without a close frame, and so may have been
+// truncated, fails with io.ErrUnexpectedEOF, and one whose close
+// frame counts more frames than were received fails with
+// ErrTruncated.
+func (s *Reader) Read(p []byte) (int, error) {
+	n, _, err := s.ReadWithAD(p)
+	return n, err
+}
+
+// ReadWithAD is like Read, but also returns the additional data
+// sent with the message, if any.
+func (s *Reader) ReadWithAD(p []byte) (int, []byte, error) {
+	if s.eof {
+		return 0, nil, io.EOF
+	}
+	if s.alert != nil {
+		return 0, nil, s.alert
+	}
+
+	for {
+		t, payload, err := s.readFrame()
+		if err == errSkipped {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if t == FrameMessage {
+			if len(payload) < MessageHeaderSize {
+				return 0, nil, ErrFrame
+			}
+			s.info = parseMessageHeader(payload)
+			payload, t = payload[MessageHeaderSize:], FrameData
+		} else {
+			s.info = MessageInfo{}
+		}
+
+		switch {
+		case t == FrameData:
+			// Ensure buffer is large enough for the plaintext. The frame has
+			// already been consumed, so the stream stays in sync.
+			if len(p) < len(payload) {
+				return 0, nil, io.ErrShortBuffer
+			}
+			return copy(p, payload), s.ad, nil
+		case t == FrameClose:
+			// the close frame counts the frames sent before it,
+			// so frames dropped ahead of it are detected
+			if len(payload) != 8 || binary.BigEndian.Uint64(payload) != s.recv-1 {
+				return 0, nil, ErrTruncated
+			}
+			if s.strict {
+				var b [1]byte
+				if n, _ := io.ReadFull(s.r, b[:]); n > 0 {
+					return 0, nil, ErrTrailingData
+				}
+			}
+			s.eof = true
+			return 0, nil, io.EOF
+		case t == FrameAlert:
+			s.alert = parseAlert(payload)
+			return 0, nil, s.alert
+		case s.handlers[t] != nil:
+			if err := s.handlers[t](payload); err != nil {
+				return 0, nil, err
+			}
+		case t < FrameControl || s.strict:
+			return 0, nil, ErrFrameType
+		}
 	}
+}
+
+// WriteTo writes every message up to the end of the stream to w, so
+// that io.Copy from a Reader ends with a nil error at a close frame,
+// whatever the size of the messages.
+func (s *Reader) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, s.max)
+	var written int64
+	for {
+		n, err := s.Read(buf)
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		n, err = w.Write(buf[:n])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// readFrame reads and decrypts the next frame. The returned
+// payload is only valid until the next call.
+func (s *Reader) readFrame() (FrameType, []byte, error) {
+	// Read the nonce and ciphertext size from the stream
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(s.r, header[:1]); err != nil {
+		if err == io.EOF {
+			// the stream ended between frames, but without a close frame
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, ErrDecrypt
+	}
+	if s.onFrame != nil {
+		s.onFrame(true)
+		defer s.onFrame(false)
+	}
+	if _, err := io.ReadFull(s.r, header[1:]); err != nil {
+		return 0, nil, ErrDecrypt
+	}
+	var nonce [NonceSize]byte
+	copy(nonce[:], header[:])
+	size := binary.LittleEndian.Uint16(header[NonceSize:])
 
-	// Read the ciphertext size
-	var size uint16
-	if err := binary.Read(s.r, binary.LittleEndian, &size); err != nil {
-		return 0, ErrDecrypt
+	if int(size) < box.Overhead+frameTypeSize {
+		return 0, nil, ErrFrame
+	}
+	if int(size)-box.Overhead-frameTypeSize > s.max {
+		return 0, nil, ErrFrameTooLarge
 	}
 
-	// Ensure buffer is large enough for ciphertext
-	if uint16(len(p)) < size-box.Overhead {
-		return 0, ErrDecrypt
+	// AEAD suites send the additional data in the clear after the size
+	var adSize [2]byte
+	s.ad = nil
+	boxed := s.aead == nil
+	if s.aead != nil {
+		if _, err := io.ReadFull(s.r, adSize[:]); err != nil {
+			return 0, nil, ErrDecrypt
+		}
+		if n := int(binary.LittleEndian.Uint16(adSize[:])); n == rekeySealed {
+			boxed = true
+		} else if n > 0 {
+			if n > s.max {
+				return 0, nil, ErrFrameTooLarge
+			}
+			s.ad = make([]byte, n)
+			if _, err := io.ReadFull(s.r, s.ad); err != nil {
+				return 0, nil, ErrDecrypt
+			}
+		}
 	}
 
 	// make a buffer large enough to handle
 	// the overhead associated with an encrypted message
 	enc := make([]byte, size)
 	if _, err := io.ReadFull(s.r, enc); err != nil {
-		return 0, ErrDecrypt
+		return 0, nil, ErrDecrypt
 	}
 
-	decrypt, auth := box.OpenAfterPrecomputation(p[0:0], enc, &nonce, &s.shared)
+	if s.tap != nil {
+		raw := append(header[:], enc...)
+		if s.aead != nil {
+			raw = bytes.Join([][]byte{header[:], adSize[:], s.ad, enc}, nil)
+		}
+		s.tap(raw)
+	}
+
+	var decrypt []byte
+	auth := false
+	if !boxed {
+		var err error
+		decrypt, err = s.aead.Open(s.buf[:0], nonce[NonceSize-s.aead.NonceSize():], enc, s.ad)
+		auth = err == nil
+	} else {
+		decrypt, auth = box.OpenAfterPrecomputation(s.buf[:0], enc, &nonce, &s.shared)
+	}
 	// if authentication failed, output bottom
 	if !auth {
-		return 0, ErrDecrypt
+		if s.recovery == SkipCorrupted {
+			return 0, nil, s.skip()
+		}
+		return 0, nil, ErrDecrypt
 	}
+	s.buf = decrypt
 
-	return len(decrypt), nil
+	if s.recv > lastFrame {
+		return 0, nil, ErrNonceExhausted
+	}
+	seq := binary.BigEndian.Uint64(nonce[nonceSeqOffset:])
+	if s.dir != dirAny && nonce[nonceDirOffset] != s.dir {
+		return 0, nil, ErrFrameOrder
+	}
+	if s.window != nil {
+		if !s.window.accept(seq) {
+			return 0, nil, ErrFrameOrder
+		}
+		if seq >= s.recv {
+			s.recv = seq + 1
+		}
+	} else {
+		if seq != s.recv {
+			return 0, nil, ErrFrameOrder
+		}
+		s.recv++
+	}
+	if s.count != nil {
+		n := HeaderSize + len(enc)
+		if s.aead != nil {
+			n += len(adSize) + len(s.ad)
+		}
+		s.count(n)
+	}
+
+	t := FrameType(decrypt[0])
+	if s.aead != nil && boxed != (t == FrameRekey) {
+		return 0, nil, ErrFrame
+	}
+	if len(s.middleware) > 0 && t != FrameClose && t != FrameAlert && t != FrameRekey {
+		f, err := applyMiddleware(s.middleware, Frame{Type: t, Payload: decrypt[frameTypeSize:]})
+		if err != nil {
+			return 0, nil, err
+		}
+		return f.Type, f.Payload, nil
+	}
+	return t, decrypt[frameTypeSize:], nil
 }
 
 // A Writer is an io.Writer which will encrypt the provided data
 // and write it to the provided wrapped io.Writer
 type Writer struct {
-	w         io.Writer
-	priv, pub *[KeySize]byte
-	shared    [KeySize]byte
+	w          io.Writer
+	priv, pub  *[KeySize]byte
+	shared     [KeySize]byte
+	max        int
+	chunk      bool
+	tap        func(frame []byte)
+	closed     bool
+	sent       uint64
+	nonces     nonceSequence
+	aead       cipher.AEAD
+	salt       []byte
+	count      func(size int)
+	middleware []Middleware
+
+	// resalt, if not nil, is the salt of the key the Writer switches
+	// to, resaltAEAD, once it has announced it with FrameRekey ahead
+	// of its next frame, calling rekeyed if set
+	resalt     []byte
+	resaltAEAD cipher.AEAD
+	rekeyed    func()
+}
+
+// SetMaxMessageSize sets the largest message the Writer puts in one
+// frame. The default is MaxMessageSize and n is capped at
+// MaxFrameMessageSize and raised to at least 8 bytes, the size of the
+// close frame. It should not exceed the peer Reader's limit.
+func (s *Writer) SetMaxMessageSize(n int) {
+	s.max = clampMessageSize(n)
+}
+
+// SetChunking controls what Write does with data larger than the
+// maximum message size: split it across several frames if chunk
+// is true, or fail with ErrFrameTooLarge (the default).
+func (s *Writer) SetChunking(chunk bool) {
+	s.chunk = chunk
+}
+
+// Write encrypts a plaintext stream using box.Seal.
+// The nonce, length and ciphertext are handed to the wrapped
+// io.Writer together as net.Buffers, so writers that support
+// vectored I/O (such as *net.TCPConn) emit each frame with one writev.
+func (s *Writer) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrWriterClosed
+	}
+	if len(p) > s.max && !s.chunk {
+		return 0, ErrFrameTooLarge
+	}
+
+	n := 0
+	for {
+		chunk := p[n:]
+		if len(chunk) > s.max {
+			chunk = chunk[:s.max]
+		}
+
+		if err := s.WriteFrame(FrameData, chunk); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+}
+
+// WriteWithAD encrypts p and writes it as one message together with
+// the additional data ad, which is sent in the clear but authenticated
+// along with p, so that it can carry routing headers or message IDs.
+// The peer gets it from ReadWithAD. Messages with additional data are
+// never chunked. Writers using SuiteBox fail with ErrNoAD.
+func (s *Writer) WriteWithAD(p, ad []byte) (int, error) {
+	if len(ad) > s.max {
+		return 0, ErrFrameTooLarge
+	}
+	if err := s.writeFrame(FrameData, p, ad); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteMessagev encrypts the concatenation of bufs and writes it as
+// one message, without the caller joining them first, so that headers
+// and bodies built separately go out in a single frame. The peer reads
+// it as one message. Unlike Write it is never chunked: bufs larger
+// than the maximum message size together fail with ErrFrameTooLarge.
+func (s *Writer) WriteMessagev(bufs [][]byte) (int, error) {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	if n > s.max {
+		return 0, ErrFrameTooLarge
+	}
+	if err := s.writeFramev(FrameData, bufs, nil); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// WriteFrame encrypts payload and writes it as a single frame of type t.
+// Applications use it to send control frames of types FrameControl and
+// up, which the peer passes to the handler registered with Handle.
+func (s *Writer) WriteFrame(t FrameType, payload []byte) error {
+	return s.writeFrame(t, payload, nil)
 }
 
-// Write encrypts a plaintext stream using box.Seal
-func (s Writer) Write(p []byte) (int, error) {
-	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		return 0, errors.New("secureWriter: cant generate random nonce: " + err.Error())
+func (s *Writer) writeFrame(t FrameType, payload, ad []byte) error {
+	if len(payload) > s.max {
+		return ErrFrameTooLarge
+	}
+	return s.writeFramev(t, [][]byte{payload}, ad)
+}
+
+// writeFramev writes a frame of type t carrying the concatenation
+// of parts, which must fit in a message
+func (s *Writer) writeFramev(t FrameType, parts [][]byte, ad []byte) error {
+	if s.closed {
+		return ErrWriterClosed
+	}
+
+	if len(ad) > 0 && s.aead == nil {
+		return ErrNoAD
+	}
+	if s.resalt != nil {
+		salt, aead := s.resalt, s.resaltAEAD
+		s.resalt, s.resaltAEAD = nil, nil
+		if err := s.writeFramev(FrameRekey, [][]byte{salt}, nil); err != nil {
+			return err
+		}
+		s.aead, s.salt = aead, salt
+		if s.rekeyed != nil {
+			s.rekeyed()
+		}
+	}
+	t, parts, err := s.sendMiddleware(t, parts)
+	if err != nil {
+		return err
+	}
+	if t != FrameClose && s.nonces.exhausted() {
+		return ErrNonceExhausted
+	}
+	nonce, err := s.nonces.next()
+	if err != nil {
+		return err
+	}
+	frame := s.sealv(t, parts, ad, nonce)
+
+	var raw []byte
+	if s.tap != nil {
+		// WriteTo consumes frame, so copy it first
+		raw = bytes.Join(frame, nil)
 	}
 
-	// write nonce
-	_, err := s.w.Write(nonce[:])
+	// write nonce, length and ciphertext
+	n, err := frame.WriteTo(s.w)
 	if err != nil {
-		return 0, ErrEncWrite
+		return ErrEncWrite
+	}
+	s.sent++
+	if s.count != nil {
+		s.count(int(n))
+	}
+
+	if s.tap != nil {
+		s.tap(raw)
+	}
+	return nil
+}
+
+// Close ends the stream with a close frame carrying the number of
+// frames written before it, so the peer Reader returns io.EOF instead
+// of io.ErrUnexpectedEOF, or ErrTruncated if frames went missing.
+// It does not close the wrapped io.Writer. Later writes fail with
+// ErrWriterClosed.
+func (s *Writer) Close() error {
+	if s.closed {
+		return nil
 	}
+	var count [8]byte
+	binary.BigEndian.PutUint64(count[:], s.sent)
+	err := s.WriteFrame(FrameClose, count[:])
+	s.closed = true
+	return err
+}
 
-	enc := box.SealAfterPrecomputation(nil, p, &nonce, &s.shared)
+// seal encrypts a frame of type t carrying p, along with the
+// additional data ad, under nonce without writing it
+func (s *Writer) seal(t FrameType, p, ad []byte, nonce *[NonceSize]byte) net.Buffers {
+	return s.sealv(t, [][]byte{p}, ad, nonce)
+}
 
-	// write ciphertext length
-	if err := binary.Write(s.w, binary.LittleEndian, uint16(len(enc))); err != nil {
-		return 0, ErrEncWrite
+// sealv is seal for the concatenation of parts
+func (s *Writer) sealv(t FrameType, parts [][]byte, ad []byte, nonce *[NonceSize]byte) net.Buffers {
+	n := frameTypeSize
+	for _, p := range parts {
+		n += len(p)
+	}
+	plain := make([]byte, frameTypeSize, n)
+	plain[0] = byte(t)
+	for _, p := range parts {
+		plain = append(plain, p...)
 	}
 
-	// write ciphertext
-	if _, err = s.w.Write(enc); err != nil {
-		return 0, ErrEncWrite
+	var enc []byte
+	if s.aead != nil && t != FrameRekey {
+		enc = s.aead.Seal(nil, nonce[NonceSize-s.aead.NonceSize():], plain, ad)
+	} else {
+		enc = box.SealAfterPrecomputation(nil, plain, nonce, &s.shared)
+	}
+
+	// ciphertext length
+	var size [2]byte
+	binary.LittleEndian.PutUint16(size[:], uint16(len(enc)))
+
+	if s.aead != nil {
+		var adSize [2]byte
+		binary.LittleEndian.PutUint16(adSize[:], uint16(len(ad)))
+		if t == FrameRekey {
+			binary.LittleEndian.PutUint16(adSize[:], rekeySealed)
+		}
+		return net.Buffers{nonce[:], size[:], adSize[:], ad, enc}
 	}
+	return net.Buffers{nonce[:], size[:], enc}
+}
 
-	return len(p), err
+// SealedSize returns the number of bytes a Writer emits for a message
+// of plaintextLen bytes sealed with SuiteBox, the suite of Writers made
+// by NewWriter. Suite.SealedSize covers the other suites.
+func SealedSize(plaintextLen int) int {
+	return plaintextLen + Overhead
+}
+
+// MaxPlaintext returns the largest message that fits in a frame
+// of frameSize bytes, or 0 if not even an empty message fits.
+// The result never exceeds MaxMessageSize.
+func MaxPlaintext(frameSize int) int {
+	n := frameSize - Overhead
+	if n < 0 {
+		return 0
+	}
+	if n > MaxMessageSize {
+		return MaxMessageSize
+	}
+	return n
 }
 
 // NewReader instantiates a new secure Reader
 // priv and pub should be keys generated with box.GenerateKey
-func NewReader(r io.Reader, priv, pub *[KeySize]byte) Reader {
-	sr := Reader{r: r, priv: priv, pub: pub}
+func NewReader(r io.Reader, priv, pub *[KeySize]byte) *Reader {
+	sr := &Reader{r: r, priv: priv, pub: pub, max: MaxMessageSize}
 	box.Precompute(&sr.shared, pub, priv)
 	return sr
 }
 
 // NewWriter instantiates a new secure Writer
 // priv and pub should be keys generated with box.GenerateKey
-func NewWriter(w io.Writer, priv, pub *[KeySize]byte) Writer {
-	sw := Writer{w: w, priv: priv, pub: pub}
+func NewWriter(w io.Writer, priv, pub *[KeySize]byte) *Writer {
+	sw := &Writer{w: w, priv: priv, pub: pub, max: MaxMessageSize}
 	box.Precompute(&sw.shared, pub, priv)
 	return sw
 }
+
+// newSharedReader instantiates a secure Reader from an already
+// computed shared key
+func newSharedReader(r io.Reader, shared *[KeySize]byte) *Reader {
+	return &Reader{r: r, shared: *shared, max: MaxMessageSize}
+}
+
+// newSharedWriter instantiates a secure Writer from an already
+// computed shared key
+func newSharedWriter(w io.Writer, shared *[KeySize]byte) *Writer {
+	return &Writer{w: w, shared: *shared, max: MaxMessageSize}
+}
+
+func clampMessageSize(n int) int {
+	if n <= 0 {
+		return MaxMessageSize
+	}
+	if n > MaxFrameMessageSize {
+		return MaxFrameMessageSize
+	}
+	if n < minMessageSize {
+		return minMessageSize
+	}
+	return n
+}
@@ -1,10 +1,15 @@
 package secure
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/iotest"
+	"testing/quick"
 )
 
 func TestReadWriterPing(t *testing.T) {
@@ -78,3 +83,201 @@ func TestSecureWriter(t *testing.T) {
 	}
 
 }
+
+func TestWriteMessagev(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, priv, pub)
+	n, err := w.WriteMessagev([][]byte{[]byte("header:"), nil, []byte("body")})
+	if err != nil || n != len("header:body") {
+		t.Fatalf("Unexpected write: %d, %v", n, err)
+	}
+	if buf.Len() != SealedSize(n) {
+		t.Fatalf("Expected one frame of %d bytes, got %d", SealedSize(n), buf.Len())
+	}
+	if _, err := w.WriteMessagev([][]byte{make([]byte, MaxMessageSize), {0}}); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+
+	p := make([]byte, 64)
+	n, err = NewReader(&buf, priv, pub).Read(p)
+	if err != nil || string(p[:n]) != "header:body" {
+		t.Fatalf("Unexpected read: %q, %v", p[:n], err)
+	}
+}
+
+func TestSealedSize(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	for _, size := range []int{0, 1, 12, MaxMessageSize} {
+		var buf bytes.Buffer
+		if _, err := NewWriter(&buf, priv, pub).Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != SealedSize(size) {
+			t.Fatalf("Unexpected sealed size for %d: %d != %d", size, buf.Len(), SealedSize(size))
+		}
+		if n := MaxPlaintext(buf.Len()); n != size {
+			t.Fatalf("Unexpected max plaintext for %d: %d != %d", buf.Len(), n, size)
+		}
+	}
+
+	if n := MaxPlaintext(Overhead - 1); n != 0 {
+		t.Fatalf("Unexpected max plaintext for a tiny frame: %d", n)
+	}
+	if n := MaxPlaintext(1 << 20); n != MaxMessageSize {
+		t.Fatalf("Unexpected max plaintext for a huge frame: %d", n)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	secureW.SetMaxMessageSize(16)
+
+	if _, err := secureW.Write(make([]byte, 17)); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+	if wire.Len() != 0 {
+		t.Fatal("Unexpected result. A rejected write reached the wire.")
+	}
+
+	secureW.SetChunking(true)
+	if n, err := secureW.Write(make([]byte, 40)); n != 40 || err != nil {
+		t.Fatalf("Unexpected chunked write result: %d, %v", n, err)
+	}
+	if wire.Len() != 2*SealedSize(16)+SealedSize(8) {
+		t.Fatalf("Unexpected wire size for chunked write: %d", wire.Len())
+	}
+
+	// a Reader with a smaller limit must refuse the frames
+	secureR := NewReader(&wire, priv, pub)
+	secureR.SetMaxMessageSize(8)
+	buf := make([]byte, 1024)
+	if _, err := secureR.Read(buf); err != ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+// A roundTrip is a payload written to a Writer in pieces of the sizes
+// in Writes, sealed in messages of at most MaxMessage bytes, and read
+// back in pieces of the sizes in Reads
+type roundTrip struct {
+	Payload      []byte
+	Writes       []int
+	Reads        []int
+	MaxMessage   int
+	OneByteWire  bool
+	ReadsPerByte bool
+}
+
+// Generate makes round trips biased towards the pathological cases:
+// tiny messages, 1-byte writes and reads, and a transport handing over
+// one byte at a time.
+func (roundTrip) Generate(r *rand.Rand, size int) reflect.Value {
+	rt := roundTrip{
+		Payload:      make([]byte, r.Intn(4*size+1)),
+		MaxMessage:   1 + r.Intn(size+1),
+		OneByteWire:  r.Intn(4) == 0,
+		ReadsPerByte: r.Intn(4) == 0,
+	}
+	r.Read(rt.Payload)
+	if r.Intn(2) == 0 {
+		rt.MaxMessage = MaxMessageSize
+	}
+	for n := 0; n < len(rt.Payload); {
+		w := 1
+		if r.Intn(3) != 0 {
+			w += r.Intn(2 * size)
+		}
+		rt.Writes = append(rt.Writes, w)
+		n += w
+	}
+	for i := 0; i < 8; i++ {
+		read := 1
+		if !rt.ReadsPerByte {
+			read += r.Intn(2 * size)
+		}
+		rt.Reads = append(rt.Reads, read)
+	}
+	return reflect.ValueOf(rt)
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	identity := func(rt roundTrip) bool {
+		var wire bytes.Buffer
+		w := NewWriter(&wire, priv, pub)
+		w.SetMaxMessageSize(rt.MaxMessage)
+		w.SetChunking(true)
+		rest := rt.Payload
+		for _, n := range rt.Writes {
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Log(err)
+				return false
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Log(err)
+			return false
+		}
+
+		var transport io.Reader = &wire
+		if rt.OneByteWire {
+			transport = iotest.OneByteReader(transport)
+		}
+		r := NewReader(transport, priv, pub)
+		r.SetMaxMessageSize(rt.MaxMessage)
+
+		// a Reader returns whole messages, so reads
+		// smaller than them go through a frameBuffer
+		fb := &frameBuffer{r: r}
+		var got []byte
+		for i := 0; ; i++ {
+			buf := make([]byte, rt.Reads[i%len(rt.Reads)])
+			n, err := fb.Read(buf)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Log(err)
+				return false
+			}
+		}
+		return bytes.Equal(got, rt.Payload)
+	}
+	if err := quick.Check(identity, &quick.Config{MaxCount: 300}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShortBufferProperty(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	// a buffer smaller than the message fails the Read
+	// rather than silently returning part of it
+	short := func(msg []byte, shortBy uint16) bool {
+		if len(msg) == 0 || len(msg) > MaxMessageSize {
+			return true
+		}
+		var wire bytes.Buffer
+		if _, err := NewWriter(&wire, priv, pub).Write(msg); err != nil {
+			return false
+		}
+		buf := make([]byte, len(msg)-1-int(shortBy)%len(msg))
+		n, err := NewReader(&wire, priv, pub).Read(buf)
+		return n == 0 && err == io.ErrShortBuffer
+	}
+	if err := quick.Check(short, nil); err != nil {
+		t.Fatal(err)
+	}
+}
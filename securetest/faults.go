@@ -0,0 +1,168 @@
+// Package securetest provides transports that inject faults, for
+// testing code built on package secure, and package secure itself,
+// against slow, fragmenting, corrupting and failing networks.
+//
+// A faulty transport goes underneath a secure.Conn:
+//
+//	conn := secure.NewClientConn(securetest.NewConn(raw, securetest.Faults{
+//		MaxRead:  7,
+//		FlipRate: 0.001,
+//		Seed:     42,
+//	}), config)
+//
+// The faults are drawn from a generator seeded with Faults.Seed, so
+// that a failing test can be reproduced by running it with the same
+// seed, as long as its reads and writes happen in the same order.
+package securetest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected means that a connection was closed by an injected
+// disconnect
+var ErrDisconnected = errors.New("securetest: injected disconnect")
+
+// Faults describes the faults a Conn injects. The zero value
+// injects none.
+type Faults struct {
+	// Latency delays every Read and Write.
+	Latency time.Duration
+
+	// MaxWrite, if not zero, splits every Write into writes of at
+	// most MaxWrite bytes to the underlying connection, so that the
+	// peer sees data arrive in fragments.
+	MaxWrite int
+
+	// MaxRead, if not zero, makes every Read return at most
+	// MaxRead bytes.
+	MaxRead int
+
+	// FlipRate is the probability that a byte read has one of its
+	// bits flipped.
+	FlipRate float64
+
+	// DisconnectRate is the probability that a Read or Write closes
+	// the underlying connection and fails with ErrDisconnected.
+	DisconnectRate float64
+
+	// Seed seeds the generator the faults are drawn from.
+	Seed int64
+}
+
+// A Conn is a net.Conn that injects faults into the connection
+// it wraps. It is safe for concurrent use.
+type Conn struct {
+	net.Conn
+	faults Faults
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewConn returns a Conn injecting the given faults into c.
+func NewConn(c net.Conn, faults Faults) *Conn {
+	return &Conn{Conn: c, faults: faults, rand: rand.New(rand.NewSource(faults.Seed))}
+}
+
+// chance reports whether an event of probability p happens
+func (c *Conn) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < p
+}
+
+// disconnect closes the connection if an injected disconnect happens
+func (c *Conn) disconnect() bool {
+	if !c.chance(c.faults.DisconnectRate) {
+		return false
+	}
+	c.Conn.Close()
+	return true
+}
+
+// Read reads from the wrapped connection, injecting the faults.
+func (c *Conn) Read(p []byte) (int, error) {
+	time.Sleep(c.faults.Latency)
+	if c.disconnect() {
+		return 0, ErrDisconnected
+	}
+	if c.faults.MaxRead > 0 && len(p) > c.faults.MaxRead {
+		p = p[:c.faults.MaxRead]
+	}
+
+	n, err := c.Conn.Read(p)
+	if c.faults.FlipRate > 0 {
+		c.mu.Lock()
+		for i := range p[:n] {
+			if c.rand.Float64() < c.faults.FlipRate {
+				p[i] ^= 1 << uint(c.rand.Intn(8))
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write writes to the wrapped connection, injecting the faults.
+func (c *Conn) Write(p []byte) (int, error) {
+	time.Sleep(c.faults.Latency)
+	if c.disconnect() {
+		return 0, ErrDisconnected
+	}
+	if c.faults.MaxWrite <= 0 {
+		return c.Conn.Write(p)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > c.faults.MaxWrite {
+			chunk = chunk[:c.faults.MaxWrite]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type listener struct {
+	net.Listener
+	faults Faults
+
+	mu   sync.Mutex
+	seed int64
+}
+
+// NewListener returns a Listener whose accepted connections inject
+// the given faults. Each connection's generator is seeded with
+// faults.Seed plus the number of connections accepted before it.
+func NewListener(l net.Listener, faults Faults) net.Listener {
+	return &listener{Listener: l, faults: faults, seed: faults.Seed}
+}
+
+// Accept waits for and returns the next connection, of type *Conn.
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	faults := l.faults
+	faults.Seed = l.seed
+	l.seed++
+	l.mu.Unlock()
+	return NewConn(c, faults), nil
+}
@@ -0,0 +1,112 @@
+package securetest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func pipe(t *testing.T, faults Faults) (*Conn, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewConn(client, faults), server
+}
+
+func TestConnFragments(t *testing.T) {
+	c, peer := pipe(t, Faults{MaxWrite: 3, MaxRead: 2})
+	msg := []byte("hello world")
+
+	// net.Pipe delivers each write to one read, so fragments show
+	go func() {
+		buf := make([]byte, 64)
+		for i := 0; i < len(msg); {
+			n, err := peer.Read(buf)
+			if err != nil || n > 3 {
+				t.Errorf("Unexpected fragment: %d, %v", n, err)
+				return
+			}
+			i += n
+		}
+		peer.Write(msg)
+	}()
+
+	if n, err := c.Write(msg); err != nil || n != len(msg) {
+		t.Fatalf("Unexpected result: %d, %v", n, err)
+	}
+	buf := make([]byte, 64)
+	if n, err := c.Read(buf); err != nil || n != 2 {
+		t.Fatalf("Expected a short read, got %d, %v", n, err)
+	}
+}
+
+func TestConnFlips(t *testing.T) {
+	c, peer := pipe(t, Faults{FlipRate: 1, Seed: 1})
+	msg := bytes.Repeat([]byte{0x55}, 16)
+	go peer.Write(msg)
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range buf {
+		// exactly one bit of every byte is flipped
+		if d := b ^ 0x55; d == 0 || d&(d-1) != 0 {
+			t.Fatalf("Unexpected byte %d: %#x", i, b)
+		}
+	}
+}
+
+func TestConnDisconnect(t *testing.T) {
+	c, peer := pipe(t, Faults{DisconnectRate: 1})
+	if _, err := c.Write([]byte("hello")); err != ErrDisconnected {
+		t.Fatalf("Expected ErrDisconnected, got %v", err)
+	}
+	// the peer sees the connection close
+	if _, err := ioutil.ReadAll(peer); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnLatency(t *testing.T) {
+	c, peer := pipe(t, Faults{Latency: 20 * time.Millisecond})
+	go ioutil.ReadAll(peer)
+
+	start := time.Now()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("Write took %v", d)
+	}
+}
+
+func TestListenerSeeds(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, Faults{Seed: 10})
+	defer l.Close()
+
+	for want := int64(10); want < 12; want++ {
+		client, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if seed := c.(*Conn).faults.Seed; seed != want {
+			t.Fatalf("Expected seed %d, got %d", want, seed)
+		}
+	}
+}
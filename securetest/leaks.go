@@ -0,0 +1,67 @@
+package securetest
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakGrace is how long goroutines get to exit
+// after the test before they count as leaked
+var leakGrace = time.Second
+
+// CheckGoroutines fails t if goroutines started during the test are
+// still running after it, once its deferred calls have run, such as
+// those of a connection that was not closed or of a handler that does
+// not return when its connection is. They get a second to exit. It
+// cannot tell goroutines of parallel tests apart, so tests using it
+// must not call t.Parallel.
+func CheckGoroutines(t testing.TB) {
+	before := goroutines()
+	t.Cleanup(func() {
+		var leaked []string
+		deadline := time.Now().Add(leakGrace)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(leaked) > 0 {
+			sort.Strings(leaked)
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// goroutines returns the stacks of the running goroutines by their ID
+func goroutines() map[string]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		// each stack starts with "goroutine <id> [<state>]:"
+		fields := strings.Fields(string(stack))
+		if len(fields) > 1 && fields[0] == "goroutine" {
+			stacks[fields[1]] = string(stack)
+		}
+	}
+	return stacks
+}
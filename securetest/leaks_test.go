@@ -0,0 +1,52 @@
+package securetest
+
+import (
+	"testing"
+	"time"
+)
+
+// A recorder is a testing.TB that records failures
+// and runs the cleanups when asked to
+type recorder struct {
+	testing.TB
+	cleanups []func()
+	failed   bool
+}
+
+func (r *recorder) Cleanup(fn func())                         { r.cleanups = append(r.cleanups, fn) }
+func (r *recorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestCheckGoroutines(t *testing.T) {
+	defer func(grace time.Duration) { leakGrace = grace }(leakGrace)
+	leakGrace = 50 * time.Millisecond
+
+	// a goroutine that exits in time is not a leak
+	r := &recorder{TB: t}
+	CheckGoroutines(r)
+	done := make(chan struct{})
+	go func() { <-done }()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(done)
+	}()
+	r.finish()
+	if r.failed {
+		t.Fatal("Unexpected result. A goroutine that exited was reported.")
+	}
+
+	r = &recorder{TB: t}
+	CheckGoroutines(r)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	go func() { <-stuck }()
+	r.finish()
+	if !r.failed {
+		t.Fatal("Unexpected result. A leaked goroutine was not reported.")
+	}
+}
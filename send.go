@@ -0,0 +1,77 @@
+package secure
+
+import (
+	"context"
+	"time"
+)
+
+// SendOptions configures Send. A nil *SendOptions sends over TCP
+// with the default Config and no timeout.
+type SendOptions struct {
+	// Network is passed to Dial. If empty, "tcp" is used.
+	Network string
+
+	// Config configures the connection. It may be nil.
+	Config *Config
+
+	// Timeout, if not zero, bounds the whole exchange: dialing, the
+	// handshake, sending the message and waiting for the response.
+	// Send fails with context.DeadlineExceeded once it has expired.
+	Timeout time.Duration
+}
+
+// Send connects to addr, performs the handshake, sends msg as one
+// message, reads one message in response and closes the connection,
+// for the request/response exchanges that need no more than that.
+// The response can be as large as the Config's MaxMessageSize.
+func Send(addr string, msg []byte, opts *SendOptions) (resp []byte, err error) {
+	if opts == nil {
+		opts = new(SendOptions)
+	}
+	network := opts.Network
+	if network == "" {
+		network = "tcp"
+	}
+	config := opts.Config
+	if config == nil {
+		config = defaultConfig()
+	}
+
+	ctx, span := config.startSpan(context.Background(), SpanSend)
+	span.SetAttribute("secure.request_size", len(msg))
+	defer func() {
+		span.SetAttribute("secure.response_size", len(resp))
+		span.End(err)
+	}()
+
+	var deadline time.Time
+	if opts.Timeout != 0 {
+		deadline = time.Now().Add(opts.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	c, err := (&Dialer{Config: config}).dial(ctx, network, addr, false)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, c.r.max)
+	n, err := c.Read(buf)
+	if err != nil {
+		// the Reader reports a transport timeout as a failed frame
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,60 @@
+package secure
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(NewListener(l, nil))
+
+	msg := bytes.Repeat([]byte("hello"), 1000)
+	got, err := Send(l.Addr().String(), msg, &SendOptions{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Unexpected response of %d bytes", len(got))
+	}
+
+	// the defaults work too
+	if got, err := Send(l.Addr().String(), []byte("ping"), nil); err != nil || string(got) != "ping" {
+		t.Fatalf("Unexpected result: %q, %v", got, err)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	// the server reads the request but never responds
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			go c.Read(make([]byte, MaxMessageSize))
+		}
+	}()
+
+	start := time.Now()
+	_, err = Send(l.Addr().String(), []byte("ping"), &SendOptions{Timeout: 100 * time.Millisecond})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Send took %v", d)
+	}
+}
@@ -0,0 +1,547 @@
+package secure
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Handler serves a secure connection. The connection is closed
+// when ServeConn returns.
+type Handler interface {
+	ServeConn(c *Conn)
+}
+
+// The HandlerFunc type is an adapter to allow the use of
+// ordinary functions as connection handlers.
+type HandlerFunc func(c *Conn)
+
+// ServeConn calls f(c).
+func (f HandlerFunc) ServeConn(c *Conn) {
+	f(c)
+}
+
+// A Server accepts secure connections and serves each of them
+// with Handler in its own goroutine, or with a fixed pool of
+// goroutines if Workers is set.
+type Server struct {
+	// Config configures the accepted connections. It may be nil.
+	// If the Handler is a *Mux and Config does not list any
+	// NextProtos, the Mux's protocols are offered.
+	Config *Config
+
+	// Handler serves every accepted connection.
+	Handler Handler
+
+	// Fallback, if not nil, serves clients that do not speak this
+	// protocol, such as the plaintext clients of a port being moved
+	// over to it. The server then waits for each client to send the
+	// Preamble before handshaking, so secure clients must set
+	// Config.SendPreamble, and plaintext clients must speak first.
+	// Fallback is passed the connection with nothing consumed from it,
+	// and the connection is closed when Fallback returns.
+	Fallback func(c net.Conn)
+
+	// RequireEncryption makes a Server with a Fallback close plaintext
+	// connections instead of serving them, once Stats shows that all
+	// clients have moved to this protocol. Plaintext clients are
+	// reported to the AuditHook with ErrPlaintext either way.
+	RequireEncryption bool
+
+	// MaxConnGoroutines, if not zero, bounds the goroutines each
+	// connection can run with Conn.Go at any one time, counting the
+	// one sending Heartbeat and the one reading ahead with
+	// Config.ReadAhead. A connection without room for the latter
+	// when its handshake completes reads without read-ahead.
+	MaxConnGoroutines int
+
+	// Workers, if not zero, serves connections on that many goroutines
+	// instead of one per connection, so that a flood of connections
+	// cannot overwhelm the scheduler. Accepted connections wait for a
+	// free worker in a queue of Backlog connections, or of Workers
+	// connections if Backlog is zero, and Shed decides what happens
+	// to new ones while it is full.
+	Workers int
+	Backlog int
+	Shed    ShedPolicy
+
+	// Quota, if not nil, bounds the connections and message bytes
+	// of each client, named by the fingerprint of its public key.
+	// Connections over quota are closed with AlertQuota once the
+	// handshake has identified the client.
+	Quota *Quota
+
+	// Authorize, if not nil, is called once the handshake has
+	// identified each client, before its connection is handed to the
+	// Handler, and closes the connection if it returns an error. The
+	// client's key, certificate and ClientInfo are available from
+	// ConnectionState, so that multi-tenant servers can decide on the
+	// tenant the client names. The client is told with an alert,
+	// AlertUnauthorized unless Authorize returns an *AlertError.
+	Authorize func(c *Conn) error
+
+	// MaxMemory, if not zero, bounds the buffer memory of all the
+	// connections being served or waiting for a worker together, so
+	// that the server's memory use stays predictable under load. Each
+	// connection counts for the most its Config lets it hold, as
+	// bounded by Config.MaxConnMemory. New connections that do not
+	// fit are closed unserved.
+	MaxMemory int64
+
+	// Heartbeat, if not zero, is how often the Server pings each
+	// client, as SendPing does, so that connections whose client
+	// vanished without closing them, such as a laptop put to sleep,
+	// are noticed. Once a ping has gone unanswered for HeartbeatGrace,
+	// or three heartbeats if it is zero, the connection is closed,
+	// its Reads and Writes fail with ErrDeadPeer and the AuditHook is
+	// called. Pongs are handled by Read, so handlers must keep reading
+	// or use Config.ReadAhead. Connections are then handshaken before
+	// they are handed to the Handler. The heartbeats are sent from a
+	// goroutine of the connection's, which counts towards
+	// MaxConnGoroutines: connections without room for it are refused.
+	Heartbeat      time.Duration
+	HeartbeatGrace time.Duration
+
+	// ProfileLabels labels the goroutines serving each connection,
+	// and those its handler starts, with LabelConn and, once the
+	// handshake has identified the client, LabelPeer, so that CPU
+	// and goroutine profiles can be broken down by connection and
+	// client. Connections are then handshaken before they are
+	// handed to the Handler.
+	ProfileLabels bool
+
+	// Profiling makes DebugHandler serve the runtime profiles.
+	Profiling bool
+
+	// conns numbers the connections for ProfileLabels
+	conns uint64
+
+	mu sync.Mutex
+
+	// config is the Config for new connections, as
+	// resolved from Config or given to Reload
+	config *Config
+
+	// listeners and active are the listeners being served and the
+	// connections accepted but not yet served, for Shutdown and Close
+	listeners map[net.Listener]struct{}
+	active    map[net.Conn]int64
+	closed    bool
+
+	// memory is the buffer memory of the active connections
+	memory int64
+
+	statsMu sync.Mutex
+	stats   ServerStats
+
+	// goroutines counts those serving connections
+	goroutines int64
+}
+
+// A ShedPolicy is what a Server with Workers does with
+// connections while its accept queue is full.
+type ShedPolicy int
+
+// Shed policies
+const (
+	// ShedWait stops accepting connections until there is room in
+	// the queue, leaving new ones in the operating system's backlog.
+	ShedWait ShedPolicy = iota
+
+	// ShedNewest closes new connections unserved.
+	ShedNewest
+
+	// ShedOldest closes the connection that has waited longest in the
+	// queue unserved, to make room for the new one, so that clients
+	// that have already given up are the ones dropped.
+	ShedOldest
+)
+
+// ServerStats counts the connections a Server has accepted, by how
+// they were served. Only a Server with a Fallback sorts them into
+// encrypted and plaintext ones, and only one with Workers sheds them.
+type ServerStats struct {
+	// Encrypted connections completed the handshake.
+	Encrypted uint64
+
+	// HandshakeFailed connections sent the Preamble
+	// but failed the handshake.
+	HandshakeFailed uint64
+
+	// Plaintext connections were served by the Fallback.
+	Plaintext uint64
+
+	// Refused connections were plaintext ones
+	// closed because of RequireEncryption.
+	Refused uint64
+
+	// Shed connections were closed unserved
+	// because the accept queue was full.
+	Shed uint64
+
+	// OverQuota connections were closed unserved
+	// because their client was over its Quota.
+	OverQuota uint64
+
+	// Unauthorized connections were closed unserved
+	// because Authorize rejected them.
+	Unauthorized uint64
+
+	// Reaped connections were closed because
+	// their client stopped answering heartbeats.
+	Reaped uint64
+
+	// OverMemory connections were closed unserved
+	// because they did not fit in MaxMemory.
+	OverMemory uint64
+}
+
+// ErrPlaintext means that a client of a Server with a Fallback
+// did not send the Preamble
+var ErrPlaintext = errors.New("client did not encrypt")
+
+// Stats returns the connection counts so far.
+func (srv *Server) Stats() ServerStats {
+	srv.statsMu.Lock()
+	defer srv.statsMu.Unlock()
+	return srv.stats
+}
+
+// Goroutines returns the number of goroutines serving connections:
+// one per connection being served, those sending Heartbeat and
+// reading ahead with Config.ReadAhead, and those its handler started
+// with Conn.Go. A Server whose listener was closed has no goroutines
+// left once every connection's handler and goroutines have returned,
+// which tests can use to check that connections do not leak them.
+func (srv *Server) Goroutines() int {
+	return int(atomic.LoadInt64(&srv.goroutines))
+}
+
+func (srv *Server) track(delta int64) {
+	atomic.AddInt64(&srv.goroutines, delta)
+}
+
+// A queuedConn is an accepted connection and the function serving it
+type queuedConn struct {
+	conn  net.Conn
+	serve func()
+}
+
+// A dispatcher hands accepted connections to goroutines serving them:
+// a new one for each, or the workers reading queue.
+type dispatcher struct {
+	srv   *Server
+	queue chan queuedConn
+}
+
+// dispatcher returns the dispatcher for one call to Serve,
+// starting its workers
+func (srv *Server) dispatcher() *dispatcher {
+	d := &dispatcher{srv: srv}
+	if srv.Workers <= 0 {
+		return d
+	}
+
+	backlog := srv.Backlog
+	if backlog <= 0 {
+		backlog = srv.Workers
+	}
+	d.queue = make(chan queuedConn, backlog)
+	for i := 0; i < srv.Workers; i++ {
+		go func() {
+			for qc := range d.queue {
+				srv.track(1)
+				qc.serve()
+				srv.track(-1)
+			}
+		}()
+	}
+	return d
+}
+
+// dispatch has conn served by fn on a goroutine accounted to the
+// Server, once a worker is free if there are workers, holding memory
+// bytes of its MaxMemory
+func (d *dispatcher) dispatch(conn net.Conn, memory int64, fn func()) {
+	if !d.srv.hold(conn, memory) {
+		conn.Close()
+		d.srv.count(&d.srv.stats.OverMemory)
+		return
+	}
+	serve := func() {
+		defer d.srv.release(conn)
+		fn()
+	}
+	if d.queue == nil {
+		d.srv.track(1)
+		go func() {
+			defer d.srv.track(-1)
+			serve()
+		}()
+		return
+	}
+
+	qc := queuedConn{conn, serve}
+	switch d.srv.Shed {
+	case ShedNewest:
+		select {
+		case d.queue <- qc:
+		default:
+			d.shed(conn)
+		}
+	case ShedOldest:
+		for {
+			select {
+			case d.queue <- qc:
+				return
+			default:
+			}
+			select {
+			case oldest := <-d.queue:
+				d.shed(oldest.conn)
+			default:
+			}
+		}
+	default:
+		d.queue <- qc
+	}
+}
+
+func (d *dispatcher) shed(conn net.Conn) {
+	conn.Close()
+	d.srv.release(conn)
+	d.srv.count(&d.srv.stats.Shed)
+}
+
+// stop lets the workers exit once they have served
+// the connections left in the queue
+func (d *dispatcher) stop() {
+	if d.queue != nil {
+		close(d.queue)
+	}
+}
+
+// accounted makes the goroutines c starts count for srv
+func (srv *Server) accounted(c *Conn) *Conn {
+	c.maxGoroutines = int32(srv.MaxConnGoroutines)
+	c.track = srv.track
+	return c
+}
+
+// admit authorizes c, enforces the Quota on it and starts its
+// heartbeats, identifying its client with the handshake, and returns
+// the function to call once c is served
+func (srv *Server) admit(c *Conn) (release func(), err error) {
+	if srv.Quota == nil && srv.Authorize == nil && srv.Heartbeat <= 0 {
+		return func() {}, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	if srv.Authorize != nil {
+		if err := srv.Authorize(c); err != nil {
+			srv.count(&srv.stats.Unauthorized)
+			c.reject(err, AlertUnauthorized)
+			return nil, err
+		}
+	}
+	if srv.Quota != nil {
+		key := Fingerprint(c.peerPub)
+		if err := srv.Quota.admit(key); err != nil {
+			if err == ErrQuota {
+				srv.count(&srv.stats.OverQuota)
+			}
+			c.reject(err, AlertInternal)
+			return nil, err
+		}
+		c.quota, c.quotaKey = srv.Quota, key
+	}
+
+	stop := func() {}
+	if srv.Heartbeat > 0 {
+		if stop, err = srv.heartbeat(c); err != nil {
+			if srv.Quota != nil {
+				srv.Quota.release(c.quotaKey)
+			}
+			c.reject(err, AlertInternal)
+			return nil, err
+		}
+	}
+	return func() {
+		stop()
+		if srv.Quota != nil {
+			srv.Quota.release(c.quotaKey)
+		}
+	}, nil
+}
+
+func (srv *Server) count(counter *uint64) {
+	srv.statsMu.Lock()
+	*counter++
+	srv.statsMu.Unlock()
+}
+
+// Serve accepts connections on l and serves them until l fails to
+// accept, or the Server is shut down, when it returns
+// ErrServerClosed. l is a plain listener; the Server wraps it itself.
+func (srv *Server) Serve(l net.Listener) error {
+	if err := srv.serving(l); err != nil {
+		return err
+	}
+	defer srv.forget(l)
+
+	d := srv.dispatcher()
+	defer d.stop()
+
+	if srv.Fallback != nil {
+		return srv.serveSniffing(l, d)
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return srv.acceptError(err)
+		}
+		config := srv.currentConfig()
+		if err := config.tune(conn); err != nil {
+			conn.Close()
+			continue
+		}
+
+		c := srv.accounted(NewServerConn(conn, config))
+		d.dispatch(c, config.connMemory(), func() {
+			defer c.Close()
+			srv.labeled(c, func() {
+				release, err := srv.admit(c)
+				if err != nil {
+					return
+				}
+				defer release()
+				srv.Handler.ServeConn(c)
+			})
+		})
+	}
+}
+
+// serveSniffing is Serve for a Server with a Fallback
+func (srv *Server) serveSniffing(l net.Listener, d *dispatcher) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return srv.acceptError(err)
+		}
+		config := srv.currentConfig()
+		if err := config.tune(conn); err != nil {
+			conn.Close()
+			continue
+		}
+
+		// sniffing consumes the preamble
+		sniffed := *config
+		sniffed.SendPreamble = false
+
+		d.dispatch(conn, sniffed.connMemory(), func() {
+			// the preamble counts as part of the handshake
+			if timeout := config.handshakeTimeout(); timeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(timeout))
+			}
+			ok, replay, err := sniff(conn)
+			conn.SetReadDeadline(time.Time{})
+			switch {
+			case err != nil:
+				conn.Close()
+
+			case ok:
+				c := srv.accounted(NewServerConn(conn, &sniffed))
+				defer c.Close()
+				if c.Handshake() != nil {
+					srv.count(&srv.stats.HandshakeFailed)
+					return
+				}
+				srv.count(&srv.stats.Encrypted)
+				srv.labeled(c, func() {
+					release, err := srv.admit(c)
+					if err != nil {
+						return
+					}
+					defer release()
+					srv.Handler.ServeConn(c)
+				})
+
+			default:
+				defer replay.Close()
+				if hook := config.AuditHook; hook != nil {
+					hook(AuditEvent{RemoteAddr: conn.RemoteAddr(), Err: ErrPlaintext, Terminated: srv.RequireEncryption})
+				}
+				if srv.RequireEncryption {
+					srv.count(&srv.stats.Refused)
+					return
+				}
+				srv.count(&srv.stats.Plaintext)
+				srv.Fallback(replay)
+			}
+		})
+	}
+}
+
+// Mux is a Handler that dispatches connections to other handlers by
+// the application protocol negotiated during the handshake, so that
+// one listener can serve several protocols.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewMux allocates and returns a new Mux.
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for protocol. The handler for the
+// empty protocol serves clients that did not negotiate one of the
+// others; without it they are disconnected.
+func (m *Mux) Handle(protocol string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[protocol] = h
+}
+
+// HandleFunc registers the handler function for protocol.
+func (m *Mux) HandleFunc(protocol string, fn func(c *Conn)) {
+	m.Handle(protocol, HandlerFunc(fn))
+}
+
+// Protocols returns the registered protocols, suitable
+// for Config.NextProtos.
+func (m *Mux) Protocols() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var protos []string
+	for p := range m.handlers {
+		if p != "" {
+			protos = append(protos, p)
+		}
+	}
+	sort.Strings(protos)
+	return protos
+}
+
+// ServeConn completes the handshake and hands c to the handler
+// registered for the negotiated protocol.
+func (m *Mux) ServeConn(c *Conn) {
+	if err := c.Handshake(); err != nil {
+		return
+	}
+
+	m.mu.RLock()
+	h, ok := m.handlers[c.ConnectionState().Protocol]
+	m.mu.RUnlock()
+
+	if ok {
+		h.ServeConn(c)
+	}
+}
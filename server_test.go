@@ -0,0 +1,365 @@
+package secure
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jboverfelt/secure/securetest"
+)
+
+func TestMux(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mux := NewMux()
+	mux.HandleFunc("greet/1", func(c *Conn) {
+		io.WriteString(c, "hello world\n")
+	})
+	mux.HandleFunc("", func(c *Conn) {
+		io.WriteString(c, "no protocol\n")
+	})
+	go (&Server{Handler: mux}).Serve(l)
+
+	for _, tc := range []struct {
+		offered  []string
+		expected string
+	}{
+		{[]string{"greet/1"}, "hello world\n"},
+		{[]string{"rpc/1"}, "no protocol\n"},
+		{nil, "no protocol\n"},
+	} {
+		conn, err := Dial("tcp", l.Addr().String(), &Config{NextProtos: tc.offered})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := string(buf[:n]); res != tc.expected {
+			t.Fatalf("Unexpected result for %q: %s != %s", tc.offered, res, tc.expected)
+		}
+		conn.Close()
+	}
+}
+
+func TestServerFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := &Config{SendPreamble: true}
+	go (&Server{
+		Config: config,
+		Handler: HandlerFunc(func(c *Conn) {
+			buf := make([]byte, 64)
+			n, err := c.Read(buf)
+			if err == nil {
+				c.Write(append([]byte("secure "), buf[:n]...))
+			}
+		}),
+		Fallback: func(c net.Conn) {
+			line, err := bufio.NewReader(c).ReadString('\n')
+			if err == nil {
+				fmt.Fprintf(c, "plain %s", line)
+			}
+		},
+	}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "secure hello" {
+		t.Fatalf("Unexpected result: %q", got)
+	}
+
+	// a short plaintext message is recognized without waiting for more
+	for _, msg := range []string{"hi\n", "GET / HTTP/1.0\n"} {
+		plain, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer plain.Close()
+		fmt.Fprint(plain, msg)
+		line, err := bufio.NewReader(plain).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "plain "+msg {
+			t.Fatalf("Unexpected result: %q", line)
+		}
+	}
+}
+
+func TestServerRequireEncryption(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	events := make(chan AuditEvent, 10)
+	config := &Config{SendPreamble: true, AuditHook: func(e AuditEvent) { events <- e }}
+	srv := &Server{
+		Config:            config,
+		Handler:           HandlerFunc(func(c *Conn) { c.Write([]byte("secure")) }),
+		Fallback:          func(c net.Conn) { c.Write([]byte("plain")) },
+		RequireEncryption: true,
+	}
+	go srv.Serve(l)
+
+	plain, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	fmt.Fprint(plain, "hi\n")
+	if b, _ := ioutil.ReadAll(plain); len(b) != 0 {
+		t.Fatalf("A plaintext client was answered with %q", b)
+	}
+	if e := <-events; e.Err != ErrPlaintext || !e.Terminated {
+		t.Fatalf("Unexpected audit event: %+v", e)
+	}
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 64)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "secure" {
+		t.Fatalf("Unexpected result: %q, %v", buf[:n], err)
+	}
+
+	// a client with the preamble but no handshake
+	broken, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(broken, Preamble)
+	broken.Close()
+
+	for i := 0; i < 100; i++ {
+		if srv.Stats().HandshakeFailed == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := ServerStats{Encrypted: 1, HandshakeFailed: 1, Refused: 1}
+	if got := srv.Stats(); got != want {
+		t.Fatalf("Unexpected stats: %+v", got)
+	}
+}
+
+func TestServerGoroutines(t *testing.T) {
+	securetest.CheckGoroutines(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan [2]error)
+	release := make(chan struct{})
+	srv := &Server{
+		MaxConnGoroutines: 1,
+		Handler: HandlerFunc(func(c *Conn) {
+			// a pump reading until the connection is closed
+			pump := c.Go(func() {
+				buf := make([]byte, MaxMessageSize)
+				for {
+					if _, err := c.Read(buf); err != nil {
+						return
+					}
+				}
+			})
+			started <- [2]error{pump, c.Go(func() {})}
+			<-release
+		}),
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if errs := <-started; errs[0] != nil || errs[1] != ErrGoroutineLimit {
+		t.Fatalf("Expected nil and ErrGoroutineLimit, got %v", errs)
+	}
+	if n := srv.Goroutines(); n != 2 {
+		t.Fatalf("Expected the handler and the pump, got %d goroutines", n)
+	}
+
+	// the handler returning closes the connection, which stops the pump
+	close(release)
+	l.Close()
+	<-served
+	for deadline := time.Now().Add(time.Second); srv.Goroutines() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running", srv.Goroutines())
+		}
+	}
+}
+
+func TestServerGoroutinesReadAhead(t *testing.T) {
+	securetest.CheckGoroutines(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		goroutines int
+		ahead      bool
+		msg        string
+	}
+	// fill has the handler use up the connection's goroutine
+	// before the handshake, leaving no room for read-ahead
+	fill := make(chan bool, 1)
+	results := make(chan result)
+	release := make(chan struct{})
+	srv := &Server{
+		Config:            &Config{ReadAhead: 2},
+		MaxConnGoroutines: 1,
+		Handler: HandlerFunc(func(c *Conn) {
+			if <-fill {
+				c.Go(func() { <-release })
+			}
+			buf := make([]byte, 64)
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result{c.Goroutines(), c.readAheadState() != nil, string(buf[:n])}
+			<-release
+		}),
+	}
+	served := make(chan error)
+	go func() { served <- srv.Serve(l) }()
+
+	for _, want := range []result{{1, true, "hello"}, {1, false, "hello"}} {
+		fill <- !want.ahead
+		conn, err := Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-results; got != want {
+			t.Fatalf("Expected %+v, got %+v", want, got)
+		}
+	}
+	// two handlers, the read-ahead goroutine and the filler
+	if n := srv.Goroutines(); n != 4 {
+		t.Fatalf("Expected 4 goroutines, got %d", n)
+	}
+
+	close(release)
+	l.Close()
+	<-served
+	for deadline := time.Now().Add(time.Second); srv.Goroutines() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running", srv.Goroutines())
+		}
+	}
+}
+
+func TestServerWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		shed ShedPolicy
+		// which of the two connections dialed while
+		// the only worker is busy gets shed
+		shedFirst bool
+	}{
+		{ShedNewest, false},
+		{ShedOldest, true},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		release := make(chan struct{})
+		srv := &Server{
+			Workers: 1,
+			Backlog: 1,
+			Shed:    tc.shed,
+			Handler: HandlerFunc(func(c *Conn) {
+				c.Write([]byte("served"))
+				<-release
+			}),
+		}
+		go srv.Serve(l)
+
+		// the worker takes the first connection
+		busy, err := Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := busy.Read(make([]byte, MaxMessageSize)); err != nil {
+			t.Fatal(err)
+		}
+
+		// one more fits in the queue, and the server has not even
+		// sent its key to it; the other is shed
+		var raw [2]net.Conn
+		for i := range raw {
+			if raw[i], err = net.Dial("tcp", l.Addr().String()); err != nil {
+				t.Fatal(err)
+			}
+			defer raw[i].Close()
+		}
+		shed, queued := raw[1], raw[0]
+		if tc.shedFirst {
+			shed, queued = raw[0], raw[1]
+		}
+		shed.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := shed.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%v: expected the shed connection to be closed, got %v", tc.shed, err)
+		}
+		queued.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := queued.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("%v: unexpected data on the queued connection", tc.shed)
+		}
+		if n := srv.Stats().Shed; n != 1 {
+			t.Fatalf("%v: expected one connection shed, got %d", tc.shed, n)
+		}
+
+		// once the worker is free, the queued connection is served
+		close(release)
+		busy.Close()
+		queued.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(queued, make([]byte, KeySize)); err != nil {
+			t.Fatalf("%v: expected the queued connection to be served, got %v", tc.shed, err)
+		}
+		l.Close()
+	}
+}
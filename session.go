@@ -0,0 +1,229 @@
+package secure
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Version of the session state written by ExportSession
+const sessionVersion = 1
+
+// Size (in bytes) of the salt a resumed session rekeys with
+const rekeySaltSize = 32
+
+// ErrSession means that session state was malformed, or that a Conn
+// was not in a state its session could be exported from
+var ErrSession = errors.New("invalid session state")
+
+// ErrSessionExported means that a Conn was used after its session
+// was exported
+var ErrSessionExported = errors.New("session exported")
+
+// ExportSession returns the state of the session of c: its keys and
+// suite, the number of frames sent and received in each direction and
+// what the handshake established about the peer and the server name,
+// so that the session
+// can be continued by ResumeSession, in this process or in another one
+// that received the underlying connection, as a server re-executing a
+// new version of its binary does. The state holds the session key and
+// must be kept as secret.
+//
+// ExportSession must not be called concurrently with Read or Write,
+// and fails with ErrSession before the handshake has completed, on a
+// client that has not yet received the server's hello, or after a
+// close frame was sent or received, and with ErrReadAhead if messages
+// are read ahead with Config.ReadAhead. Afterwards c can no longer read or
+// write, and closing it closes the underlying connection without
+// sending a close frame.
+func (c *Conn) ExportSession() ([]byte, error) {
+	c.handshakeMu.Lock()
+	handshaked := c.handshaked
+	c.handshakeMu.Unlock()
+	if !handshaked {
+		return nil, ErrSession
+	}
+	if err := c.failed(); err != nil {
+		return nil, err
+	}
+	if c.readAheadState() != nil {
+		return nil, ErrReadAhead
+	}
+	if c.r.handlers[FrameHello] != nil || c.r.eof || c.w.closed {
+		return nil, ErrSession
+	}
+
+	c.stateMu.Lock()
+	protocol, suite, keyID, cert, serverName := c.protocol, c.suite, c.peerKeyID, c.peerCert, c.serverName
+	c.stateMu.Unlock()
+	fields := [][]byte{c.r.salt, c.w.salt, []byte(protocol), []byte(keyID), []byte(serverName)}
+	for _, field := range fields {
+		if len(field) > 255 {
+			return nil, ErrSession
+		}
+	}
+
+	var flags byte
+	if c.isClient {
+		flags = 1
+	}
+	b := []byte{sessionVersion, flags, byte(suite)}
+	b = append(b, c.r.shared[:]...)
+	b = append(b, c.peerPub[:]...)
+	b = appendUint64(b, c.w.sent)
+	b = appendUint64(b, c.r.recv)
+	for _, field := range fields {
+		b = append(append(b, byte(len(field))), field...)
+	}
+
+	var certData []byte
+	if cert != nil {
+		var err error
+		if certData, err = cert.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	if len(certData) > 0xffff {
+		return nil, ErrSession
+	}
+	b = append(b, byte(len(certData)>>8), byte(len(certData)))
+	b = append(b, certData...)
+
+	c.stopLimits()
+	c.failMu.Lock()
+	c.failErr = ErrSessionExported
+	c.failMu.Unlock()
+	return b, nil
+}
+
+// ResumeSession returns a Conn continuing the session exported from
+// another Conn by ExportSession, over conn, which must carry on from
+// where the exported Conn's transport stopped. No handshake is run.
+// config supplies the settings that are not part of the session, such
+// as MaxMessageSize or FrameTap, and may be nil.
+//
+// With an AEAD suite, whose nonces are mostly made of the frame
+// number, the Conn rekeys the frames it sends with a fresh salt before
+// the first of them, so that resuming the same state twice does not
+// reuse nonces. The peer must support FrameRekey.
+func ResumeSession(state []byte, conn net.Conn, config *Config) (*Conn, error) {
+	if len(state) < 3+2*KeySize+16 || state[0] != sessionVersion || state[1] > 1 {
+		return nil, ErrSession
+	}
+	suite := Suite(state[2])
+	if !suite.supported() {
+		return nil, ErrSession
+	}
+	b := state[3:]
+
+	var shared, peerPub [KeySize]byte
+	copy(shared[:], b)
+	copy(peerPub[:], b[KeySize:])
+	b = b[2*KeySize:]
+	sent := binary.BigEndian.Uint64(b)
+	recv := binary.BigEndian.Uint64(b[8:])
+	b = b[16:]
+
+	var readSalt, writeSalt, protocol, keyID, serverName []byte
+	for _, field := range []*[]byte{&readSalt, &writeSalt, &protocol, &keyID, &serverName} {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return nil, ErrSession
+		}
+		*field, b = b[1:1+int(b[0])], b[1+int(b[0]):]
+	}
+	if len(b) < 2 || len(b) != 2+int(binary.BigEndian.Uint16(b)) {
+		return nil, ErrSession
+	}
+	var cert *Certificate
+	if len(b) > 2 {
+		cert = new(Certificate)
+		if err := cert.UnmarshalBinary(b[2:]); err != nil {
+			return nil, ErrSession
+		}
+	}
+
+	c := NewServerConn(conn, config)
+	c.isClient = state[1] == 1
+	c.peerPub = &peerPub
+	c.setupSession(&shared)
+	c.w.sent, c.w.nonces.seq, c.r.recv = sent, sent, recv
+	if suite != SuiteBox {
+		var err error
+		if c.r.aead, err = suite.aead(&shared, readSalt); err != nil {
+			return nil, err
+		}
+		if c.w.aead, err = suite.aead(&shared, writeSalt); err != nil {
+			return nil, err
+		}
+		c.r.salt = append([]byte(nil), readSalt...)
+		c.w.salt = append([]byte(nil), writeSalt...)
+
+		// the frames sent from here on may also be sent by another
+		// Conn resuming the same state, so they get a key of their own
+		salt := make([]byte, rekeySaltSize)
+		if _, err := io.ReadFull(c.config.randReader(), salt); err != nil {
+			return nil, err
+		}
+		aead, err := suite.aead(&shared, salt)
+		if err != nil {
+			return nil, err
+		}
+		c.w.resalt, c.w.resaltAEAD = salt, aead
+		c.w.rekeyed = c.rekeyed
+	}
+
+	c.suite, c.protocol, c.peerKeyID, c.peerCert = suite, string(protocol), string(keyID), cert
+	c.serverName = string(serverName)
+	c.handshaked = true
+	// the session was established by the Conn it continues,
+	// and is rekeying until the new key is announced
+	c.lifecycle = int32(StateEstablished)
+	if c.w.resalt != nil {
+		c.lifecycle = int32(StateRekeying)
+	}
+	c.startLimits()
+	c.setRecovery()
+	c.startReadAhead()
+	return c, nil
+}
+
+// rekeyed counts the FrameRekey sent by c's Writer and moves c out of
+// StateRekeying, unless it has moved on to draining or closed already
+func (c *Conn) rekeyed() {
+	_, span := c.config.startSpan(context.Background(), SpanRekey)
+	span.SetAttribute("secure.sent", true)
+	span.End(nil)
+	atomic.AddUint64(&c.stats.rekeys, 1)
+	atomic.CompareAndSwapInt32(&c.lifecycle, int32(StateRekeying), int32(StateEstablished))
+}
+
+// handleRekey switches the frames read after a FrameRekey
+// to the key salted with its payload
+func (c *Conn) handleRekey(salt []byte) (err error) {
+	_, span := c.config.startSpan(context.Background(), SpanRekey)
+	span.SetAttribute("secure.sent", false)
+	defer func() { span.End(err) }()
+
+	c.stateMu.Lock()
+	suite := c.suite
+	c.stateMu.Unlock()
+	if suite == SuiteBox || len(salt) != rekeySaltSize {
+		return ErrFrame
+	}
+	aead, err := suite.aead(&c.r.shared, salt)
+	if err != nil {
+		return err
+	}
+	c.r.aead, c.r.salt = aead, append([]byte(nil), salt...)
+	atomic.AddUint64(&c.stats.rekeys, 1)
+	return nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
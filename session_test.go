@@ -0,0 +1,177 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestResumeSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := &Config{
+		Suites:      []Suite{SuiteAES256GCM},
+		NextProtos:  []string{"echo"},
+		ServerName:  "echo.example",
+		ServerNames: []string{"echo.example"},
+	}
+
+	// the server hands every connection over to a new Conn
+	// after each message, as a re-executed server would
+	errs := make(chan error, 1)
+	go (&Server{Config: config, Handler: HandlerFunc(func(c *Conn) {
+		buf := make([]byte, MaxMessageSize)
+		for i := 0; i < 3; i++ {
+			n, err := c.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := c.Write(buf[:n]); err != nil {
+				errs <- err
+				return
+			}
+			// only the resumed Conns rekey, before their first frame
+			want := uint64(1)
+			if i == 0 {
+				want = 0
+			}
+			if n := c.Stats().Rekeys; n != want {
+				t.Errorf("Expected %d rekeys, got %d", want, n)
+			}
+
+			state, err := c.ExportSession()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := c.Write(buf[:n]); err != ErrSessionExported {
+				t.Errorf("Expected ErrSessionExported, got %v", err)
+			}
+			if c, err = ResumeSession(state, c.NetConn(), nil); err != nil {
+				errs <- err
+				return
+			}
+			if got := c.ConnectionState(); got.Suite != SuiteAES256GCM || got.Protocol != "echo" || got.ServerName != "echo.example" {
+				t.Errorf("Unexpected resumed state: %+v", got)
+			}
+		}
+		errs <- c.Close()
+	})}).Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	for _, msg := range []string{"one", "two", "three"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("Unexpected result: %q != %q", buf[:n], msg)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("Expected the resumed connection to end with a close frame")
+	}
+	// the replies of the two resumed Conns and the close frame of the
+	// last one each followed a key switch
+	if n := conn.Stats().Rekeys; n != 3 {
+		t.Fatalf("Expected 3 rekeys read, got %d", n)
+	}
+}
+
+func TestResumeSessionInvalid(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	if _, err := NewClientConn(c1, nil).ExportSession(); err != ErrSession {
+		t.Fatalf("Expected ErrSession before the handshake, got %v", err)
+	}
+	for _, state := range [][]byte{nil, {sessionVersion}, make([]byte, 200)} {
+		if _, err := ResumeSession(state, c1, nil); err != ErrSession {
+			t.Fatalf("Expected ErrSession, got %v", err)
+		}
+	}
+}
+
+func TestResumeSessionTwice(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	config := &Config{Suites: []Suite{SuiteAES256GCM}}
+	c := NewClientConn(client, config)
+	c.waitHello = true
+	s := NewServerConn(server, config)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Handshake() }()
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	state, err := c.ExportSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each resumption rekeys before its first frame, so that
+	// the two do not seal under the same key and nonces
+	var salts [][]byte
+	for i := 0; i < 2; i++ {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		resumed, err := ResumeSession(state, a, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := resumed.ConnectionState().Lifecycle; s != StateRekeying {
+			t.Fatalf("Expected %v until the rekey frame is sent, got %v", StateRekeying, s)
+		}
+		written := make(chan error, 1)
+		go func() {
+			_, err := resumed.Write([]byte("hello"))
+			written <- err
+		}()
+
+		header := make([]byte, HeaderSize+2)
+		if _, err := io.ReadFull(b, header); err != nil {
+			t.Fatal(err)
+		}
+		if n := binary.LittleEndian.Uint16(header[HeaderSize:]); n != rekeySealed {
+			t.Fatalf("Expected a rekey frame first, got additional data size %d", n)
+		}
+		go io.Copy(ioutil.Discard, b)
+		if err := <-written; err != nil {
+			t.Fatal(err)
+		}
+		if s := resumed.ConnectionState().Lifecycle; s != StateEstablished {
+			t.Fatalf("Expected %v after the rekey frame, got %v", StateEstablished, s)
+		}
+		salts = append(salts, resumed.w.salt)
+	}
+	if bytes.Equal(salts[0], salts[1]) || bytes.Equal(salts[0], c.w.salt) {
+		t.Fatal("Unexpected result. Resumed sessions share a key.")
+	}
+}
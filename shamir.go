@@ -0,0 +1,157 @@
+package secure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// PEM block type of an encoded key share
+const pemKeyShare = "SECURE KEY SHARE"
+
+// Size (in bytes) of the tag identifying the key a share belongs to
+const shareTagSize = 4
+
+// KeyShareSize is the size (in bytes) of a key share: its x coordinate,
+// the number of shares required, a tag identifying the key and the
+// share of each byte of the key.
+const KeyShareSize = 1 + 1 + shareTagSize + KeySize
+
+// ErrKeyShare means that key shares were malformed, too few,
+// or did not belong to the same key
+var ErrKeyShare = errors.New("invalid or insufficient key shares")
+
+// SplitKey splits a private key into n shares using Shamir's secret
+// sharing, so that any k of them recover the key with CombineKeys while
+// fewer reveal nothing about it. It suits backing up long-lived
+// identities without any single custodian holding the full key.
+// 1 <= k <= n <= 255.
+func SplitKey(priv *[KeySize]byte, n, k int) ([][]byte, error) {
+	if k < 1 || n < k || n > 255 {
+		return nil, ErrKeyShare
+	}
+
+	// every byte of the key is the constant term of its own random
+	// polynomial of degree k-1
+	coeffs := make([]byte, KeySize*(k-1))
+	if _, err := io.ReadFull(rand.Reader, coeffs); err != nil {
+		return nil, err
+	}
+
+	tag := shareTag(priv)
+	shares := make([][]byte, n)
+	for i := range shares {
+		x := byte(i + 1)
+		share := append([]byte{x, byte(k)}, tag...)
+		for j, secret := range priv {
+			// Horner's rule, from the highest coefficient down
+			var y byte
+			for d := k - 2; d >= 0; d-- {
+				y = gfMul(y, x) ^ coeffs[d*KeySize+j]
+			}
+			share = append(share, gfMul(y, x)^secret)
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// CombineKeys recovers a private key from the shares returned
+// by SplitKey. It needs at least as many shares as were required
+// when splitting, and checks that they recover the original key.
+func CombineKeys(shares [][]byte) (*[KeySize]byte, error) {
+	if len(shares) == 0 || len(shares[0]) != KeyShareSize {
+		return nil, ErrKeyShare
+	}
+	k := int(shares[0][1])
+	tag := shares[0][2 : 2+shareTagSize]
+
+	// use the first k distinct shares
+	var xs []byte
+	var used [][]byte
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if len(s) != KeyShareSize || int(s[1]) != k || subtle.ConstantTimeCompare(s[2:2+shareTagSize], tag) != 1 {
+			return nil, ErrKeyShare
+		}
+		if s[0] == 0 || seen[s[0]] {
+			continue
+		}
+		seen[s[0]] = true
+		xs = append(xs, s[0])
+		used = append(used, s)
+	}
+	if k < 1 || len(used) < k {
+		return nil, ErrKeyShare
+	}
+	xs, used = xs[:k], used[:k]
+
+	// Lagrange interpolation at x = 0
+	priv := new([KeySize]byte)
+	for i, s := range used {
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				// in GF(2^8) subtraction is addition
+				basis = gfMul(basis, gfMul(xj, gfInv(xj^xs[i])))
+			}
+		}
+		for b := range priv {
+			priv[b] ^= gfMul(basis, s[2+shareTagSize+b])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(shareTag(priv), tag) != 1 {
+		return nil, ErrKeyShare
+	}
+	return priv, nil
+}
+
+// shareTag identifies the key shares were split from
+// by a prefix of the hash of its public key
+func shareTag(priv *[KeySize]byte) []byte {
+	var pub [KeySize]byte
+	curve25519.ScalarBaseMult(&pub, priv)
+	sum := sha256.Sum256(pub[:])
+	return sum[:shareTagSize]
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial,
+// without branching on the operands
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a != 0 as a^254
+func gfInv(a byte) byte {
+	r := a
+	for i := 0; i < 6; i++ {
+		r = gfMul(gfMul(r, r), a)
+	}
+	return gfMul(r, r)
+}
+
+// EncodeKeyShare returns the PEM encoding of a key share.
+func EncodeKeyShare(share []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: pemKeyShare, Bytes: share})
+}
+
+// DecodeKeyShare parses the first key share in PEM encoded data.
+func DecodeKeyShare(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemKeyShare || len(block.Bytes) != KeyShareSize {
+		return nil, ErrKeyShare
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,74 @@
+package secure
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("Unexpected inverse of %d", a)
+		}
+	}
+	// 0x57 * 0x83 = 0xc1, as in FIPS-197
+	if p := gfMul(0x57, 0x83); p != 0xc1 {
+		t.Fatalf("Unexpected product: %#x", p)
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	_, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := SplitKey(priv, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var some [][]byte
+		for _, i := range pick {
+			share, err := DecodeKeyShare(EncodeKeyShare(shares[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			some = append(some, share)
+		}
+		got, err := CombineKeys(some)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *priv {
+			t.Fatalf("Shares %v recovered the wrong key", pick)
+		}
+	}
+
+	// too few, duplicated or foreign shares are refused
+	_, other, _ := box.GenerateKey(rand.Reader)
+	otherShares, _ := SplitKey(other, 5, 3)
+	for name, some := range map[string][][]byte{
+		"too few":    shares[:2],
+		"duplicated": {shares[0], shares[0], shares[1]},
+		"mixed":      {shares[0], shares[1], otherShares[2]},
+	} {
+		if _, err := CombineKeys(some); err != ErrKeyShare {
+			t.Fatalf("Expected ErrKeyShare for %s shares, got %v", name, err)
+		}
+	}
+
+	// a corrupted share is detected
+	bad := append([]byte(nil), shares[2]...)
+	bad[len(bad)-1] ^= 1
+	if _, err := CombineKeys([][]byte{shares[0], shares[1], bad}); err != ErrKeyShare {
+		t.Fatalf("Expected ErrKeyShare, got %v", err)
+	}
+
+	if _, err := SplitKey(priv, 2, 3); err != ErrKeyShare {
+		t.Fatalf("Expected ErrKeyShare, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrServerClosed means that a Server was shut down or closed
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is how often Shutdown checks
+// whether the connections have been served
+const shutdownPollInterval = 10 * time.Millisecond
+
+// resolveConfig returns the Config connections are served with
+// for config, offering the Mux's protocols if config lists none
+func (srv *Server) resolveConfig(config *Config) *Config {
+	if config == nil {
+		config = defaultConfig()
+	}
+	if mux, ok := srv.Handler.(*Mux); ok && len(config.NextProtos) == 0 {
+		c := *config
+		c.NextProtos = mux.Protocols()
+		config = &c
+	}
+	return config
+}
+
+// currentConfig returns the Config for new connections
+func (srv *Server) currentConfig() *Config {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.config == nil {
+		srv.config = srv.resolveConfig(srv.Config)
+	}
+	return srv.config
+}
+
+// Reload makes the Server serve the connections it accepts from then
+// on with config instead of Config, such as after rotating its keys.
+// Connections already accepted keep the Config they were accepted
+// with.
+func (srv *Server) Reload(config *Config) {
+	config = srv.resolveConfig(config)
+	srv.mu.Lock()
+	srv.config = config
+	srv.mu.Unlock()
+}
+
+// serving registers l as served, failing with
+// ErrServerClosed if the Server is shut down
+func (srv *Server) serving(l net.Listener) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closed {
+		return ErrServerClosed
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	return nil
+}
+
+// forget unregisters l once it is no longer served
+func (srv *Server) forget(l net.Listener) {
+	srv.mu.Lock()
+	delete(srv.listeners, l)
+	srv.mu.Unlock()
+}
+
+// acceptError returns the error Serve returns for err,
+// ErrServerClosed if the listener was closed by Shutdown
+func (srv *Server) acceptError(err error) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// hold counts conn as active, holding memory bytes of the Server's
+// MaxMemory, until release is called. It reports whether there was
+// enough memory left.
+func (srv *Server) hold(conn net.Conn, memory int64) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.reserveMemory(memory) {
+		return false
+	}
+	if srv.active == nil {
+		srv.active = make(map[net.Conn]int64)
+	}
+	srv.active[conn] = memory
+	return true
+}
+
+// release stops counting conn as active
+func (srv *Server) release(conn net.Conn) {
+	srv.mu.Lock()
+	srv.memory -= srv.active[conn]
+	delete(srv.active, conn)
+	srv.mu.Unlock()
+}
+
+// closeListeners marks the Server as closed
+// and closes the listeners it serves
+func (srv *Server) closeListeners() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closed = true
+	var err error
+	for l := range srv.listeners {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Shutdown shuts the Server down gracefully: it closes its listeners,
+// so that Serve returns ErrServerClosed, and waits for the connections
+// already accepted to be served, including the goroutines their
+// handlers started with Conn.Go. If ctx is done first, Shutdown
+// returns its error, and the remaining connections can be cut with
+// Close. A Server that was shut down cannot serve again.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	err := srv.closeListeners()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		srv.mu.Lock()
+		idle := len(srv.active) == 0
+		srv.mu.Unlock()
+		if idle && srv.Goroutines() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes the Server's listeners and every connection it has
+// accepted and not yet finished serving. Handlers see their
+// connections fail. For a graceful shutdown, use Shutdown.
+func (srv *Server) Close() error {
+	err := srv.closeListeners()
+	srv.mu.Lock()
+	conns := make([]net.Conn, 0, len(srv.active))
+	for conn := range srv.active {
+		conns = append(conns, conn)
+	}
+	srv.mu.Unlock()
+
+	for _, conn := range conns {
+		// closing a Conn would wait to send the close frame
+		if c, ok := conn.(*Conn); ok {
+			conn = c.NetConn()
+		}
+		conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serving, finish := make(chan struct{}), make(chan struct{})
+	srv := &Server{Handler: HandlerFunc(func(c *Conn) {
+		c.Handshake()
+		serving <- struct{}{}
+		<-finish
+		c.Write([]byte("done"))
+	})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-serving
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("Serve = %v, want ErrServerClosed", err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Fatal("listener still accepting after Shutdown")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the connection was served", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	buf := make([]byte, 16)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "done" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(l); err != ErrServerClosed {
+		t.Fatalf("Serve after Shutdown = %v", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serving := make(chan struct{})
+	srv := &Server{Handler: HandlerFunc(func(c *Conn) {
+		c.Handshake()
+		close(serving)
+		c.Read(make([]byte, 16))
+	})}
+	go srv.Serve(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-serving
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown after Close = %v", err)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	oldPub, oldPriv := mustGenerateKey(t, rand.Reader)
+	newPub, newPriv := mustGenerateKey(t, rand.Reader)
+	srv := &Server{
+		Config:  &Config{PublicKey: oldPub, PrivateKey: oldPriv},
+		Handler: HandlerFunc(func(c *Conn) { c.Read(make([]byte, 16)) }),
+	}
+	go srv.Serve(l)
+
+	peer := func() *[KeySize]byte {
+		t.Helper()
+		conn, err := Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		pub, err := conn.PeerPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+
+	if pub := peer(); *pub != *oldPub {
+		t.Fatal("served with the wrong key before Reload")
+	}
+	srv.Reload(&Config{PublicKey: newPub, PrivateKey: newPriv})
+	if pub := peer(); *pub != *newPub {
+		t.Fatal("served with the old key after Reload")
+	}
+}
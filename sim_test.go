@@ -0,0 +1,332 @@
+package secure
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The simulation runs whole client/server exchanges over in-memory
+// transports, with every source of variation drawn from one seed: the
+// keys, nonces and hello randoms through Config.Rand, the clock through
+// Config.Time, the configuration and messages, how the transports
+// fragment the stream and where the goroutines yield to each other.
+// What each side writes then depends on the seed alone, so a failing
+// seed reproduces with
+//
+//	go test -run TestSimulation -sim.seed <seed>
+var simSeed = flag.Int64("sim.seed", 0, "Run the simulation with this seed only")
+
+// simEpoch is where the fake clocks start, long before any real time
+// the keys used in the simulation are valid at
+var simEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A simClock is a fake clock advancing by a millisecond
+// every time it is read
+type simClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(time.Millisecond)
+	return c.t
+}
+
+// A simRand is a seeded generator safe for concurrent use
+type simRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSimRand(seed int64) *simRand {
+	return &simRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (r *simRand) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Read(p)
+}
+
+func (r *simRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// A simLink is one direction of an in-memory connection.
+// It records everything written to it.
+type simLink struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	buf    []byte
+	wire   []byte
+	closed bool
+}
+
+func newSimLink() *simLink {
+	l := new(simLink)
+	l.cond.L = &l.mu
+	return l
+}
+
+func (l *simLink) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// A simConn is an in-memory net.Conn. Writes never block, reads return
+// a random share of what was written, and both yield the processor a
+// random number of times first, to shuffle the goroutines' scheduling.
+type simConn struct {
+	in, out *simLink
+	rand    *simRand
+}
+
+func simPipe(seed int64) (client, server *simConn) {
+	up, down := newSimLink(), newSimLink()
+	return &simConn{in: down, out: up, rand: newSimRand(seed)},
+		&simConn{in: up, out: down, rand: newSimRand(seed + 1)}
+}
+
+func (c *simConn) yield() {
+	for i := c.rand.Intn(4); i > 0; i-- {
+		runtime.Gosched()
+	}
+}
+
+func (c *simConn) Read(p []byte) (int, error) {
+	c.yield()
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	for len(c.in.buf) == 0 && !c.in.closed {
+		c.in.cond.Wait()
+	}
+	if len(c.in.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := 1 + c.rand.Intn(len(c.in.buf))
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, c.in.buf[:n])
+	c.in.buf = c.in.buf[n:]
+	return n, nil
+}
+
+func (c *simConn) Write(p []byte) (int, error) {
+	c.yield()
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	if c.out.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.out.buf = append(c.out.buf, p...)
+	c.out.wire = append(c.out.wire, p...)
+	c.out.cond.Broadcast()
+	return len(p), nil
+}
+
+func (c *simConn) CloseWrite() error {
+	c.out.close()
+	return nil
+}
+
+func (c *simConn) Close() error {
+	c.out.close()
+	c.in.close()
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr                { return simAddr{} }
+func (c *simConn) RemoteAddr() net.Addr               { return simAddr{} }
+func (c *simConn) SetDeadline(t time.Time) error      { return nil }
+func (c *simConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *simConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type simAddr struct{}
+
+func (simAddr) Network() string { return "sim" }
+func (simAddr) String() string  { return "sim" }
+
+// simFrames records the frames a Conn tapped
+type simFrames struct {
+	mu            sync.Mutex
+	sent, arrived [][]byte
+}
+
+func (f *simFrames) tap(dir TapDirection, frame []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if dir == TapOutbound {
+		f.sent = append(f.sent, frame)
+	} else {
+		f.arrived = append(f.arrived, frame)
+	}
+}
+
+// simulate runs one exchange, checks the invariants and returns
+// what each side wrote
+func simulate(t *testing.T, seed int64) (fromClient, fromServer []byte) {
+	r := rand.New(rand.NewSource(seed))
+	clientConn, serverConn := simPipe(seed)
+	clientFrames, serverFrames := new(simFrames), new(simFrames)
+
+	config := func(seed int64, frames *simFrames) *Config {
+		pub, priv := mustGenerateKey(t, newSimRand(seed))
+		c := &Config{
+			PublicKey:   pub,
+			PrivateKey:  priv,
+			KeyInfo:     &KeyInfo{Expires: simEpoch.Add(time.Hour)},
+			Rand:        newSimRand(seed),
+			Time:        (&simClock{t: simEpoch}).Now,
+			FrameTap:    frames.tap,
+			ChunkWrites: r.Intn(2) == 0,
+		}
+		if r.Intn(2) == 0 {
+			c.Suites = []Suite{SuiteAES256GCM}
+		}
+		return c
+	}
+	client := NewClientConn(clientConn, config(seed+2, clientFrames))
+	server := NewServerConn(serverConn, config(seed+3, serverFrames))
+
+	// the messages are marked, so that leaks show on the wire
+	msgs := make([][]byte, 1+r.Intn(20))
+	for i := range msgs {
+		msgs[i] = []byte(fmt.Sprintf("plaintext %d of seed %d:", i, seed))
+		filler := make([]byte, r.Intn(3*MaxMessageSize/2))
+		r.Read(filler)
+		msgs[i] = append(msgs[i], filler...)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, err := server.Read(buf)
+			if err == io.EOF {
+				done <- server.Close()
+				return
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+			if _, err := server.Write(buf[:n]); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, MaxMessageSize)
+	for i, msg := range msgs {
+		if len(msg) > MaxMessageSize && !client.config.ChunkWrites {
+			msg = msg[:MaxMessageSize]
+			msgs[i] = msg
+		}
+		if _, err := client.Write(msg); err != nil {
+			t.Fatalf("Seed %d: %v", seed, err)
+		}
+		var echo []byte
+		for len(echo) < len(msg) {
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Fatalf("Seed %d: %v", seed, err)
+			}
+			echo = append(echo, buf[:n]...)
+		}
+		if !bytes.Equal(echo, msg) {
+			t.Fatalf("Seed %d: message %d echoed wrong", seed, i)
+		}
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatalf("Seed %d: %v", seed, err)
+	}
+	if _, err := client.Read(buf); err != io.EOF {
+		t.Fatalf("Seed %d: expected io.EOF, got %v", seed, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Seed %d: server: %v", seed, err)
+	}
+	client.Close()
+
+	fromClient, fromServer = clientConn.out.wire, serverConn.out.wire
+	checkSimWire(t, seed, "client", fromClient, clientFrames.sent, serverFrames.arrived, server.r.recv, msgs)
+	checkSimWire(t, seed, "server", fromServer, serverFrames.sent, clientFrames.arrived, client.r.recv, msgs)
+	return fromClient, fromServer
+}
+
+// checkSimWire checks what one side wrote: nothing but its public key
+// and the frames it sealed, all of which the peer received and
+// authenticated, none of them carrying a message in the clear
+func checkSimWire(t *testing.T, seed int64, side string, wire []byte, sent, arrived [][]byte, authenticated uint64, msgs [][]byte) {
+	if len(wire) < KeySize || !bytes.Equal(wire[KeySize:], bytes.Join(sent, nil)) {
+		t.Fatalf("Seed %d: the %s wrote more than its key and frames", seed, side)
+	}
+	if len(arrived) != len(sent) {
+		t.Fatalf("Seed %d: %d frames from the %s, %d arrived", seed, len(sent), side, len(arrived))
+	}
+	for i := range sent {
+		if !bytes.Equal(sent[i], arrived[i]) {
+			t.Fatalf("Seed %d: frame %d from the %s arrived altered", seed, i, side)
+		}
+	}
+	if authenticated != uint64(len(sent)) {
+		t.Fatalf("Seed %d: %d frames from the %s, %d authenticated", seed, len(sent), side, authenticated)
+	}
+	for i, msg := range msgs {
+		if bytes.Contains(wire, msg[:len(msg)/2+1]) {
+			t.Fatalf("Seed %d: message %d leaked in the clear from the %s", seed, i, side)
+		}
+	}
+}
+
+func mustGenerateKey(t testing.TB, r io.Reader) (pub, priv *[KeySize]byte) {
+	kp, err := (&Config{Rand: r}).keyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := kp.(staticKeys)
+	return keys.pub, keys.priv
+}
+
+func TestSimulation(t *testing.T) {
+	seeds := []int64{*simSeed}
+	if *simSeed == 0 {
+		seeds = nil
+		n := int64(100)
+		if testing.Short() {
+			n = 10
+		}
+		for seed := int64(1); seed <= n; seed++ {
+			seeds = append(seeds, seed)
+		}
+	}
+
+	for _, seed := range seeds {
+		fromClient, fromServer := simulate(t, seed)
+
+		// however the goroutines were scheduled, a seed
+		// always puts the same bytes on the wire
+		if seed%10 == 1 || len(seeds) == 1 {
+			againClient, againServer := simulate(t, seed)
+			if !bytes.Equal(fromClient, againClient) || !bytes.Equal(fromServer, againServer) {
+				t.Fatalf("Seed %d: the wire differs between runs", seed)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+)
+
+// Preamble is sent by clients with Config.SendPreamble before anything
+// else, so that a server can tell them apart from plaintext clients on
+// the same port. It starts with a zero byte, which text protocols such
+// as HTTP never do.
+const Preamble = "\x00SECURE\x01"
+
+// ErrPreamble means that a peer expected to send the Preamble did not
+var ErrPreamble = errors.New("missing protocol preamble")
+
+// preamble sends the Preamble from a client, or checks
+// that the client sent it on a server
+func (c *Conn) preamble() error {
+	if c.isClient {
+		_, err := io.WriteString(c.conn, Preamble)
+		return err
+	}
+
+	var b [len(Preamble)]byte
+	if _, err := io.ReadFull(c.conn, b[:]); err != nil {
+		return err
+	}
+	if string(b[:]) != Preamble {
+		return ErrPreamble
+	}
+	return nil
+}
+
+// sniff reads from conn until it has seen the Preamble or anything
+// else. A plaintext client is answered as soon as its first bytes
+// differ, so it need not send as many bytes as the Preamble has.
+// The returned net.Conn replays whatever sniff read that was not
+// part of the Preamble.
+func sniff(conn net.Conn) (ok bool, replay net.Conn, err error) {
+	var b [len(Preamble)]byte
+	n := 0
+	for n < len(b) {
+		m, err := conn.Read(b[n:])
+		n += m
+		if !bytes.HasPrefix([]byte(Preamble), b[:n]) {
+			return false, &replayConn{conn, bytes.NewReader(b[:n])}, nil
+		}
+		if err != nil && n < len(b) {
+			return false, nil, err
+		}
+	}
+	return true, conn, nil
+}
+
+// A replayConn is a net.Conn whose reads return buffered data
+// before the rest of the connection
+type replayConn struct {
+	net.Conn
+	buf *bytes.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if c.buf.Len() > 0 {
+		return c.buf.Read(p)
+	}
+	return c.Conn.Read(p)
+}
@@ -0,0 +1,165 @@
+package secure
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// A ConnState is a stage in the lifecycle of a Conn, as reported by
+// ConnectionState. A Conn only moves along the transitions drawn by
+// StateDiagram.
+type ConnState int32
+
+// Connection states
+const (
+	// StateNew connections have not started the handshake.
+	StateNew ConnState = iota
+
+	// StateHandshaking connections are exchanging keys and hellos.
+	StateHandshaking
+
+	// StateEstablished connections carry messages both ways.
+	StateEstablished
+
+	// StateRekeying connections carry messages both ways, but have yet
+	// to announce the key they switch to with FrameRekey, as resumed
+	// sessions do ahead of the first frame they send.
+	StateRekeying
+
+	// StateDraining connections have sent or received the close frame
+	// or an alert, so at most one direction still carries messages.
+	StateDraining
+
+	// StateClosed connections were closed, or terminated by a failed
+	// handshake, a limit or an alert. Every call fails.
+	StateClosed
+)
+
+// transitions lists the states each state may move to
+var transitions = [...][]ConnState{
+	StateNew:         {StateHandshaking, StateClosed},
+	StateHandshaking: {StateEstablished, StateClosed},
+	StateEstablished: {StateRekeying, StateDraining, StateClosed},
+	StateRekeying:    {StateEstablished, StateDraining, StateClosed},
+	StateDraining:    {StateClosed},
+	StateClosed:      nil,
+}
+
+// debugStates makes a Conn panic with a *StateError when it is used in
+// a state that does not allow it, or moved along a transition that
+// StateDiagram does not draw, rather than leaving the call to fail
+// with whatever error it runs into. Building with -tags securestates
+// turns it on.
+var debugStates = false
+
+func (s ConnState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateHandshaking:
+		return "handshaking"
+	case StateEstablished:
+		return "established"
+	case StateRekeying:
+		return "rekeying"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// allows reports whether s may move to next
+func (s ConnState) allows(next ConnState) bool {
+	if s < 0 || int(s) >= len(transitions) {
+		return false
+	}
+	for _, t := range transitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StateDiagram returns the lifecycle of a Conn as a Graphviz digraph,
+// with a node for every ConnState and an edge for every transition, for
+// rendering with dot(1) in documentation and design reviews.
+func StateDiagram() string {
+	var b strings.Builder
+	b.WriteString("digraph conn {\n")
+	b.WriteString("\trankdir=LR;\n")
+	for s := range transitions {
+		shape := "ellipse"
+		if ConnState(s) == StateClosed {
+			shape = "doublecircle"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=%s];\n", ConnState(s), shape)
+	}
+	for s, next := range transitions {
+		for _, t := range next {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", ConnState(s), t)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// A StateError reports a Conn used in a state that does not allow it,
+// such as a session frame sealed before the handshake. Builds with
+// -tags securestates panic with one where it happens. Using a Conn
+// after Close is not one of them: it fails with net.ErrClosed.
+type StateError struct {
+	// Op is what was attempted, such as "read" or a transition
+	// to another state.
+	Op    string
+	State ConnState
+}
+
+func (e *StateError) Error() string {
+	return "secure: " + e.Op + " in state " + e.State.String()
+}
+
+// state returns where c is in its lifecycle
+func (c *Conn) state() ConnState {
+	return ConnState(atomic.LoadInt32(&c.lifecycle))
+}
+
+// setState moves c to next. A transition StateDiagram does not draw
+// leaves c where it is, or panics with debugStates. A closed Conn
+// stays closed whatever comes next, as Close may be called at any
+// time, such as during the handshake or as the session ends.
+func (c *Conn) setState(next ConnState) {
+	for {
+		s := c.state()
+		if s == next || s == StateClosed {
+			return
+		}
+		if !s.allows(next) {
+			c.misuse("transition to "+next.String(), s)
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.lifecycle, int32(s), int32(next)) {
+			return
+		}
+	}
+}
+
+// assertSession checks that the handshake has set up the session
+// before op seals or opens frames with it
+func (c *Conn) assertSession(op string) {
+	if s := c.state(); s == StateNew || s == StateHandshaking {
+		c.misuse(op+" before the handshake", s)
+	}
+}
+
+// misuse reports that op was attempted in state s, which does not
+// allow it. Other than in debug builds, the call is left to fail
+// with its own error.
+func (c *Conn) misuse(op string, s ConnState) {
+	if debugStates {
+		panic(&StateError{Op: op, State: s})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build securestates
+// +build securestates
+
+// Building with -tags securestates checks that every Conn moves along
+// the transitions of StateDiagram and is only used in the states that
+// allow it, panicking with a *StateError otherwise.
+
+package secure
+
+func init() {
+	debugStates = true
+}
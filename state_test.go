@@ -0,0 +1,120 @@
+package secure
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStateDiagram(t *testing.T) {
+	dot := StateDiagram()
+	for _, want := range []string{
+		"digraph conn {",
+		"\tnew -> handshaking;",
+		"\thandshaking -> established;",
+		"\testablished -> rekeying;",
+		"\trekeying -> established;",
+		"\tdraining -> closed;",
+		"\tclosed [shape=doublecircle];",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("Expected %q in the diagram:\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "closed ->") || strings.Contains(dot, "unknown") {
+		t.Fatalf("Unexpected transitions in the diagram:\n%s", dot)
+	}
+}
+
+func TestConnLifecycle(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	served := make(chan ConnState, 1)
+	go func() {
+		raw, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := raw.(*Conn)
+		defer c.Close()
+		if _, err := c.Read(make([]byte, 64)); err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+		served <- c.ConnectionState().Lifecycle
+	}()
+
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewClientConn(raw, nil)
+	for _, step := range []struct {
+		fn   func() error
+		want ConnState
+	}{
+		{func() error { return nil }, StateNew},
+		{conn.Handshake, StateEstablished},
+		{conn.CloseWrite, StateDraining},
+		{conn.Close, StateClosed},
+	} {
+		if err := step.fn(); err != nil {
+			t.Fatal(err)
+		}
+		if s := conn.ConnectionState().Lifecycle; s != step.want {
+			t.Fatalf("Expected %v, got %v", step.want, s)
+		}
+	}
+	if s := <-served; s != StateDraining {
+		t.Fatalf("Expected the server to be draining after the close frame, got %v", s)
+	}
+}
+
+func TestStateAssertions(t *testing.T) {
+	defer func(debug bool) { debugStates = debug }(debugStates)
+
+	// without assertions, invalid transitions are ignored
+	debugStates = false
+	c := NewClientConn(nil, nil)
+	c.setState(StateDraining)
+	if s := c.state(); s != StateNew {
+		t.Fatalf("Expected an invalid transition to be ignored, got %v", s)
+	}
+
+	debugStates = true
+	expectPanic := func(want *StateError, fn func()) {
+		t.Helper()
+		defer func() {
+			if err := recover(); !reflect.DeepEqual(err, want) {
+				t.Fatalf("Expected a panic with %v, got %v", want, err)
+			}
+		}()
+		fn()
+	}
+	expectPanic(&StateError{Op: "transition to draining", State: StateNew}, func() {
+		c.setState(StateDraining)
+	})
+	expectPanic(&StateError{Op: "write frame before the handshake", State: StateNew}, func() {
+		c.writeFrame(FramePing, nil)
+	})
+
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	c = NewClientConn(p1, nil)
+	c.Close()
+	// using a closed Conn is the caller's error, not the protocol's
+	if _, err := c.Read(make([]byte, 1)); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from Read, got %v", err)
+	}
+	if _, err := c.Write([]byte("hello")); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed from Write, got %v", err)
+	}
+	if got := (&StateError{Op: "read", State: StateClosed}).Error(); got != "secure: read in state closed" {
+		t.Fatalf("Unexpected message %q", got)
+	}
+}
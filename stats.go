@@ -0,0 +1,82 @@
+package secure
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats is a snapshot of the counters of a Conn.
+type ConnStats struct {
+	// FramesIn and FramesOut are the numbers of frames read and
+	// written since the handshake, including control frames such as
+	// pings and the hellos.
+	FramesIn, FramesOut uint64
+
+	// BytesIn and BytesOut are the sizes of those frames
+	// as sent on the wire.
+	BytesIn, BytesOut uint64
+
+	// LastActivity is when the last frame was read or written, or the
+	// zero time if none was. Applications can close connections idle
+	// for too long by comparing it with the current time.
+	LastActivity time.Time
+
+	// RTT is the round-trip time measured by Ping and SendPing,
+	// smoothed over the pings so far like TCP's, or zero if none
+	// was answered.
+	RTT time.Duration
+
+	// Rekeys is the number of times the key of either direction was
+	// switched with FrameRekey, as announced by a resumed Conn before
+	// its first frame or as read from the peer.
+	Rekeys uint64
+}
+
+// connCounters holds the counters of a Conn. They are updated
+// atomically, so Stats can be called concurrently with Read and Write.
+type connCounters struct {
+	framesIn, framesOut uint64
+	bytesIn, bytesOut   uint64
+	lastActivity        int64 // in Unix nanoseconds
+	rtt                 int64 // in nanoseconds
+	ponged              int64 // when the last answered SendPing was sent, since pingEpoch
+	rekeys              uint64
+}
+
+// Stats returns the connection's counters.
+func (c *Conn) Stats() ConnStats {
+	s := ConnStats{
+		FramesIn:  atomic.LoadUint64(&c.stats.framesIn),
+		FramesOut: atomic.LoadUint64(&c.stats.framesOut),
+		BytesIn:   atomic.LoadUint64(&c.stats.bytesIn),
+		BytesOut:  atomic.LoadUint64(&c.stats.bytesOut),
+		RTT:       time.Duration(atomic.LoadInt64(&c.stats.rtt)),
+		Rekeys:    atomic.LoadUint64(&c.stats.rekeys),
+	}
+	if t := atomic.LoadInt64(&c.stats.lastActivity); t != 0 {
+		s.LastActivity = time.Unix(0, t)
+	}
+	return s
+}
+
+// countFrame counts a frame of size bytes read or written
+func (c *Conn) countFrame(frames, bytes *uint64, size int) {
+	atomic.AddUint64(frames, 1)
+	atomic.AddUint64(bytes, uint64(size))
+	atomic.StoreInt64(&c.stats.lastActivity, c.config.now().UnixNano())
+}
+
+// recordRTT folds a round-trip time measured by a ping into the
+// smoothed RTT, weighing it by 1/8 as TCP does
+func (c *Conn) recordRTT(rtt time.Duration) {
+	for {
+		old := atomic.LoadInt64(&c.stats.rtt)
+		smoothed := int64(rtt)
+		if old != 0 {
+			smoothed = old + (int64(rtt)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&c.stats.rtt, old, smoothed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package secure
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnStats(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hellos, the messages and the ping
+	s := conn.Stats()
+	if s.FramesOut != 5 || s.FramesIn != 5 {
+		t.Fatalf("Unexpected frame counts: %d out, %d in", s.FramesOut, s.FramesIn)
+	}
+	frame := uint64(Overhead + len("hello"))
+	if s.BytesOut < 3*frame || s.BytesIn < 3*frame {
+		t.Fatalf("Unexpected byte counts: %d out, %d in", s.BytesOut, s.BytesIn)
+	}
+	if s.LastActivity.Before(start) || s.RTT <= 0 {
+		t.Fatalf("Unexpected activity and RTT: %v, %v", s.LastActivity, s.RTT)
+	}
+}
+
+func TestRecordRTT(t *testing.T) {
+	var c Conn
+	c.recordRTT(80 * time.Millisecond)
+	c.recordRTT(160 * time.Millisecond)
+	if rtt := c.Stats().RTT; rtt != 90*time.Millisecond {
+		t.Fatalf("Unexpected smoothed RTT: %v", rtt)
+	}
+}
@@ -0,0 +1,96 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// A Suite identifies the authenticated encryption that seals the
+// frames of a Conn.
+type Suite uint8
+
+// Supported suites
+const (
+	// SuiteBox seals frames with NaCl box (XSalsa20 and Poly1305). It is
+	// always supported, and used unless another suite is negotiated.
+	SuiteBox Suite = iota
+
+	// SuiteAES256GCM seals frames with AES-256-GCM under a key derived
+	// from the shared key and random values exchanged in the hellos, so
+	// it is fresh for every connection. Unlike SuiteBox it can
+	// authenticate additional data sent in the clear with a message.
+	SuiteAES256GCM
+)
+
+// ErrNoAD means that a message with additional data was written
+// while using a suite that cannot authenticate it
+var ErrNoAD = errors.New("suite does not support additional data")
+
+func (s Suite) String() string {
+	switch s {
+	case SuiteBox:
+		return "box"
+	case SuiteAES256GCM:
+		return "aes256gcm"
+	}
+	return fmt.Sprintf("Suite(%d)", uint8(s))
+}
+
+// SealedSize returns the number of bytes a Writer sealing with s emits
+// for a message of plaintextLen bytes sent with adLen bytes of
+// additional data. Suites that can authenticate additional data add
+// its 2 byte length to every frame, even when there is none, and the
+// data itself, so their frames are larger than SealedSize reports.
+func (s Suite) SealedSize(plaintextLen, adLen int) int {
+	if s == SuiteBox {
+		return SealedSize(plaintextLen)
+	}
+	return SealedSize(plaintextLen) + 2 + adLen
+}
+
+// supported reports whether this package implements s
+func (s Suite) supported() bool {
+	return s <= SuiteAES256GCM
+}
+
+// aead returns the AEAD for s keyed from the shared key and the salt,
+// or nil for SuiteBox, which is used directly. AEADs with nonces
+// shorter than NonceSize use the end of the frame nonce, which holds
+// the frame's direction and number.
+func (s Suite) aead(shared *[KeySize]byte, salt []byte) (cipher.AEAD, error) {
+	switch s {
+	case SuiteBox:
+		return nil, nil
+	case SuiteAES256GCM:
+		key := make([]byte, 32)
+		kdf := hkdf.New(sha256.New, shared[:], salt, []byte("secure "+s.String()))
+		if _, err := io.ReadFull(kdf, key); err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	return nil, fmt.Errorf("secure: unsupported suite %v", s)
+}
+
+// selectSuite picks the first of the server's suites
+// that the client offered, or SuiteBox if there is none
+func selectSuite(server, client []Suite) Suite {
+	for _, s := range server {
+		for _, c := range client {
+			if s == c && s.supported() {
+				return s
+			}
+		}
+	}
+	return SuiteBox
+}
@@ -0,0 +1,95 @@
+package secure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSuiteAD(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	var wire bytes.Buffer
+	secureW := NewWriter(&wire, priv, pub)
+	if _, err := secureW.WriteWithAD([]byte("hello"), []byte("route=a")); err != ErrNoAD {
+		t.Fatalf("Expected ErrNoAD with box, got %v", err)
+	}
+
+	aead, err := SuiteAES256GCM.aead(&secureW.shared, []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secureW.aead = aead
+	secureW.WriteWithAD([]byte("hello world\n"), []byte("route=a"))
+	secureW.Write([]byte("no data"))
+	frame := append([]byte(nil), wire.Bytes()...)
+
+	secureR := NewReader(&wire, priv, pub)
+	secureR.aead = aead
+	buf := make([]byte, 1024)
+	for _, expected := range []struct{ msg, ad string }{
+		{"hello world\n", "route=a"},
+		{"no data", ""},
+	} {
+		n, ad, err := secureR.ReadWithAD(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != expected.msg || string(ad) != expected.ad {
+			t.Fatalf("Unexpected result: %q %q != %q %q", buf[:n], ad, expected.msg, expected.ad)
+		}
+	}
+
+	// the additional data is in the clear but authenticated
+	i := bytes.Index(frame, []byte("route=a"))
+	if i < 0 {
+		t.Fatal("Unexpected result. The additional data is not in the clear.")
+	}
+	frame[i] ^= 1
+	secureR = NewReader(bytes.NewReader(frame), priv, pub)
+	secureR.aead = aead
+	if _, _, err := secureR.ReadWithAD(buf); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt for tampered additional data, got %v", err)
+	}
+}
+
+func TestSuiteSealedSize(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	for _, suite := range []Suite{SuiteBox, SuiteAES256GCM} {
+		for _, ad := range []string{"", "route=a"} {
+			if suite == SuiteBox && ad != "" {
+				continue
+			}
+			var buf bytes.Buffer
+			secureW := NewWriter(&buf, priv, pub)
+			aead, err := suite.aead(&secureW.shared, []byte("salt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			secureW.aead = aead
+			if _, err := secureW.WriteWithAD(make([]byte, 12), []byte(ad)); err != nil {
+				t.Fatal(err)
+			}
+			if n := suite.SealedSize(12, len(ad)); buf.Len() != n {
+				t.Fatalf("Unexpected sealed size for %v with %q: %d != %d", suite, ad, buf.Len(), n)
+			}
+		}
+	}
+}
+
+func TestSelectSuite(t *testing.T) {
+	for _, tc := range []struct {
+		server, client []Suite
+		expected       Suite
+	}{
+		{nil, nil, SuiteBox},
+		{[]Suite{SuiteAES256GCM}, nil, SuiteBox},
+		{nil, []Suite{SuiteAES256GCM}, SuiteBox},
+		{[]Suite{SuiteAES256GCM}, []Suite{SuiteBox, SuiteAES256GCM}, SuiteAES256GCM},
+		{[]Suite{Suite(200)}, []Suite{Suite(200)}, SuiteBox},
+	} {
+		if s := selectSuite(tc.server, tc.client); s != tc.expected {
+			t.Fatalf("Unexpected suite for %v and %v: %v != %v", tc.server, tc.client, s, tc.expected)
+		}
+	}
+}
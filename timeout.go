@@ -0,0 +1,123 @@
+package secure
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default timeouts, used when the corresponding Config field is zero
+const (
+	// DefaultHandshakeTimeout bounds how long a handshake may take.
+	DefaultHandshakeTimeout = 10 * time.Second
+
+	// DefaultFrameTimeout bounds how long the rest of a frame may
+	// take to arrive once its first byte has.
+	DefaultFrameTimeout = 30 * time.Second
+
+	// DefaultWaitTimeout bounds how long the first peer on a Relay
+	// channel waits for the second.
+	DefaultWaitTimeout = 10 * time.Minute
+)
+
+// ErrHandshakeTimeout means that the peer did not complete the
+// handshake within Config.HandshakeTimeout
+var ErrHandshakeTimeout = errors.New("handshake timed out")
+
+// ErrFrameTimeout means that the peer started a frame but did not send
+// the rest of it within Config.FrameTimeout, and the connection was
+// terminated
+var ErrFrameTimeout = errors.New("frame timed out")
+
+// handshakeTimeout returns the HandshakeTimeout, or 0 if there is none
+func (c *Config) handshakeTimeout() time.Duration {
+	return timeoutOrDefault(c.HandshakeTimeout, DefaultHandshakeTimeout)
+}
+
+// frameTimeout returns the FrameTimeout, or 0 if there is none
+func (c *Config) frameTimeout() time.Duration {
+	return timeoutOrDefault(c.FrameTimeout, DefaultFrameTimeout)
+}
+
+func timeoutOrDefault(d, def time.Duration) time.Duration {
+	switch {
+	case d < 0:
+		return 0
+	case d == 0:
+		return def
+	}
+	return d
+}
+
+// startHandshakeTimer expires the connection's deadline once the
+// handshake has taken longer than the HandshakeTimeout, like
+// handshakeContext, and returns a func that stops it and reports
+// whether it expired. If it did, the deadlines set with SetDeadline
+// are put back, for a handshake that completed regardless.
+func (c *Conn) startHandshakeTimer() (stop func() (expired bool)) {
+	timeout := c.config.handshakeTimeout()
+	if timeout <= 0 {
+		return func() bool { return false }
+	}
+
+	fired := make(chan struct{})
+	t := time.AfterFunc(timeout, func() {
+		c.conn.SetDeadline(time.Unix(1, 0))
+		close(fired)
+	})
+	return func() bool {
+		if t.Stop() {
+			return false
+		}
+		<-fired
+		c.stateMu.Lock()
+		c.conn.SetReadDeadline(c.readDeadline)
+		c.conn.SetWriteDeadline(c.writeDeadline)
+		c.stateMu.Unlock()
+		return true
+	}
+}
+
+// A frameClock enforces the FrameTimeout of a Conn: it starts when the
+// first byte of a frame arrives and stops once the frame has been read.
+type frameClock struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	reading  bool
+	deadline time.Time
+}
+
+// frameRead starts the frame clock if started is true,
+// and stops it otherwise. It is called by the Reader.
+func (c *Conn) frameRead(started bool) {
+	f := &c.frames
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reading = started
+	if !started {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		return
+	}
+
+	timeout := c.config.frameTimeout()
+	f.deadline = time.Now().Add(timeout)
+	if f.timer == nil {
+		f.timer = time.AfterFunc(timeout, c.frameExpired)
+	} else {
+		f.timer.Reset(timeout)
+	}
+}
+
+// frameExpired terminates the connection if a frame is still being
+// read when the frame clock expires. The clock may have been stopped
+// and started again for the next frame since.
+func (c *Conn) frameExpired() {
+	c.frames.mu.Lock()
+	expired := c.frames.reading && !time.Now().Before(c.frames.deadline)
+	c.frames.mu.Unlock()
+	if expired {
+		c.abort(ErrFrameTimeout)
+	}
+}
@@ -0,0 +1,75 @@
+package secure
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	for timeout, want := range map[time.Duration]time.Duration{0: DefaultHandshakeTimeout, -1: 0, time.Second: time.Second} {
+		if got := (&Config{HandshakeTimeout: timeout}).handshakeTimeout(); got != want {
+			t.Fatalf("HandshakeTimeout %v resolved to %v", timeout, got)
+		}
+	}
+
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	server := NewServerConn(p2, &Config{HandshakeTimeout: 20 * time.Millisecond})
+	defer server.Close()
+
+	// a client that sends half its key and stalls
+	go func() {
+		p1.Read(make([]byte, KeySize))
+		p1.Write(make([]byte, KeySize/2))
+	}()
+	start := time.Now()
+	if err := server.Handshake(); err != ErrHandshakeTimeout {
+		t.Fatalf("Expected ErrHandshakeTimeout, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("The handshake took %v to time out", d)
+	}
+}
+
+func TestFrameTimeout(t *testing.T) {
+	p1, p2 := net.Pipe()
+	client := NewClientConn(p1, nil)
+	server := NewServerConn(p2, &Config{FrameTimeout: 20 * time.Millisecond})
+	defer client.Close()
+	defer server.Close()
+	// a pipe has no buffer, so the client must read the server's
+	// hello before writing
+	client.waitHello = true
+	go server.Handshake()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	// whole frames are read however long they take to start
+	read := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 64))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+
+	// but a frame that stops halfway terminates the connection
+	go p1.Write(make([]byte, HeaderSize/2))
+	start := time.Now()
+	if _, err := server.Read(make([]byte, 64)); err != ErrFrameTimeout {
+		t.Fatalf("Expected ErrFrameTimeout, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("The frame took %v to time out", d)
+	}
+	if _, err := server.Write([]byte("late")); err != ErrFrameTimeout {
+		t.Fatalf("Expected writes to fail with ErrFrameTimeout, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package secure
+
+import "context"
+
+// A Tracer starts the spans that connections report their work in, so
+// that the cost of the handshake and encryption shows up in distributed
+// traces. It is an interface so that the package does not depend on
+// OpenTelemetry; an adapter over an OpenTelemetry trace.Tracer takes a
+// few lines:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, secure.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) SetAttribute(key string, value interface{}) {
+//		s.Span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+//	}
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.Span.RecordError(err)
+//			s.Span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.Span.End()
+//	}
+type Tracer interface {
+	// Start starts a span called name as a child of the span in
+	// ctx, if any, and returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is an operation being traced.
+type Span interface {
+	// SetAttribute records a detail of the operation.
+	SetAttribute(key string, value interface{})
+
+	// End ends the span, with the error the operation failed
+	// with, if any.
+	End(err error)
+}
+
+// Names of the spans started by the package
+const (
+	// SpanDial covers dialing and the handshake of Dial,
+	// DialContext and Dialer.Dial.
+	SpanDial = "secure.Dial"
+
+	// SpanHandshake covers the handshake of a connection.
+	// When dialing, it is a child of SpanDial.
+	SpanHandshake = "secure.Handshake"
+
+	// SpanSend covers the whole request/response exchange of Send.
+	SpanSend = "secure.Send"
+
+	// SpanRekey marks a switch of the key of one direction with
+	// FrameRekey: the one a resumed Conn announces before its first
+	// frame, or one read from the peer.
+	SpanRekey = "secure.Rekey"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End(err error)                              {}
+
+// startSpan starts a span with the configured Tracer, if any
+func (c *Config) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.Tracer.Start(ctx, name)
+}
@@ -0,0 +1,181 @@
+package secure
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+// recordedSpan is a span kept by a recordingTracer
+type recordedSpan struct {
+	name, parent string
+	attrs        map[string]interface{}
+	ended        bool
+	err          error
+}
+
+type spanKey struct{}
+
+// recordingTracer records the spans started with it
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), tracedSpan{t, s}
+}
+
+// span returns the first span called name
+func (t *recordingTracer) span(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type tracedSpan struct {
+	t *recordingTracer
+	s *recordedSpan
+}
+
+func (s tracedSpan) SetAttribute(key string, value interface{}) {
+	s.t.mu.Lock()
+	s.s.attrs[key] = value
+	s.t.mu.Unlock()
+}
+
+func (s tracedSpan) End(err error) {
+	s.t.mu.Lock()
+	s.s.ended, s.s.err = true, err
+	s.t.mu.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	serverTracer := new(recordingTracer)
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{Tracer: serverTracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, MaxMessageSize)
+		n, _ := c.Read(buf)
+		c.Write(buf[:n])
+	}()
+
+	tracer := new(recordingTracer)
+	resp, err := Send(l.Addr().String(), []byte("traced"), &SendOptions{Config: &Config{Tracer: tracer}})
+	if err != nil || string(resp) != "traced" {
+		t.Fatalf("Unexpected result: %q, %v", resp, err)
+	}
+
+	for _, want := range []struct{ name, parent string }{
+		{SpanSend, ""},
+		{SpanDial, SpanSend},
+		{SpanHandshake, SpanDial},
+	} {
+		s := tracer.span(want.name)
+		if s == nil || !s.ended || s.err != nil || s.parent != want.parent {
+			t.Fatalf("Unexpected span %s: %+v", want.name, s)
+		}
+	}
+	if s := tracer.span(SpanSend); s.attrs["secure.request_size"] != 6 || s.attrs["secure.response_size"] != 6 {
+		t.Fatalf("Unexpected attributes %v", s.attrs)
+	}
+	if s := tracer.span(SpanHandshake); s.attrs["secure.client"] != true || s.attrs["secure.suite"] == nil {
+		t.Fatalf("Unexpected attributes %v", s.attrs)
+	}
+
+	// servers trace their handshakes too
+	s := serverTracer.span(SpanHandshake)
+	if s == nil || !s.ended || s.attrs["secure.client"] != false {
+		t.Fatalf("Unexpected server span %+v", s)
+	}
+
+	// failures end the spans with the error
+	tracer = new(recordingTracer)
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := (&Dialer{Config: &Config{Tracer: tracer}}).Dial("tcp", conn.Addr().String()); err == nil {
+		t.Fatal("Expected the dial to fail")
+	}
+	if s := tracer.span(SpanDial); s == nil || s.err == nil {
+		t.Fatalf("Unexpected span %+v", s)
+	}
+}
+
+func TestTracerRekey(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	clientTracer := new(recordingTracer)
+	c := NewClientConn(client, &Config{Suites: []Suite{SuiteAES256GCM}, Tracer: clientTracer})
+	c.waitHello = true
+	s := NewServerConn(server, &Config{Suites: []Suite{SuiteAES256GCM}})
+
+	done := make(chan error, 1)
+	go func() { done <- s.Handshake() }()
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.ExportSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the resumed server announces its new key with its first
+	// frame, which the client switches to
+	serverTracer := new(recordingTracer)
+	resumed, err := ResumeSession(state, server, &Config{Tracer: serverTracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := resumed.Write([]byte("rekeyed"))
+		done <- err
+	}()
+	buf := make([]byte, 64)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "rekeyed" {
+		t.Fatalf("Unexpected result: %q, %v", buf[:n], err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		tracer *recordingTracer
+		sent   bool
+	}{
+		{serverTracer, true},
+		{clientTracer, false},
+	} {
+		s := want.tracer.span(SpanRekey)
+		if s == nil || !s.ended || s.err != nil || s.attrs["secure.sent"] != want.sent {
+			t.Fatalf("Unexpected span %+v", s)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+)
+
+// Size (in bytes) of a resume request: the offset the receiver has
+// stored and the SHA-256 of the bytes before it
+const resumeRequestSize = 8 + sha256.Size
+
+// A truncater can drop the end of a file, as *os.File does
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// SendFile sends f over rw, which is normally a *Conn, to a peer
+// calling ReceiveFile. The receiver first reports how much of the file
+// it already has along with a hash of it, and if that prefix matches
+// f, only the rest is sent. Otherwise the whole file is sent again.
+// Over a *Conn, the contents are followed by the Manifest of the whole
+// file, which the receiver checks what it stored against.
+// It returns the offset the transfer resumed from. SendFile does not
+// close rw; the transfer is complete once it is closed.
+func SendFile(rw io.ReadWriter, f io.ReadSeeker) (int64, error) {
+	var req [resumeRequestSize]byte
+	if _, err := io.ReadFull(rw, req[:]); err != nil {
+		return 0, err
+	}
+	offset := int64(binary.BigEndian.Uint64(req[:8]))
+
+	// the receiver's prefix is kept only if it is the start of f
+	if offset > 0 {
+		sum, err := prefixHash(f, offset)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare(sum, req[8:]) != 1 {
+			offset = 0
+		}
+	}
+
+	var reply [8]byte
+	binary.BigEndian.PutUint64(reply[:], uint64(offset))
+	if _, err := rw.Write(reply[:]); err != nil {
+		return 0, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(chunkWriter{rw}, f); err != nil {
+		return offset, err
+	}
+
+	fw, ok := rw.(frameWriter)
+	if !ok {
+		return offset, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return offset, err
+	}
+	m, err := NewManifest(f)
+	if err != nil {
+		return offset, err
+	}
+	return offset, writeManifest(fw, m)
+}
+
+// ReceiveFile receives a file sent with SendFile over rw into f,
+// keeping whatever f already holds if the sender has the same bytes
+// at its start, so that an interrupted transfer can be resumed by
+// calling ReceiveFile again with the same f. If the sender's file
+// starts differently, f is rewritten from the start, and truncated
+// if it supports that. Over a *Conn, the whole of f is then read back
+// and checked against the sender's Manifest, so that corruption in
+// storage fails with a *ManifestError. It returns the offset the
+// transfer resumed from, and succeeds once the sender closes rw.
+func ReceiveFile(rw io.ReadWriter, f io.ReadWriteSeeker) (int64, error) {
+	var manifest *manifestReceiver
+	if h, ok := rw.(frameHandler); ok {
+		manifest = new(manifestReceiver)
+		h.Handle(FrameManifest, manifest.handle)
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	sum, err := prefixHash(f, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var req [resumeRequestSize]byte
+	binary.BigEndian.PutUint64(req[:8], uint64(size))
+	copy(req[8:], sum)
+	if _, err := rw.Write(req[:]); err != nil {
+		return 0, err
+	}
+
+	var reply [8]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return 0, err
+	}
+	offset := int64(binary.BigEndian.Uint64(reply[:]))
+	if offset != 0 && offset != size {
+		return 0, ErrFrame
+	}
+
+	if offset < size {
+		if t, ok := f.(truncater); ok {
+			if err := t.Truncate(offset); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(f, &frameBuffer{r: rw}); err != nil || manifest == nil {
+		return offset, err
+	}
+
+	m, err := manifest.manifest()
+	if err != nil {
+		return offset, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return offset, err
+	}
+	return offset, m.Verify(f)
+}
+
+// prefixHash returns the SHA-256 of the first n bytes of f. A file
+// shorter than n has no such prefix and yields a nil hash.
+func prefixHash(f io.ReadSeeker, n int64) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,134 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestReceiveFileResume(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make([]byte, 3*MaxMessageSize+100)
+	rand.Read(data)
+
+	offsets := make(chan int64, 1)
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		defer c.Close()
+		offset, err := SendFile(c, bytes.NewReader(data))
+		if err != nil {
+			offset = -1
+		}
+		offsets <- offset
+	})}).Serve(l)
+
+	f, err := ioutil.TempFile("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	corrupt := append([]byte(nil), data[:100]...)
+	corrupt[0] ^= 1
+	for _, tt := range []struct {
+		name   string
+		stored []byte
+		offset int64
+	}{
+		{"empty file", nil, 0},
+		{"received prefix", data[:MaxMessageSize+7], MaxMessageSize + 7},
+		{"complete file", data, int64(len(data))},
+		{"corrupt prefix", corrupt, 0},
+		{"longer file", append(append([]byte(nil), data...), 'x'), 0},
+	} {
+		if err := f.Truncate(0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(tt.stored, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := Dial("tcp", l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, err := ReceiveFile(conn, f)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if sent := <-offsets; offset != tt.offset || sent != tt.offset {
+			t.Fatalf("%s: unexpected offsets: received %d, sent %d, expected %d", tt.name, offset, sent, tt.offset)
+		}
+
+		got, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: unexpected result of %d bytes", tt.name, len(got))
+		}
+	}
+}
+
+// A corruptingFile flips a bit of everything written to it
+// at offset, like faulty storage would
+type corruptingFile struct {
+	io.ReadSeeker
+	f      *os.File
+	offset int64
+}
+
+func (f *corruptingFile) Write(p []byte) (int, error) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if i := f.offset - pos; i >= 0 && i < int64(len(p)) {
+		p = append([]byte(nil), p...)
+		p[i] ^= 1
+	}
+	return f.f.Write(p)
+}
+
+func TestReceiveFileCorrupt(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make([]byte, ManifestChunkSize+100)
+	rand.Read(data)
+	go (&Server{Handler: HandlerFunc(func(c *Conn) {
+		defer c.Close()
+		SendFile(c, bytes.NewReader(data))
+	})}).Serve(l)
+
+	f, err := ioutil.TempFile("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	conn, err := Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = ReceiveFile(conn, &corruptingFile{f, f, ManifestChunkSize + 50})
+	if e, ok := err.(*ManifestError); !ok || e.Chunk != 1 {
+		t.Fatalf("Expected a ManifestError for chunk 1, got %v", err)
+	}
+}
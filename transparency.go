@@ -0,0 +1,360 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// Prefix of the message a log signs, so that its tree heads
+// cannot be confused with anything else its key signs
+const treeHeadContext = "secure certificate log tree head\x00"
+
+// Version of the binary encoding of an InclusionProof
+const inclusionProofVersion = 1
+
+// Domain separation of the leaves and nodes of a log's hash tree,
+// as in RFC 6962
+const (
+	leafPrefix = 0
+	nodePrefix = 1
+)
+
+// ErrNotLogged means that a certificate came without a valid proof
+// of inclusion in one of the logs listed in Config.TrustedLogs
+var ErrNotLogged = errors.New("certificate not in a trusted log")
+
+// ErrLogProof means that a proof did not verify against a tree head,
+// or a tree head against the log's key
+var ErrLogProof = errors.New("invalid log proof")
+
+// A CertificateLog is an append-only log of issued device
+// certificates, in the manner of Certificate Transparency: every
+// certificate an identity issues is appended, and the log signs the
+// root of a Merkle tree over all of them. Peers requiring proof of
+// inclusion (see Config.TrustedLogs) only accept certificates that
+// were logged, so an identity whose key is stolen cannot mint a
+// certificate without it showing up where the identity's owner
+// monitors the log, and the log cannot drop certificates without
+// failing the consistency proofs that monitors compare tree heads
+// with.
+//
+// The log is kept in memory. To persist it, store the certificates
+// and append them again, in the same order, on start.
+type CertificateLog struct {
+	key ed25519.PrivateKey
+
+	mu     sync.Mutex
+	leaves [][sha256.Size]byte
+	certs  []*Certificate
+}
+
+// NewCertificateLog returns an empty log signing its
+// tree heads with key.
+func NewCertificateLog(key ed25519.PrivateKey) *CertificateLog {
+	return &CertificateLog{key: key}
+}
+
+// A TreeHead is a log's signed commitment to its first Size entries.
+type TreeHead struct {
+	Size      uint64
+	Root      [sha256.Size]byte
+	Signature []byte
+}
+
+// signed returns the message the log signs
+func (h *TreeHead) signed() []byte {
+	b := []byte(treeHeadContext)
+	b = appendUint64(b, h.Size)
+	return append(b, h.Root[:]...)
+}
+
+// Verify checks that h was signed by the log with public key logKey.
+func (h *TreeHead) Verify(logKey ed25519.PublicKey) error {
+	if len(logKey) != ed25519.PublicKeySize || !ed25519.Verify(logKey, h.signed(), h.Signature) {
+		return ErrLogProof
+	}
+	return nil
+}
+
+// An InclusionProof proves that a certificate is the entry at Index of
+// a log whose signed tree head is Head. It is sent along with the
+// certificate in the handshake (see Config.CertificateProof).
+type InclusionProof struct {
+	Head  TreeHead
+	Index uint64
+	Path  [][sha256.Size]byte
+}
+
+// Append adds cert to the log and returns the proof of its inclusion
+// in the log's new tree head.
+func (l *CertificateLog) Append(cert *Certificate) (*InclusionProof, error) {
+	leaf, err := cert.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leaves = append(l.leaves, leafHash(leaf))
+	l.certs = append(l.certs, cert)
+	return l.prove(uint64(len(l.leaves) - 1)), nil
+}
+
+// Prove returns the proof that cert is included in the log's current
+// tree head, such as for certificates appended before the head that
+// peers have last seen. It fails with ErrNotLogged if cert is not in
+// the log.
+func (l *CertificateLog) Prove(cert *Certificate) (*InclusionProof, error) {
+	leaf, err := cert.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := leafHash(leaf)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, x := range l.leaves {
+		if x == h {
+			return l.prove(uint64(i)), nil
+		}
+	}
+	return nil, ErrNotLogged
+}
+
+// prove returns the inclusion proof of the leaf at index
+// in the current tree head
+func (l *CertificateLog) prove(index uint64) *InclusionProof {
+	return &InclusionProof{
+		Head:  l.head(),
+		Index: index,
+		Path:  inclusionPath(index, l.leaves),
+	}
+}
+
+// TreeHead returns the log's current signed tree head.
+func (l *CertificateLog) TreeHead() *TreeHead {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.head()
+	return &h
+}
+
+func (l *CertificateLog) head() TreeHead {
+	h := TreeHead{Size: uint64(len(l.leaves)), Root: treeHash(l.leaves)}
+	h.Signature = ed25519.Sign(l.key, h.signed())
+	return h
+}
+
+// Entries returns the certificates in the log, in order, for
+// monitoring what was issued and for persisting the log.
+func (l *CertificateLog) Entries() []*Certificate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Certificate(nil), l.certs...)
+}
+
+// ConsistencyProof returns the proof that the log's first size entries
+// are unchanged in its current tree head, for monitors holding an
+// older tree head of that size to check with VerifyConsistency.
+func (l *CertificateLog) ConsistencyProof(size uint64) ([][sha256.Size]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if size > uint64(len(l.leaves)) {
+		return nil, ErrLogProof
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	return consistencyPath(size, l.leaves, true), nil
+}
+
+// Verify checks that p proves that cert is in a log signing with
+// logKey.
+func (p *InclusionProof) Verify(cert *Certificate, logKey ed25519.PublicKey) error {
+	if err := p.Head.Verify(logKey); err != nil {
+		return err
+	}
+	leaf, err := cert.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if p.Index >= p.Head.Size {
+		return ErrLogProof
+	}
+
+	fn, sn := p.Index, p.Head.Size-1
+	r := leafHash(leaf)
+	for _, h := range p.Path {
+		if sn == 0 {
+			return ErrLogProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(h, r)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			r = nodeHash(r, h)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 || r != p.Head.Root {
+		return ErrLogProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that proof, from ConsistencyProof, proves
+// that the log with tree head newer only appended to the entries of
+// older, so that peers gossiping the tree heads they have seen can
+// tell whether the log showed them all the same history. Both heads
+// must have been verified.
+func VerifyConsistency(older, newer *TreeHead, proof [][sha256.Size]byte) error {
+	switch {
+	case older.Size > newer.Size:
+		return ErrLogProof
+	case older.Size == newer.Size:
+		if len(proof) != 0 || older.Root != newer.Root {
+			return ErrLogProof
+		}
+		return nil
+	case older.Size == 0:
+		return nil
+	}
+
+	if older.Size&(older.Size-1) == 0 {
+		proof = append([][sha256.Size]byte{older.Root}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrLogProof
+	}
+	fn, sn := older.Size-1, newer.Size-1
+	for fn&1 == 1 {
+		fn, sn = fn>>1, sn>>1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrLogProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr, sr = nodeHash(c, fr), nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 || fr != older.Root || sr != newer.Root {
+		return ErrLogProof
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *InclusionProof) MarshalBinary() ([]byte, error) {
+	if len(p.Head.Signature) != ed25519.SignatureSize || len(p.Path) > 64 {
+		return nil, ErrLogProof
+	}
+	b := []byte{inclusionProofVersion}
+	b = appendUint64(b, p.Head.Size)
+	b = append(b, p.Head.Root[:]...)
+	b = append(b, p.Head.Signature...)
+	b = appendUint64(b, p.Index)
+	b = append(b, byte(len(p.Path)))
+	for _, h := range p.Path {
+		b = append(b, h[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It does not
+// verify the proof.
+func (p *InclusionProof) UnmarshalBinary(data []byte) error {
+	const fixed = 1 + 8 + sha256.Size + ed25519.SignatureSize + 8 + 1
+	if len(data) < fixed || data[0] != inclusionProofVersion {
+		return ErrLogProof
+	}
+	n := int(data[fixed-1])
+	if len(data) != fixed+n*sha256.Size {
+		return ErrLogProof
+	}
+
+	b := data[1:]
+	p.Head.Size = binary.BigEndian.Uint64(b)
+	b = b[8:]
+	copy(p.Head.Root[:], b)
+	b = b[sha256.Size:]
+	p.Head.Signature = append([]byte(nil), b[:ed25519.SignatureSize]...)
+	b = b[ed25519.SignatureSize:]
+	p.Index = binary.BigEndian.Uint64(b)
+	b = b[9:]
+	p.Path = make([][sha256.Size]byte, n)
+	for i := range p.Path {
+		copy(p.Path[i][:], b[i*sha256.Size:])
+	}
+	return nil
+}
+
+func leafHash(leaf []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte{leafPrefix}, leaf...))
+}
+
+func nodeHash(left, right [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(bytes.Join([][]byte{{nodePrefix}, left[:], right[:]}, nil))
+}
+
+// split returns the largest power of two smaller than n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// treeHash returns the root of the hash tree over leaves
+func treeHash(leaves [][sha256.Size]byte) [sha256.Size]byte {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+// inclusionPath returns the audit path of the leaf at index
+func inclusionPath(index uint64, leaves [][sha256.Size]byte) [][sha256.Size]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < uint64(k) {
+		return append(inclusionPath(index, leaves[:k]), treeHash(leaves[k:]))
+	}
+	return append(inclusionPath(index-uint64(k), leaves[k:]), treeHash(leaves[:k]))
+}
+
+// consistencyPath returns the proof that the first m leaves are a
+// prefix of leaves; whole is set while the first m leaves cover a
+// subtree the verifier knows the root of
+func consistencyPath(m uint64, leaves [][sha256.Size]byte, whole bool) [][sha256.Size]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if whole {
+			return nil
+		}
+		return [][sha256.Size]byte{treeHash(leaves)}
+	}
+	k := uint64(split(len(leaves)))
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], whole), treeHash(leaves[k:]))
+	}
+	return append(consistencyPath(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
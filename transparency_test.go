@@ -0,0 +1,219 @@
+package secure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestCertificateLog(t *testing.T) {
+	logPub, logPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, idPriv, _ := ed25519.GenerateKey(rand.Reader)
+	otherLog, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	log := NewCertificateLog(logPriv)
+	var certs []*Certificate
+	var heads []*TreeHead
+	for i := 0; i < 20; i++ {
+		pub, _, _ := box.GenerateKey(rand.Reader)
+		cert, err := Certify(idPriv, "device", pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := log.Append(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(cert, logPub); err != nil {
+			t.Fatalf("Proof of entry %d: %v", i, err)
+		}
+		if err := proof.Verify(cert, otherLog); err != ErrLogProof {
+			t.Fatalf("Expected ErrLogProof for another log, got %v", err)
+		}
+		certs = append(certs, cert)
+		heads = append(heads, log.TreeHead())
+	}
+
+	// every entry is proven in the latest head, and none for another
+	for i, cert := range certs {
+		proof, err := log.Prove(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Index != uint64(i) || proof.Head.Size != 20 {
+			t.Fatalf("Unexpected proof of entry %d: %+v", i, proof)
+		}
+		if err := proof.Verify(cert, logPub); err != nil {
+			t.Fatalf("Proof of entry %d: %v", i, err)
+		}
+		if err := proof.Verify(certs[(i+1)%len(certs)], logPub); err != ErrLogProof {
+			t.Fatalf("Expected ErrLogProof for another entry, got %v", err)
+		}
+
+		b, err := proof.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded InclusionProof
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(cert, logPub); err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j < len(b); j++ {
+			b[j] ^= 1
+			var p InclusionProof
+			if p.UnmarshalBinary(b) == nil && p.Verify(cert, logPub) == nil {
+				t.Fatalf("Tampered byte %d of proof %d went unnoticed", j, i)
+			}
+			b[j] ^= 1
+		}
+	}
+	pub, _, _ := box.GenerateKey(rand.Reader)
+	unlogged, _ := Certify(idPriv, "device", pub)
+	if _, err := log.Prove(unlogged); err != ErrNotLogged {
+		t.Fatalf("Expected ErrNotLogged, got %v", err)
+	}
+	if n := len(log.Entries()); n != 20 {
+		t.Fatalf("Unexpected number of entries: %d", n)
+	}
+
+	// each earlier head is consistent with every later one
+	for i, older := range heads {
+		for _, newer := range heads[i:] {
+			replay := NewCertificateLog(logPriv)
+			for _, cert := range certs[:newer.Size] {
+				replay.Append(cert)
+			}
+			proof, err := replay.ConsistencyProof(older.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyConsistency(older, newer, proof); err != nil {
+				t.Fatalf("Consistency of %d and %d: %v", older.Size, newer.Size, err)
+			}
+		}
+	}
+
+	// a log that rewrote its history is caught
+	forked := NewCertificateLog(logPriv)
+	for _, cert := range certs[:10] {
+		forked.Append(cert)
+	}
+	forked.Append(unlogged)
+	for _, cert := range certs[11:] {
+		forked.Append(cert)
+	}
+	for _, older := range heads[10:19] {
+		proof, err := forked.ConsistencyProof(older.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyConsistency(older, forked.TreeHead(), proof); err != ErrLogProof {
+			t.Fatalf("Expected ErrLogProof for a fork at size %d, got %v", older.Size, err)
+		}
+	}
+}
+
+func TestConnTrustedLogs(t *testing.T) {
+	logPub, logPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherLogPriv, _ := ed25519.GenerateKey(rand.Reader)
+	idPub, idPriv, _ := ed25519.GenerateKey(rand.Reader)
+	log := NewCertificateLog(logPriv)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go (&Server{
+		Config: &Config{
+			TrustedIdentities: []ed25519.PublicKey{idPub},
+			TrustedLogs:       []ed25519.PublicKey{logPub},
+		},
+		Handler: HandlerFunc(func(c *Conn) {
+			if c.Handshake() != nil {
+				return
+			}
+			proof := c.ConnectionState().PeerCertificateProof
+			if proof == nil || proof.Head.Verify(logPub) != nil {
+				return
+			}
+			c.Write([]byte("ok"))
+		}),
+	}).Serve(l)
+
+	device := func(log *CertificateLog) *Config {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := Certify(idPriv, "laptop", pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &Config{PublicKey: pub, PrivateKey: priv, Certificate: cert}
+		if log != nil {
+			if config.CertificateProof, err = log.Append(cert); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return config
+	}
+
+	read := func(config *Config) (string, error) {
+		conn, err := Dial("tcp", l.Addr().String(), config)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		return string(buf[:n]), err
+	}
+
+	got, err := read(device(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ok" {
+		t.Fatalf("Unexpected result: %q", got)
+	}
+
+	// a certificate logged after its proof was made
+	// is proven again in the latest head
+	late := device(log)
+	log.Append(device(nil).Certificate)
+	if late.CertificateProof, err = log.Prove(late.Certificate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(late); err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof must be for this certificate
+	swapped := device(nil)
+	swapped.CertificateProof = late.CertificateProof
+
+	for name, config := range map[string]*Config{
+		"no proof":                         device(nil),
+		"proof of an entry of another log": device(NewCertificateLog(otherLogPriv)),
+		"proof of another certificate":     swapped,
+	} {
+		if _, err := read(config); err == nil {
+			t.Fatalf("The server accepted a client with %s", name)
+		}
+	}
+}
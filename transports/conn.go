@@ -0,0 +1,68 @@
+package transports
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrUnsupported means that a transport is not available
+// on this operating system
+var ErrUnsupported = errors.New("transports: not supported on this operating system")
+
+// An Addr names the device or pipe at one end of a stream.
+type Addr struct {
+	Net, Name string
+}
+
+// Network returns the kind of stream, such as "pipe" or "serial".
+func (a Addr) Network() string { return a.Net }
+
+// String returns the name of the device or pipe.
+func (a Addr) String() string { return a.Name }
+
+// deadliner is implemented by streams supporting deadlines,
+// such as *os.File for pollable files
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+type streamConn struct {
+	io.ReadWriteCloser
+	addr Addr
+}
+
+// NewConn returns a net.Conn reading and writing rw, whose local and
+// remote address are both addr. Deadlines are passed on to rw if it
+// supports them, as an *os.File does for terminals and pipes the
+// runtime can poll, and otherwise fail with ErrUnsupported.
+func NewConn(rw io.ReadWriteCloser, addr Addr) net.Conn {
+	return &streamConn{rw, addr}
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.addr }
+func (c *streamConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(deadliner); ok {
+		return d.SetDeadline(t)
+	}
+	return ErrUnsupported
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrUnsupported
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrUnsupported
+}
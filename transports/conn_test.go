@@ -0,0 +1,101 @@
+package transports
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jboverfelt/secure"
+)
+
+// pipeEnds joins the read end of one pipe
+// and the write end of another
+type pipeEnds struct {
+	*os.File
+	w *os.File
+}
+
+func (p pipeEnds) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func (p pipeEnds) Close() error {
+	p.w.Close()
+	return p.File.Close()
+}
+
+// streamPair returns two connected streams made of os pipes
+func streamPair(t *testing.T) (net.Conn, net.Conn) {
+	r1, w1, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, w2, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewConn(pipeEnds{r1, w2}, Addr{"pipe", "a"}), NewConn(pipeEnds{r2, w1}, Addr{"pipe", "b"})
+}
+
+// exchange runs a secure echo over the two ends of a stream
+func exchange(t *testing.T, client, server net.Conn) {
+	done := make(chan error, 1)
+	read := make(chan struct{})
+	go func() {
+		c := secure.NewServerConn(server, nil)
+		defer c.Close()
+		buf := make([]byte, secure.MaxMessageSize)
+		n, err := c.Read(buf)
+		if err == nil {
+			_, err = c.Write(buf[:n])
+		}
+		done <- err
+
+		// closing a pseudo-terminal's master discards
+		// what its slave has not read yet
+		<-read
+	}()
+
+	c := secure.NewClientConn(client, nil)
+	defer c.Close()
+	if _, err := io.WriteString(c, "provision me"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, secure.MaxMessageSize)
+	n, err := c.Read(buf)
+	close(read)
+	if err != nil || string(buf[:n]) != "provision me" {
+		t.Fatalf("Unexpected result: %q, %v", buf[:n], err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, server := streamPair(t)
+	if client.RemoteAddr().Network() != "pipe" || client.LocalAddr().String() != "a" {
+		t.Fatalf("Unexpected address %v", client.LocalAddr())
+	}
+	exchange(t, client, server)
+}
+
+func TestConnDeadline(t *testing.T) {
+	client, server := streamPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	// os pipes are polled, so their deadlines are passed on
+	if err := client.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(make([]byte, 1)); !os.IsTimeout(err) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+
+	// streams without deadlines say so
+	c := NewConn(struct{ io.ReadWriteCloser }{client}, Addr{})
+	if err := c.SetDeadline(time.Now()); err != ErrUnsupported {
+		t.Fatalf("Expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+// Package transports adapts byte streams other than network sockets,
+// such as Windows named pipes, serial ports and WebSocket connections,
+// to net.Conn, so that a secure.Conn can run over them for local RPC,
+// device provisioning or browser clients:
+//
+//	port, err := transports.OpenSerial("/dev/ttyUSB0", 115200)
+//	if err != nil {
+//		return err
+//	}
+//	conn := secure.NewClientConn(port, config)
+//
+// Package secure has no transport interface of its own: a Conn runs
+// over any net.Conn, which is what these adapters return. Named pipes
+// are only available on Windows, and serial ports on Linux and
+// Windows; elsewhere the functions fail with ErrUnsupported.
+//
+// Package secure and this package build for GOOS=js GOARCH=wasm. There,
+// DialWebSocket goes through the browser's WebSocket API, so a Go client
+// compiled to WebAssembly can reach a native server accepting with
+// AcceptWebSocket.
+package transports
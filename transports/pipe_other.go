@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package transports
+
+import "net"
+
+// DialPipe fails with ErrUnsupported: named pipes
+// are only supported on Windows.
+func DialPipe(name string) (net.Conn, error) {
+	return nil, ErrUnsupported
+}
+
+// ListenPipe fails with ErrUnsupported: named pipes
+// are only supported on Windows.
+func ListenPipe(name string) (net.Listener, error) {
+	return nil, ErrUnsupported
+}
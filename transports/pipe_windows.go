@@ -0,0 +1,117 @@
+package transports
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+const (
+	pipeAccessDuplex       = 0x3
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 64 << 10
+	errorPipeConnected     = syscall.Errno(535)
+)
+
+// errListenerClosed means that Accept was called on a closed listener
+var errListenerClosed = errors.New("transports: listener closed")
+
+// pipePath returns the path of the named pipe name, which may
+// be given as a full \\.\pipe\ path or as the bare name
+func pipePath(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+	return `\\.\pipe\` + name
+}
+
+// DialPipe connects to the named pipe name, such as `\\.\pipe\secure`
+// or just "secure". The connection does not support deadlines.
+func DialPipe(name string) (net.Conn, error) {
+	path := pipePath(name)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(f, Addr{"pipe", path}), nil
+}
+
+type pipeListener struct {
+	path string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// ListenPipe returns a listener accepting connections to the named
+// pipe name, such as `\\.\pipe\secure` or just "secure". Each accepted
+// connection is a new instance of the pipe.
+func ListenPipe(name string) (net.Listener, error) {
+	return &pipeListener{path: pipePath(name)}, nil
+}
+
+func (l *pipeListener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// Accept creates an instance of the pipe and waits for a client
+// to connect to it.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	if l.isClosed() {
+		return nil, errListenerClosed
+	}
+	path, err := syscall.UTF16PtrFromString(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(path)),
+		pipeAccessDuplex, 0, pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, err
+	}
+	if ok, _, err := procConnectNamedPipe.Call(h, 0); ok == 0 && err != errorPipeConnected {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, err
+	}
+
+	// Close connects to the pipe itself to end a pending Accept
+	if l.isClosed() {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, errListenerClosed
+	}
+	return NewConn(os.NewFile(h, l.path), Addr{"pipe", l.path}), nil
+}
+
+// Close stops the listener. A pending Accept is woken up by
+// connecting to the pipe, and fails.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	if f, err := os.OpenFile(l.path, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return Addr{"pipe", l.path}
+}
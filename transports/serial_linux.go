@@ -0,0 +1,81 @@
+package transports
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// cbaud masks the speed bits of the termios control flags
+const cbaud = 0x100f
+
+var bauds = map[int]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+// OpenSerial opens the serial port at name, such as /dev/ttyUSB0,
+// in raw mode at baud bits per second, with 8 data bits, no parity
+// and one stop bit. The connection supports deadlines.
+func OpenSerial(name string, baud int) (net.Conn, error) {
+	speed, ok := bauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("transports: unsupported baud rate %d", baud)
+	}
+
+	// non-blocking, so that the runtime polls it and deadlines work
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var ioctlErr error
+	err = rc.Control(func(fd uintptr) {
+		var t syscall.Termios
+		if ioctlErr = ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)); ioctlErr != nil {
+			return
+		}
+		makeRaw(&t, speed)
+		ioctlErr = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t))
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return NewConn(f, Addr{"serial", name}), nil
+}
+
+// makeRaw sets t up like cfmakeraw, at the given speed
+func makeRaw(t *syscall.Termios, speed uint32) {
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | cbaud
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Ispeed, t.Ospeed = speed, speed
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package transports
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPTY returns the master of a pseudo-terminal
+// and the path of its slave
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo-terminals:", err)
+	}
+	var unlock, n int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		t.Skip("cannot unlock the pseudo-terminal:", err)
+	}
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		t.Skip("cannot name the pseudo-terminal:", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpenSerial(t *testing.T) {
+	master, name := openPTY(t)
+
+	// a pseudo-terminal takes the same settings as a serial port,
+	// and raw mode keeps it from mangling the frames
+	port, err := OpenSerial(name, 115200)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	exchange(t, port, NewConn(master, Addr{"serial", "/dev/ptmx"}))
+
+	if _, err := OpenSerial(name, 1234); err == nil {
+		t.Fatal("Unexpected result. An unsupported baud rate was accepted.")
+	}
+}
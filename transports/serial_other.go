@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package transports
+
+import "net"
+
+// OpenSerial fails with ErrUnsupported: serial ports
+// are only supported on Linux and Windows.
+func OpenSerial(name string, baud int) (net.Conn, error) {
+	return nil, ErrUnsupported
+}
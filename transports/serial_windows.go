@@ -0,0 +1,87 @@
+package transports
+
+import (
+	"net"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+var (
+	procGetCommState    = kernel32.NewProc("GetCommState")
+	procSetCommState    = kernel32.NewProc("SetCommState")
+	procSetCommTimeouts = kernel32.NewProc("SetCommTimeouts")
+)
+
+// dcb is the Win32 DCB structure describing a serial port's settings
+type dcb struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// commTimeouts is the Win32 COMMTIMEOUTS structure
+type commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+// dcbBinary is the fBinary flag, which Windows requires
+const dcbBinary = 0x1
+
+// OpenSerial opens the serial port name, such as COM3, at baud bits
+// per second, with 8 data bits, no parity and one stop bit. The
+// connection does not support deadlines.
+func OpenSerial(name string, baud int) (net.Conn, error) {
+	path := name
+	if !strings.HasPrefix(path, `\\`) {
+		// COM10 and up can only be opened by their device path
+		path = `\\.\` + name
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	h := f.Fd()
+
+	d := dcb{DCBlength: uint32(unsafe.Sizeof(dcb{}))}
+	if ok, _, err := procGetCommState.Call(h, uintptr(unsafe.Pointer(&d))); ok == 0 {
+		f.Close()
+		return nil, err
+	}
+	d.BaudRate = uint32(baud)
+	d.Flags = dcbBinary
+	d.ByteSize, d.Parity, d.StopBits = 8, 0, 0
+	if ok, _, err := procSetCommState.Call(h, uintptr(unsafe.Pointer(&d))); ok == 0 {
+		f.Close()
+		return nil, err
+	}
+
+	// reads return as soon as any byte has arrived,
+	// instead of waiting for the whole buffer
+	t := commTimeouts{
+		ReadIntervalTimeout:        ^uint32(0),
+		ReadTotalTimeoutMultiplier: ^uint32(0),
+		ReadTotalTimeoutConstant:   ^uint32(0) - 1,
+	}
+	if ok, _, err := procSetCommTimeouts.Call(h, uintptr(unsafe.Pointer(&t))); ok == 0 {
+		f.Close()
+		return nil, err
+	}
+	return NewConn(f, Addr{"serial", name}), nil
+}
@@ -0,0 +1,288 @@
+package transports
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrNotWebSocket means that a request or response was not a valid
+// WebSocket handshake
+var ErrNotWebSocket = errors.New("transports: not a websocket handshake")
+
+// errFrameTooLarge means that a peer sent a frame larger than a
+// WebSocket connection accepts
+var errFrameTooLarge = errors.New("transports: websocket frame too large")
+
+// websocketGUID is mixed into the handshake key, as in RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxControlPayload is the largest payload of a control frame
+const maxControlPayload = 125
+
+// websocketAccept returns the Sec-WebSocket-Accept value for key
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma separated
+// header name in h lists token, ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AcceptWebSocket completes the WebSocket handshake of r, which must
+// be a GET request asking to upgrade, and returns the connection as a
+// net.Conn carrying the stream in binary messages. On failure it
+// replies to r with 400 Bad Request. Browsers can then run the
+// protocol to a native server, by dialing with DialWebSocket from a
+// program built for GOOS=js:
+//
+//	http.HandleFunc("/secure", func(w http.ResponseWriter, r *http.Request) {
+//		ws, err := transports.AcceptWebSocket(w, r)
+//		if err != nil {
+//			return
+//		}
+//		conn := secure.NewServerConn(ws, config)
+//		...
+//	})
+func AcceptWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, ErrNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket: connection cannot be taken over", http.StatusInternalServerError)
+		return nil, errors.New("transports: response does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWebSocketConn(conn, rw.Reader, false), nil
+}
+
+// A webSocketConn carries a stream over a WebSocket connection,
+// writing each Write as one binary message and reading messages,
+// binary or text, back to back
+type webSocketConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	// client is set on the dialing side, which must mask its frames
+	client bool
+
+	rmu sync.Mutex
+
+	// remaining is what is left of the payload of the current
+	// data frame, unmasked with mask from maskPos on
+	remaining uint64
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+
+	// eof is set once the peer sent a close frame
+	eof bool
+
+	wmu sync.Mutex
+
+	// closeSent is set once a close frame was written
+	closeSent bool
+
+	closeOnce sync.Once
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, client bool) *webSocketConn {
+	return &webSocketConn{Conn: conn, br: br, client: client}
+}
+
+// readHeader reads the header of the next frame
+func (c *webSocketConn) readHeader() (opcode byte, length uint64, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return 0, 0, err
+	}
+	opcode = h[0] & 0x0f
+	length = uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, 0, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > 1<<31 {
+		return 0, 0, errFrameTooLarge
+	}
+	c.masked, c.maskPos = h[1]&0x80 != 0, 0
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return opcode, length, nil
+}
+
+// unmask unmasks p, the next bytes of the current frame's payload
+func (c *webSocketConn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		opcode, length, err := c.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case opContinuation, opText, opBinary:
+			c.remaining = length
+			continue
+		}
+
+		// control frames are short and never fragmented
+		if length > maxControlPayload {
+			return 0, errFrameTooLarge
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return 0, err
+		}
+		c.unmask(payload)
+		switch opcode {
+		case opPing:
+			c.writeFrame(opPong, payload)
+		case opClose:
+			c.eof = true
+			c.writeFrame(opClose, payload)
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// writeFrame writes one final frame holding p
+func (c *webSocketConn) writeFrame(opcode byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if opcode == opClose {
+		if c.closeSent {
+			return nil
+		}
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 2, 14+len(p))
+	frame[0] = 0x80 | opcode
+	switch {
+	case len(p) < 126:
+		frame[1] = byte(len(p))
+	case len(p) <= 0xffff:
+		frame[1] = 126
+		frame = frame[:4]
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(p)))
+	default:
+		frame[1] = 127
+		frame = frame[:10]
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(p)))
+	}
+	if !c.client {
+		frame = append(frame, p...)
+	} else {
+		frame[1] |= 0x80
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, p...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i&3]
+		}
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame, if the connection is still
+// writable, and closes the underlying connection.
+func (c *webSocketConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000, normal closure
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,215 @@
+//go:build js && wasm
+// +build js,wasm
+
+package transports
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// A jsWebSocket is a net.Conn over the browser's WebSocket API. The
+// browser delivers messages to callbacks on its event loop, which
+// queue them for Read without blocking.
+type jsWebSocket struct {
+	ws    js.Value
+	addr  Addr
+	funcs []js.Func
+
+	mu   sync.Mutex
+	cond sync.Cond
+
+	// queue holds the messages not yet read, the first of
+	// them possibly in part
+	queue [][]byte
+
+	// open is set once the connection is established, and
+	// err once it failed or was closed
+	open bool
+	err  error
+
+	readDeadline time.Time
+	timer        *time.Timer
+}
+
+// DialWebSocket connects to the WebSocket endpoint rawurl, a ws:// or
+// wss:// URL, such as one served with AcceptWebSocket, through the
+// browser's WebSocket API, and returns the connection as a net.Conn
+// carrying the stream in binary messages. Browsers do not let pages
+// use plain sockets, so this is how a Go program compiled to
+// WebAssembly reaches a native server.
+func DialWebSocket(rawurl string) (net.Conn, error) {
+	constructor := js.Global().Get("WebSocket")
+	if constructor.IsUndefined() {
+		return nil, ErrUnsupported
+	}
+
+	c := &jsWebSocket{addr: Addr{"websocket", rawurl}}
+	c.cond.L = &c.mu
+	var err error
+	func() {
+		// the constructor throws on malformed URLs
+		defer func() {
+			if r := recover(); r != nil {
+				err = &net.OpError{Op: "dial", Net: "websocket", Err: ErrNotWebSocket}
+			}
+		}()
+		c.ws = constructor.New(rawurl)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	c.ws.Set("binaryType", "arraybuffer")
+
+	c.on("open", func(js.Value) {
+		c.open = true
+	})
+	c.on("message", func(ev js.Value) {
+		data := ev.Get("data")
+		var msg []byte
+		if data.Type() == js.TypeString {
+			msg = []byte(data.String())
+		} else {
+			array := js.Global().Get("Uint8Array").New(data)
+			msg = make([]byte, array.Get("length").Int())
+			js.CopyBytesToGo(msg, array)
+		}
+		if len(msg) > 0 {
+			c.queue = append(c.queue, msg)
+		}
+	})
+	c.on("error", func(js.Value) {
+		if c.err == nil {
+			c.err = errors.New("transports: websocket error")
+		}
+	})
+	c.on("close", func(js.Value) {
+		if !c.open {
+			c.err = &net.OpError{Op: "dial", Net: "websocket", Err: errors.New("connection refused")}
+		} else if c.err == nil {
+			c.err = io.EOF
+		}
+	})
+
+	c.mu.Lock()
+	for !c.open && c.err == nil {
+		c.cond.Wait()
+	}
+	err = c.err
+	c.mu.Unlock()
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// on registers fn as the handler of the socket's event, called
+// with the lock held and waking up readers afterwards
+func (c *jsWebSocket) on(event string, fn func(ev js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		c.mu.Lock()
+		fn(args[0])
+		c.mu.Unlock()
+		c.cond.Broadcast()
+		return nil
+	})
+	c.funcs = append(c.funcs, f)
+	c.ws.Call("addEventListener", event, f)
+}
+
+// release closes the socket and frees the callbacks
+func (c *jsWebSocket) release() {
+	c.ws.Call("close")
+	for _, f := range c.funcs {
+		f.Release()
+	}
+	c.funcs = nil
+}
+
+// expired reports whether the read deadline has passed
+func (c *jsWebSocket) expired() bool {
+	return !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline)
+}
+
+func (c *jsWebSocket) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) == 0 && c.err == nil && !c.expired() {
+		c.cond.Wait()
+	}
+	if len(c.queue) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(p, c.queue[0])
+	if c.queue[0] = c.queue[0][n:]; len(c.queue[0]) == 0 {
+		c.queue = c.queue[1:]
+	}
+	return n, nil
+}
+
+// Write queues p as one binary message. The browser buffers what
+// it has not sent yet, so Write does not block.
+func (c *jsWebSocket) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	array := js.Global().Get("Uint8Array").New(len(p))
+	js.CopyBytesToJS(array, p)
+	c.ws.Call("send", array)
+	return len(p), nil
+}
+
+func (c *jsWebSocket) Close() error {
+	c.mu.Lock()
+	if c.err == net.ErrClosed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.err = net.ErrClosed
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	c.release()
+	return nil
+}
+
+func (c *jsWebSocket) LocalAddr() net.Addr  { return c.addr }
+func (c *jsWebSocket) RemoteAddr() net.Addr { return c.addr }
+
+func (c *jsWebSocket) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *jsWebSocket) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !t.IsZero() {
+		c.timer = time.AfterFunc(time.Until(t), c.cond.Broadcast)
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline does nothing, as Write never blocks.
+func (c *jsWebSocket) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package transports
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSocket(t *testing.T) {
+	accepted := make(chan net.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := AcceptWebSocket(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- ws
+	}))
+	defer srv.Close()
+
+	client, err := DialWebSocket("ws" + strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, client, <-accepted)
+}
+
+func TestWebSocketFraming(t *testing.T) {
+	client, server := net.Pipe()
+	c := newWebSocketConn(client, bufio.NewReader(client), true)
+	s := newWebSocketConn(server, bufio.NewReader(server), false)
+	defer c.Close()
+	defer s.Close()
+	go io.Copy(ioutil.Discard, c)
+
+	// a ping is answered and skipped, and sizes take each length encoding
+	go func() {
+		c.writeFrame(opPing, []byte("ping"))
+		for _, n := range []int{5, 300, 70000} {
+			c.Write(make([]byte, n))
+		}
+	}()
+	for _, n := range []int{5, 300, 70000} {
+		buf := make([]byte, n)
+		for read := 0; read < n; {
+			m, err := s.Read(buf[read:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			read += m
+		}
+		for i, b := range buf {
+			if b != 0 {
+				t.Fatalf("Unexpected byte %d of %d: %#x", i, n, b)
+			}
+		}
+	}
+}
+
+func TestAcceptWebSocketRejects(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, err := AcceptWebSocket(w, httptest.NewRequest("GET", "/", nil)); err != ErrNotWebSocket {
+		t.Fatalf("Expected ErrNotWebSocket, got %v", err)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+	if _, err := DialWebSocket("http://localhost/"); err == nil {
+		t.Fatal("Expected an error for a non-websocket URL")
+	}
+}
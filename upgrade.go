@@ -0,0 +1,41 @@
+package secure
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+)
+
+// UpgradeClient switches conn, on which a plaintext protocol has been
+// spoken so far, over to this protocol as the client, in the manner of
+// STARTTLS, and runs the handshake. If the plaintext protocol was read
+// through rd, any bytes it buffered past the point of the upgrade are
+// taken to be the start of the handshake; rd must not be used again.
+// rd may be nil.
+func UpgradeClient(conn net.Conn, rd *bufio.Reader, config *Config) (*Conn, error) {
+	c := NewClientConn(upgradeConn(conn, rd), config)
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// UpgradeServer is like UpgradeClient for the server side.
+func UpgradeServer(conn net.Conn, rd *bufio.Reader, config *Config) (*Conn, error) {
+	c := NewServerConn(upgradeConn(conn, rd), config)
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// upgradeConn returns conn with the bytes buffered in rd put back
+func upgradeConn(conn net.Conn, rd *bufio.Reader) net.Conn {
+	if rd == nil || rd.Buffered() == 0 {
+		return conn
+	}
+
+	// Peek cannot fail for what is already buffered
+	buffered, _ := rd.Peek(rd.Buffered())
+	return &replayConn{conn, bytes.NewReader(append([]byte(nil), buffered...))}
+}
@@ -0,0 +1,105 @@
+package secure
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		server, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer server.Close()
+
+		// the server reads lines until told to upgrade
+		rd := bufio.NewReader(server)
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			done <- err
+			return
+		}
+		if line != "STARTSECURE\n" {
+			done <- fmt.Errorf("unexpected command %q", line)
+			return
+		}
+		fmt.Fprint(server, "OK\n")
+		c, err := UpgradeServer(server, rd, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		buf := make([]byte, 64)
+		n, err := c.Read(buf)
+		if err == nil {
+			_, err = c.Write(buf[:n])
+		}
+		done <- err
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the server's key follows its answer, so
+	// the client's line reader may buffer it
+	rd := bufio.NewReader(client)
+	fmt.Fprint(client, "STARTSECURE\n")
+	if line, err := rd.ReadString('\n'); err != nil || line != "OK\n" {
+		t.Fatalf("Unexpected answer: %q, %v", line, err)
+	}
+
+	c, err := UpgradeClient(client, rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "hello world\n" {
+		t.Fatalf("Unexpected result: %q", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradeConn(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte("OK\nafter"))
+		server.Write([]byte(" and more"))
+		server.Close()
+	}()
+
+	rd := bufio.NewReader(client)
+	if line, _ := rd.ReadString('\n'); line != "OK\n" {
+		t.Fatalf("Unexpected line: %q", line)
+	}
+	rest, err := ioutil.ReadAll(upgradeConn(client, rd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "after and more" {
+		t.Fatalf("Unexpected result: %q", rest)
+	}
+}
@@ -0,0 +1,76 @@
+package secure
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// URIScheme is the scheme of URIs naming a server together with the
+// fingerprint of its public key, so that both can be shared as one
+// string:
+//
+//	secure://example.com:9000/#secfp1...
+//
+// Dial accepts them in place of an address and fails unless the
+// server's key matches the fingerprint.
+const URIScheme = "secure"
+
+// ErrURI means that a secure URI was malformed
+var ErrURI = errors.New("malformed secure URI")
+
+// FormatURI returns the secure URI of the server at addr, a host and
+// port, holding pub. The fingerprint is in KeyBech32 format.
+func FormatURI(addr string, pub *[KeySize]byte) string {
+	u := url.URL{
+		Scheme:   URIScheme,
+		Host:     addr,
+		Path:     "/",
+		Fragment: FormatFingerprint(pub, KeyBech32),
+	}
+	return u.String()
+}
+
+// ParseURI returns the address and the fingerprint of the server's
+// public key from a secure URI. The fingerprint may be in any format
+// MatchFingerprint accepts.
+func ParseURI(uri string) (addr, fingerprint string, err error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || u.Scheme != URIScheme || u.User != nil || u.RawQuery != "" {
+		return "", "", ErrURI
+	}
+	if u.Hostname() == "" || u.Port() == "" || (u.Path != "" && u.Path != "/") {
+		return "", "", ErrURI
+	}
+	if !isFingerprint(u.Fragment) {
+		return "", "", ErrURI
+	}
+	return u.Host, u.Fragment, nil
+}
+
+// isURI reports whether addr is a secure URI rather than an address
+func isURI(addr string) bool {
+	return strings.HasPrefix(strings.ToLower(addr), URIScheme+"://")
+}
+
+// isFingerprint reports whether s is a fingerprint as returned by
+// Fingerprint or FormatFingerprint
+func isFingerprint(s string) bool {
+	if _, err := ParseFingerprint(s); err == nil {
+		return true
+	}
+	groups := strings.Split(s, "-")
+	if len(groups) != fingerprintSize/2 {
+		return false
+	}
+	for _, g := range groups {
+		if len(g) != 4 || strings.ToLower(g) != g {
+			return false
+		}
+		if _, err := hex.DecodeString(g); err != nil {
+			return false
+		}
+	}
+	return true
+}
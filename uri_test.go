@@ -0,0 +1,63 @@
+package secure
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestURI(t *testing.T) {
+	pub, _ := mustGenerateKey(t, rand.Reader)
+
+	uri := FormatURI("example.com:9000", pub)
+	addr, fingerprint, err := ParseURI(uri)
+	if err != nil || addr != "example.com:9000" || !MatchFingerprint(pub, fingerprint) {
+		t.Fatalf("Unexpected parse of %s: %q, %q, %v", uri, addr, fingerprint, err)
+	}
+	if addr, _, err := ParseURI(FormatURI("[::1]:9000", pub)); err != nil || addr != "[::1]:9000" {
+		t.Fatalf("Unexpected parse of an IPv6 URI: %q, %v", addr, err)
+	}
+	if _, fingerprint, err := ParseURI("secure://example.com:9000/#" + Fingerprint(pub)); err != nil || !MatchFingerprint(pub, fingerprint) {
+		t.Fatalf("Unexpected parse of a URI with a hex fingerprint: %q, %v", fingerprint, err)
+	}
+
+	fp := FormatFingerprint(pub, KeyBech32)
+	for _, bad := range []string{
+		"example.com:9000",
+		"https://example.com:9000/#" + fp,
+		"secure://example.com/#" + fp,
+		"secure://:9000/#" + fp,
+		"secure://example.com:9000/path#" + fp,
+		"secure://example.com:9000/?q=1#" + fp,
+		"secure://user@example.com:9000/#" + fp,
+		"secure://example.com:9000/",
+		"secure://example.com:9000/#" + fp[:len(fp)-1],
+	} {
+		if _, _, err := ParseURI(bad); err != ErrURI {
+			t.Fatalf("Expected ErrURI for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestDialURI(t *testing.T) {
+	pub, priv := mustGenerateKey(t, rand.Reader)
+	other, _ := mustGenerateKey(t, rand.Reader)
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{PublicKey: pub, PrivateKey: priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoServer(l)
+
+	conn, err := Dial("tcp", FormatURI(l.Addr().String(), pub), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := Dial("tcp", FormatURI(l.Addr().String(), other), nil); err != ErrFingerprint {
+		t.Fatalf("Expected ErrFingerprint, got %v", err)
+	}
+	if _, err := Dial("tcp", "secure://"+l.Addr().String(), nil); err != ErrURI {
+		t.Fatalf("Expected ErrURI without a fingerprint, got %v", err)
+	}
+}